@@ -0,0 +1,359 @@
+package archiveextractor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	defaultMaxEntries = 10000
+	defaultMaxRatio   = 100
+	defaultMaxSize    = 10 * 1024 * 1024 * 1024
+)
+
+type extractor struct {
+	logger          levels.Levels
+	dataDriver      lib.DataDriver
+	metaDataDriver  lib.MetaDataDriver
+	temporaryFolder string
+	maxEntries      int
+	maxRatio        int64
+	maxSize         int64
+}
+
+// New returns an implementation of Extractor that writes the entries through dataDriver and metaDataDriver.
+// Archives are staged in temporaryFolder so they can be validated before anything is extracted.
+// An archive is rejected when it has more than maxEntries entries, when its contents take more than maxSize bytes
+// or when they are more than maxRatio times bigger than the archive, zero values mean the defaults.
+// When dataDriver implements QuotaReporter the contents must also fit in the bytes available at the target.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, temporaryFolder string, maxEntries, maxRatio int, maxSize int64) lib.Extractor {
+	logger = logger.With("pkg", "archiveextractor")
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	if maxRatio <= 0 {
+		maxRatio = defaultMaxRatio
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &extractor{
+		logger:          logger,
+		dataDriver:      dataDriver,
+		metaDataDriver:  metaDataDriver,
+		temporaryFolder: temporaryFolder,
+		maxEntries:      maxEntries,
+		maxRatio:        int64(maxRatio),
+		maxSize:         maxSize,
+	}
+}
+
+func (e *extractor) Extract(ctx context.Context, user lib.User, p string, format lib.ArchiveFormat, r io.Reader) ([]lib.ExtractResult, error) {
+	if format != lib.ArchiveFormatZIP && format != lib.ArchiveFormatTarGz {
+		return nil, badInputError(fmt.Sprintf("archive format %d not supported", format))
+	}
+
+	fd, err := ioutil.TempFile(e.temporaryFolder, "extract")
+	if err != nil {
+		e.logger.Error().Log("error", err, "msg", "error creating temporary file")
+		return nil, err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	archiveSize, err := io.Copy(fd, r)
+	if err != nil {
+		e.logger.Error().Log("error", err, "msg", "error staging archive")
+		return nil, err
+	}
+
+	total, err := e.validate(fd, archiveSize, format)
+	if err != nil {
+		e.logger.Warn().Log("msg", "archive rejected", "error", err)
+		return nil, err
+	}
+
+	target := filepath.Clean("/" + p)
+	if err := e.checkQuota(ctx, user, target, total); err != nil {
+		e.logger.Warn().Log("msg", "archive rejected", "error", err)
+		return nil, err
+	}
+	folders := map[string]bool{}
+	if err := e.createFolders(ctx, user, target, folders); err != nil {
+		return nil, err
+	}
+
+	results := []lib.ExtractResult{}
+	err = e.walk(fd, archiveSize, format, func(ent *entry, open func() (io.ReadCloser, error)) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ent.name == "" {
+			return nil
+		}
+
+		res := &result{path: filepath.Join(target, filepath.FromSlash(ent.name)), folder: ent.folder, size: ent.size}
+		results = append(results, res)
+		if ent.err != nil {
+			res.err = ent.err
+			return nil
+		}
+
+		if ent.folder {
+			res.err = e.createFolders(ctx, user, res.path, folders)
+			return nil
+		}
+		if err := e.createFolders(ctx, user, filepath.Dir(res.path), folders); err != nil {
+			res.err = err
+			return nil
+		}
+		readCloser, err := open()
+		if err != nil {
+			res.err = err
+			return nil
+		}
+		res.err = e.dataDriver.UploadFile(ctx, user, res.path, readCloser, "", 0)
+		return nil
+	})
+	if err != nil {
+		e.logger.Error().Log("error", err, "msg", "extraction aborted")
+		return nil, err
+	}
+
+	var failed int
+	for _, res := range results {
+		if res.Err() != nil {
+			failed++
+		}
+	}
+	e.logger.Info().Log("msg", "archive extracted", "path", target, "numentries", len(results), "numfailed", failed)
+	return results, nil
+}
+
+// validate checks the limits and the names of all the entries and returns the total size of the contents.
+// The sizes declared in the headers can be trusted as the zip and tar readers fail when the
+// contents do not match them, so the contents do not need to be decompressed.
+func (e *extractor) validate(fd *os.File, archiveSize int64, format lib.ArchiveFormat) (int64, error) {
+	var numEntries int
+	var total int64
+	err := e.walk(fd, archiveSize, format, func(ent *entry, open func() (io.ReadCloser, error)) error {
+		numEntries++
+		if numEntries > e.maxEntries {
+			return tooBigError(fmt.Sprintf("archive has more than %d entries", e.maxEntries))
+		}
+		if ent.slip {
+			return badInputError(fmt.Sprintf("entry %q points outside of the target folder", ent.rawName))
+		}
+		total += ent.size
+		if total > e.maxSize {
+			return tooBigError(fmt.Sprintf("archive contents exceed %d bytes", e.maxSize))
+		}
+		if total > e.maxRatio*archiveSize {
+			return tooBigError(fmt.Sprintf("archive contents are more than %d times bigger than the archive", e.maxRatio))
+		}
+		return nil
+	})
+	return total, err
+}
+
+// checkQuota checks that the contents fit in the bytes available to the user at target,
+// so an archive is not left half extracted when the quota is exceeded.
+// Files replaced by the archive are not discounted.
+func (e *extractor) checkQuota(ctx context.Context, user lib.User, target string, total int64) error {
+	quotaReporter, ok := e.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return nil
+	}
+	available, err := quotaReporter.AvailableBytes(ctx, user, target)
+	if err != nil {
+		return err
+	}
+	if available >= 0 && total > available {
+		return tooBigError(fmt.Sprintf("archive contents take %d bytes but only %d are available", total, available))
+	}
+	return nil
+}
+
+// walk calls fn for every entry of the archive in order, open returns the contents of file entries.
+func (e *extractor) walk(fd *os.File, archiveSize int64, format lib.ArchiveFormat, fn func(ent *entry, open func() (io.ReadCloser, error)) error) error {
+	if format == lib.ArchiveFormatZIP {
+		zr, err := zip.NewReader(fd, archiveSize)
+		if err != nil {
+			return badInputError(fmt.Sprintf("invalid zip archive: %s", err))
+		}
+		for _, f := range zr.File {
+			ent := newEntry(f.Name, f.FileInfo().IsDir(), int64(f.UncompressedSize64))
+			if f.Mode()&os.ModeSymlink != 0 {
+				ent.err = badInputError("symbolic links are not supported")
+			}
+			if err := fn(ent, f.Open); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	gr, err := gzip.NewReader(fd)
+	if err != nil {
+		return badInputError(fmt.Sprintf("invalid gzip stream: %s", err))
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return badInputError(fmt.Sprintf("invalid tar archive: %s", err))
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			if err := fn(newEntry(header.Name, true, 0), nil); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			open := func() (io.ReadCloser, error) { return ioutil.NopCloser(tr), nil }
+			if err := fn(newEntry(header.Name, false, header.Size), open); err != nil {
+				return err
+			}
+		default:
+			ent := newEntry(header.Name, false, 0)
+			ent.err = badInputError("only regular files and folders are supported")
+			if err := fn(ent, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// createFolders creates the folder at p and its missing parents,
+// folders known to exist are cached in folders.
+func (e *extractor) createFolders(ctx context.Context, user lib.User, p string, folders map[string]bool) error {
+	if p == "/" || folders[p] {
+		return nil
+	}
+	if err := e.createFolders(ctx, user, filepath.Dir(p), folders); err != nil {
+		return err
+	}
+
+	fileInfo, err := e.metaDataDriver.Examine(ctx, user, p)
+	if err != nil {
+		codeErr, ok := err.(lib.Error)
+		if !ok || codeErr.Code() != lib.CodeNotFound {
+			return err
+		}
+		if err := e.metaDataDriver.CreateFolder(ctx, user, p); err != nil {
+			return err
+		}
+	} else if !fileInfo.Folder() {
+		return alreadyExistError(fmt.Sprintf("%s already exists and is not a folder", p))
+	}
+	folders[p] = true
+	return nil
+}
+
+type entry struct {
+	rawName string
+	// name is the cleaned slash separated path of the entry relative to the target folder.
+	name   string
+	folder bool
+	size   int64
+	// slip is set when the name tries to escape the target folder.
+	slip bool
+	// err is set when the entry can not be extracted.
+	err error
+}
+
+func newEntry(rawName string, folder bool, size int64) *entry {
+	ent := &entry{rawName: rawName, folder: folder, size: size}
+	// archives created on Windows may use backslashes as separators.
+	name := strings.Replace(rawName, "\\", "/", -1)
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		ent.slip = true
+		return ent
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			ent.slip = true
+			return ent
+		}
+	}
+	if name = path.Clean(name); name != "." {
+		ent.name = name
+	}
+	return ent
+}
+
+type result struct {
+	path   string
+	folder bool
+	size   int64
+	err    error
+}
+
+func (r *result) Path() string {
+	return r.path
+}
+func (r *result) Folder() bool {
+	return r.folder
+}
+func (r *result) Size() int64 {
+	return r.size
+}
+func (r *result) Err() error {
+	return r.err
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type tooBigError string
+
+func (e tooBigError) Error() string {
+	return string(e)
+}
+func (e tooBigError) Code() lib.Code {
+	return lib.Code(lib.CodeTooBig)
+}
+func (e tooBigError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
@@ -9,10 +9,13 @@ import (
 type middleware struct {
 	cm          lib.ContextManager
 	tokenDriver lib.TokenDriver
+	provisioner lib.Provisioner
 }
 
-func New(cm lib.ContextManager, tokenDriver lib.TokenDriver) lib.AuthenticationMiddleware {
-	return &middleware{cm: cm, tokenDriver: tokenDriver}
+// New returns an AuthenticationMiddleware that provisions the authenticated users with provisioner
+// before handling their requests.
+func New(cm lib.ContextManager, tokenDriver lib.TokenDriver, provisioner lib.Provisioner) lib.AuthenticationMiddleware {
+	return &middleware{cm: cm, tokenDriver: tokenDriver, provisioner: provisioner}
 }
 
 func (m *middleware) HandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
@@ -25,6 +28,11 @@ func (m *middleware) HandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
+		if err := m.provisioner.Provision(r.Context(), user); err != nil {
+			logger.Error().Log("error", err, "msg", "error provisioning user")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 		r = r.WithContext(m.cm.SetUser(r.Context(), user))
 		r = r.WithContext(m.cm.SetAccessToken(r.Context(), token))
 		l := logger.With("user", user.Username())
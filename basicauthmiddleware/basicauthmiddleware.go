@@ -10,10 +10,13 @@ type middleware struct {
 	cm          lib.ContextManager
 	userDriver  lib.UserDriver
 	tokenDriver lib.TokenDriver
+	provisioner lib.Provisioner
 }
 
-func New(cm lib.ContextManager, userDriver lib.UserDriver, tokenDriver lib.TokenDriver, cookieName string) lib.BasicAuthMiddleware {
-	return &middleware{cm: cm, userDriver: userDriver, tokenDriver: tokenDriver, cookieName: cookieName}
+// New returns a BasicAuthMiddleware that provisions the authenticated users with provisioner
+// before handling their requests.
+func New(cm lib.ContextManager, userDriver lib.UserDriver, tokenDriver lib.TokenDriver, provisioner lib.Provisioner, cookieName string) lib.BasicAuthMiddleware {
+	return &middleware{cm: cm, userDriver: userDriver, tokenDriver: tokenDriver, provisioner: provisioner, cookieName: cookieName}
 }
 
 func (m *middleware) HandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
@@ -25,6 +28,11 @@ func (m *middleware) HandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
 		if err == nil {
 			user, err := m.tokenDriver.UserFromToken(authCookie.Value)
 			if err == nil {
+				if err := m.provisioner.Provision(r.Context(), user); err != nil {
+					logger.Error().Log("error", err, "msg", "error provisioning user")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
 				l := logger.With("user", user.Username())
 				r = r.WithContext(m.cm.SetLog(r.Context(), &l))
 				r = r.WithContext(m.cm.SetUser(r.Context(), user))
@@ -66,6 +74,12 @@ func (m *middleware) HandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if err := m.provisioner.Provision(r.Context(), user); err != nil {
+			logger.Error().Log("error", err, "msg", "error provisioning user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		// save token into cookie for further requests
 		cookie := &http.Cookie{}
 		cookie.Name = m.cookieName
@@ -0,0 +1,37 @@
+package capability
+
+import (
+	"github.com/clawio/lib"
+)
+
+// Supports tells if driver supports the optional interface named by c. The driver must implement
+// the interface and, when it implements lib.CapabilityReporter, report that it supports it,
+// as decorators implement every optional interface whatever the driver they wrap supports.
+func Supports(driver interface{}, c lib.Capability) bool {
+	var ok bool
+	switch c {
+	case lib.CapabilityFileIDResolver:
+		_, ok = driver.(lib.FileIDResolver)
+	case lib.CapabilitySearcher:
+		_, ok = driver.(lib.Searcher)
+	case lib.CapabilityContentSearcher:
+		_, ok = driver.(lib.ContentSearcher)
+	case lib.CapabilityFolderPager:
+		_, ok = driver.(lib.FolderPager)
+	case lib.CapabilityFolderWalker:
+		_, ok = driver.(lib.FolderWalker)
+	case lib.CapabilityChangeFeed:
+		_, ok = driver.(lib.ChangeFeed)
+	case lib.CapabilityModTimeSetter:
+		_, ok = driver.(lib.ModTimeSetter)
+	case lib.CapabilityFileIDSetter:
+		_, ok = driver.(lib.FileIDSetter)
+	}
+	if !ok {
+		return false
+	}
+	if reporter, isReporter := driver.(lib.CapabilityReporter); isReporter {
+		return reporter.Supports(c)
+	}
+	return true
+}
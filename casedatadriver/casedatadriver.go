@@ -0,0 +1,69 @@
+package casedatadriver
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+}
+
+// New returns an implementation of DataDriver that makes the namespace of dataDriver case-insensitive.
+// metaDataDriver must be the same namespace wrapped by casemdatadriver, the paths it returns
+// have the case of the resources stored and are the ones sent to dataDriver.
+// Uploading a file whose name only differs in case from an existing one overwrites it.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver) lib.DataDriver {
+	logger = logger.With("pkg", "casedatadriver")
+	return &driver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+	}
+}
+
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	return d.dataDriver.UploadFile(ctx, user, d.resolve(ctx, user, path), r, clientChecksum, clientModTime)
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	return d.dataDriver.DownloadFile(ctx, user, d.resolve(ctx, user, path))
+}
+
+// AvailableBytes reports no limit when the wrapped driver has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, user, d.resolve(ctx, user, path))
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+// resolve returns the path of the existing resource or, for new files, the path of the
+// existing parent joined with the name sent by the client. Otherwise path is returned as is
+// and the data driver reports the error.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) string {
+	path = filepath.Clean("/" + path)
+	if fileInfo, err := d.metaDataDriver.Examine(ctx, user, path); err == nil {
+		return fileInfo.Path()
+	}
+	if fileInfo, err := d.metaDataDriver.Examine(ctx, user, filepath.Dir(path)); err == nil {
+		return filepath.Join(fileInfo.Path(), filepath.Base(path))
+	}
+	return path
+}
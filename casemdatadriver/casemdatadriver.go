@@ -0,0 +1,275 @@
+package casemdatadriver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+}
+
+// New returns an implementation of MetaDataDriver that makes the namespace of metaDataDriver case-insensitive.
+// Paths are resolved ignoring case to the resources stored, whose case is preserved, and creating
+// a resource whose name only differs in case from an existing one returns an alreadyExistError.
+// When a name exists with the exact case it is preferred, so resources created before the mode was
+// enabled that only differ in case are still reachable.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver) lib.MetaDataDriver {
+	logger = logger.With("pkg", "casemdatadriver")
+	d := &driver{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+	}
+	return d
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	return d.metaDataDriver.Examine(ctx, user, path)
+}
+
+// ExamineByID returns a notSupportedError when the wrapped driver can not resolve ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+	return fileIDResolver.ExamineByID(ctx, user, id)
+}
+
+// Search returns a notSupportedError when the wrapped driver can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	searcher, ok := d.metaDataDriver.(lib.Searcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+	if query.Scope != "" {
+		scope, _, err := d.resolve(ctx, user, query.Scope)
+		if err != nil {
+			return nil, err
+		}
+		query.Scope = scope
+	}
+	return searcher.Search(ctx, user, query)
+}
+
+// SearchContent returns a notSupportedError when the wrapped driver can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	contentSearcher, ok := d.metaDataDriver.(lib.ContentSearcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+	if query.Scope != "" {
+		scope, _, err := d.resolve(ctx, user, query.Scope)
+		if err != nil {
+			return nil, err
+		}
+		query.Scope = scope
+	}
+	return contentSearcher.SearchContent(ctx, user, query)
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	return d.metaDataDriver.ListFolder(ctx, user, path)
+}
+
+// ListFolderPage returns a notSupportedError when the wrapped driver can not page folders.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	folderPager, ok := d.metaDataDriver.(lib.FolderPager)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support paging folders")
+	}
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	return folderPager.ListFolderPage(ctx, user, path, query)
+}
+
+// WalkFolder returns a notSupportedError when the wrapped driver can not walk folders.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	folderWalker, ok := d.metaDataDriver.(lib.FolderWalker)
+	if !ok {
+		return notSupportedError("metadata driver does not support walking folders")
+	}
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	return folderWalker.WalkFolder(ctx, user, path, fn)
+}
+
+// SetModTime returns a notSupportedError when the wrapped driver can not set modification times.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	modTimeSetter, ok := d.metaDataDriver.(lib.ModTimeSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	return modTimeSetter.SetModTime(ctx, user, path, modTime)
+}
+
+// GetChanges returns a notSupportedError when the wrapped driver has no change feed.
+func (d *driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	changeFeed, ok := d.metaDataDriver.(lib.ChangeFeed)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support change feeds")
+	}
+	return changeFeed.GetChanges(ctx, user, cursor, limit)
+}
+
+// SetFileID returns a notSupportedError when the wrapped driver can not set ids.
+func (d *driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	fileIDSetter, ok := d.metaDataDriver.(lib.FileIDSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting file ids")
+	}
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	return fileIDSetter.SetFileID(ctx, user, path, id)
+}
+
+// RecursiveSizes returns false when the wrapped driver can not compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer)
+	return ok && recursiveSizer.RecursiveSizes()
+}
+
+// Supports tells if the wrapped driver supports the optional interface.
+func (d *driver) Supports(c lib.Capability) bool {
+	return capability.Supports(d.metaDataDriver, c)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.metaDataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+// CreateFolder keeps the case sent by the client for the new folder.
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	resolved, exists, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return alreadyExistError(fmt.Sprintf("%s already exists", resolved))
+	}
+	return d.metaDataDriver.CreateFolder(ctx, user, resolved)
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	path, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	return d.metaDataDriver.Delete(ctx, user, path)
+}
+
+// Move replaces the existing target with the case it is stored with, so the wrapped driver
+// applies its usual overwrite rules. Moving a resource to its own path with another case renames it.
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	sourcePath, _, err := d.resolve(ctx, user, sourcePath)
+	if err != nil {
+		return err
+	}
+	resolvedTarget, _, err := d.resolve(ctx, user, targetPath)
+	if err != nil {
+		return err
+	}
+	if resolvedTarget == sourcePath {
+		resolvedTarget = filepath.Join(filepath.Dir(resolvedTarget), filepath.Base(filepath.Clean("/"+targetPath)))
+	}
+	return d.metaDataDriver.Move(ctx, user, sourcePath, resolvedTarget)
+}
+
+// resolve returns path with the case of the resources stored and whether the resource exists.
+// The components that do not exist, and the ones after them, are kept with the case of path.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) (string, bool, error) {
+	path = filepath.Clean("/" + path)
+	// most clients keep the case of the names they got, so the exact path is tried first.
+	if _, err := d.metaDataDriver.Examine(ctx, user, path); err == nil {
+		return path, true, nil
+	} else if !isNotFound(err) {
+		return "", false, err
+	}
+
+	resolved := "/"
+	names := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, name := range names {
+		fileInfos, err := d.metaDataDriver.ListFolder(ctx, user, resolved)
+		if err != nil {
+			if isNotFound(err) {
+				return filepath.Join(append([]string{resolved}, names[i:]...)...), false, nil
+			}
+			return "", false, err
+		}
+		match := ""
+		for _, fileInfo := range fileInfos {
+			stored := filepath.Base(fileInfo.Path())
+			if stored == name {
+				match = stored
+				break
+			}
+			if match == "" && strings.EqualFold(stored, name) {
+				match = stored
+			}
+		}
+		if match == "" {
+			return filepath.Join(append([]string{resolved}, names[i:]...)...), false, nil
+		}
+		resolved = filepath.Join(resolved, match)
+	}
+	return resolved, true, nil
+}
+
+func isNotFound(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
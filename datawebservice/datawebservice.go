@@ -18,6 +18,8 @@ type service struct {
 	dataDriver        lib.DataDriver
 	am                lib.AuthenticationMiddleware
 	wec               lib.WebErrorConverter
+	archiver          lib.Archiver
+	extractor         lib.Extractor
 	uploadMaxFileSize int64
 }
 
@@ -27,6 +29,8 @@ func New(
 	dataDriver lib.DataDriver,
 	am lib.AuthenticationMiddleware,
 	wec lib.WebErrorConverter,
+	archiver lib.Archiver,
+	extractor lib.Extractor,
 	uploadMaxFileSize int64) lib.WebService {
 	return &service{
 		cm:                cm,
@@ -34,6 +38,8 @@ func New(
 		dataDriver:        dataDriver,
 		am:                am,
 		wec:               wec,
+		archiver:          archiver,
+		extractor:         extractor,
 		uploadMaxFileSize: uploadMaxFileSize,
 	}
 }
@@ -50,6 +56,12 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/data/download": {
 			"POST": s.am.HandlerFunc(s.downloadEndpoint),
 		},
+		"/data/archive": {
+			"POST": s.am.HandlerFunc(s.archiveEndpoint),
+		},
+		"/data/extract": {
+			"POST": s.am.HandlerFunc(s.extractEndpoint),
+		},
 	}
 }
 
@@ -83,7 +95,7 @@ func (s *service) uploadEndpoint(w http.ResponseWriter, r *http.Request) {
 	clientChecksum := s.getClientChecksum(r)
 	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
 	context.WithValue(r.Context(), "extra", req.Extra)
-	if err := s.dataDriver.UploadFile(r.Context(), user, req.Path, readCloser, clientChecksum); err != nil {
+	if err := s.dataDriver.UploadFile(r.Context(), user, req.Path, readCloser, clientChecksum, req.ModTime); err != nil {
 		s.handleUploadEndpointError(err, w, r)
 		return
 	}
@@ -115,6 +127,32 @@ func (s *service) handleUploadEndpointError(err error, w http.ResponseWriter, r
 			w.WriteHeader(http.StatusPartialContent)
 			return
 		}
+		if codeErr.Code() == lib.CodeInvalidName {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeTooBig {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write(jsonErr)
+			return
+		}
 	}
 
 	logger.Error().Log("error", err, "msg", "unexpected error uploading file")
@@ -191,6 +229,175 @@ func (s *service) handleDownloadEndpointError(err error, w http.ResponseWriter,
 	return
 }
 
+func (s *service) archiveEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &archiveRequest{}
+	if err := json.Unmarshal([]byte(r.Header.Get("clawio-api-arg")), req); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json in clawio-api-arg header")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	format, ext := lib.ArchiveFormatZIP, ".zip"
+	switch req.Format {
+	case "", "zip":
+	case "tar.gz", "tgz":
+		format, ext = lib.ArchiveFormatTarGz, ".tar.gz"
+	default:
+		s.handleDownloadEndpointError(badRequestError(fmt.Sprintf("archive format %q not supported", req.Format)), w, r)
+		return
+	}
+	if len(req.Paths) == 0 {
+		s.handleDownloadEndpointError(badRequestError("paths can not be empty"), w, r)
+		return
+	}
+
+	name := "archive"
+	if len(req.Paths) == 1 && filepath.Clean("/"+req.Paths[0]) != "/" {
+		name = filepath.Base(filepath.Clean("/" + req.Paths[0]))
+	}
+
+	// add security headers
+	w.Header().Add("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Content-Type", "clawio/archive")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename='%s'", name+ext))
+
+	// the archiver examines all the paths before writing, so errors
+	// before the first write can still be sent with their status code.
+	aw := &archiveResponseWriter{w: w}
+	if err := s.archiver.Archive(r.Context(), user, req.Paths, format, aw); err != nil {
+		if !aw.written {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Disposition")
+			s.handleDownloadEndpointError(err, w, r)
+			return
+		}
+		logger.Error().Log("error", err, "msg", "archive download aborted")
+		return
+	}
+}
+
+// archiveResponseWriter tracks if the archive has started to be written.
+type archiveResponseWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (a *archiveResponseWriter) Write(p []byte) (int, error) {
+	a.written = true
+	return a.w.Write(p)
+}
+
+// extractEndpoint uploads an archive and extracts it into the folder at path.
+// The archive is rejected as a whole if it is not valid, otherwise the response
+// contains the result of every entry, as some of them may fail.
+func (s *service) extractEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &extractRequest{}
+	if err := json.Unmarshal([]byte(r.Header.Get("clawio-api-arg")), req); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json in clawio-api-arg header")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	format := lib.ArchiveFormatZIP
+	switch req.Format {
+	case "", "zip":
+	case "tar.gz", "tgz":
+		format = lib.ArchiveFormatTarGz
+	default:
+		s.handleExtractEndpointError(badRequestError(fmt.Sprintf("archive format %q not supported", req.Format)), w, r)
+		return
+	}
+
+	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
+	results, err := s.extractor.Extract(r.Context(), user, req.Path, format, readCloser)
+	if err != nil {
+		s.handleExtractEndpointError(err, w, r)
+		return
+	}
+
+	entries := []*extractEntry{}
+	for _, res := range results {
+		entry := &extractEntry{Path: res.Path(), Folder: res.Folder(), Size: res.Size()}
+		if err := res.Err(); err != nil {
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *service) handleExtractEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+
+	if err.Error() == "http: request body too large" {
+		logger.Error().Log("error", err, "msg", "request body max size exceed")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if codeErr, ok := err.(lib.Error); ok {
+		status := 0
+		switch codeErr.Code() {
+		case lib.CodeNotFound:
+			status = http.StatusNotFound
+		case lib.CodeForbidden:
+			status = http.StatusForbidden
+		case lib.CodeAlreadyExist:
+			status = http.StatusConflict
+		case lib.CodeBadInputData, lib.CodeInvalidName:
+			status = http.StatusBadRequest
+		case lib.CodeTooBig:
+			status = http.StatusRequestEntityTooLarge
+		}
+		if status != 0 {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(status)
+			w.Write(jsonErr)
+			return
+		}
+	}
+
+	logger.Error().Log("error", err, "msg", "unexpected error extracting archive")
+	w.WriteHeader(http.StatusInternalServerError)
+	return
+}
+
 func (s *service) getClientChecksum(r *http.Request) string {
 	if t := r.Header.Get("checksum"); t != "" {
 		return t
@@ -213,4 +420,24 @@ func (e badRequestError) Message() string {
 type pathRequest struct {
 	Path  string      `json:"path"`
 	Extra interface{} `json:"extra"`
+	// ModTime is the modification time of the uploaded file in nanoseconds since the epoch,
+	// zero means the time of the upload.
+	ModTime int64 `json:"mtime"`
+}
+
+type archiveRequest struct {
+	Paths  []string `json:"paths"`
+	Format string   `json:"format"`
+}
+
+type extractRequest struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+type extractEntry struct {
+	Path   string `json:"path"`
+	Folder bool   `json:"folder"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
 }
@@ -55,11 +55,11 @@ func (c *webServiceClient) getDataURL(ctx context.Context) (string, error) {
 	chosenURL := chosenNode.URL() + "/data"
 	return chosenURL, nil
 }
-func (c *webServiceClient) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string) error {
+func (c *webServiceClient) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
 	traceID := c.cm.MustGetTraceID(ctx)
 	token := c.cm.MustGetAccessToken(ctx)
 
-	pathReq := &pathReq{Path: path}
+	pathReq := &pathReq{Path: path, ModTime: clientModTime}
 	jsonHeader, err := json.Marshal(pathReq)
 	if err != nil {
 		c.logger.Error().Log("error", err, "msg", "error encoding path request")
@@ -150,7 +150,8 @@ func (c *webServiceClient) DownloadFile(ctx context.Context, user lib.User, path
 }
 
 type pathReq struct {
-	Path string `json:"path"`
+	Path    string `json:"path"`
+	ModTime int64  `json:"mtime,omitempty"`
 }
 
 type internalError string
@@ -0,0 +1,156 @@
+package etcdnotificationbroker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/memnotificationbroker"
+	"github.com/coreos/etcd/client"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	// ttl is how long a notification is kept in etcd, enough for
+	// all the nodes watching the key to receive it.
+	ttl        = time.Minute
+	defaultKey = "/notifications"
+
+	// retryInterval is the time to wait before watching again after an error.
+	retryInterval = time.Second
+)
+
+type broker struct {
+	logger levels.Levels
+	key    string
+	kapi   client.KeysAPI
+	local  lib.NotificationBroker
+}
+
+// New returns an implementation of NotificationBroker that fans out the notifications
+// across nodes using the etcd cluster used as registry backend.
+// Every node watches the key and delivers the notifications to its own subscribers,
+// so a change done on one node reaches the subscribers connected to any other node.
+func New(logger levels.Levels, urlList, key, username, password string) (lib.NotificationBroker, error) {
+	logger = logger.With("pkg", "etcdnotificationbroker")
+	urls := strings.Split(urlList, ",")
+	etcdConfig := client.Config{
+		Endpoints:               urls,
+		Username:                username,
+		Password:                password,
+		Transport:               client.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	}
+	c, err := client.New(etcdConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == "" {
+		key = defaultKey
+	}
+
+	b := &broker{
+		logger: logger,
+		key:    key,
+		kapi:   client.NewKeysAPI(c),
+		local:  memnotificationbroker.New(logger),
+	}
+	go b.watch()
+	return b, nil
+}
+
+// Publish stores the notification in etcd, it is delivered to the local subscribers
+// when it comes back through the watch, like for any other node.
+func (b *broker) Publish(ctx context.Context, notification lib.Notification) error {
+	n := &xnotification{
+		XUsername:   notification.Username(),
+		XKind:       notification.Kind(),
+		XID:         notification.ID(),
+		XPath:       notification.Path(),
+		XSourcePath: notification.SourcePath(),
+		XTimestamp:  notification.Timestamp(),
+	}
+	jsonValue, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.kapi.CreateInOrder(ctx, b.key, string(jsonValue), &client.CreateInOrderOptions{TTL: ttl})
+	if err != nil {
+		b.logger.Error().Log("error", err, "msg", "error publishing notification")
+		return err
+	}
+	return nil
+}
+
+func (b *broker) Subscribe(user lib.User) (<-chan lib.Notification, func()) {
+	return b.local.Subscribe(user)
+}
+
+// watch delivers the notifications stored in etcd to the local subscribers.
+func (b *broker) watch() {
+	ctx := context.Background()
+	var afterIndex uint64
+	watcher := b.kapi.Watcher(b.key, &client.WatcherOptions{Recursive: true})
+	for {
+		res, err := watcher.Next(ctx)
+		if err != nil {
+			if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeEventIndexCleared {
+				// the node has been disconnected for too long, the notifications in between are lost.
+				b.logger.Warn().Log("msg", "notifications lost, watching from current index", "index", etcdErr.Index)
+				afterIndex = etcdErr.Index
+			} else {
+				b.logger.Error().Log("error", err, "msg", "error watching notifications")
+				time.Sleep(retryInterval)
+			}
+			watcher = b.kapi.Watcher(b.key, &client.WatcherOptions{Recursive: true, AfterIndex: afterIndex})
+			continue
+		}
+
+		if res.Node == nil {
+			continue
+		}
+		afterIndex = res.Node.ModifiedIndex
+		if res.Action != "create" && res.Action != "set" {
+			continue
+		}
+
+		n := &xnotification{}
+		if err := json.Unmarshal([]byte(res.Node.Value), n); err != nil {
+			b.logger.Error().Log("error", err, "msg", "invalid notification", "key", res.Node.Key)
+			continue
+		}
+		b.local.Publish(ctx, n)
+	}
+}
+
+type xnotification struct {
+	XUsername   string         `json:"username"`
+	XKind       lib.ChangeKind `json:"kind"`
+	XID         string         `json:"id"`
+	XPath       string         `json:"path"`
+	XSourcePath string         `json:"source_path"`
+	XTimestamp  int64          `json:"timestamp"`
+}
+
+func (n *xnotification) Username() string {
+	return n.XUsername
+}
+func (n *xnotification) Kind() lib.ChangeKind {
+	return n.XKind
+}
+func (n *xnotification) ID() string {
+	return n.XID
+}
+func (n *xnotification) Path() string {
+	return n.XPath
+}
+func (n *xnotification) SourcePath() string {
+	return n.XSourcePath
+}
+func (n *xnotification) Timestamp() int64 {
+	return n.XTimestamp
+}
@@ -51,14 +51,22 @@ type configuration struct {
 	OCFSDataDriverChecksum             string `json:"ocfs_data_driver_checksum"`
 	OCFSDataDriverVerifyClientChecksum bool   `json:"ocfs_data_driver_verify_client_checksum"`
 
-	MetaDataDriver                  string `json:"meta_data_driver"`
-	FSMDataDriverDataFolder         string `json:"fsm_data_driver_data_folder"`
-	FSMDataDriverTemporaryFolder    string `json:"fsm_data_driver_temporary_folder"`
-	OCFSMDataDriverDataFolder       string `json:"ocfsm_data_driver_data_folder"`
-	OCFSMDataDriverTemporaryFolder  string `json:"ocfsm_data_driver_temporary_folder"`
-	OCFSMDataDriverMaxSQLIddle      int    `json:"ocfsm_data_driver_max_sql_iddle"`
-	OCFSMDataDriverMaxSQLConcurrent int    `json:"ocfsm_data_driver_max_sql_concurrent"`
-	OCFSMDataDriverDSN              string `json:"ocfsm_data_driver_dsn"`
+	MetaDataDriver                    string `json:"meta_data_driver"`
+	FSMDataDriverDataFolder           string `json:"fsm_data_driver_data_folder"`
+	FSMDataDriverTemporaryFolder      string `json:"fsm_data_driver_temporary_folder"`
+	FSMDataDriverRecursiveSize        bool   `json:"fsm_data_driver_recursive_size"`
+	OCFSMDataDriverDataFolder         string `json:"ocfsm_data_driver_data_folder"`
+	OCFSMDataDriverTemporaryFolder    string `json:"ocfsm_data_driver_temporary_folder"`
+	OCFSMDataDriverMaxSQLIddle        int    `json:"ocfsm_data_driver_max_sql_iddle"`
+	OCFSMDataDriverMaxSQLConcurrent   int    `json:"ocfsm_data_driver_max_sql_concurrent"`
+	OCFSMDataDriverDSN                string `json:"ocfsm_data_driver_dsn"`
+	OCXattrMDataDriverDataFolder      string `json:"ocxattrm_data_driver_data_folder"`
+	OCXattrMDataDriverTemporaryFolder string `json:"ocxattrm_data_driver_temporary_folder"`
+
+	FSWatcher                      string `json:"fs_watcher"`
+	InotifyFSWatcherDataFolder     string `json:"inotify_fs_watcher_data_folder"`
+	InotifyFSWatcherDebounce       int    `json:"inotify_fs_watcher_debounce"`
+	InotifyFSWatcherRescanInterval int    `json:"inotify_fs_watcher_rescan_interval"`
 
 	TokenDriver       string `json:"token_driver"`
 	JWTTokenDriverKey string `json:"jwt_token_driver_key"`
@@ -69,6 +77,42 @@ type configuration struct {
 	ETCDRegistryDriverPassword string `json:"etcd_registry_driver_password"`
 	ETCDRegistryDriverKey      string `json:"etcd_registry_driver_key"`
 
+	NotificationBroker        string `json:"notification_broker"`
+	ETCDNotificationBrokerKey string `json:"etcd_notification_broker_key"`
+
+	ShareDriver                    string `json:"share_driver"`
+	SQLShareDriverMaxSQLIddle      int    `json:"sql_share_driver_max_sql_iddle"`
+	SQLShareDriverMaxSQLConcurrent int    `json:"sql_share_driver_max_sql_concurrent"`
+	SQLShareDriverDSN              string `json:"sql_share_driver_dsn"`
+	SharesFolder                   string `json:"shares_folder"`
+
+	SpaceDriver                    string `json:"space_driver"`
+	SQLSpaceDriverMaxSQLIddle      int    `json:"sql_space_driver_max_sql_iddle"`
+	SQLSpaceDriverMaxSQLConcurrent int    `json:"sql_space_driver_max_sql_concurrent"`
+	SQLSpaceDriverDSN              string `json:"sql_space_driver_dsn"`
+	SpaceAdministrators            string `json:"space_administrators"`
+	SpacesFolder                   string `json:"spaces_folder"`
+
+	ProvisionerSkeletonFolder string `json:"provisioner_skeleton_folder"`
+	ProvisionerArchiveFolder  string `json:"provisioner_archive_folder"`
+	ProvisionerAdministrators string `json:"provisioner_administrators"`
+
+	UserExportAdministrators string `json:"user_export_administrators"`
+
+	ArchiveExtractorTemporaryFolder string `json:"archive_extractor_temporary_folder"`
+	ArchiveExtractorMaxEntries      int    `json:"archive_extractor_max_entries"`
+	ArchiveExtractorMaxRatio        int    `json:"archive_extractor_max_ratio"`
+	ArchiveExtractorMaxSize         int64  `json:"archive_extractor_max_size"`
+
+	ContentIndexFolder      string `json:"content_index_folder"`
+	ContentIndexMaxFileSize int64  `json:"content_index_max_file_size"`
+	ContentIndexQueueSize   int    `json:"content_index_queue_size"`
+
+	PathPolicyMapForbiddenCharacters bool   `json:"path_policy_map_forbidden_characters"`
+	PathPolicyMaxNameLength          int    `json:"path_policy_max_name_length"`
+	PathPolicyMaxPathLength          int    `json:"path_policy_max_path_length"`
+	PathPolicyBlockedPatterns        string `json:"path_policy_blocked_patterns"`
+
 	BasicAuthMiddleware                     string `json:"basic_auth_middleware"`
 	BasicAuthMiddlewareCookieName           string `json:"basic_auth_middleware_cookie_name"`
 	CORSMiddlewareEnabled                   bool   `json:"cors_middleware_enabled"`
@@ -84,9 +128,26 @@ type configuration struct {
 
 	MetaDataWebService string `json:"meta_data_web_service"`
 
+	NotificationWebService          string `json:"notification_web_service"`
+	NotificationWebServiceHeartbeat int    `json:"notification_web_service_heartbeat"`
+
 	OCWebService                        string `json:"oc_web_service"`
 	OCWebServiceMaxUploadFileSize       int64  `json:"oc_web_service_max_upload_file_size"`
 	RemoteOCWebServiceMaxUploadFileSize int64  `json:"remote_oc_web_service_max_upload_file_size"`
+
+	OCShareWebService                  string `json:"oc_share_web_service"`
+	OCShareWebServiceMaxUploadFileSize int64  `json:"oc_share_web_service_max_upload_file_size"`
+
+	SpaceWebService string `json:"space_web_service"`
+
+	ProvisioningWebService string `json:"provisioning_web_service"`
+
+	UserExportWebService              string `json:"user_export_web_service"`
+	UserExportWebServiceMaxImportSize int64  `json:"user_export_web_service_max_import_size"`
+
+	PreviewWebService            string `json:"preview_web_service"`
+	PreviewWebServiceCacheFolder string `json:"preview_web_service_cache_folder"`
+	PreviewWebServiceMaxFileSize int64  `json:"preview_web_service_max_file_size"`
 }
 
 func New(filename string) (lib.ConfigurationSource, error) {
@@ -174,6 +235,7 @@ func (c *configuration) GetFSMDataDriverDataFolder() string { return c.FSMDataDr
 func (c *configuration) GetFSMDataDriverTemporaryFolder() string {
 	return c.FSMDataDriverTemporaryFolder
 }
+func (c *configuration) GetFSMDataDriverRecursiveSize() bool  { return c.FSMDataDriverRecursiveSize }
 func (c *configuration) GetOCFSMDataDriverDataFolder() string { return c.OCFSMDataDriverDataFolder }
 func (c *configuration) GetOCFSMDataDriverTemporaryFolder() string {
 	return c.OCFSMDataDriverTemporaryFolder
@@ -183,6 +245,21 @@ func (c *configuration) GetOCFSMDataDriverMaxSQLConcurrent() int {
 	return c.OCFSMDataDriverMaxSQLConcurrent
 }
 func (c *configuration) GetOCFSMDataDriverDSN() string { return c.OCFSMDataDriverDSN }
+func (c *configuration) GetOCXattrMDataDriverDataFolder() string {
+	return c.OCXattrMDataDriverDataFolder
+}
+func (c *configuration) GetOCXattrMDataDriverTemporaryFolder() string {
+	return c.OCXattrMDataDriverTemporaryFolder
+}
+
+func (c *configuration) GetFSWatcher() string { return c.FSWatcher }
+func (c *configuration) GetInotifyFSWatcherDataFolder() string {
+	return c.InotifyFSWatcherDataFolder
+}
+func (c *configuration) GetInotifyFSWatcherDebounce() int { return c.InotifyFSWatcherDebounce }
+func (c *configuration) GetInotifyFSWatcherRescanInterval() int {
+	return c.InotifyFSWatcherRescanInterval
+}
 
 func (c *configuration) GetTokenDriver() string       { return c.TokenDriver }
 func (c *configuration) GetJWTTokenDriverKey() string { return c.JWTTokenDriverKey }
@@ -193,6 +270,62 @@ func (c *configuration) GetETCDRegistryDriverUsername() string { return c.ETCDRe
 func (c *configuration) GetETCDRegistryDriverPassword() string { return c.ETCDRegistryDriverPassword }
 func (c *configuration) GetETCDRegistryDriverKey() string      { return c.ETCDRegistryDriverKey }
 
+func (c *configuration) GetNotificationBroker() string        { return c.NotificationBroker }
+func (c *configuration) GetETCDNotificationBrokerKey() string { return c.ETCDNotificationBrokerKey }
+
+func (c *configuration) GetShareDriver() string { return c.ShareDriver }
+func (c *configuration) GetSQLShareDriverMaxSQLIddle() int {
+	return c.SQLShareDriverMaxSQLIddle
+}
+func (c *configuration) GetSQLShareDriverMaxSQLConcurrent() int {
+	return c.SQLShareDriverMaxSQLConcurrent
+}
+func (c *configuration) GetSQLShareDriverDSN() string { return c.SQLShareDriverDSN }
+func (c *configuration) GetSharesFolder() string      { return c.SharesFolder }
+
+func (c *configuration) GetSpaceDriver() string { return c.SpaceDriver }
+func (c *configuration) GetSQLSpaceDriverMaxSQLIddle() int {
+	return c.SQLSpaceDriverMaxSQLIddle
+}
+func (c *configuration) GetSQLSpaceDriverMaxSQLConcurrent() int {
+	return c.SQLSpaceDriverMaxSQLConcurrent
+}
+func (c *configuration) GetSQLSpaceDriverDSN() string   { return c.SQLSpaceDriverDSN }
+func (c *configuration) GetSpaceAdministrators() string { return c.SpaceAdministrators }
+func (c *configuration) GetSpacesFolder() string        { return c.SpacesFolder }
+
+func (c *configuration) GetProvisionerSkeletonFolder() string {
+	return c.ProvisionerSkeletonFolder
+}
+func (c *configuration) GetProvisionerArchiveFolder() string {
+	return c.ProvisionerArchiveFolder
+}
+func (c *configuration) GetProvisionerAdministrators() string {
+	return c.ProvisionerAdministrators
+}
+
+func (c *configuration) GetUserExportAdministrators() string {
+	return c.UserExportAdministrators
+}
+
+func (c *configuration) GetArchiveExtractorTemporaryFolder() string {
+	return c.ArchiveExtractorTemporaryFolder
+}
+func (c *configuration) GetArchiveExtractorMaxEntries() int { return c.ArchiveExtractorMaxEntries }
+func (c *configuration) GetArchiveExtractorMaxRatio() int   { return c.ArchiveExtractorMaxRatio }
+func (c *configuration) GetArchiveExtractorMaxSize() int64  { return c.ArchiveExtractorMaxSize }
+
+func (c *configuration) GetContentIndexFolder() string     { return c.ContentIndexFolder }
+func (c *configuration) GetContentIndexMaxFileSize() int64 { return c.ContentIndexMaxFileSize }
+func (c *configuration) GetContentIndexQueueSize() int     { return c.ContentIndexQueueSize }
+
+func (c *configuration) GetPathPolicyMapForbiddenCharacters() bool {
+	return c.PathPolicyMapForbiddenCharacters
+}
+func (c *configuration) GetPathPolicyMaxNameLength() int      { return c.PathPolicyMaxNameLength }
+func (c *configuration) GetPathPolicyMaxPathLength() int      { return c.PathPolicyMaxPathLength }
+func (c *configuration) GetPathPolicyBlockedPatterns() string { return c.PathPolicyBlockedPatterns }
+
 func (c *configuration) GetBasicAuthMiddleware() string {
 	return c.BasicAuthMiddleware
 }
@@ -230,6 +363,14 @@ func (c *configuration) GetMetaDataWebService() string {
 	return c.MetaDataWebService
 }
 
+func (c *configuration) GetNotificationWebService() string {
+	return c.NotificationWebService
+}
+
+func (c *configuration) GetNotificationWebServiceHeartbeat() int {
+	return c.NotificationWebServiceHeartbeat
+}
+
 func (c *configuration) GetDataWebServiceMaxUploadFileSize() int64 {
 	return c.DataWebServiceMaxUploadFileSize
 }
@@ -243,3 +384,35 @@ func (c *configuration) GetOCWebServiceMaxUploadFileSize() int64 {
 func (c *configuration) GetRemoteOCWebServiceMaxUploadFileSize() int64 {
 	return c.RemoteOCWebServiceMaxUploadFileSize
 }
+
+func (c *configuration) GetOCShareWebService() string {
+	return c.OCShareWebService
+}
+func (c *configuration) GetOCShareWebServiceMaxUploadFileSize() int64 {
+	return c.OCShareWebServiceMaxUploadFileSize
+}
+
+func (c *configuration) GetSpaceWebService() string {
+	return c.SpaceWebService
+}
+
+func (c *configuration) GetProvisioningWebService() string {
+	return c.ProvisioningWebService
+}
+
+func (c *configuration) GetUserExportWebService() string {
+	return c.UserExportWebService
+}
+func (c *configuration) GetUserExportWebServiceMaxImportSize() int64 {
+	return c.UserExportWebServiceMaxImportSize
+}
+
+func (c *configuration) GetPreviewWebService() string {
+	return c.PreviewWebService
+}
+func (c *configuration) GetPreviewWebServiceCacheFolder() string {
+	return c.PreviewWebServiceCacheFolder
+}
+func (c *configuration) GetPreviewWebServiceMaxFileSize() int64 {
+	return c.PreviewWebServiceMaxFileSize
+}
@@ -0,0 +1,142 @@
+package folderpage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/clawio/lib"
+)
+
+// Builder selects the entries of a page while a folder is read,
+// keeping at most about twice the limit of the query in memory.
+type Builder struct {
+	query   lib.ListQuery
+	after   *lib.ListKey
+	entries []entry
+}
+
+type entry struct {
+	key  lib.ListKey
+	item interface{}
+}
+
+type continuationToken struct {
+	SortBy     lib.ListSortKey `json:"s"`
+	Descending bool            `json:"d"`
+	Name       string          `json:"n"`
+	Size       int64           `json:"z,omitempty"`
+	Modified   int64           `json:"m,omitempty"`
+}
+
+// New returns a builder for the page of the query.
+// It fails when the query is not valid, like when the continuation token was issued for another sort order.
+func New(query lib.ListQuery) (*Builder, error) {
+	if query.SortBy > lib.ListSortByModified {
+		return nil, errors.New("invalid sort key")
+	}
+	if query.FilesOnly && query.FoldersOnly {
+		return nil, errors.New("files only and folders only can not be both set")
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("limit can not be negative")
+	}
+
+	b := &Builder{query: query, entries: []entry{}}
+	if query.ContinuationToken == "" {
+		return b, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(query.ContinuationToken)
+	if err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+	token := &continuationToken{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+	if token.SortBy != query.SortBy || token.Descending != query.Descending {
+		return nil, errors.New("continuation token does not match the sort order")
+	}
+	b.after = &lib.ListKey{Name: token.Name, Size: token.Size, Modified: token.Modified}
+	return b, nil
+}
+
+// Add adds the entry if it passes the filters and goes after the continuation token.
+func (b *Builder) Add(key lib.ListKey, folder bool, item interface{}) {
+	if !Match(b.query, key.Name, folder) {
+		return
+	}
+	if b.after != nil && !Less(b.query, *b.after, key) {
+		return
+	}
+	b.entries = append(b.entries, entry{key: key, item: item})
+	// one more entry than the limit is kept to know if there is a next page.
+	if b.query.Limit > 0 && len(b.entries) > 2*(b.query.Limit+1) {
+		b.sort()
+		b.entries = b.entries[:b.query.Limit+1]
+	}
+}
+
+// Page returns the items of the page in order and the continuation token for the next page.
+func (b *Builder) Page() ([]interface{}, string) {
+	b.sort()
+	var token string
+	if b.query.Limit > 0 && len(b.entries) > b.query.Limit {
+		b.entries = b.entries[:b.query.Limit]
+		token = newContinuationToken(b.query, b.entries[len(b.entries)-1].key)
+	}
+	items := make([]interface{}, len(b.entries))
+	for i, entry := range b.entries {
+		items[i] = entry.item
+	}
+	return items, token
+}
+
+func (b *Builder) sort() {
+	sort.Slice(b.entries, func(i, j int) bool { return Less(b.query, b.entries[i].key, b.entries[j].key) })
+}
+
+// Match returns if an entry passes the filters of the query.
+func Match(query lib.ListQuery, name string, folder bool) bool {
+	if (query.FilesOnly && folder) || (query.FoldersOnly && !folder) {
+		return false
+	}
+	return strings.HasPrefix(name, query.NamePrefix)
+}
+
+// Less returns if the entry at a goes before the one at b in the order of the query.
+func Less(query lib.ListQuery, a, b lib.ListKey) bool {
+	if query.Descending {
+		a, b = b, a
+	}
+	switch query.SortBy {
+	case lib.ListSortBySize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case lib.ListSortByModified:
+		if a.Modified != b.Modified {
+			return a.Modified < b.Modified
+		}
+	}
+	return a.Name < b.Name
+}
+
+// NewKey returns the key of the entry of a resource.
+func NewKey(fileInfo lib.FileInfo) lib.ListKey {
+	return lib.ListKey{Name: filepath.Base(fileInfo.Path()), Size: fileInfo.Size(), Modified: fileInfo.Modified()}
+}
+
+func newContinuationToken(query lib.ListQuery, key lib.ListKey) string {
+	data, _ := json.Marshal(&continuationToken{
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		Name:       key.Name,
+		Size:       key.Size,
+		Modified:   key.Modified,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/clawio/lib"
 	"github.com/go-kit/kit/log/levels"
@@ -52,8 +53,8 @@ func (c *driver) Init(ctx context.Context, user lib.User) error {
 // 1) Write the file to a temporary folder.
 // 2) Optional: calculate the checksum of the file if server-checksum is enabled.
 // 3) Optional: if a client-checksum is provided, check if it matches with the server-checksum.
-// 4) Move the file from the temporary folder to user folder.
-func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string) error {
+// 4) Move the file from the temporary folder to user folder, with the client modification time if provided.
+func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
 	tempFileName, err := c.saveToTempFile(r)
 	if err != nil {
 		c.logger.Error().Log("error", err)
@@ -82,6 +83,14 @@ func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r i
 	}
 
 	// 4) Move the file from the temporary folder to user folder.
+	// The modification time is set before so the file never shows up with the time of the upload.
+	if clientModTime != 0 {
+		t := time.Unix(0, clientModTime)
+		if err := os.Chtimes(tempFileName, t, t); err != nil {
+			c.logger.Error().Log("error", err, "msg", "error setting client modification time")
+			return err
+		}
+	}
 	localPath := c.getLocalPath(user, path)
 	if err := os.Rename(tempFileName, localPath); err != nil {
 		c.logger.Error().Log("error", err)
@@ -5,25 +5,57 @@ import (
 	"path/filepath"
 
 	"context"
+	"errors"
 	"fmt"
 	"github.com/clawio/lib"
+	"github.com/clawio/lib/folderpage"
+	"github.com/clawio/lib/searchfilter"
 	"github.com/go-kit/kit/log/levels"
+	"io"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// readdirBatchSize is the number of entries read at once while paging a folder.
+	readdirBatchSize = 1000
+
+	// recursiveSizeTTL is how long the recursive size of a folder is cached.
+	// Changes done through this driver invalidate the cache, uploads done through
+	// the data driver are only seen once the size expires.
+	recursiveSizeTTL = 30 * time.Second
+
+	// maxCachedSizes is the number of cached sizes after which the expired ones are pruned.
+	maxCachedSizes = 10000
 )
 
 type driver struct {
 	logger          levels.Levels
 	dataFolder      string
 	temporaryFolder string
+	recursiveSize   bool
+
+	sizesMu sync.Mutex
+	sizes   map[string]cachedSize
+}
+
+type cachedSize struct {
+	size    int64
+	expires time.Time
 }
 
 // New returns an implementation of MetaDataController.
-func New(logger levels.Levels, dataFolder, temporaryFolder string) (lib.MetaDataDriver, error) {
+// When recursiveSize is true the size of folders is the size of all their descendants,
+// it is computed walking the folder and cached for a short time.
+func New(logger levels.Levels, dataFolder, temporaryFolder string, recursiveSize bool) (lib.MetaDataDriver, error) {
 	logger = logger.With("pkg", "fdmdatadriver")
 	c := &driver{
 		logger:          logger,
 		dataFolder:      dataFolder,
 		temporaryFolder: temporaryFolder,
+		recursiveSize:   recursiveSize,
+		sizes:           map[string]cachedSize{},
 	}
 
 	if err := os.MkdirAll(dataFolder, 0755); err != nil {
@@ -54,6 +86,7 @@ func (c *driver) CreateFolder(ctx context.Context, user lib.User, path string) e
 		}
 		return err
 	}
+	c.invalidateSizes(localPath)
 	c.logger.Info().Log("msg", "folder created", "folder", localPath)
 	return nil
 }
@@ -69,7 +102,7 @@ func (c *driver) Examine(ctx context.Context, user lib.User, path string) (lib.F
 		return nil, err
 	}
 	c.logger.Info().Log("msg", "file examined", "file", localPath)
-	fileInfo := c.convert(path, fsFileInfo)
+	fileInfo := c.convert(localPath, path, fsFileInfo)
 	return fileInfo, nil
 }
 
@@ -109,17 +142,125 @@ func (c *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]
 	var fileInfos []lib.FileInfo
 	for _, fi := range fsFileInfos {
 		nodePath := filepath.Join(path, filepath.Base(fi.Name()))
-		fileInfos = append(fileInfos, c.convert(nodePath, fi))
+		fileInfos = append(fileInfos, c.convert(filepath.Join(localPath, fi.Name()), nodePath, fi))
 	}
 	return fileInfos, nil
 }
 
+// ListFolderPage reads the folder in batches, so only the entries of the page are kept in memory.
+func (c *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badInputError(err.Error())
+	}
+
+	localPath := c.getLocalPath(user, path)
+	fsFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	if !fsFileInfo.IsDir() {
+		return nil, isFolderError(fmt.Sprintf("%q is not a folder", localPath))
+	}
+
+	fd, err := os.Open(localPath)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	for {
+		fsFileInfos, err := fd.Readdir(readdirBatchSize)
+		for _, fi := range fsFileInfos {
+			key := lib.ListKey{Name: fi.Name(), Size: c.getSize(filepath.Join(localPath, fi.Name()), fi), Modified: fi.ModTime().UnixNano()}
+			builder.Add(key, fi.IsDir(), fi)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.logger.Error().Log("error", err)
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	items, continuationToken := builder.Page()
+	fileInfos := []lib.FileInfo{}
+	for _, item := range items {
+		fi := item.(os.FileInfo)
+		fileInfos = append(fileInfos, c.convert(filepath.Join(localPath, fi.Name()), filepath.Join(path, fi.Name()), fi))
+	}
+	c.logger.Info().Log("msg", "folder page read", "numfiles", len(fileInfos), "hasmore", continuationToken != "")
+	return &folderPage{fileInfos: fileInfos, continuationToken: continuationToken}, nil
+}
+
+// WalkFolder reads the folder in batches and calls fn with the entries of each batch, in directory order.
+func (c *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	localPath := c.getLocalPath(user, path)
+	fsFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	if !fsFileInfo.IsDir() {
+		return isFolderError(fmt.Sprintf("%q is not a folder", localPath))
+	}
+
+	fd, err := os.Open(localPath)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	defer fd.Close()
+
+	for {
+		fsFileInfos, err := fd.Readdir(readdirBatchSize)
+		if len(fsFileInfos) > 0 {
+			fileInfos := []lib.FileInfo{}
+			for _, fi := range fsFileInfos {
+				fileInfos = append(fileInfos, c.convert(filepath.Join(localPath, fi.Name()), filepath.Join(path, fi.Name()), fi))
+			}
+			if err := fn(fileInfos); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			c.logger.Error().Log("error", err)
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
 func (c *driver) Delete(ctx context.Context, user lib.User, path string) error {
 	localPath := c.getLocalPath(user, path)
 	err := os.RemoveAll(localPath)
 	if err != nil {
 		c.logger.Error().Log("error", err)
 	}
+	c.invalidateSizes(localPath)
 	c.logger.Info().Log("msg", "file deleted", "file", localPath)
 	return nil
 }
@@ -137,22 +278,165 @@ func (c *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath
 		}
 		return err
 	}
+	c.invalidateSizes(sourceLocalPath)
+	c.invalidateSizes(targetLocalPath)
 	c.logger.Info().Log("msg", "file renamed", "source", sourceLocalPath, "target", targetLocalPath)
 	return nil
 }
+
+// SetModTime sets the modification time of the resource on the filesystem.
+// RecursiveSizes returns if the driver was created to compute the sizes of folders.
+func (c *driver) RecursiveSizes() bool {
+	return c.recursiveSize
+}
+
+func (c *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	localPath := c.getLocalPath(user, path)
+	t := time.Unix(0, modTime)
+	if err := os.Chtimes(localPath, t, t); err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	c.logger.Info().Log("msg", "modification time set", "file", localPath, "mtime", modTime)
+	return nil
+}
+
+// Search walks the scope in lexical order, so pages are stable while the tree does not change.
+func (c *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	scope := filepath.Clean("/" + query.Scope)
+	localScope := filepath.Clean(c.getLocalPath(user, scope))
+	fsFileInfo, err := os.Stat(localScope)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	if !fsFileInfo.IsDir() {
+		return nil, isFolderError(fmt.Sprintf("%q is not a folder", scope))
+	}
+
+	result := &searchResult{fileInfos: []lib.FileInfo{}}
+	var skipped int
+	err = filepath.Walk(localScope, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// resources removed while walking are ignored.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if localPath == localScope {
+			return nil
+		}
+
+		fileInfo := c.convert(localPath, filepath.Join(scope, strings.TrimPrefix(localPath, localScope)), fi)
+		if !searchfilter.Match(query, fileInfo) {
+			return nil
+		}
+		if skipped < query.Offset {
+			skipped++
+			return nil
+		}
+		if query.Limit > 0 && len(result.fileInfos) == query.Limit {
+			result.hasMore = true
+			return errStopWalk
+		}
+		result.fileInfos = append(result.fileInfos, fileInfo)
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		c.logger.Error().Log("error", err, "msg", "error searching")
+		return nil, err
+	}
+	c.logger.Info().Log("msg", "search done", "scope", scope, "numresults", len(result.fileInfos))
+	return result, nil
+}
+
 func (c *driver) getLocalPath(user lib.User, path string) string {
 	dataFolder := strings.Trim(c.dataFolder, "/")
 	path = strings.Trim(path, "/")
 	return fmt.Sprintf("/%s/%s/%s", dataFolder, user.Username(), filepath.Clean(path))
 }
 
-func (c *driver) convert(path string, fsFileInfo os.FileInfo) lib.FileInfo {
-	return &fileInfo{path: path, osFileInfo: fsFileInfo}
+func (c *driver) convert(localPath, path string, fsFileInfo os.FileInfo) lib.FileInfo {
+	return &fileInfo{path: path, osFileInfo: fsFileInfo, size: c.getSize(localPath, fsFileInfo)}
+}
+
+// getSize returns the size of the resource, the recursive size for folders when enabled.
+// If the recursive size can not be computed the size of the folder inode is returned.
+func (c *driver) getSize(localPath string, fsFileInfo os.FileInfo) int64 {
+	if !c.recursiveSize || !fsFileInfo.IsDir() {
+		return fsFileInfo.Size()
+	}
+
+	localPath = filepath.Clean(localPath)
+	now := time.Now()
+	c.sizesMu.Lock()
+	cached, ok := c.sizes[localPath]
+	c.sizesMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.size
+	}
+
+	var size int64
+	err := filepath.Walk(localPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// resources removed while walking are ignored.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error computing recursive size", "folder", localPath)
+		return fsFileInfo.Size()
+	}
+
+	c.sizesMu.Lock()
+	defer c.sizesMu.Unlock()
+	if len(c.sizes) >= maxCachedSizes {
+		for p, cached := range c.sizes {
+			if !now.Before(cached.expires) {
+				delete(c.sizes, p)
+			}
+		}
+	}
+	c.sizes[localPath] = cachedSize{size: size, expires: now.Add(recursiveSizeTTL)}
+	return size
+}
+
+// invalidateSizes removes the cached sizes affected by a change on localPath,
+// which are the ones of its ancestors and its descendants.
+func (c *driver) invalidateSizes(localPath string) {
+	if !c.recursiveSize {
+		return
+	}
+	localPath = filepath.Clean(localPath)
+	c.sizesMu.Lock()
+	defer c.sizesMu.Unlock()
+	for p := range c.sizes {
+		if p == localPath || strings.HasPrefix(localPath, p+"/") || strings.HasPrefix(p, localPath+"/") {
+			delete(c.sizes, p)
+		}
+	}
 }
 
 type fileInfo struct {
 	path       string
 	osFileInfo os.FileInfo
+	size       int64
 }
 
 func (f *fileInfo) Path() string {
@@ -164,7 +448,7 @@ func (f *fileInfo) Folder() bool {
 }
 
 func (f *fileInfo) Size() int64 {
-	return int64(f.osFileInfo.Size())
+	return f.size
 }
 
 func (f *fileInfo) Modified() int64 {
@@ -179,6 +463,35 @@ func (f *fileInfo) ExtraAttributes() map[string]interface{} {
 	return nil
 }
 
+// errStopWalk stops the walk once a page of results is full.
+var errStopWalk = errors.New("stop walk")
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
+type searchResult struct {
+	fileInfos []lib.FileInfo
+	hasMore   bool
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	return r.fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
 type checksumError string
 
 func (e checksumError) Error() string {
@@ -239,3 +552,15 @@ func (e renameError) Code() lib.Code {
 func (e renameError) Message() string {
 	return string(e)
 }
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
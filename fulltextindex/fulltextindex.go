@@ -0,0 +1,855 @@
+package fulltextindex
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	defaultMaxFileSize = 64 * 1024 * 1024
+	defaultQueueSize   = 1024
+
+	// maxTextSize is the number of bytes of text kept for every file, the rest is not indexed.
+	maxTextSize = 1024 * 1024
+	// maxPartSize limits the decompressed size of the parts of Office documents and of PDF streams.
+	maxPartSize = 64 * 1024 * 1024
+	// terms shorter or longer than these number of runes are not indexed.
+	minTermLength = 2
+	maxTermLength = 64
+	// snippets take about snippetBefore bytes before the first match and snippetAfter bytes after it.
+	snippetBefore = 60
+	snippetAfter  = 100
+
+	// parameters of the Okapi BM25 ranking function.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type jobKind int
+
+const (
+	jobIndex jobKind = iota
+	jobRemove
+	jobMove
+)
+
+type job struct {
+	kind       jobKind
+	user       lib.User
+	path       string
+	targetPath string
+}
+
+type index struct {
+	logger      levels.Levels
+	dataDriver  lib.DataDriver
+	folder      string
+	maxFileSize int64
+	jobs        chan *job
+
+	mu    sync.Mutex
+	users map[string]*userIndex // username => loaded index
+}
+
+// New returns an implementation of ContentIndex that keeps an inverted index for every user in folder.
+// Contents are read with dataDriver, files bigger than maxFileSize bytes are not indexed.
+// Changes are queued, up to queueSize of them, and applied one by one by a single goroutine,
+// callers block when the queue is full. Zero values mean the defaults.
+// The index of a user is loaded in memory the first time it is used and kept there.
+func New(logger levels.Levels, dataDriver lib.DataDriver, folder string, maxFileSize int64, queueSize int) (lib.ContentIndex, error) {
+	logger = logger.With("pkg", "fulltextindex")
+	if maxFileSize <= 0 {
+		maxFileSize = defaultMaxFileSize
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+	idx := &index{
+		logger:      logger,
+		dataDriver:  dataDriver,
+		folder:      folder,
+		maxFileSize: maxFileSize,
+		jobs:        make(chan *job, queueSize),
+		users:       map[string]*userIndex{},
+	}
+	go idx.work()
+	return idx, nil
+}
+
+func (idx *index) Index(ctx context.Context, user lib.User, p string) error {
+	return idx.enqueue(ctx, &job{kind: jobIndex, user: user, path: filepath.Clean("/" + p)})
+}
+
+func (idx *index) Remove(ctx context.Context, user lib.User, p string) error {
+	return idx.enqueue(ctx, &job{kind: jobRemove, user: user, path: filepath.Clean("/" + p)})
+}
+
+func (idx *index) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	return idx.enqueue(ctx, &job{
+		kind:       jobMove,
+		user:       user,
+		path:       filepath.Clean("/" + sourcePath),
+		targetPath: filepath.Clean("/" + targetPath),
+	})
+}
+
+func (idx *index) enqueue(ctx context.Context, j *job) error {
+	select {
+	case idx.jobs <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SearchContent returns the files having all the terms of the query ranked with BM25.
+func (idx *index) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	terms := uniqueTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, badInputError(fmt.Sprintf("query must have words of at least %d characters", minTermLength))
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return nil, badInputError("offset and limit can not be negative")
+	}
+
+	ui, err := idx.getUserIndex(user)
+	if err != nil {
+		return nil, err
+	}
+	scope := filepath.Clean("/" + query.Scope)
+
+	ui.mu.RLock()
+	defer ui.mu.RUnlock()
+
+	// the candidates are the documents of the rarest term.
+	sort.Slice(terms, func(i, j int) bool { return len(ui.Postings[terms[i]]) < len(ui.Postings[terms[j]]) })
+	matches := []*match{}
+	numDocuments := float64(len(ui.Documents))
+	avgLength := float64(ui.TotalLength) / math.Max(numDocuments, 1)
+	for p := range ui.Postings[terms[0]] {
+		if scope != "/" && !strings.HasPrefix(p, scope+"/") {
+			continue
+		}
+		length := float64(ui.Documents[p].Length)
+		var score float64
+		for _, term := range terms {
+			tf, ok := ui.Postings[term][p]
+			if !ok {
+				score = -1
+				break
+			}
+			n := float64(len(ui.Postings[term]))
+			idf := math.Log(1 + (numDocuments-n+0.5)/(n+0.5))
+			score += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*(1-bm25B+bm25B*length/avgLength))
+		}
+		if score >= 0 {
+			matches = append(matches, &match{path: p, score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].path < matches[j].path
+	})
+
+	result := &searchResult{matches: []lib.ContentMatch{}}
+	if query.Offset < len(matches) {
+		matches = matches[query.Offset:]
+		if query.Limit > 0 && len(matches) > query.Limit {
+			matches = matches[:query.Limit]
+			result.hasMore = true
+		}
+		for _, m := range matches {
+			m.snippet = snippet(ui.Documents[m.path].Text, terms)
+			result.matches = append(result.matches, m)
+		}
+	}
+	return result, nil
+}
+
+// work applies the queued changes, indexes are saved when there are no more changes pending.
+func (idx *index) work() {
+	dirty := map[*userIndex]bool{}
+	for j := range idx.jobs {
+		ui, err := idx.getUserIndex(j.user)
+		if err != nil {
+			idx.logger.Error().Log("error", err, "msg", "error loading index", "user", j.user.Username())
+			continue
+		}
+
+		switch j.kind {
+		case jobIndex:
+			idx.indexFile(ui, j.user, j.path)
+		case jobRemove:
+			ui.mu.Lock()
+			ui.removeTree(j.path)
+			ui.mu.Unlock()
+		case jobMove:
+			ui.mu.Lock()
+			ui.moveTree(j.path, j.targetPath)
+			ui.mu.Unlock()
+		}
+		dirty[ui] = true
+
+		if len(idx.jobs) == 0 {
+			for ui := range dirty {
+				if err := idx.save(ui); err != nil {
+					idx.logger.Error().Log("error", err, "msg", "error saving index", "user", ui.username)
+				}
+				delete(dirty, ui)
+			}
+		}
+	}
+}
+
+func (idx *index) indexFile(ui *userIndex, user lib.User, p string) {
+	text, err := idx.extract(user, p)
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	// the old entry is removed also when the new contents can not be indexed.
+	ui.remove(p)
+	if err != nil {
+		idx.logger.Warn().Log("msg", "file not indexed", "user", user.Username(), "path", p, "error", err)
+		return
+	}
+	if text == "" {
+		return
+	}
+	ui.add(p, text)
+	idx.logger.Info().Log("msg", "file indexed", "user", user.Username(), "path", p, "numterms", ui.Documents[p].Length)
+}
+
+// extract returns the text of the file, which is empty for files without a supported text format.
+func (idx *index) extract(user lib.User, p string) (string, error) {
+	format := getFormat(p)
+	if format == formatNone {
+		return "", nil
+	}
+
+	readCloser, err := idx.dataDriver.DownloadFile(context.Background(), user, p)
+	if err != nil {
+		return "", err
+	}
+	defer readCloser.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(readCloser, idx.maxFileSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > idx.maxFileSize {
+		return "", fmt.Errorf("file is bigger than %d bytes", idx.maxFileSize)
+	}
+
+	var text string
+	switch format {
+	case formatText:
+		text = string(bytes.Runes(data))
+	case formatPDF:
+		text = extractPDF(data)
+	case formatWord:
+		text, err = extractZIP(data, func(name string) bool { return name == "word/document.xml" })
+	case formatPresentation:
+		text, err = extractZIP(data, func(name string) bool {
+			ok, _ := path.Match("ppt/slides/slide*.xml", name)
+			return ok
+		})
+	case formatSpreadsheet:
+		text, err = extractZIP(data, func(name string) bool { return name == "xl/sharedStrings.xml" })
+	case formatOpenDocument:
+		text, err = extractZIP(data, func(name string) bool { return name == "content.xml" })
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(text) > maxTextSize {
+		text = strings.ToValidUTF8(text[:maxTextSize], "")
+	}
+	return text, nil
+}
+
+// getUserIndex returns the index of the user, loading it from disk the first time.
+func (idx *index) getUserIndex(user lib.User) (*userIndex, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if ui, ok := idx.users[user.Username()]; ok {
+		return ui, nil
+	}
+
+	ui := &userIndex{
+		username:  user.Username(),
+		Documents: map[string]*document{},
+		Postings:  map[string]map[string]int{},
+	}
+	fd, err := os.Open(idx.getIndexPath(user.Username()))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer fd.Close()
+		if err := gob.NewDecoder(fd).Decode(ui); err != nil {
+			return nil, err
+		}
+	}
+	idx.users[user.Username()] = ui
+	return ui, nil
+}
+
+// save writes the index to a temporary file that replaces the old one, so a crash never leaves a truncated index.
+func (idx *index) save(ui *userIndex) error {
+	ui.mu.RLock()
+	defer ui.mu.RUnlock()
+	fd, err := ioutil.TempFile(idx.folder, "save")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	if err := gob.NewEncoder(fd).Encode(ui); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), idx.getIndexPath(ui.username))
+}
+
+// getIndexPath hashes the username so it is always a valid file name.
+func (idx *index) getIndexPath(username string) string {
+	return filepath.Join(idx.folder, fmt.Sprintf("%x.gob", sha1.Sum([]byte(username))))
+}
+
+// userIndex is the inverted index of the files of a user, exported fields are persisted.
+type userIndex struct {
+	mu       sync.RWMutex
+	username string
+
+	Documents map[string]*document // path => document
+	// Postings has the paths of the documents having every term with the number of occurrences.
+	Postings map[string]map[string]int
+	// TotalLength is the sum of the lengths of all the documents.
+	TotalLength int64
+}
+
+type document struct {
+	// Text is kept to build the snippets.
+	Text string
+	// Length is the number of terms of the text.
+	Length int
+}
+
+func (ui *userIndex) add(p, text string) {
+	doc := &document{Text: text}
+	tokenize(text, func(term string, start, end int) bool {
+		if ui.Postings[term] == nil {
+			ui.Postings[term] = map[string]int{}
+		}
+		ui.Postings[term][p]++
+		doc.Length++
+		return true
+	})
+	ui.Documents[p] = doc
+	ui.TotalLength += int64(doc.Length)
+}
+
+func (ui *userIndex) remove(p string) {
+	doc, ok := ui.Documents[p]
+	if !ok {
+		return
+	}
+	tokenize(doc.Text, func(term string, start, end int) bool {
+		delete(ui.Postings[term], p)
+		if len(ui.Postings[term]) == 0 {
+			delete(ui.Postings, term)
+		}
+		return true
+	})
+	delete(ui.Documents, p)
+	ui.TotalLength -= int64(doc.Length)
+}
+
+func (ui *userIndex) removeTree(p string) {
+	for _, docPath := range ui.getTree(p) {
+		ui.remove(docPath)
+	}
+}
+
+// moveTree moves the entries below sourcePath to targetPath, replacing the ones there.
+func (ui *userIndex) moveTree(sourcePath, targetPath string) {
+	docPaths := ui.getTree(sourcePath)
+	ui.removeTree(targetPath)
+	for _, docPath := range docPaths {
+		doc := ui.Documents[docPath]
+		ui.remove(docPath)
+		ui.add(targetPath+strings.TrimPrefix(docPath, sourcePath), doc.Text)
+	}
+}
+
+// getTree returns the paths of the documents at p or below it.
+func (ui *userIndex) getTree(p string) []string {
+	docPaths := []string{}
+	for docPath := range ui.Documents {
+		if p == "/" || docPath == p || strings.HasPrefix(docPath, p+"/") {
+			docPaths = append(docPaths, docPath)
+		}
+	}
+	return docPaths
+}
+
+// tokenize calls fn with the lowercase terms of the text and their byte offsets until fn returns false.
+// Terms are sequences of letters and digits.
+func tokenize(text string, fn func(term string, start, end int) bool) {
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if !emit(text, start, i, fn) {
+				return
+			}
+			start = -1
+		}
+	}
+	if start >= 0 {
+		emit(text, start, len(text), fn)
+	}
+}
+
+func emit(text string, start, end int, fn func(term string, start, end int) bool) bool {
+	n := utf8.RuneCountInString(text[start:end])
+	if n < minTermLength || n > maxTermLength {
+		return true
+	}
+	return fn(strings.ToLower(text[start:end]), start, end)
+}
+
+func uniqueTerms(text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	tokenize(text, func(term string, start, end int) bool {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+		return true
+	})
+	return terms
+}
+
+// snippet returns the text around the first occurrence of any of the terms, cut at word boundaries.
+func snippet(text string, terms []string) string {
+	wanted := map[string]bool{}
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	first := -1
+	tokenize(text, func(term string, start, end int) bool {
+		if wanted[term] {
+			first = start
+			return false
+		}
+		return true
+	})
+	if first < 0 {
+		return ""
+	}
+
+	start, end := first-snippetBefore, first+snippetAfter
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	} else {
+		s := start
+		for s < first && !unicode.IsSpace(rune(text[s-1])) {
+			s++
+		}
+		// texts without spaces are cut at a rune boundary.
+		if s == first {
+			for s = start; !utf8.RuneStart(text[s]); s++ {
+			}
+		}
+		start = s
+	}
+	if end >= len(text) {
+		end, suffix = len(text), ""
+	} else {
+		e := end
+		for e > first && !unicode.IsSpace(rune(text[e])) {
+			e--
+		}
+		if e == first {
+			for e = end; !utf8.RuneStart(text[e]); e-- {
+			}
+		}
+		end = e
+	}
+	return prefix + strings.Join(strings.Fields(text[start:end]), " ") + suffix
+}
+
+type format int
+
+const (
+	formatNone format = iota
+	formatText
+	formatPDF
+	formatWord
+	formatPresentation
+	formatSpreadsheet
+	formatOpenDocument
+)
+
+// getFormat returns the text format of the file from its extension.
+func getFormat(p string) format {
+	switch ext := strings.ToLower(filepath.Ext(p)); ext {
+	case ".txt", ".md", ".markdown", ".csv", ".log":
+		return formatText
+	case ".pdf":
+		return formatPDF
+	case ".docx", ".docm":
+		return formatWord
+	case ".pptx", ".pptm":
+		return formatPresentation
+	case ".xlsx", ".xlsm":
+		return formatSpreadsheet
+	case ".odt", ".odp", ".ods":
+		return formatOpenDocument
+	default:
+		if strings.HasPrefix(mime.TypeByExtension(ext), "text/") {
+			return formatText
+		}
+		return formatNone
+	}
+}
+
+// extractZIP returns the text of the XML parts of a ZIP based document, like Office Open XML
+// and OpenDocument files, whose names are accepted by match, in the order of their names.
+func extractZIP(data []byte, match func(name string) bool) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	files := []*zip.File{}
+	for _, f := range zr.File {
+		if match(f.Name) {
+			files = append(files, f)
+		}
+	}
+	// slide10.xml goes after slide9.xml.
+	sort.Slice(files, func(i, j int) bool {
+		if len(files[i].Name) != len(files[j].Name) {
+			return len(files[i].Name) < len(files[j].Name)
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	buf := &strings.Builder{}
+	for _, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		err = extractXML(io.LimitReader(rc, maxPartSize), buf)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		if buf.Len() > maxTextSize {
+			break
+		}
+	}
+	return buf.String(), nil
+}
+
+// extractXML writes the character data of the document, with a line break after every paragraph,
+// table row or shared string, and a space for tabs and line breaks inside paragraphs.
+func extractXML(r io.Reader, buf *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			buf.Write(t)
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tab", "br", "s":
+				buf.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h", "si", "tr":
+				buf.WriteString("\n")
+			}
+		}
+	}
+}
+
+// extractPDF returns the text shown by the content streams of the document.
+// It is a best effort: streams compressed with filters other than FlateDecode are skipped, and
+// the text of fonts with custom encodings, like most CID fonts, comes out as garbage that is not indexed.
+func extractPDF(data []byte) string {
+	buf := &strings.Builder{}
+	for i := 0; buf.Len() <= maxTextSize; {
+		n := bytes.Index(data[i:], []byte("stream"))
+		if n < 0 {
+			break
+		}
+		start := i + n + len("stream")
+		i = start
+		// skip endstream and keywords that are not followed by the end of line of a stream.
+		if bytes.HasSuffix(data[:start-len("stream")], []byte("end")) {
+			continue
+		}
+		if bytes.HasPrefix(data[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(data[start:], []byte("\n")) {
+			start++
+		} else {
+			continue
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		end += start
+		i = end
+
+		// the dictionary of the stream is between the object header and the stream.
+		dictStart := bytes.LastIndex(data[:start], []byte("obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := data[dictStart:start]
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/FontFile")) {
+			continue
+		}
+
+		content := data[start:end]
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Count(dict, []byte("Decode")) > 1 {
+				continue
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// truncated streams still give the text before the error.
+			content, _ = ioutil.ReadAll(io.LimitReader(zr, maxPartSize))
+			zr.Close()
+		}
+		extractPDFContent(content, buf)
+	}
+	return buf.String()
+}
+
+// extractPDFContent writes the strings shown by the text operators of a content stream.
+func extractPDFContent(content []byte, buf *strings.Builder) {
+	var operands []string
+	var inArray bool
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := readPDFString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<':
+			// hex strings are mostly glyph ids of CID fonts, they are skipped.
+			for i < len(content) && content[i] != '>' {
+				i++
+			}
+			i++
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		default:
+			j := i + 1
+			for j < len(content) && !isPDFSpace(content[j]) && !isPDFDelimiter(content[j]) {
+				j++
+			}
+			token := string(content[i:j])
+			i = j
+
+			n, err := strconv.ParseFloat(token, 64)
+			if err == nil || strings.HasPrefix(token, "/") {
+				// big negative kernings inside TJ arrays separate words.
+				if err == nil && inArray && n < -200 {
+					operands = append(operands, " ")
+				}
+				continue
+			}
+			switch token {
+			case "Tj", "TJ":
+				buf.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				buf.WriteString("\n" + strings.Join(operands, ""))
+			case "Td", "TD":
+				buf.WriteString(" ")
+			case "T*", "ET":
+				buf.WriteString("\n")
+			}
+			operands = nil
+		}
+	}
+}
+
+// readPDFString returns the literal string at the beginning of data and the number of bytes it takes.
+// Strings are PDFDocEncoding, read as Latin-1, or UTF-16BE when they start with a byte order mark.
+func readPDFString(data []byte) (string, int) {
+	raw := []byte{}
+	depth := 0
+	i := 0
+	for ; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '(':
+			if depth > 0 {
+				raw = append(raw, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return decodePDFString(raw), i + 1
+			}
+			raw = append(raw, c)
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				raw = append(raw, '\n')
+			case 'r':
+				raw = append(raw, '\r')
+			case 't':
+				raw = append(raw, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for k := 0; k < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; k++ {
+						v = v*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					raw = append(raw, byte(v))
+				} else {
+					raw = append(raw, e)
+				}
+			}
+		default:
+			raw = append(raw, c)
+		}
+	}
+	return decodePDFString(raw), i
+}
+
+func decodePDFString(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := []uint16{}
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(raw))
+	for i, c := range raw {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+type match struct {
+	path    string
+	score   float64
+	snippet string
+}
+
+func (m *match) Path() string {
+	return m.path
+}
+func (m *match) Score() float64 {
+	return m.score
+}
+func (m *match) Snippet() string {
+	return m.snippet
+}
+
+type searchResult struct {
+	matches []lib.ContentMatch
+	hasMore bool
+}
+
+func (r *searchResult) Matches() []lib.ContentMatch {
+	return r.matches
+}
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,64 @@
+package indexdatadriver
+
+import (
+	"context"
+	"io"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/occhunk"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger     levels.Levels
+	dataDriver lib.DataDriver
+	index      lib.ContentIndex
+}
+
+// New returns an implementation of DataDriver that sends to the index
+// every file uploaded successfully through dataDriver.
+func New(logger levels.Levels, dataDriver lib.DataDriver, index lib.ContentIndex) lib.DataDriver {
+	logger = logger.With("pkg", "indexdatadriver")
+	return &driver{
+		logger:     logger,
+		dataDriver: dataDriver,
+		index:      index,
+	}
+}
+
+// UploadFile indexes ownCloud chunked uploads once the chunk that completes the file is uploaded,
+// the other chunks return an error with CodeUploadIsPartial.
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	if err := d.dataDriver.UploadFile(ctx, user, path, r, clientChecksum, clientModTime); err != nil {
+		return err
+	}
+	if chunkInfo, ok := occhunk.Parse(path); ok {
+		path = chunkInfo.Path
+	}
+	if err := d.index.Index(ctx, user, path); err != nil {
+		d.logger.Error().Log("error", err, "msg", "error queuing file for indexing", "path", path)
+	}
+	return nil
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	return d.dataDriver.DownloadFile(ctx, user, path)
+}
+
+// AvailableBytes reports no limit when the wrapped driver has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, user, path)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
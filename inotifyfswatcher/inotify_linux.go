@@ -0,0 +1,222 @@
+package inotifyfswatcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-kit/kit/log/levels"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+type inotifyBackend struct {
+	logger     levels.Levels
+	dataFolder string
+	fd         int
+	file       *os.File
+
+	events chan<- *event
+	done   <-chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	watches map[int]string // watch descriptor => folder
+}
+
+func newBackend(logger levels.Levels, dataFolder string) (backend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	return &inotifyBackend{
+		logger:     logger,
+		dataFolder: dataFolder,
+		fd:         fd,
+		// a non blocking descriptor is handled by the runtime poller,
+		// so closing the file unblocks the pending reads.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: map[int]string{},
+	}, nil
+}
+
+func (b *inotifyBackend) start(events chan<- *event, done <-chan struct{}) error {
+	b.events = events
+	b.done = done
+	if err := b.addRecursive(b.dataFolder); err != nil {
+		return err
+	}
+	go b.read()
+	return nil
+}
+
+// send delivers the event unless the watcher has been stopped.
+func (b *inotifyBackend) send(e *event) {
+	select {
+	case b.events <- e:
+	case <-b.done:
+	}
+}
+
+func (b *inotifyBackend) stop() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.file.Close()
+}
+
+// addRecursive adds a watch to folder and all its descendant folders,
+// as inotify is not recursive.
+func (b *inotifyBackend) addRecursive(folder string) error {
+	return filepath.Walk(folder, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// the folder may have been removed during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		// hidden folders at the top level, like temporary folders, do not belong to any user.
+		if filepath.Dir(p) == b.dataFolder && strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(b.fd, p, watchMask)
+		if err != nil {
+			if err == syscall.ENOENT {
+				return nil
+			}
+			b.logger.Error().Log("error", err, "msg", "error adding watch", "folder", p)
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		b.mu.Lock()
+		b.watches[wd] = p
+		b.mu.Unlock()
+		return nil
+	})
+}
+
+// rename updates the folders of the watches after a folder has been moved,
+// as the watches follow the inode and not the path.
+func (b *inotifyBackend) rename(source, target string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for wd, folder := range b.watches {
+		if folder == source || strings.HasPrefix(folder, source+"/") {
+			b.watches[wd] = target + strings.TrimPrefix(folder, source)
+		}
+	}
+}
+
+// remove removes the watches of a folder moved outside the data folder.
+func (b *inotifyBackend) remove(folder string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for wd, f := range b.watches {
+		if f == folder || strings.HasPrefix(f, folder+"/") {
+			syscall.InotifyRmWatch(b.fd, uint32(wd))
+			delete(b.watches, wd)
+		}
+	}
+}
+
+func (b *inotifyBackend) folder(wd int) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	folder, ok := b.watches[wd]
+	return folder, ok
+}
+
+func (b *inotifyBackend) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := b.file.Read(buf)
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if !closed {
+				b.logger.Crit().Log("error", err, "msg", "error reading inotify events, watcher is not running")
+			}
+			return
+		}
+
+		// moves are reported as a pair of events sharing a cookie.
+		// Sources without target in the same read are moves outside the data folder.
+		movedFrom := map[uint32]*event{}
+		var cookies []uint32
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			mask := raw.Mask
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				b.send(&event{overflow: true})
+				continue
+			}
+			if mask&syscall.IN_IGNORED != 0 {
+				b.mu.Lock()
+				delete(b.watches, int(raw.Wd))
+				b.mu.Unlock()
+				continue
+			}
+
+			folder, ok := b.folder(int(raw.Wd))
+			if !ok || name == "" {
+				continue
+			}
+			p := filepath.Join(folder, name)
+			isDir := mask&syscall.IN_ISDIR != 0
+
+			switch {
+			case mask&syscall.IN_MOVED_FROM != 0:
+				movedFrom[raw.Cookie] = &event{path: p, kind: changeRemoved}
+				cookies = append(cookies, raw.Cookie)
+			case mask&syscall.IN_MOVED_TO != 0:
+				if from, ok := movedFrom[raw.Cookie]; ok {
+					delete(movedFrom, raw.Cookie)
+					if isDir {
+						b.rename(from.path, p)
+					}
+					b.send(&event{path: p, source: from.path, kind: changeMoved})
+					continue
+				}
+				if isDir {
+					b.addRecursive(p)
+					b.send(&event{path: p, kind: changeTree})
+					continue
+				}
+				b.send(&event{path: p, kind: changeModified})
+			case mask&syscall.IN_CREATE != 0:
+				if isDir {
+					// the folder can contain files created before the watch was added.
+					b.addRecursive(p)
+					b.send(&event{path: p, kind: changeTree})
+					continue
+				}
+				b.send(&event{path: p, kind: changeModified})
+			case mask&syscall.IN_CLOSE_WRITE != 0:
+				b.send(&event{path: p, kind: changeModified})
+			case mask&syscall.IN_DELETE != 0:
+				b.send(&event{path: p, kind: changeRemoved})
+			}
+		}
+
+		for _, cookie := range cookies {
+			if from, ok := movedFrom[cookie]; ok {
+				b.remove(from.path)
+				b.send(from)
+			}
+		}
+	}
+}
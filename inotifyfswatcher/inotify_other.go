@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package inotifyfswatcher
+
+import (
+	"errors"
+
+	"github.com/go-kit/kit/log/levels"
+)
+
+// newBackend fails on non Linux platforms as inotify is a Linux only API.
+func newBackend(logger levels.Levels, dataFolder string) (backend, error) {
+	return nil, errors.New("inotifyfswatcher: inotify is only available on Linux")
+}
@@ -0,0 +1,321 @@
+package inotifyfswatcher
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	defaultDebounce       = time.Second
+	defaultRescanInterval = time.Hour
+
+	// maxDebounceFactor limits how long a change can wait for the debounce period
+	// to expire, in number of debounce periods.
+	maxDebounceFactor = 10
+)
+
+// changeKind is the type of change detected on a path.
+type changeKind int
+
+const (
+	changeModified changeKind = iota
+	changeRemoved
+	changeMoved
+	changeTree
+)
+
+// change is a pending change waiting for the debounce period to expire.
+// Several events on the same path are collapsed into one change.
+type change struct {
+	kind   changeKind
+	source string // only set for changeMoved
+}
+
+// event is a filesystem event already decoded by the platform specific code.
+type event struct {
+	path   string
+	source string // only set for moves
+	kind   changeKind
+	// overflow is set when the kernel has dropped events, so a full rescan is needed.
+	overflow bool
+}
+
+type watcher struct {
+	logger         levels.Levels
+	dataFolder     string
+	syncer         lib.MetaDataSyncer
+	debounce       time.Duration
+	rescanInterval time.Duration
+	backend        backend
+	pending        map[string]*change
+	oldest         time.Time
+	events         chan *event
+	done           chan struct{}
+	stopped        chan struct{}
+
+	// mu protects started, the loop only runs, and closes stopped, after a successful Start.
+	mu      sync.Mutex
+	started bool
+}
+
+// backend is the platform specific part that watches the data folder.
+type backend interface {
+	start(events chan<- *event, done <-chan struct{}) error
+	stop() error
+}
+
+// New returns an implementation of FSWatcher that keeps the metadata of the
+// syncer in sync with the changes done directly on the data folder.
+// Changes are debounced during debounce and a full rescan is triggered every rescanInterval
+// to catch changes not reported by the kernel, like writes done on NFS by other hosts.
+func New(logger levels.Levels, dataFolder string, syncer lib.MetaDataSyncer, debounce, rescanInterval time.Duration) (lib.FSWatcher, error) {
+	logger = logger.With("pkg", "inotifyfswatcher")
+	if syncer == nil {
+		return nil, errors.New("inotifyfswatcher: metadata driver does not support syncing")
+	}
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+	if rescanInterval <= 0 {
+		rescanInterval = defaultRescanInterval
+	}
+
+	dataFolder = filepath.Clean(dataFolder)
+	b, err := newBackend(logger, dataFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &watcher{
+		logger:         logger,
+		dataFolder:     dataFolder,
+		syncer:         syncer,
+		debounce:       debounce,
+		rescanInterval: rescanInterval,
+		backend:        b,
+		pending:        map[string]*change{},
+		events:         make(chan *event, 1024),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}, nil
+}
+
+// Start starts watching the data folder.
+// A full rescan is done at start to pick up the changes done while the watcher was not running.
+func (w *watcher) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return errors.New("inotifyfswatcher: watcher already started")
+	}
+	if err := w.backend.start(w.events, w.done); err != nil {
+		w.logger.Error().Log("error", err)
+		return err
+	}
+	w.started = true
+	w.logger.Info().Log("msg", "watcher started", "datafolder", w.dataFolder, "debounce", w.debounce, "rescaninterval", w.rescanInterval)
+	go w.loop()
+	return nil
+}
+
+// Stop stops watching the data folder, pending changes are flushed.
+// Stopping a watcher that has not been started only releases the backend.
+func (w *watcher) Stop() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		// already stopped.
+		return nil
+	default:
+	}
+	err := w.backend.stop()
+	close(w.done)
+	if w.started {
+		<-w.stopped
+	}
+	w.logger.Info().Log("msg", "watcher stopped")
+	return err
+}
+
+func (w *watcher) loop() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.rescanInterval)
+	defer ticker.Stop()
+
+	// the timer is only armed when there are pending changes.
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	w.rescan()
+	for {
+		select {
+		case <-w.done:
+			w.flush()
+			return
+		case e := <-w.events:
+			if e.overflow {
+				w.logger.Warn().Log("msg", "events have been dropped by the kernel, full rescan needed")
+				w.pending = map[string]*change{}
+				w.rescan()
+				continue
+			}
+			w.add(e)
+			// a continuous stream of events must not delay the changes forever.
+			if w.oldest.IsZero() {
+				w.oldest = time.Now()
+			}
+			if time.Since(w.oldest) >= maxDebounceFactor*w.debounce {
+				timer.Stop()
+				w.flush()
+				continue
+			}
+			timer.Reset(w.debounce)
+		case <-timer.C:
+			w.flush()
+		case <-ticker.C:
+			w.flush()
+			w.rescan()
+		}
+	}
+}
+
+// add collapses the event into the pending changes.
+func (w *watcher) add(e *event) {
+	switch e.kind {
+	case changeMoved:
+		// the move carries the previous changes of the source.
+		delete(w.pending, e.source)
+		w.pending[e.path] = &change{kind: changeMoved, source: e.source}
+	case changeModified:
+		// a modification does not override a more complete change.
+		if _, ok := w.pending[e.path]; ok {
+			return
+		}
+		w.pending[e.path] = &change{kind: changeModified}
+	default:
+		w.pending[e.path] = &change{kind: e.kind}
+	}
+}
+
+// flush applies the pending changes, shortest paths first so parents are handled before children.
+func (w *watcher) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	ctx := context.Background()
+	for _, p := range paths {
+		ch := w.pending[p]
+		if err := w.apply(ctx, p, ch); err != nil {
+			w.logger.Error().Log("error", err, "msg", "error syncing change", "file", p)
+		}
+	}
+	w.logger.Info().Log("msg", "changes flushed", "numchanges", len(paths))
+	w.pending = map[string]*change{}
+	w.oldest = time.Time{}
+}
+
+func (w *watcher) apply(ctx context.Context, localPath string, ch *change) error {
+	user, path, ok := w.split(localPath)
+	if !ok {
+		return nil
+	}
+
+	switch ch.kind {
+	case changeMoved:
+		sourceUser, sourcePath, ok := w.split(ch.source)
+		if !ok {
+			return w.syncer.SyncTree(ctx, user, path)
+		}
+		if sourceUser.Username() != user.Username() {
+			// moves between homes are handled as a removal plus a creation.
+			if err := w.syncer.SyncPath(ctx, sourceUser, sourcePath); err != nil {
+				return err
+			}
+			return w.syncer.SyncTree(ctx, user, path)
+		}
+		return w.syncer.SyncMove(ctx, user, sourcePath, path)
+	case changeTree:
+		return w.syncer.SyncTree(ctx, user, path)
+	default:
+		return w.syncer.SyncPath(ctx, user, path)
+	}
+}
+
+// rescan reconciles the metadata of all the homes inside the data folder.
+func (w *watcher) rescan() {
+	start := time.Now()
+	fileInfos, err := ioutil.ReadDir(w.dataFolder)
+	if err != nil {
+		w.logger.Error().Log("error", err, "msg", "error reading data folder")
+		return
+	}
+
+	ctx := context.Background()
+	for _, fi := range fileInfos {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		if err := w.syncer.SyncTree(ctx, &user{username: fi.Name()}, "/"); err != nil {
+			w.logger.Error().Log("error", err, "msg", "error rescanning home", "user", fi.Name())
+		}
+	}
+	w.logger.Info().Log("msg", "data folder rescanned", "numhomes", len(fileInfos), "duration", time.Since(start))
+}
+
+// split converts a local path into the user owning it and the path relative to its home.
+// Ex: /data/demo/photos/1.png => demo, /photos/1.png
+func (w *watcher) split(localPath string) (lib.User, string, bool) {
+	rel, err := filepath.Rel(w.dataFolder, localPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, "", false
+	}
+	tokens := strings.SplitN(rel, string(filepath.Separator), 2)
+	if strings.HasPrefix(tokens[0], ".") {
+		return nil, "", false
+	}
+	path := "/"
+	if len(tokens) == 2 {
+		path = "/" + tokens[1]
+	}
+	return &user{username: tokens[0]}, path, true
+}
+
+// user is the minimal user derived from the name of a home folder.
+// Metadata drivers only rely on the username to locate resources.
+type user struct {
+	username string
+}
+
+func (u *user) Username() string {
+	return u.username
+}
+
+func (u *user) Email() string {
+	return ""
+}
+
+func (u *user) DisplayName() string {
+	return u.username
+}
+
+func (u *user) ExtraAttributes() map[string]interface{} {
+	return nil
+}
@@ -27,6 +27,9 @@ func (a *authenticator) CreateToken(user lib.User) (string, error) {
 	claims["username"] = user.Username()
 	claims["email"] = user.Email()
 	claims["display_name"] = user.DisplayName()
+	if groups, ok := user.ExtraAttributes()["groups"].([]string); ok {
+		claims["groups"] = groups
+	}
 	claims["exp"] = time.Now().Add(time.Second * 3600).UnixNano()
 	return token.SignedString([]byte(a.key))
 }
@@ -62,10 +65,22 @@ func (a *authenticator) getUserFromRawToken(rawToken *jwt.Token) (lib.User, erro
 		a.logger.Error().Log("error", err)
 		return nil, err
 	}
+
+	// groups is optional, tokens created before it was added do not have it.
+	var groups []string
+	if rawGroups, ok := claims["groups"].([]interface{}); ok {
+		for _, g := range rawGroups {
+			if group, ok := g.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+
 	return &user{
 		username:    username,
 		email:       email,
 		displayName: displayName,
+		groups:      groups,
 	}, nil
 }
 
@@ -105,6 +120,7 @@ type user struct {
 	username    string
 	email       string
 	displayName string
+	groups      []string
 }
 
 func (u *user) Username() string {
@@ -120,5 +136,8 @@ func (u *user) DisplayName() string {
 }
 
 func (u *user) ExtraAttributes() map[string]interface{} {
-	return nil
+	if len(u.groups) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"groups": u.groups}
 }
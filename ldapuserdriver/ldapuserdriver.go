@@ -62,7 +62,7 @@ func (c *driver) GetByCredentials(username, password string) (lib.User, error) {
 		c.baseDN,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		fmt.Sprintf(c.filter, username),
-		[]string{"dn"},
+		[]string{"dn", "memberOf"},
 		nil,
 	)
 
@@ -79,7 +79,8 @@ func (c *driver) GetByCredentials(username, password string) (lib.User, error) {
 	}
 
 	userdn := sr.Entries[0].DN
-	c.logger.Info().Log("msg", "user exists", "dn", userdn)
+	groups := getGroups(sr.Entries[0].GetAttributeValues("memberOf"))
+	c.logger.Info().Log("msg", "user exists", "dn", userdn, "numgroups", len(groups))
 
 	// Bind as the user to verify their password
 	err = l.Bind(userdn, password)
@@ -92,14 +93,30 @@ func (c *driver) GetByCredentials(username, password string) (lib.User, error) {
 	// TODO(labkode) Get more attrs from LDAP query like email and displayName at least
 	u := &user{
 		username: username,
+		groups:   groups,
 	}
 	return u, nil
 }
 
+// getGroups returns the names of the groups in the memberOf values, the value of
+// the first attribute of their DNs, so group shares can be resolved for LDAP users.
+func getGroups(memberOf []string) []string {
+	groups := []string{}
+	for _, v := range memberOf {
+		dn, err := ldap.ParseDN(v)
+		if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		groups = append(groups, dn.RDNs[0].Attributes[0].Value)
+	}
+	return groups
+}
+
 type user struct {
 	username    string
 	email       string
 	displayName string
+	groups      []string
 }
 
 func (u *user) Username() string {
@@ -115,7 +132,10 @@ func (u *user) DisplayName() string {
 }
 
 func (u *user) ExtraAttributes() map[string]interface{} {
-	return nil
+	if len(u.groups) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"groups": u.groups}
 }
 
 type userNotFoundError string
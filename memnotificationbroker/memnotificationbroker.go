@@ -0,0 +1,72 @@
+package memnotificationbroker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+// subscriberBuffer is the number of notifications a subscriber can have pending
+// before new notifications for it are dropped.
+const subscriberBuffer = 64
+
+type broker struct {
+	logger      levels.Levels
+	mu          sync.RWMutex
+	subscribers map[string]map[chan lib.Notification]struct{} // username => subscribers
+}
+
+// New returns an implementation of NotificationBroker that delivers
+// the notifications to the subscribers of the same process.
+func New(logger levels.Levels) lib.NotificationBroker {
+	logger = logger.With("pkg", "memnotificationbroker")
+	return &broker{
+		logger:      logger,
+		subscribers: map[string]map[chan lib.Notification]struct{}{},
+	}
+}
+
+// Publish delivers the notification to the subscribers of its user.
+// Slow subscribers do not block the publisher, the notification is dropped for them.
+func (b *broker) Publish(ctx context.Context, notification lib.Notification) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers[notification.Username()] {
+		select {
+		case ch <- notification:
+		default:
+			b.logger.Warn().Log("msg", "subscriber is too slow, notification dropped", "user", notification.Username(), "path", notification.Path())
+		}
+	}
+	return nil
+}
+
+func (b *broker) Subscribe(user lib.User) (<-chan lib.Notification, func()) {
+	ch := make(chan lib.Notification, subscriberBuffer)
+
+	b.mu.Lock()
+	if _, ok := b.subscribers[user.Username()]; !ok {
+		b.subscribers[user.Username()] = map[chan lib.Notification]struct{}{}
+	}
+	b.subscribers[user.Username()][ch] = struct{}{}
+	b.mu.Unlock()
+	b.logger.Info().Log("msg", "subscribed", "user", user.Username())
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[user.Username()], ch)
+			if len(b.subscribers[user.Username()]) == 0 {
+				delete(b.subscribers, user.Username())
+			}
+			b.mu.Unlock()
+			// Publish holds the read lock while sending, so no send can happen after this point.
+			close(ch)
+			b.logger.Info().Log("msg", "unsubscribed", "user", user.Username())
+		})
+	}
+	return ch, cancel
+}
@@ -6,18 +6,24 @@ import (
 	"strings"
 )
 
+// New returns a UserDriver with the users in userList.
+// Users are separated by commas and have the form username:password:email:displayname[:group1;group2].
 func New(userList string) lib.UserDriver {
 	users := []*user{}
 	userTokens := strings.Split(userList, ",")
 	for _, userToken := range userTokens {
 		fields := strings.Split(userToken, ":")
 		if len(fields) >= 4 {
-			users = append(users, &user{
+			u := &user{
 				username:    fields[0],
 				password:    fields[1],
 				email:       fields[2],
 				displayName: fields[3],
-			})
+			}
+			if len(fields) >= 5 && fields[4] != "" {
+				u.groups = strings.Split(fields[4], ";")
+			}
+			users = append(users, u)
 		}
 	}
 	return &driver{users: users}
@@ -37,6 +43,7 @@ type user struct {
 	email       string
 	displayName string
 	password    string
+	groups      []string
 }
 
 func (u *user) Username() string {
@@ -52,7 +59,10 @@ func (u *user) DisplayName() string {
 }
 
 func (u *user) ExtraAttributes() map[string]interface{} {
-	return nil
+	if len(u.groups) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"groups": u.groups}
 }
 
 type driver struct {
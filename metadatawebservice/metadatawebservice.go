@@ -3,12 +3,27 @@ package metadatawebservice
 import (
 	"net/http"
 
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/clawio/lib/folderpage"
 	"github.com/go-kit/kit/log/levels"
 	"path/filepath"
 )
 
+const (
+	// maxBatchOperations limits the work done in a single request.
+	maxBatchOperations = 1000
+
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
 type service struct {
 	cm             lib.ContextManager
 	logger         levels.Levels
@@ -53,6 +68,24 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/meta/makefolder": {
 			"POST": s.am.HandlerFunc(s.makeFolderEndpoint),
 		},
+		"/meta/changes": {
+			"POST": s.am.HandlerFunc(s.changesEndpoint),
+		},
+		"/meta/examine-by-id": {
+			"POST": s.am.HandlerFunc(s.examineByIDEndpoint),
+		},
+		"/meta/batch": {
+			"POST": s.am.HandlerFunc(s.batchEndpoint),
+		},
+		"/meta/search": {
+			"POST": s.am.HandlerFunc(s.searchEndpoint),
+		},
+		"/meta/searchcontent": {
+			"POST": s.am.HandlerFunc(s.searchContentEndpoint),
+		},
+		"/meta/setmodtime": {
+			"POST": s.am.HandlerFunc(s.setModTimeEndpoint),
+		},
 	}
 }
 
@@ -106,11 +139,12 @@ func (s *service) handleExamineEndpointError(err error, w http.ResponseWriter, r
 	return
 }
 
+// listFolderEndpoint returns all the entries of the folder, or a page of them when the request has a page query.
 func (s *service) listFolderEndpoint(w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
 
-	req := &pathRequest{}
+	req := &listFolderRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		logger.Error().Log("error", err)
 		codeErr := badRequestError("invalid json")
@@ -125,6 +159,11 @@ func (s *service) listFolderEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Page != nil {
+		s.listFolderPage(w, r, req.Path, *req.Page)
+		return
+	}
+
 	fileInfos, err := s.metaDataDriver.ListFolder(r.Context(), user, req.Path)
 	if err != nil {
 		s.handleListFolderEndpointError(err, w, r)
@@ -145,6 +184,65 @@ func (s *service) listFolderEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.Write(fileInfosJSON)
 }
 
+func (s *service) listFolderPage(w http.ResponseWriter, r *http.Request, path string, query lib.ListQuery) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	if query.Limit <= 0 {
+		query.Limit = defaultListLimit
+	}
+	if query.Limit > maxListLimit {
+		query.Limit = maxListLimit
+	}
+
+	page, err := s.getFolderPage(r.Context(), user, path, query)
+	if err != nil {
+		s.handleListFolderEndpointError(err, w, r)
+		return
+	}
+	res := &listFolderPageResponse{FileInfos: []*fileInfoResponse{}, ContinuationToken: page.ContinuationToken()}
+	for _, fi := range page.FileInfos() {
+		res.FileInfos = append(res.FileInfos, fileInfoToFileInfoResponse(fi))
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// getFolderPage uses the driver when it can page folders, else the whole folder is listed and paged in memory.
+func (s *service) getFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	if folderPager, ok := s.metaDataDriver.(lib.FolderPager); ok && capability.Supports(s.metaDataDriver, lib.CapabilityFolderPager) {
+		page, err := folderPager.ListFolderPage(ctx, user, path, query)
+		codeErr, ok := err.(lib.Error)
+		if err == nil || !ok || codeErr.Code() != lib.CodeNotSupported {
+			return page, err
+		}
+	}
+
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badRequestError(err.Error())
+	}
+	fileInfos, err := s.metaDataDriver.ListFolder(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fileInfos {
+		builder.Add(folderpage.NewKey(fi), fi.Folder(), fi)
+	}
+	items, continuationToken := builder.Page()
+	page := &folderPage{fileInfos: []lib.FileInfo{}, continuationToken: continuationToken}
+	for _, item := range items {
+		page.fileInfos = append(page.fileInfos, item.(lib.FileInfo))
+	}
+	return page, nil
+}
+
 func (s *service) handleListFolderEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	logger.Error().Log("error", err)
@@ -215,7 +313,7 @@ func (s *service) handleMoveEndpointError(err error, w http.ResponseWriter, r *h
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		if codeErr.Code() == lib.CodeBadInputData {
+		if codeErr.Code() == lib.CodeBadInputData || codeErr.Code() == lib.CodeInvalidName {
 			jsonErr, err := s.wec.ErrorToJSON(codeErr)
 			if err != nil {
 				s.logger.Error().Log("error", err)
@@ -309,7 +407,7 @@ func (s *service) handleMakeFolderEndpointError(err error, w http.ResponseWriter
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		if codeErr.Code() == lib.CodeBadInputData {
+		if codeErr.Code() == lib.CodeBadInputData || codeErr.Code() == lib.CodeInvalidName {
 			jsonErr, err := s.wec.ErrorToJSON(codeErr)
 			if err != nil {
 				s.logger.Error().Log("error", err)
@@ -331,6 +429,438 @@ func (s *service) handleMakeFolderEndpointError(err error, w http.ResponseWriter
 	return
 }
 
+func (s *service) changesEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	changeFeed, ok := s.metaDataDriver.(lib.ChangeFeed)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityChangeFeed) {
+		logger.Warn().Log("msg", "metadata driver does not support change feeds")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	req := &changesRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	changeSet, err := changeFeed.GetChanges(r.Context(), user, req.Cursor, req.Limit)
+	if err != nil {
+		s.handleChangesEndpointError(err, w, r)
+		return
+	}
+	changesJSON, err := json.Marshal(changeSetToChangesResponse(changeSet))
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(changesJSON)
+}
+
+func (s *service) handleChangesEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeBadInputData {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				s.logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+		if codeErr.Code() == lib.CodeNotSupported {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error getting changes")
+	w.WriteHeader(http.StatusInternalServerError)
+	return
+}
+
+func (s *service) examineByIDEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	fileIDResolver, ok := s.metaDataDriver.(lib.FileIDResolver)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityFileIDResolver) {
+		logger.Warn().Log("msg", "metadata driver does not support file ids")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	req := &idRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.ID == "" {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	fileInfo, err := fileIDResolver.ExamineByID(r.Context(), user, req.ID)
+	if err != nil {
+		s.handleExamineByIDEndpointError(err, w, r)
+		return
+	}
+	fileInfoJSON, err := json.Marshal(fileInfoToFileInfoResponse(fileInfo))
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(fileInfoJSON)
+}
+
+// setModTimeEndpoint sets the modification time of a resource, mtime is in nanoseconds since the epoch.
+func (s *service) setModTimeEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	modTimeSetter, ok := s.metaDataDriver.(lib.ModTimeSetter)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityModTimeSetter) {
+		logger.Warn().Log("msg", "metadata driver does not support setting modification times")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	req := &setModTimeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.ModTime <= 0 {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	if err := modTimeSetter.SetModTime(r.Context(), user, req.Path, req.ModTime); err != nil {
+		s.handleSetModTimeEndpointError(err, w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *service) handleSetModTimeEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeNotSupported {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+	}
+
+	logger.Error().Log("error", err, "msg", "unexpected error setting modification time")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (s *service) handleExamineByIDEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeNotSupported {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+	}
+
+	logger.Error().Log("error", err, "msg", "unexpected error examining file by id")
+	w.WriteHeader(http.StatusInternalServerError)
+	return
+}
+
+// batchEndpoint executes an ordered list of operations and returns the result of each one.
+// The status code is 200 whenever the request is valid, failures are reported per operation.
+func (s *service) batchEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &batchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	if len(req.Operations) > maxBatchOperations {
+		codeErr := badRequestError(fmt.Sprintf("a batch can not have more than %d operations", maxBatchOperations))
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+
+	results := []*batchResultResponse{}
+	var failed bool
+	for _, op := range req.Operations {
+		if failed && req.StopOnError {
+			results = append(results, &batchResultResponse{Skipped: true})
+			continue
+		}
+		result := s.executeBatchOperation(r, user, op)
+		if result.Error != nil {
+			failed = true
+		}
+		results = append(results, result)
+	}
+
+	data, err := json.Marshal(&batchResponse{Results: results})
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Info().Log("msg", "batch executed", "numoperations", len(req.Operations), "failed", failed)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// executeBatchOperation executes a single operation applying the same checks as the single endpoints.
+func (s *service) executeBatchOperation(r *http.Request, user lib.User, op lib.BatchOperation) *batchResultResponse {
+	logger := s.cm.MustGetLog(r.Context())
+
+	var err error
+	result := &batchResultResponse{}
+	switch op.Kind {
+	case lib.BatchExamine:
+		var fileInfo lib.FileInfo
+		if fileInfo, err = s.metaDataDriver.Examine(r.Context(), user, op.Path); err == nil {
+			result.FileInfo = fileInfoToFileInfoResponse(fileInfo)
+		}
+	case lib.BatchListFolder:
+		var fileInfos []lib.FileInfo
+		if fileInfos, err = s.metaDataDriver.ListFolder(r.Context(), user, op.Path); err == nil {
+			result.FileInfos = []*fileInfoResponse{}
+			for _, fi := range fileInfos {
+				result.FileInfos = append(result.FileInfos, fileInfoToFileInfoResponse(fi))
+			}
+		}
+	case lib.BatchMove:
+		sourcePath := filepath.Clean("/" + op.Source)
+		targetPath := filepath.Clean("/" + op.Target)
+		if sourcePath == "/" || targetPath == "/" {
+			err = forbiddenError("lib can not be moved")
+		} else {
+			err = s.metaDataDriver.Move(r.Context(), user, sourcePath, targetPath)
+		}
+	case lib.BatchDelete:
+		if filepath.Clean("/"+op.Path) == "/" {
+			err = forbiddenError("lib can not be deleted")
+		} else {
+			err = s.metaDataDriver.Delete(r.Context(), user, op.Path)
+		}
+	case lib.BatchCreateFolder:
+		err = s.metaDataDriver.CreateFolder(r.Context(), user, op.Path)
+	default:
+		err = badRequestError(fmt.Sprintf("operation kind %d not supported", op.Kind))
+	}
+
+	if err != nil {
+		codeErr, ok := err.(lib.Error)
+		if !ok {
+			logger.Error().Log("error", err, "msg", "unexpected error executing batch operation", "kind", op.Kind)
+			codeErr = internalError("unexpected error")
+		}
+		result.Error = &batchErrorResponse{Code: codeErr.Code(), Message: codeErr.Message()}
+	}
+	return result
+}
+
+func (s *service) searchEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	searcher, ok := s.metaDataDriver.(lib.Searcher)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilitySearcher) {
+		logger.Warn().Log("msg", "metadata driver does not support search")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	query := &lib.SearchQuery{}
+	if err := json.NewDecoder(r.Body).Decode(query); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	result, err := searcher.Search(r.Context(), user, *query)
+	if err != nil {
+		s.handleSearchEndpointError(err, w, r)
+		return
+	}
+
+	res := &searchResponse{FileInfos: []*fileInfoResponse{}, HasMore: result.HasMore()}
+	for _, fi := range result.FileInfos() {
+		res.FileInfos = append(res.FileInfos, fileInfoToFileInfoResponse(fi))
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// searchContentEndpoint returns the files whose contents have all the words of the query, the most relevant first.
+func (s *service) searchContentEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	contentSearcher, ok := s.metaDataDriver.(lib.ContentSearcher)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityContentSearcher) {
+		logger.Warn().Log("msg", "metadata driver does not support content search")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	query := &lib.ContentQuery{}
+	if err := json.NewDecoder(r.Body).Decode(query); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	result, err := contentSearcher.SearchContent(r.Context(), user, *query)
+	if err != nil {
+		s.handleSearchEndpointError(err, w, r)
+		return
+	}
+
+	res := &searchContentResponse{Matches: []*contentMatchResponse{}, HasMore: result.HasMore()}
+	for _, m := range result.Matches() {
+		res.Matches = append(res.Matches, &contentMatchResponse{Path: m.Path(), Score: m.Score(), Snippet: m.Snippet()})
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *service) handleSearchEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		switch codeErr.Code() {
+		case lib.CodeNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case lib.CodeForbidden:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case lib.CodeNotSupported:
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		case lib.CodeBadInputData:
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				s.logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error searching")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
 type badRequestError string
 
 func (e badRequestError) Error() string {
@@ -343,6 +873,30 @@ func (e badRequestError) Message() string {
 	return string(e)
 }
 
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
+
+type internalError string
+
+func (e internalError) Error() string {
+	return string(e)
+}
+func (e internalError) Code() lib.Code {
+	return lib.Code(lib.CodeInternal)
+}
+func (e internalError) Message() string {
+	return string(e)
+}
+
 func fileInfoToFileInfoResponse(fileInfo lib.FileInfo) *fileInfoResponse {
 	return &fileInfoResponse{
 		Path:            fileInfo.Path(),
@@ -354,6 +908,45 @@ func fileInfoToFileInfoResponse(fileInfo lib.FileInfo) *fileInfoResponse {
 	}
 }
 
+func changeSetToChangesResponse(changeSet lib.ChangeSet) *changesResponse {
+	changeResponses := []*changeResponse{}
+	for _, c := range changeSet.Changes() {
+		changeResponses = append(changeResponses, &changeResponse{
+			Kind:       c.Kind(),
+			ID:         c.ID(),
+			Path:       c.Path(),
+			SourcePath: c.SourcePath(),
+			Timestamp:  c.Timestamp(),
+		})
+	}
+	return &changesResponse{
+		Changes: changeResponses,
+		Cursor:  changeSet.Cursor(),
+		HasMore: changeSet.HasMore(),
+		Reset:   changeSet.Reset(),
+	}
+}
+
+type changesRequest struct {
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+type changesResponse struct {
+	Changes []*changeResponse `json:"changes"`
+	Cursor  string            `json:"cursor"`
+	HasMore bool              `json:"has_more"`
+	Reset   bool              `json:"reset"`
+}
+
+type changeResponse struct {
+	Kind       lib.ChangeKind `json:"kind"`
+	ID         string         `json:"id"`
+	Path       string         `json:"path"`
+	SourcePath string         `json:"source_path,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+}
+
 type fileInfoResponse struct {
 	Path            string                 `json:"path"`
 	Folder          bool                   `json:"folder"`
@@ -363,10 +956,79 @@ type fileInfoResponse struct {
 	ExtraAttributes map[string]interface{} `json:"extra_attributes"`
 }
 
+type batchRequest struct {
+	Operations  []lib.BatchOperation `json:"operations"`
+	StopOnError bool                 `json:"stop_on_error"`
+}
+
+type batchResponse struct {
+	Results []*batchResultResponse `json:"results"`
+}
+
+type batchResultResponse struct {
+	Error     *batchErrorResponse `json:"error,omitempty"`
+	Skipped   bool                `json:"skipped,omitempty"`
+	FileInfo  *fileInfoResponse   `json:"file_info,omitempty"`
+	FileInfos []*fileInfoResponse `json:"file_infos,omitempty"`
+}
+
+type batchErrorResponse struct {
+	Code    lib.Code `json:"code"`
+	Message string   `json:"message"`
+}
+
+type searchResponse struct {
+	FileInfos []*fileInfoResponse `json:"file_infos"`
+	HasMore   bool                `json:"has_more"`
+}
+
+type searchContentResponse struct {
+	Matches []*contentMatchResponse `json:"matches"`
+	HasMore bool                    `json:"has_more"`
+}
+
+type contentMatchResponse struct {
+	Path    string  `json:"path"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type listFolderRequest struct {
+	Path string         `json:"path"`
+	Page *lib.ListQuery `json:"page"`
+}
+
+type listFolderPageResponse struct {
+	FileInfos         []*fileInfoResponse `json:"file_infos"`
+	ContinuationToken string              `json:"continuation_token"`
+}
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
 type pathRequest struct {
 	Path string `json:"path"`
 }
 
+type idRequest struct {
+	ID string `json:"id"`
+}
+
+type setModTimeRequest struct {
+	Path    string `json:"path"`
+	ModTime int64  `json:"mtime"`
+}
+
 type moveRequest struct {
 	Source string `json:"source"`
 	Target string `json:"target"`
@@ -206,6 +206,56 @@ func (c *webServiceClient) Delete(ctx context.Context, user lib.User, path strin
 	return internalError(fmt.Sprintf("error deleting on remote"))
 }
 
+func (c *webServiceClient) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	setModTimeReq := &setModTimeReq{Path: path, ModTime: modTime}
+	jsonBody, err := json.Marshal(setModTimeReq)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding set modification time request")
+		return err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url+"/setmodtime", bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return notFoundError("")
+	}
+
+	if res.StatusCode == http.StatusForbidden {
+		return forbiddenError("")
+	}
+
+	if res.StatusCode == http.StatusNotImplemented {
+		return notSupportedError("remote metadata driver does not support setting modification times")
+	}
+
+	c.logger.Error().Log("error", "error setting modification time on remote", "httpstatuscode", res.StatusCode)
+	return internalError(fmt.Sprintf("error setting modification time on remote"))
+}
+
 func (c *webServiceClient) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
 	traceID := c.cm.MustGetTraceID(ctx)
 	token := c.cm.MustGetAccessToken(ctx)
@@ -233,7 +283,7 @@ func (c *webServiceClient) Move(ctx context.Context, user lib.User, sourcePath,
 		return err
 	}
 	defer res.Body.Close()
-	ioutil.ReadAll(res.Body)
+	body, _ := ioutil.ReadAll(res.Body)
 
 	if res.StatusCode == http.StatusOK {
 		return nil
@@ -243,6 +293,10 @@ func (c *webServiceClient) Move(ctx context.Context, user lib.User, sourcePath,
 		return notFoundError("")
 	}
 
+	if res.StatusCode == http.StatusBadRequest {
+		return decodeRemoteError(body, "move rejected by remote")
+	}
+
 	c.logger.Error().Log("error", "error moving on remote", "httpstatuscode", res.StatusCode)
 	return internalError(fmt.Sprintf("error moving on remote"))
 }
@@ -274,20 +328,371 @@ func (c *webServiceClient) CreateFolder(ctx context.Context, user lib.User, path
 		return err
 	}
 	defer res.Body.Close()
-	ioutil.ReadAll(res.Body)
+	body, _ := ioutil.ReadAll(res.Body)
 
 	if res.StatusCode == http.StatusOK {
 		return nil
 	}
 
+	if res.StatusCode == http.StatusBadRequest {
+		return decodeRemoteError(body, "folder creation rejected by remote")
+	}
+
 	c.logger.Error().Log("error", "error creating folder on remote", "httpstatuscode", res.StatusCode)
 	return internalError("error creating folder on remote")
 }
 
+func (c *webServiceClient) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	changesReq := &changesReq{Cursor: cursor, Limit: limit}
+	jsonBody, err := json.Marshal(changesReq)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding changes request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url+"/changes", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		cs := &changeSet{}
+		err = json.Unmarshal(body, cs)
+		return cs, err
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		return nil, badInputError("invalid cursor")
+	}
+
+	c.logger.Error().Log("error", "error getting changes on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error getting changes on remote")
+}
+
+func (c *webServiceClient) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	idReq := &idReq{ID: id}
+	jsonBody, err := json.Marshal(idReq)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding id request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url+"/examine-by-id", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		fi := &fileInfo{}
+		err = json.Unmarshal(body, fi)
+		return fi, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, notFoundError("")
+	}
+
+	if res.StatusCode == http.StatusNotImplemented {
+		return nil, notSupportedError("remote metadata driver does not support file ids")
+	}
+
+	c.logger.Error().Log("error", "error examining by id on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error examining by id on remote")
+}
+
+func (c *webServiceClient) Batch(ctx context.Context, user lib.User, operations []lib.BatchOperation, stopOnError bool) ([]lib.BatchResult, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	batchReq := &batchReq{Operations: operations, StopOnError: stopOnError}
+	jsonBody, err := json.Marshal(batchReq)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding batch request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url+"/batch", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		batchRes := &batchRes{}
+		if err := json.Unmarshal(body, batchRes); err != nil {
+			c.logger.Error().Log("error", err)
+			return nil, err
+		}
+		results := []lib.BatchResult{}
+		for _, r := range batchRes.Results {
+			results = append(results, r)
+		}
+		return results, nil
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		return nil, badInputError("batch request rejected by remote")
+	}
+
+	c.logger.Error().Log("error", "error executing batch on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error executing batch on remote")
+}
+
+func (c *webServiceClient) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	jsonBody, err := json.Marshal(query)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding search request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url+"/search", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		result := &searchResult{}
+		err = json.Unmarshal(body, result)
+		return result, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, notFoundError("")
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		return nil, badInputError("search rejected by remote")
+	}
+
+	if res.StatusCode == http.StatusNotImplemented {
+		return nil, notSupportedError("remote metadata driver does not support search")
+	}
+
+	c.logger.Error().Log("error", "error searching on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error searching on remote")
+}
+
+func (c *webServiceClient) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	jsonBody, err := json.Marshal(query)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding content search request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url+"/searchcontent", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		result := &contentSearchResult{}
+		err = json.Unmarshal(body, result)
+		return result, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, notFoundError("")
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		return nil, badInputError("content search rejected by remote")
+	}
+
+	if res.StatusCode == http.StatusNotImplemented {
+		return nil, notSupportedError("remote metadata driver does not support content search")
+	}
+
+	c.logger.Error().Log("error", "error searching contents on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error searching contents on remote")
+}
+
+func (c *webServiceClient) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	traceID := c.cm.MustGetTraceID(ctx)
+	token := c.cm.MustGetAccessToken(ctx)
+
+	jsonBody, err := json.Marshal(&listFolderReq{Path: path, Page: &query})
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding list request")
+		return nil, err
+	}
+
+	url, err := c.getMetaDataURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url+"/list", bytes.NewReader(jsonBody))
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	req.Header.Add("authorization", "Bearer "+token)
+	req.Header.Add("x-clawio-tid", traceID)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		page := &folderPage{}
+		err = json.Unmarshal(body, page)
+		return page, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, notFoundError("")
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		return nil, badInputError("list rejected by remote")
+	}
+
+	c.logger.Error().Log("error", "error listing on remote", "httpstatuscode", res.StatusCode)
+	return nil, internalError("error listing on remote")
+}
+
+type listFolderReq struct {
+	Path string         `json:"path"`
+	Page *lib.ListQuery `json:"page"`
+}
+
 type pathReq struct {
 	Path string `json:"path"`
 }
 
+type idReq struct {
+	ID string `json:"id"`
+}
+
+type setModTimeReq struct {
+	Path    string `json:"path"`
+	ModTime int64  `json:"mtime"`
+}
+
 type fileInfo struct {
 	XPath            string                 `json:"path"`
 	XFolder          bool                   `json:"folder"`
@@ -321,6 +726,206 @@ func (f *fileInfo) ExtraAttributes() map[string]interface{} {
 	return f.XExtraAttributes
 }
 
+type changesReq struct {
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+type changeSet struct {
+	XChanges []*change `json:"changes"`
+	XCursor  string    `json:"cursor"`
+	XHasMore bool      `json:"has_more"`
+	XReset   bool      `json:"reset"`
+}
+
+func (s *changeSet) Changes() []lib.Change {
+	changes := []lib.Change{}
+	for _, c := range s.XChanges {
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func (s *changeSet) Cursor() string {
+	return s.XCursor
+}
+
+func (s *changeSet) HasMore() bool {
+	return s.XHasMore
+}
+
+func (s *changeSet) Reset() bool {
+	return s.XReset
+}
+
+type change struct {
+	XKind       lib.ChangeKind `json:"kind"`
+	XID         string         `json:"id"`
+	XPath       string         `json:"path"`
+	XSourcePath string         `json:"source_path"`
+	XTimestamp  int64          `json:"timestamp"`
+}
+
+func (c *change) Kind() lib.ChangeKind {
+	return c.XKind
+}
+
+func (c *change) ID() string {
+	return c.XID
+}
+
+func (c *change) Path() string {
+	return c.XPath
+}
+
+func (c *change) SourcePath() string {
+	return c.XSourcePath
+}
+
+func (c *change) Timestamp() int64 {
+	return c.XTimestamp
+}
+
+type folderPage struct {
+	XFileInfos         []*fileInfo `json:"file_infos"`
+	XContinuationToken string      `json:"continuation_token"`
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	fileInfos := []lib.FileInfo{}
+	for _, fi := range p.XFileInfos {
+		fileInfos = append(fileInfos, fi)
+	}
+	return fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.XContinuationToken
+}
+
+type searchResult struct {
+	XFileInfos []*fileInfo `json:"file_infos"`
+	XHasMore   bool        `json:"has_more"`
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	fileInfos := []lib.FileInfo{}
+	for _, fi := range r.XFileInfos {
+		fileInfos = append(fileInfos, fi)
+	}
+	return fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.XHasMore
+}
+
+type contentSearchResult struct {
+	XMatches []*contentMatch `json:"matches"`
+	XHasMore bool            `json:"has_more"`
+}
+
+func (r *contentSearchResult) Matches() []lib.ContentMatch {
+	matches := []lib.ContentMatch{}
+	for _, m := range r.XMatches {
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func (r *contentSearchResult) HasMore() bool {
+	return r.XHasMore
+}
+
+type contentMatch struct {
+	XPath    string  `json:"path"`
+	XScore   float64 `json:"score"`
+	XSnippet string  `json:"snippet"`
+}
+
+func (m *contentMatch) Path() string {
+	return m.XPath
+}
+
+func (m *contentMatch) Score() float64 {
+	return m.XScore
+}
+
+func (m *contentMatch) Snippet() string {
+	return m.XSnippet
+}
+
+type batchReq struct {
+	Operations  []lib.BatchOperation `json:"operations"`
+	StopOnError bool                 `json:"stop_on_error"`
+}
+
+type batchRes struct {
+	Results []*batchResult `json:"results"`
+}
+
+type batchResult struct {
+	XError     *remoteError `json:"error"`
+	XSkipped   bool         `json:"skipped"`
+	XFileInfo  *fileInfo    `json:"file_info"`
+	XFileInfos []*fileInfo  `json:"file_infos"`
+}
+
+func (r *batchResult) Err() lib.Error {
+	if r.XError == nil {
+		return nil
+	}
+	return r.XError
+}
+
+func (r *batchResult) Skipped() bool {
+	return r.XSkipped
+}
+
+func (r *batchResult) FileInfo() lib.FileInfo {
+	if r.XFileInfo == nil {
+		return nil
+	}
+	return r.XFileInfo
+}
+
+func (r *batchResult) FileInfos() []lib.FileInfo {
+	if r.XFileInfos == nil {
+		return nil
+	}
+	fileInfos := []lib.FileInfo{}
+	for _, fi := range r.XFileInfos {
+		fileInfos = append(fileInfos, fi)
+	}
+	return fileInfos
+}
+
+// remoteError is an error returned by the remote service with its original code.
+type remoteError struct {
+	XCode    lib.Code `json:"code"`
+	XMessage string   `json:"message"`
+}
+
+func (e *remoteError) Error() string {
+	return e.XMessage
+}
+func (e *remoteError) Code() lib.Code {
+	return e.XCode
+}
+func (e *remoteError) Message() string {
+	return e.XMessage
+}
+
+// decodeRemoteError returns the error encoded in body by the remote service, keeping its code,
+// or a badInputError with msg when the body can not be decoded.
+func decodeRemoteError(body []byte, msg string) lib.Error {
+	remoteErr := &remoteError{}
+	if err := json.Unmarshal(body, remoteErr); err != nil || remoteErr.XMessage == "" {
+		return badInputError(msg)
+	}
+	return remoteErr
+}
+
 type internalError string
 
 func (e internalError) Error() string {
@@ -345,7 +950,43 @@ func (e notFoundError) Message() string {
 	return string(e)
 }
 
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
 type moveRequest struct {
 	Source string `json:"source"`
 	Target string `json:"target"`
 }
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
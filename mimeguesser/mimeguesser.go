@@ -15,7 +15,7 @@ func New() lib.MimeGuesser {
 }
 
 func (m *guesser) FromString(name string) string {
-	return mime.TypeByExtension(filepath.Base(name))
+	return mime.TypeByExtension(filepath.Ext(name))
 }
 
 func (m *guesser) FromFileInfo(fileInfo lib.FileInfo) string {
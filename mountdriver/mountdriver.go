@@ -0,0 +1,685 @@
+package mountdriver
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/clawio/lib/folderpage"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger levels.Levels
+	mounts []*lib.Mount // longest prefix first
+}
+
+// New returns an implementation of Storage that routes every path to the mount with the longest
+// prefix containing it. The folders leading to the mount points that are not inside another mount
+// are virtual and read-only, and mount points hide the resources with the same path in the parent mount.
+// Mount points and the folders containing them can not be deleted or moved.
+// Moves between mounts copy the resource to the target mount and then delete the source.
+// File ids, search, paging and modification times are forwarded to the drivers supporting them,
+// searches must be scoped to a folder without mount points below it. Changes are not aggregated.
+func New(logger levels.Levels, mounts []lib.Mount) (lib.Storage, error) {
+	logger = logger.With("pkg", "mountdriver")
+	d := &driver{logger: logger, mounts: []*lib.Mount{}}
+	prefixes := map[string]bool{}
+	for _, m := range mounts {
+		m.Prefix = filepath.Clean("/" + m.Prefix)
+		if m.DataDriver == nil || m.MetaDataDriver == nil {
+			return nil, fmt.Errorf("mount %q has no drivers", m.Prefix)
+		}
+		if prefixes[m.Prefix] {
+			return nil, fmt.Errorf("%q is mounted twice", m.Prefix)
+		}
+		prefixes[m.Prefix] = true
+		mount := m
+		d.mounts = append(d.mounts, &mount)
+	}
+	sort.Slice(d.mounts, func(i, j int) bool { return len(d.mounts[i].Prefix) > len(d.mounts[j].Prefix) })
+	return d, nil
+}
+
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m == nil || d.containsMountPoints(path) {
+		r.Close()
+		return forbiddenError(fmt.Sprintf("can not upload to %s", path))
+	}
+	return m.DataDriver.UploadFile(ctx, user, rel, r, clientChecksum, clientModTime)
+}
+
+// AvailableBytes forwards to the data driver of the mount, nothing can be uploaded outside of the mounts.
+// It reports no limit when the data driver of the mount has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m == nil || d.containsMountPoints(path) {
+		return 0, nil
+	}
+	quotaReporter, ok := m.DataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, user, rel)
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m == nil {
+		return nil, notFoundError(fmt.Sprintf("%s is not mounted", path))
+	}
+	return m.DataDriver.DownloadFile(ctx, user, rel)
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m != nil {
+		fileInfo, err := m.MetaDataDriver.Examine(ctx, user, rel)
+		if err == nil {
+			return toMountFileInfo(m, fileInfo), nil
+		}
+		if !isNotFoundError(err) || !d.containsMountPoints(path) {
+			return nil, err
+		}
+	} else if !d.hasMountsBelow(path) {
+		return nil, notFoundError(fmt.Sprintf("%s is not mounted", path))
+	}
+	return d.getVirtualFolderInfo(ctx, user, path), nil
+}
+
+// ExamineByID asks every mount supporting file ids, the resources hidden by a mount point are not returned.
+// It returns a notSupportedError when no mount supports file ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	supported := false
+	for _, m := range d.mounts {
+		fileIDResolver, ok := m.MetaDataDriver.(lib.FileIDResolver)
+		if !ok || !capability.Supports(m.MetaDataDriver, lib.CapabilityFileIDResolver) {
+			continue
+		}
+		supported = true
+		fileInfo, err := fileIDResolver.ExamineByID(ctx, user, id)
+		if err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return nil, err
+		}
+		fileInfo = toMountFileInfo(m, fileInfo)
+		if owner, _ := d.route(fileInfo.Path()); owner == m {
+			return fileInfo, nil
+		}
+	}
+	if !supported {
+		return nil, notSupportedError("mounted drivers do not support file ids")
+	}
+	return nil, notFoundError(fmt.Sprintf("resource with id %s not found", id))
+}
+
+// Search returns a notSupportedError when the scope has mount points below it or when
+// the driver of its mount can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	m, rel, err := d.routeScope(query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	searcher, ok := m.MetaDataDriver.(lib.Searcher)
+	if !ok || !capability.Supports(m.MetaDataDriver, lib.CapabilitySearcher) {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+	query.Scope = rel
+	result, err := searcher.Search(ctx, user, query)
+	if err != nil {
+		return nil, err
+	}
+	fileInfos := []lib.FileInfo{}
+	for _, fileInfo := range result.FileInfos() {
+		fileInfos = append(fileInfos, toMountFileInfo(m, fileInfo))
+	}
+	return &searchResult{fileInfos: fileInfos, hasMore: result.HasMore()}, nil
+}
+
+// SearchContent returns a notSupportedError when the scope has mount points below it or when
+// the driver of its mount can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	m, rel, err := d.routeScope(query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	contentSearcher, ok := m.MetaDataDriver.(lib.ContentSearcher)
+	if !ok || !capability.Supports(m.MetaDataDriver, lib.CapabilityContentSearcher) {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+	query.Scope = rel
+	result, err := contentSearcher.SearchContent(ctx, user, query)
+	if err != nil {
+		return nil, err
+	}
+	matches := []lib.ContentMatch{}
+	for _, match := range result.Matches() {
+		matches = append(matches, &contentMatch{ContentMatch: match, path: filepath.Join(m.Prefix, filepath.Clean("/"+match.Path()))})
+	}
+	return &contentSearchResult{matches: matches, hasMore: result.HasMore()}, nil
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	path = filepath.Clean("/" + path)
+	fileInfos := []lib.FileInfo{}
+	m, rel := d.route(path)
+	if m != nil {
+		children, err := m.MetaDataDriver.ListFolder(ctx, user, rel)
+		if err != nil && (!isNotFoundError(err) || !d.containsMountPoints(path)) {
+			return nil, err
+		}
+		for _, child := range children {
+			fileInfos = append(fileInfos, toMountFileInfo(m, child))
+		}
+	} else if !d.hasMountsBelow(path) {
+		return nil, notFoundError(fmt.Sprintf("%s is not mounted", path))
+	}
+	return d.mergeMountPoints(ctx, user, path, fileInfos), nil
+}
+
+// WalkFolder forwards the walk to the driver of the mount when the folder has no mount points below it
+// and the driver can walk folders, otherwise the merged folder is yielded in one batch.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m != nil && !d.hasMountsBelow(path) {
+		if folderWalker, ok := m.MetaDataDriver.(lib.FolderWalker); ok && capability.Supports(m.MetaDataDriver, lib.CapabilityFolderWalker) {
+			return folderWalker.WalkFolder(ctx, user, rel, func(fileInfos []lib.FileInfo) error {
+				for i, fileInfo := range fileInfos {
+					fileInfos[i] = toMountFileInfo(m, fileInfo)
+				}
+				return fn(fileInfos)
+			})
+		}
+	}
+
+	fileInfos, err := d.ListFolder(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	return fn(fileInfos)
+}
+
+// ListFolderPage forwards the query to the driver of the mount when the folder has no mount points below it
+// and the driver can page folders, otherwise the merged folder is paged in memory.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m != nil && !d.hasMountsBelow(path) {
+		if folderPager, ok := m.MetaDataDriver.(lib.FolderPager); ok && capability.Supports(m.MetaDataDriver, lib.CapabilityFolderPager) {
+			page, err := folderPager.ListFolderPage(ctx, user, rel, query)
+			if err != nil {
+				return nil, err
+			}
+			fileInfos := []lib.FileInfo{}
+			for _, fileInfo := range page.FileInfos() {
+				fileInfos = append(fileInfos, toMountFileInfo(m, fileInfo))
+			}
+			return &folderPage{fileInfos: fileInfos, continuationToken: page.ContinuationToken()}, nil
+		}
+	}
+
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badInputError(err.Error())
+	}
+	fileInfos, err := d.ListFolder(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileInfo := range fileInfos {
+		builder.Add(folderpage.NewKey(fileInfo), fileInfo.Folder(), fileInfo)
+	}
+	return newFolderPage(builder), nil
+}
+
+// SetModTime returns a notSupportedError when the driver of the mount can not set modification times.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	path = filepath.Clean("/" + path)
+	m, rel := d.route(path)
+	if m == nil {
+		if d.hasMountsBelow(path) {
+			return forbiddenError(fmt.Sprintf("%s is a virtual folder", path))
+		}
+		return notFoundError(fmt.Sprintf("%s is not mounted", path))
+	}
+	modTimeSetter, ok := m.MetaDataDriver.(lib.ModTimeSetter)
+	if !ok || !capability.Supports(m.MetaDataDriver, lib.CapabilityModTimeSetter) {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+	return modTimeSetter.SetModTime(ctx, user, rel, modTime)
+}
+
+// RecursiveSizes returns true when the drivers of all the mounts compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	for _, m := range d.mounts {
+		recursiveSizer, ok := m.MetaDataDriver.(lib.RecursiveSizer)
+		if !ok || !recursiveSizer.RecursiveSizes() {
+			return false
+		}
+	}
+	return true
+}
+
+// Supports tells if the drivers of the mounts support the optional interface. Folders can always
+// be walked and paged, the rest of interfaces are supported when the driver of any mount supports them.
+func (d *driver) Supports(c lib.Capability) bool {
+	if c == lib.CapabilityFolderWalker || c == lib.CapabilityFolderPager {
+		return true
+	}
+	for _, m := range d.mounts {
+		if capability.Supports(m.MetaDataDriver, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	path = filepath.Clean("/" + path)
+	if d.containsMountPoints(path) {
+		return alreadyExistError(fmt.Sprintf("%s already exists", path))
+	}
+	m, rel := d.route(path)
+	if m == nil {
+		return forbiddenError(fmt.Sprintf("can not create folders in %s", filepath.Dir(path)))
+	}
+	return m.MetaDataDriver.CreateFolder(ctx, user, rel)
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	path = filepath.Clean("/" + path)
+	if d.containsMountPoints(path) {
+		return forbiddenError(fmt.Sprintf("can not delete %s, it is or contains a mount point", path))
+	}
+	m, rel := d.route(path)
+	if m == nil {
+		return notFoundError(fmt.Sprintf("%s is not mounted", path))
+	}
+	return m.MetaDataDriver.Delete(ctx, user, rel)
+}
+
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	sourcePath = filepath.Clean("/" + sourcePath)
+	targetPath = filepath.Clean("/" + targetPath)
+	for _, path := range []string{sourcePath, targetPath} {
+		if d.containsMountPoints(path) {
+			return forbiddenError(fmt.Sprintf("can not move %s, it is or contains a mount point", path))
+		}
+	}
+	source, sourceRel := d.route(sourcePath)
+	if source == nil {
+		return notFoundError(fmt.Sprintf("%s is not mounted", sourcePath))
+	}
+	target, targetRel := d.route(targetPath)
+	if target == nil {
+		return forbiddenError(fmt.Sprintf("can not move to %s", filepath.Dir(targetPath)))
+	}
+	if source == target {
+		return source.MetaDataDriver.Move(ctx, user, sourceRel, targetRel)
+	}
+	return d.moveAcrossMounts(ctx, user, source, sourceRel, target, targetRel)
+}
+
+// moveAcrossMounts copies the resource to the target mount and then deletes the source.
+// An existing target file is overwritten, like the drivers do when moving, but folders are not merged.
+// If the copy fails the partial copy is removed and the source is kept.
+func (d *driver) moveAcrossMounts(ctx context.Context, user lib.User, source *lib.Mount, sourcePath string, target *lib.Mount, targetPath string) error {
+	fileInfo, err := source.MetaDataDriver.Examine(ctx, user, sourcePath)
+	if err != nil {
+		return err
+	}
+	existing, err := target.MetaDataDriver.Examine(ctx, user, targetPath)
+	if err != nil && !isNotFoundError(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && (existing.Folder() || fileInfo.Folder()) {
+		return alreadyExistError(fmt.Sprintf("%s already exists", filepath.Join(target.Prefix, targetPath)))
+	}
+
+	if err := d.copy(ctx, user, source, sourcePath, fileInfo, target, targetPath); err != nil {
+		d.logger.Error().Log("error", err, "msg", "error copying across mounts", "source", sourcePath, "target", targetPath)
+		if !exists {
+			if err := target.MetaDataDriver.Delete(ctx, user, targetPath); err != nil && !isNotFoundError(err) {
+				d.logger.Error().Log("error", err, "msg", "error removing partial copy", "target", targetPath)
+			}
+		}
+		return err
+	}
+	return source.MetaDataDriver.Delete(ctx, user, sourcePath)
+}
+
+// copy copies recursively the resource described by fileInfo, keeping the modification times of the files.
+func (d *driver) copy(ctx context.Context, user lib.User, source *lib.Mount, sourcePath string, fileInfo lib.FileInfo, target *lib.Mount, targetPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !fileInfo.Folder() {
+		r, err := source.DataDriver.DownloadFile(ctx, user, sourcePath)
+		if err != nil {
+			return err
+		}
+		// checksums are not compared, the drivers of both mounts may use different types.
+		return target.DataDriver.UploadFile(ctx, user, targetPath, r, "", fileInfo.Modified())
+	}
+
+	if err := target.MetaDataDriver.CreateFolder(ctx, user, targetPath); err != nil {
+		return err
+	}
+	children, err := source.MetaDataDriver.ListFolder(ctx, user, sourcePath)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		name := filepath.Base(child.Path())
+		if err := d.copy(ctx, user, source, filepath.Join(sourcePath, name), child, target, filepath.Join(targetPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route returns the mount containing path and the path relative to it, nil if path is not mounted.
+func (d *driver) route(path string) (*lib.Mount, string) {
+	path = filepath.Clean("/" + path)
+	for _, m := range d.mounts {
+		if isInside(path, m.Prefix) {
+			return m, filepath.Clean("/" + strings.TrimPrefix(path, m.Prefix))
+		}
+	}
+	return nil, ""
+}
+
+// routeScope routes the scope of a search, which can not have mount points below it.
+func (d *driver) routeScope(scope string) (*lib.Mount, string, error) {
+	scope = filepath.Clean("/" + scope)
+	m, rel := d.route(scope)
+	if m == nil {
+		if d.hasMountsBelow(scope) {
+			return nil, "", notSupportedError("search across mount points is not supported")
+		}
+		return nil, "", notFoundError(fmt.Sprintf("%s is not mounted", scope))
+	}
+	if d.hasMountsBelow(scope) {
+		return nil, "", notSupportedError("search across mount points is not supported")
+	}
+	return m, rel, nil
+}
+
+// containsMountPoints returns true when path is a mount point or has mount points below it.
+func (d *driver) containsMountPoints(path string) bool {
+	for _, m := range d.mounts {
+		if isInside(m.Prefix, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *driver) hasMountsBelow(path string) bool {
+	for _, m := range d.mounts {
+		if m.Prefix != path && isInside(m.Prefix, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMountPoints adds to the entries of the folder the mount points and the virtual folders
+// leading to them, which hide the entries with the same name.
+func (d *driver) mergeMountPoints(ctx context.Context, user lib.User, path string, fileInfos []lib.FileInfo) []lib.FileInfo {
+	mountPoints := d.listMountPoints(ctx, user, path)
+	if len(mountPoints) == 0 {
+		return fileInfos
+	}
+	hidden := map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		hidden[mountPoint.Path()] = true
+	}
+	merged := []lib.FileInfo{}
+	for _, fileInfo := range fileInfos {
+		if !hidden[filepath.Clean("/"+fileInfo.Path())] {
+			merged = append(merged, fileInfo)
+		}
+	}
+	return append(merged, mountPoints...)
+}
+
+// listMountPoints returns the children of the folder that are mount points or virtual folders leading to them.
+func (d *driver) listMountPoints(ctx context.Context, user lib.User, path string) []lib.FileInfo {
+	names := map[string]bool{}
+	for _, m := range d.mounts {
+		if m.Prefix == path || !isInside(m.Prefix, path) {
+			continue
+		}
+		rest := strings.TrimPrefix(strings.TrimPrefix(m.Prefix, path), "/")
+		names[strings.SplitN(rest, "/", 2)[0]] = true
+	}
+	sortedNames := []string{}
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	fileInfos := []lib.FileInfo{}
+	for _, name := range sortedNames {
+		childPath := filepath.Join(path, name)
+		if m, rel := d.route(childPath); m != nil && m.Prefix == childPath {
+			fileInfo, err := m.MetaDataDriver.Examine(ctx, user, rel)
+			if err == nil {
+				fileInfos = append(fileInfos, toMountFileInfo(m, fileInfo))
+				continue
+			}
+			// the root of the mount may not exist yet, like the home of a new user.
+			d.logger.Warn().Log("error", err, "msg", "mount point not available", "prefix", m.Prefix)
+		}
+		fileInfos = append(fileInfos, d.getVirtualFolderInfo(ctx, user, childPath))
+	}
+	return fileInfos
+}
+
+// getVirtualFolderInfo returns a folder of the mount table. Its etag changes every time the etag of
+// one of the mount points below it changes, so sync clients detect changes inside them.
+func (d *driver) getVirtualFolderInfo(ctx context.Context, user lib.User, path string) lib.FileInfo {
+	info := &virtualFolderInfo{path: path}
+	h := md5.New()
+	for _, fileInfo := range d.listMountPoints(ctx, user, path) {
+		info.size += fileInfo.Size()
+		if fileInfo.Modified() > info.modified {
+			info.modified = fileInfo.Modified()
+		}
+		etag, _ := fileInfo.ExtraAttributes()["etag"].(string)
+		fmt.Fprintf(h, "%s:%s\n", fileInfo.Path(), etag)
+	}
+	info.etag = fmt.Sprintf("%x", h.Sum(nil))
+	return info
+}
+
+// isInside returns true when path is folder or is inside it.
+func isInside(path, folder string) bool {
+	return folder == "/" || path == folder || strings.HasPrefix(path, folder+"/")
+}
+
+func toMountFileInfo(m *lib.Mount, fi lib.FileInfo) lib.FileInfo {
+	return &fileInfo{FileInfo: fi, path: filepath.Join(m.Prefix, filepath.Clean("/"+fi.Path()))}
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type fileInfo struct {
+	lib.FileInfo
+	path string
+}
+
+func (f *fileInfo) Path() string {
+	return f.path
+}
+
+type virtualFolderInfo struct {
+	path     string
+	size     int64
+	modified int64
+	etag     string
+}
+
+func (f *virtualFolderInfo) Path() string {
+	return f.path
+}
+
+func (f *virtualFolderInfo) Folder() bool {
+	return true
+}
+
+func (f *virtualFolderInfo) Size() int64 {
+	return f.size
+}
+
+func (f *virtualFolderInfo) Modified() int64 {
+	return f.modified
+}
+
+func (f *virtualFolderInfo) Checksum() string {
+	return ""
+}
+
+func (f *virtualFolderInfo) ExtraAttributes() map[string]interface{} {
+	return map[string]interface{}{
+		"etag":        f.etag,
+		"permissions": lib.PermissionRead,
+	}
+}
+
+type searchResult struct {
+	fileInfos []lib.FileInfo
+	hasMore   bool
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	return r.fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
+type contentSearchResult struct {
+	matches []lib.ContentMatch
+	hasMore bool
+}
+
+func (r *contentSearchResult) Matches() []lib.ContentMatch {
+	return r.matches
+}
+
+func (r *contentSearchResult) HasMore() bool {
+	return r.hasMore
+}
+
+// contentMatch is a match inside a mount with the path of the mount table.
+type contentMatch struct {
+	lib.ContentMatch
+	path string
+}
+
+func (m *contentMatch) Path() string {
+	return m.path
+}
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func newFolderPage(builder *folderpage.Builder) *folderPage {
+	items, continuationToken := builder.Page()
+	fileInfos := []lib.FileInfo{}
+	for _, item := range items {
+		fileInfos = append(fileInfos, item.(lib.FileInfo))
+	}
+	return &folderPage{fileInfos: fileInfos, continuationToken: continuationToken}
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
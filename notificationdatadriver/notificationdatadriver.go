@@ -0,0 +1,76 @@
+package notificationdatadriver
+
+import (
+	"context"
+	"io"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/notificationfanout"
+	"github.com/clawio/lib/occhunk"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	publisher      *notificationfanout.Publisher
+}
+
+// New returns an implementation of DataDriver that publishes a notification
+// to the broker every time a file is uploaded through dataDriver.
+// metaDataDriver is used to tell creations from modifications and to obtain the ids.
+// Like notificationmdatadriver, the users that see the file through the shares of shareDriver
+// and the spaces of spaceDriver are notified too, either can be nil.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, shareDriver lib.ShareDriver, spaceDriver lib.SpaceDriver, broker lib.NotificationBroker) lib.DataDriver {
+	logger = logger.With("pkg", "notificationdatadriver")
+	return &driver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		publisher:      notificationfanout.New(logger, metaDataDriver, shareDriver, spaceDriver, broker),
+	}
+}
+
+// UploadFile notifies ownCloud chunked uploads once the chunk that completes the file is uploaded,
+// with the path of the file, the other chunks return an error with CodeUploadIsPartial.
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	target := path
+	if chunkInfo, ok := occhunk.Parse(path); ok {
+		target = chunkInfo.Path
+	}
+
+	kind := lib.ChangeModify
+	if _, err := d.metaDataDriver.Examine(ctx, user, target); err != nil {
+		kind = lib.ChangeCreate
+	}
+
+	if err := d.dataDriver.UploadFile(ctx, user, path, r, clientChecksum, clientModTime); err != nil {
+		return err
+	}
+
+	d.publisher.Publish(ctx, user, kind, target, d.publisher.Examine(ctx, user, target), "", nil)
+	return nil
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	return d.dataDriver.DownloadFile(ctx, user, path)
+}
+
+// AvailableBytes reports no limit when the wrapped driver has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, user, path)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
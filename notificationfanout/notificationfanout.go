@@ -0,0 +1,240 @@
+package notificationfanout
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/go-kit/kit/log/levels"
+)
+
+// Publisher publishes the notifications of a change to the user doing it and to the users
+// that see the resource through a share or a space: the owner, the recipients of the shares
+// of the owner and the members of the space.
+// Group shares are not fanned out, as the members of a group can not be listed.
+type Publisher struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	shareDriver    lib.ShareDriver
+	spaceDriver    lib.SpaceDriver
+	broker         lib.NotificationBroker
+}
+
+// New returns a publisher that resolves the paths of the other users with metaDataDriver,
+// which must expose the shares and the spaces and resolve ids, else only the user doing the change is notified.
+// shareDriver and spaceDriver can be nil when there are no shares or no spaces.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, shareDriver lib.ShareDriver, spaceDriver lib.SpaceDriver, broker lib.NotificationBroker) *Publisher {
+	return &Publisher{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+		shareDriver:    shareDriver,
+		spaceDriver:    spaceDriver,
+		broker:         broker,
+	}
+}
+
+// Resource is a resource as seen by the user doing the change.
+// Deleted resources are examined before the deletion, so they carry the id they had.
+type Resource struct {
+	// FileInfo is the resource, nil when it does not exist.
+	FileInfo lib.FileInfo
+	// Parent is the folder containing the resource, nil when it does not exist.
+	Parent lib.FileInfo
+}
+
+// Examine returns the resource at path and its parent, the ones not found are left nil.
+func (p *Publisher) Examine(ctx context.Context, user lib.User, path string) *Resource {
+	path = filepath.Clean("/" + path)
+	res := &Resource{}
+	if fi, err := p.metaDataDriver.Examine(ctx, user, path); err == nil {
+		res.FileInfo = fi
+	}
+	if path != "/" {
+		if fi, err := p.metaDataDriver.Examine(ctx, user, filepath.Dir(path)); err == nil {
+			res.Parent = fi
+		}
+	}
+	return res
+}
+
+// Publish notifies the change of the resource at path. For moves source is the resource at sourcePath
+// examined before the move, the parent is used to resolve sourcePath for the other users.
+// Errors are logged but not returned, as the change has already been done.
+func (p *Publisher) Publish(ctx context.Context, user lib.User, kind lib.ChangeKind, path string, res *Resource, sourcePath string, source *Resource) {
+	path = filepath.Clean("/" + path)
+	if sourcePath != "" {
+		sourcePath = filepath.Clean("/" + sourcePath)
+	}
+	id := getID(res.FileInfo)
+	timestamp := time.Now().UnixNano()
+
+	p.publish(ctx, &notification{username: user.Username(), kind: kind, id: id, path: path, sourcePath: sourcePath, timestamp: timestamp})
+
+	fileIDResolver, ok := p.metaDataDriver.(lib.FileIDResolver)
+	if !ok || !capability.Supports(p.metaDataDriver, lib.CapabilityFileIDResolver) {
+		return
+	}
+	for _, username := range p.getUsers(ctx, user, res, source) {
+		other := &fanoutUser{username: username}
+		n := &notification{username: username, kind: kind, id: id, timestamp: timestamp}
+		var inTarget, inSource bool
+		n.path, inTarget = p.translate(ctx, fileIDResolver, other, path, res)
+		if sourcePath != "" && source != nil {
+			// the id already resolves to the target, only the parent tells where the source was.
+			n.sourcePath, inSource = p.translate(ctx, fileIDResolver, other, sourcePath, &Resource{Parent: source.Parent})
+		}
+		switch {
+		case inTarget && !inSource && n.kind == lib.ChangeMove:
+			// the resource has been moved into the view of the user.
+			n.kind = lib.ChangeCreate
+		case !inTarget && inSource:
+			// the resource has been moved out of the view of the user.
+			n.kind, n.path, n.sourcePath = lib.ChangeDelete, n.sourcePath, ""
+		case !inTarget:
+			continue
+		}
+		p.publish(ctx, n)
+	}
+}
+
+// getUsers returns the users other than user that see the resource.
+func (p *Publisher) getUsers(ctx context.Context, user lib.User, resources ...*Resource) []string {
+	seen := map[string]bool{user.Username(): true}
+	var usernames []string
+	add := func(username string) {
+		if username != "" && !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+
+	for _, res := range resources {
+		if res == nil {
+			continue
+		}
+		for _, fi := range []lib.FileInfo{res.FileInfo, res.Parent} {
+			if fi == nil {
+				continue
+			}
+			extraAttributes := fi.ExtraAttributes()
+			if spaceID, _ := extraAttributes["space"].(string); spaceID != "" && p.spaceDriver != nil {
+				space, err := p.spaceDriver.GetSpace(ctx, user, spaceID)
+				if err != nil {
+					p.logger.Error().Log("error", err, "msg", "error getting space", "spaceid", spaceID)
+					continue
+				}
+				for member := range space.Members() {
+					add(member)
+				}
+				continue
+			}
+
+			owner, _ := extraAttributes["owner"].(string)
+			if owner == "" {
+				owner = user.Username()
+			}
+			add(owner)
+			if p.shareDriver == nil {
+				continue
+			}
+			shares, err := p.shareDriver.ListUserShares(ctx, &fanoutUser{username: owner}, "")
+			if err != nil {
+				p.logger.Error().Log("error", err, "msg", "error listing shares", "owner", owner)
+				continue
+			}
+			for _, share := range shares {
+				if share.RecipientType() == lib.ShareRecipientUser {
+					add(share.Recipient())
+				}
+			}
+		}
+	}
+	return usernames
+}
+
+// translate returns the path of the resource for another user, looking up the resource by id
+// or, when it does not exist anymore, its parent. It returns false when the user does not see them.
+func (p *Publisher) translate(ctx context.Context, fileIDResolver lib.FileIDResolver, user lib.User, path string, res *Resource) (string, bool) {
+	if id := getID(res.FileInfo); id != "" {
+		if fi, err := fileIDResolver.ExamineByID(ctx, user, id); err == nil {
+			return filepath.Clean("/" + fi.Path()), true
+		}
+	}
+	if id := getID(res.Parent); id != "" {
+		if fi, err := fileIDResolver.ExamineByID(ctx, user, id); err == nil {
+			return filepath.Join("/", fi.Path(), filepath.Base(path)), true
+		}
+	}
+	return "", false
+}
+
+func (p *Publisher) publish(ctx context.Context, n *notification) {
+	if err := p.broker.Publish(ctx, n); err != nil {
+		p.logger.Error().Log("error", err, "msg", "error publishing notification", "user", n.username, "path", n.path)
+	}
+}
+
+func getID(fi lib.FileInfo) string {
+	if fi == nil {
+		return ""
+	}
+	id, _ := fi.ExtraAttributes()["id"].(string)
+	return id
+}
+
+// fanoutUser is the minimal user the resources of other users are resolved for.
+// Drivers only rely on the username to locate resources.
+type fanoutUser struct {
+	username string
+}
+
+func (u *fanoutUser) Username() string {
+	return u.username
+}
+
+func (u *fanoutUser) Email() string {
+	return ""
+}
+
+func (u *fanoutUser) DisplayName() string {
+	return u.username
+}
+
+func (u *fanoutUser) ExtraAttributes() map[string]interface{} {
+	return nil
+}
+
+type notification struct {
+	username   string
+	kind       lib.ChangeKind
+	id         string
+	path       string
+	sourcePath string
+	timestamp  int64
+}
+
+func (n *notification) Username() string {
+	return n.username
+}
+
+func (n *notification) Kind() lib.ChangeKind {
+	return n.kind
+}
+
+func (n *notification) ID() string {
+	return n.id
+}
+
+func (n *notification) Path() string {
+	return n.path
+}
+
+func (n *notification) SourcePath() string {
+	return n.sourcePath
+}
+
+func (n *notification) Timestamp() int64 {
+	return n.timestamp
+}
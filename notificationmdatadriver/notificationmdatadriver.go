@@ -0,0 +1,174 @@
+package notificationmdatadriver
+
+import (
+	"context"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/clawio/lib/notificationfanout"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	publisher      *notificationfanout.Publisher
+}
+
+// New returns an implementation of MetaDataDriver that publishes a notification
+// to the broker every time an operation on metaDataDriver changes a resource.
+// The notification is published for the user doing the change and for the users that see the
+// resource through the shares of shareDriver and the spaces of spaceDriver, either can be nil.
+// metaDataDriver must expose the shares and the spaces, see notificationfanout.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, shareDriver lib.ShareDriver, spaceDriver lib.SpaceDriver, broker lib.NotificationBroker) lib.MetaDataDriver {
+	logger = logger.With("pkg", "notificationmdatadriver")
+	d := &driver{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+		publisher:      notificationfanout.New(logger, metaDataDriver, shareDriver, spaceDriver, broker),
+	}
+	return d
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	return d.metaDataDriver.Examine(ctx, user, path)
+}
+
+// ExamineByID returns a notSupportedError when the wrapped driver can not resolve ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+	return fileIDResolver.ExamineByID(ctx, user, id)
+}
+
+// Search returns a notSupportedError when the wrapped driver can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	searcher, ok := d.metaDataDriver.(lib.Searcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+	return searcher.Search(ctx, user, query)
+}
+
+// SearchContent returns a notSupportedError when the wrapped driver can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	contentSearcher, ok := d.metaDataDriver.(lib.ContentSearcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+	return contentSearcher.SearchContent(ctx, user, query)
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	return d.metaDataDriver.ListFolder(ctx, user, path)
+}
+
+// ListFolderPage returns a notSupportedError when the wrapped driver can not page folders.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	folderPager, ok := d.metaDataDriver.(lib.FolderPager)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support paging folders")
+	}
+	return folderPager.ListFolderPage(ctx, user, path, query)
+}
+
+// WalkFolder returns a notSupportedError when the wrapped driver can not walk folders.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	folderWalker, ok := d.metaDataDriver.(lib.FolderWalker)
+	if !ok {
+		return notSupportedError("metadata driver does not support walking folders")
+	}
+	return folderWalker.WalkFolder(ctx, user, path, fn)
+}
+
+// SetModTime returns a notSupportedError when the wrapped driver can not set modification times.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	modTimeSetter, ok := d.metaDataDriver.(lib.ModTimeSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+	if err := modTimeSetter.SetModTime(ctx, user, path, modTime); err != nil {
+		return err
+	}
+	d.publisher.Publish(ctx, user, lib.ChangeModify, path, d.publisher.Examine(ctx, user, path), "", nil)
+	return nil
+}
+
+// GetChanges returns a notSupportedError when the wrapped driver has no change feed.
+func (d *driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	changeFeed, ok := d.metaDataDriver.(lib.ChangeFeed)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support change feeds")
+	}
+	return changeFeed.GetChanges(ctx, user, cursor, limit)
+}
+
+// SetFileID returns a notSupportedError when the wrapped driver can not set ids.
+func (d *driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	fileIDSetter, ok := d.metaDataDriver.(lib.FileIDSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting file ids")
+	}
+	return fileIDSetter.SetFileID(ctx, user, path, id)
+}
+
+// RecursiveSizes returns false when the wrapped driver can not compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer)
+	return ok && recursiveSizer.RecursiveSizes()
+}
+
+// Supports tells if the wrapped driver supports the optional interface.
+func (d *driver) Supports(c lib.Capability) bool {
+	return capability.Supports(d.metaDataDriver, c)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.metaDataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	if err := d.metaDataDriver.CreateFolder(ctx, user, path); err != nil {
+		return err
+	}
+	d.publisher.Publish(ctx, user, lib.ChangeCreate, path, d.publisher.Examine(ctx, user, path), "", nil)
+	return nil
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	// the resource is examined before it is gone, to keep its id.
+	res := d.publisher.Examine(ctx, user, path)
+	if err := d.metaDataDriver.Delete(ctx, user, path); err != nil {
+		return err
+	}
+	d.publisher.Publish(ctx, user, lib.ChangeDelete, path, res, "", nil)
+	return nil
+}
+
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	source := d.publisher.Examine(ctx, user, sourcePath)
+	if err := d.metaDataDriver.Move(ctx, user, sourcePath, targetPath); err != nil {
+		return err
+	}
+	d.publisher.Publish(ctx, user, lib.ChangeMove, targetPath, d.publisher.Examine(ctx, user, targetPath), sourcePath, source)
+	return nil
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
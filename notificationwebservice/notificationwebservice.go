@@ -0,0 +1,172 @@
+package notificationwebservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	"golang.org/x/net/websocket"
+)
+
+const defaultHeartbeat = 30 * time.Second
+
+type service struct {
+	cm        lib.ContextManager
+	logger    levels.Levels
+	broker    lib.NotificationBroker
+	am        lib.AuthenticationMiddleware
+	heartbeat time.Duration
+}
+
+// New returns a web service that pushes to the authenticated clients the
+// changes done to their files, so they do not need to poll for them.
+// heartbeat is the interval between keep-alive messages sent to idle SSE clients
+// to avoid intermediate proxies closing the connection.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	broker lib.NotificationBroker,
+	am lib.AuthenticationMiddleware,
+	heartbeat time.Duration) lib.WebService {
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	return &service{
+		cm:        cm,
+		logger:    logger,
+		broker:    broker,
+		am:        am,
+		heartbeat: heartbeat,
+	}
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/notifications/sse": {
+			"GET": s.am.HandlerFunc(s.sseEndpoint),
+		},
+		"/notifications/ws": {
+			"GET": s.am.HandlerFunc(s.webSocketEndpoint),
+		},
+	}
+}
+
+// sseEndpoint streams the notifications as Server-Sent Events.
+func (s *service) sseEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Error().Log("error", "response writer does not support flushing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	notifications, cancel := s.broker.Subscribe(user)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Info().Log("msg", "sse client disconnected")
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			notificationJSON, err := json.Marshal(notificationToNotificationResponse(n))
+			if err != nil {
+				logger.Error().Log("error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", n.Timestamp(), notificationJSON); err != nil {
+				logger.Error().Log("error", err, "msg", "error writing notification")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// webSocketEndpoint streams the notifications as JSON text messages over a WebSocket.
+func (s *service) webSocketEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	// the request has already been authenticated, so the origin is not checked.
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		notifications, cancel := s.broker.Subscribe(user)
+		defer cancel()
+
+		// clients are not expected to send messages, reading is only
+		// needed to detect when the connection is closed.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var msg string
+			for {
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				logger.Info().Log("msg", "websocket client disconnected")
+				return
+			case n, ok := <-notifications:
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(ws, notificationToNotificationResponse(n)); err != nil {
+					logger.Error().Log("error", err, "msg", "error writing notification")
+					return
+				}
+			}
+		}
+	}}
+	server.ServeHTTP(w, r)
+}
+
+func notificationToNotificationResponse(n lib.Notification) *notificationResponse {
+	return &notificationResponse{
+		Kind:       n.Kind(),
+		ID:         n.ID(),
+		Path:       n.Path(),
+		SourcePath: n.SourcePath(),
+		Timestamp:  n.Timestamp(),
+	}
+}
+
+type notificationResponse struct {
+	Kind       lib.ChangeKind `json:"kind"`
+	ID         string         `json:"id"`
+	Path       string         `json:"path"`
+	SourcePath string         `json:"source_path,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+}
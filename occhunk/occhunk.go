@@ -0,0 +1,43 @@
+package occhunk
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// chunkRegexp matches the paths of the chunks of ownCloud chunked uploads,
+// the path of the file followed by -chunking-<transfer id>-<total chunks>-<current chunk>.
+var chunkRegexp = regexp.MustCompile(`^(.*)-chunking-(\w+)-([0-9]+)-([0-9]+)$`)
+
+// Info describes a chunk of an ownCloud chunked upload.
+type Info struct {
+	// Path is the path of the file the chunks are assembled into.
+	Path         string
+	TransferID   string
+	TotalChunks  int64
+	CurrentChunk int64
+}
+
+// Parse returns the chunk info of path and true when path is the path of a chunk.
+// Data drivers return an error with CodeUploadIsPartial for every chunk but the one that
+// completes the file, so decorators can act on Path when uploading a chunk succeeds.
+func Parse(path string) (*Info, bool) {
+	matches := chunkRegexp.FindStringSubmatch(path)
+	if matches == nil {
+		return nil, false
+	}
+	totalChunks, err := strconv.ParseInt(matches[3], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	currentChunk, err := strconv.ParseInt(matches[4], 10, 64)
+	if err != nil || currentChunk >= totalChunks {
+		return nil, false
+	}
+	return &Info{
+		Path:         matches[1],
+		TransferID:   matches[2],
+		TotalChunks:  totalChunks,
+		CurrentChunk: currentChunk,
+	}, true
+}
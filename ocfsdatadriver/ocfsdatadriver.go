@@ -16,10 +16,12 @@ import (
 
 	"github.com/clawio/lib"
 	"github.com/clawio/lib/ocfsmdatadriver"
+	"github.com/clawio/lib/ocxattrmdatadriver"
 	"github.com/go-kit/kit/log/levels"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 type driver struct {
@@ -30,7 +32,14 @@ type driver struct {
 	checksum               string
 	verifyClientChecksum   bool
 	metaDataDriver         lib.MetaDataDriver
-	ownCloudMetaDataDriver *ocfsmdatadriver.Driver
+	ownCloudMetaDataDriver ownCloudMetaDataDriver
+}
+
+// ownCloudMetaDataDriver is implemented by the metadata drivers that keep
+// the ETags and checksums needed by the ownCloud sync protocol.
+// modTime is the modification time of the resource at from, zero means now.
+type ownCloudMetaDataDriver interface {
+	PropagateChanges(user lib.User, from, to, checksum string, modTime int64) error
 }
 
 // New returns an implementation of DataDriver.
@@ -57,10 +66,15 @@ func New(logger levels.Levels, dataFolder, temporaryFolder, chunksFolder, checks
 	// check that metadata driver is compatible with owncloud.
 	// This is an ugly check but is the one needed to keep the metadata driver interface clean
 	// We cast to all compatible implementations.
-	ownCloudMetaDataDriver, ok := metaDataDriver.(*ocfsmdatadriver.Driver)
-	if !ok {
-		logger.Crit().Log("error", "metadata driver is not ocfsmdatadriver")
-		return nil, errors.New("metadata driver is not ocfsmdatadriver")
+	var ownCloudMetaDataDriver ownCloudMetaDataDriver
+	switch d := metaDataDriver.(type) {
+	case *ocfsmdatadriver.Driver:
+		ownCloudMetaDataDriver = d
+	case *ocxattrmdatadriver.Driver:
+		ownCloudMetaDataDriver = d
+	default:
+		logger.Crit().Log("error", "metadata driver is not ocfsmdatadriver or ocxattrmdatadriver")
+		return nil, errors.New("metadata driver is not ocfsmdatadriver or ocxattrmdatadriver")
 	}
 
 	return &driver{
@@ -85,8 +99,11 @@ func (c *driver) Init(ctx context.Context, user lib.User) error {
 // 2) Optional: calculate the checksum of the file if server-checksum is enabled.
 // 3) Optional: if a client-checksum is provided, check if it matches with the server-checksum.
 // 4) Move the file from the temporary folder to user folder.
-func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string) error {
+func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
 	defer r.Close()
+	if err := c.validateName(path); err != nil {
+		return err
+	}
 	// if the file is a chunk we handle it differently
 	isChunked, err := c.isChunkedUpload(path)
 	if err != nil {
@@ -96,7 +113,7 @@ func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r i
 
 	if isChunked {
 		c.logger.Info().Log("msg", "upload is chunk upload")
-		return c.uploadChunk(ctx, user, path, r, clientChecksum)
+		return c.uploadChunk(ctx, user, path, r, clientChecksum, clientModTime)
 	}
 
 	tempFileName, err := c.saveToTempFile(r)
@@ -128,6 +145,10 @@ func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r i
 
 	// 4) Move the file from the temporary folder to user folder.
 	localPath := c.getLocalPath(user, path)
+	c.inheritMetaData(user, path, tempFileName)
+	if err := c.setModTime(tempFileName, clientModTime); err != nil {
+		return err
+	}
 	if err := os.Rename(tempFileName, localPath); err != nil {
 		c.logger.Error().Log("error", err)
 		if os.IsNotExist(err) {
@@ -136,7 +157,7 @@ func (c *driver) UploadFile(ctx context.Context, user lib.User, path string, r i
 		return err
 	}
 	c.logger.Info().Log("msg", "atomic rename completed", "source", tempFileName, "target", localPath)
-	if err = c.ownCloudMetaDataDriver.PropagateChanges(user, path, "/", computedChecksum); err != nil {
+	if err = c.ownCloudMetaDataDriver.PropagateChanges(user, path, "/", computedChecksum, clientModTime); err != nil {
 		c.logger.Error().Log("error", err, "msg", "error propagating changes")
 	}
 	return nil
@@ -171,7 +192,8 @@ func (c *driver) DownloadFile(ctx context.Context, user lib.User, path string) (
 	return fd, nil
 }
 
-func (c *driver) uploadChunk(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string) error {
+// uploadChunk saves a chunk of a file, clientModTime is only applied by the chunk that completes the file.
+func (c *driver) uploadChunk(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
 	chunkInfo, err := getChunkBLOBInfo(path)
 	if err != nil {
 		err := fmt.Errorf("error getting chunk info from path: %s", path)
@@ -322,6 +344,10 @@ func (c *driver) uploadChunk(ctx context.Context, user lib.User, path string, r
 
 	// 4) Move the file from the temporary folder to user folder.
 	localPath := c.getLocalPath(user, path)
+	c.inheritMetaData(user, path, tempFileName)
+	if err := c.setModTime(tempFileName, clientModTime); err != nil {
+		return err
+	}
 	if err := os.Rename(tempFileName, localPath); err != nil {
 		c.logger.Error().Log("error", err)
 		if os.IsNotExist(err) {
@@ -330,12 +356,56 @@ func (c *driver) uploadChunk(ctx context.Context, user lib.User, path string, r
 		return err
 	}
 	c.logger.Info().Log("msg", "atomic rename completed", "source", tempFileName, "target", localPath)
-	if err = c.ownCloudMetaDataDriver.PropagateChanges(user, path, "/", computedChecksum); err != nil {
+	if err = c.ownCloudMetaDataDriver.PropagateChanges(user, path, "/", computedChecksum, clientModTime); err != nil {
 		c.logger.Error().Log("error", err, "msg", "error propagating changes")
 	}
 	return nil
 }
 
+// setModTime sets the modification time given by the client to the file before it is moved to the user folder,
+// so the file never shows up with the time of the upload.
+func (c *driver) setModTime(tempFileName string, clientModTime int64) error {
+	if clientModTime == 0 {
+		return nil
+	}
+	t := time.Unix(0, clientModTime)
+	if err := os.Chtimes(tempFileName, t, t); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting client modification time")
+		return err
+	}
+	return nil
+}
+
+// validateName rejects the names reserved by the metadata driver, like the sidecars of ocxattrmdatadriver.
+// Chunks are checked against the name of the file they are assembled into.
+func (c *driver) validateName(path string) error {
+	d, ok := c.ownCloudMetaDataDriver.(*ocxattrmdatadriver.Driver)
+	if !ok {
+		return nil
+	}
+	if isChunked, _ := c.isChunkedUpload(path); isChunked {
+		chunkInfo, err := getChunkBLOBInfo(path)
+		if err != nil {
+			return err
+		}
+		path = chunkInfo.path
+	}
+	return d.ValidateName(path)
+}
+
+// inheritMetaData keeps the metadata that is tied to the file inode, like
+// the extended attributes used by ocxattrmdatadriver, when a new version
+// of the file replaces the current one.
+func (c *driver) inheritMetaData(user lib.User, path, tempFileName string) {
+	d, ok := c.ownCloudMetaDataDriver.(*ocxattrmdatadriver.Driver)
+	if !ok {
+		return
+	}
+	if err := d.InheritMetaData(user, path, tempFileName); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error inheriting metadata")
+	}
+}
+
 func (c *driver) saveToTempFile(r io.Reader) (string, error) {
 	temporaryFolder := fmt.Sprintf("/%s", c.temporaryFolder)
 	fd, err := ioutil.TempFile(temporaryFolder, "")
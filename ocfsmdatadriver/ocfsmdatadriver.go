@@ -3,14 +3,22 @@ package ocfsmdatadriver
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"context"
+	"encoding/base64"
+	"fmt"
 	"github.com/clawio/lib"
+	"github.com/clawio/lib/folderpage"
+	"github.com/clawio/lib/searchfilter"
 	"github.com/go-kit/kit/log/levels"
 	"github.com/go-sql-Driver/mysql"
 	"github.com/jinzhu/gorm"
 	"github.com/satori/go.uuid"
+	"io"
+	"io/ioutil"
 	"time"
 )
 
@@ -55,11 +63,93 @@ type record struct {
 	// to check if a node has been updated comparing its modtime. ETags cannot be used in CAS because they do not
 	// tell when the resource was modified, just that is has been modified.
 	ModTime int64 `gorm:"column:modtime"`
+
+	// Size is the size of the blob for files and the aggregate size of the children
+	// for folders. The size of folders is updated in the same ancestor walk used to
+	// propagate ETags, adding the difference in size of the resource that changed.
+	// Folders only account for the children that have a record, SyncTree computes it again
+	// from the filesystem.
+	Size int64 `gorm:"column:size"`
+
+	// Stat is the fingerprint of the blob of files when the record was written, see statFingerprint.
+	// It tells changes done directly on the data folder apart from the ones done through this driver.
+	Stat string `gorm:"column:stat"`
 }
 
 // TableName returns the name of the SQL table.
 func (r *record) TableName() string { return "records" }
 
+// changeRecord is an entry of the change journal stored on the SQL database.
+// The journal is ordered by Seq and it is queried by home, so every
+// user sees the changes of its own namespace.
+type changeRecord struct {
+	// Seq is the position of the change inside the journal.
+	// Cursors given to clients point to a Seq.
+	Seq uint64 `gorm:"primary_key;column:seq"`
+
+	// Home is the virtual path of the home of the user, ex: /d/demo
+	Home string `sql:"index:idx_home"`
+
+	Kind lib.ChangeKind
+
+	// FileID is the ID of the resource, it is kept across moves.
+	FileID string `gorm:"column:fileid"`
+
+	// Path is the path relative to the home of the user.
+	Path string
+
+	// SourcePath is only set for moves.
+	SourcePath string `gorm:"column:sourcepath"`
+
+	Timestamp int64 `sql:"index:idx_timestamp"`
+}
+
+// TableName returns the name of the SQL table.
+func (r *changeRecord) TableName() string { return "changes" }
+
+// purgeRecord keeps the highest seq removed from the journal of a home,
+// cursors pointing before it have expired.
+type purgeRecord struct {
+	Home string `gorm:"primary_key"`
+	Seq  uint64
+}
+
+// TableName returns the name of the SQL table.
+func (r *purgeRecord) TableName() string { return "change_purges" }
+
+// migrationRecord marks a data migration as done, so it is run again
+// when the process stops before finishing it.
+type migrationRecord struct {
+	Name string `gorm:"primary_key"`
+	Done int64
+}
+
+// TableName returns the name of the SQL table.
+func (r *migrationRecord) TableName() string { return "migrations" }
+
+// sizesMigration is the migration that sets the sizes of the records created before the size column.
+const sizesMigration = "sizes"
+
+const (
+	// changeRetention is how long the changes are kept in the journal.
+	// Cursors whose next changes have been removed are expired and their clients need to start over.
+	changeRetention = 30 * 24 * time.Hour
+
+	// changeSettle is how long a change may take to be committed once its seq has been assigned.
+	// Concurrent inserts can be committed out of order, so changes are only delivered up to the
+	// seq before the first change recorded within this time, else a cursor could skip a change.
+	changeSettle = 5 * time.Second
+
+	defaultChangesLimit = 1000
+
+	// searchBatchSize is the number of records read at once while searching.
+	searchBatchSize = 500
+
+	// readdirBatchSize is the number of entries read at once while paging a folder,
+	// their records are read with a single query.
+	readdirBatchSize = 500
+)
+
 // Driver implements the MetaDataDriver interface.
 type Driver struct {
 	logger                      levels.Levels
@@ -105,15 +195,97 @@ func New(logger levels.Levels, sqlLogger mysql.Logger, maxSQLIdleConnections, ma
 	db.DB().SetMaxIdleConns(maxSQLIdleConnections)
 	db.DB().SetMaxOpenConns(maxSQLConcurrentConnections)
 
-	err = db.AutoMigrate(&record{}).Error
+	// existing installations get the size column with every size set to zero,
+	// new ones have nothing to backfill.
+	existing := db.HasTable(&record{})
+	err = db.AutoMigrate(&record{}, &changeRecord{}, &purgeRecord{}, &migrationRecord{}).Error
 	if err != nil {
 		return nil, err
 	}
 
 	c.db = db
+	if err := c.migrate(sizesMigration, existing, c.backfillSizes); err != nil {
+		logger.Error().Log("error", err, "msg", "error backfilling sizes")
+		return nil, err
+	}
 	return c, nil
 }
 
+// migrate runs fn unless the migration name is marked as done, and marks it once fn succeeds.
+// fn is not run when run is false, the migration is just marked. Migrations must be idempotent
+// as they are run again from the start when the process stops before marking them.
+func (c *Driver) migrate(name string, run bool, fn func() error) error {
+	err := c.db.Where("name=?", name).First(&migrationRecord{}).Error
+	if err == nil {
+		return nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if run {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return c.db.Create(&migrationRecord{Name: name, Done: time.Now().Unix()}).Error
+}
+
+// backfillSizes sets the sizes of the records of every home from the filesystem.
+// It runs once on installations that existed before the size column, the sizes are
+// computed again from the filesystem, so running it again after an interruption is safe.
+func (c *Driver) backfillSizes() error {
+	c.logger.Info().Log("msg", "backfilling sizes of records")
+	homes, err := ioutil.ReadDir(c.dataFolder)
+	if err != nil {
+		return err
+	}
+	for _, home := range homes {
+		if !home.IsDir() {
+			continue
+		}
+		localHome := filepath.Join(c.dataFolder, home.Name())
+		homeVirtualPath := secureJoin("/", string(home.Name()[0]), home.Name())
+		sizes := map[string]int64{}
+		err := filepath.Walk(localHome, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			path := secureJoin("/", strings.TrimPrefix(p, localHome))
+			sizes[path] = fi.Size()
+			for folder := filepath.Dir(path); ; folder = filepath.Dir(folder) {
+				sizes[folder] += fi.Size()
+				if folder == "/" {
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		tx := c.db.Begin()
+		// resources not in the map are empty and keep the default size of zero.
+		for path, size := range sizes {
+			if err := tx.Model(&record{}).Where("virtualpath=?", secureJoin(homeVirtualPath, path)).UpdateColumn("size", size).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		c.logger.Info().Log("msg", "sizes backfilled", "home", homeVirtualPath, "numresources", len(sizes))
+	}
+	return nil
+}
+
 // Init initializes the user home directory.
 func (c *Driver) Init(ctx context.Context, user lib.User) error {
 	localPath := c.getLocalPath(user, "/")
@@ -161,6 +333,89 @@ func (c *Driver) Examine(ctx context.Context, user lib.User, path string) (lib.F
 	return fileInfo, nil
 }
 
+// ExamineByID returns the resource of the user with the given id, wherever it has been moved.
+func (c *Driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	home := c.GetVirtualPath(user, "/")
+	rec := &record{}
+	err := c.db.Where("id=? AND (virtualpath=? OR virtualpath LIKE ?)", id, home, escapeLike(home)+"/%").First(rec).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("resource with id " + id + " not found")
+		}
+		c.logger.Error().Log("error", err, "msg", "error getting record by id")
+		return nil, err
+	}
+
+	_, path := splitVirtualPath(rec.VirtualPath)
+	return c.Examine(ctx, user, path)
+}
+
+// Search finds the resources of the user in the records table, so resources created outside of
+// the driver are found once they are synced. Records are filtered in the database by scope, name and
+// modification time, the rest of the filters are applied on the stat of the file, which also skips stale records.
+func (c *Driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	scope := filepath.Clean("/" + query.Scope)
+	osFileInfo, err := os.Stat(c.getLocalPath(user, scope))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	if !osFileInfo.IsDir() {
+		return nil, isFolderError("scope is not a folder")
+	}
+
+	db := c.db.Where("virtualpath LIKE ?", escapeLike(c.GetVirtualPath(user, scope))+"/%")
+	if pattern, ok := nameToLike(query.Name); ok {
+		db = db.Where("virtualpath LIKE ?", "%/"+pattern)
+	}
+	if query.ModifiedAfter > 0 {
+		db = db.Where("modtime > ?", query.ModifiedAfter)
+	}
+	if query.ModifiedBefore > 0 {
+		db = db.Where("modtime < ?", query.ModifiedBefore)
+	}
+
+	result := &searchResult{fileInfos: []lib.FileInfo{}}
+	var skipped int
+	for offset := 0; ; offset += searchBatchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var recs []record
+		if err := db.Order("virtualpath").Offset(offset).Limit(searchBatchSize).Find(&recs).Error; err != nil {
+			c.logger.Error().Log("error", err, "msg", "error searching records")
+			return nil, err
+		}
+		for i := range recs {
+			_, p := splitVirtualPath(recs[i].VirtualPath)
+			osFileInfo, err := os.Stat(c.getLocalPath(user, p))
+			if err != nil {
+				continue
+			}
+			fileInfo := c.getObjectInfo(p, osFileInfo, &recs[i])
+			if !searchfilter.Match(query, fileInfo) {
+				continue
+			}
+			if skipped < query.Offset {
+				skipped++
+				continue
+			}
+			if query.Limit > 0 && len(result.fileInfos) == query.Limit {
+				result.hasMore = true
+				return result, nil
+			}
+			result.fileInfos = append(result.fileInfos, fileInfo)
+		}
+		if len(recs) < searchBatchSize {
+			break
+		}
+	}
+	c.logger.Info().Log("msg", "search done", "scope", scope, "numresults", len(result.fileInfos))
+	return result, nil
+}
+
 func (c *Driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
 	localPath := c.getLocalPath(user, path)
 	osFileInfo, err := os.Stat(localPath)
@@ -197,6 +452,154 @@ func (c *Driver) ListFolder(ctx context.Context, user lib.User, path string) ([]
 	return fileInfos, nil
 }
 
+// ListFolderPage reads the folder in batches, so only the entries of the page are kept in memory.
+// Entries are sorted by the modification time and the folder sizes kept in the database, which are propagated from the children.
+// Records are only created for the entries of the page.
+func (c *Driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badInputError(err.Error())
+	}
+
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	if !osFileInfo.IsDir() {
+		return nil, isFolderError("file is not a folder")
+	}
+	fd, err := os.Open(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	for {
+		osFileInfos, err := fd.Readdir(readdirBatchSize)
+		if len(osFileInfos) > 0 {
+			records, dbErr := c.getRecords(user, path, osFileInfos)
+			if dbErr != nil {
+				return nil, dbErr
+			}
+			for _, fi := range osFileInfos {
+				key := lib.ListKey{Name: fi.Name(), Size: fi.Size(), Modified: fi.ModTime().UnixNano()}
+				if rec, ok := records[fi.Name()]; ok {
+					key.Modified = rec.ModTime
+					if fi.IsDir() {
+						key.Size = rec.Size
+					}
+				}
+				builder.Add(key, fi.IsDir(), fi)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	items, continuationToken := builder.Page()
+	fileInfos := []lib.FileInfo{}
+	for _, item := range items {
+		fi := item.(os.FileInfo)
+		p := filepath.Join(path, fi.Name())
+		rec, err := c.GetDBMetaData(c.GetVirtualPath(user, p), true, c.GetVirtualPath(user, "/"))
+		if err != nil {
+			return nil, err
+		}
+		fileInfos = append(fileInfos, c.getObjectInfo(p, fi, rec))
+	}
+	return &folderPage{fileInfos: fileInfos, continuationToken: continuationToken}, nil
+}
+
+// WalkFolder reads the folder in batches and calls fn with the entries of each batch, in directory order.
+// The records of a batch are read with a single query, records are only created for the entries that miss them.
+func (c *Driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	if !osFileInfo.IsDir() {
+		return isFolderError("file is not a folder")
+	}
+	fd, err := os.Open(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	defer fd.Close()
+
+	for {
+		osFileInfos, err := fd.Readdir(readdirBatchSize)
+		if len(osFileInfos) > 0 {
+			records, dbErr := c.getRecords(user, path, osFileInfos)
+			if dbErr != nil {
+				return dbErr
+			}
+			fileInfos := []lib.FileInfo{}
+			for _, fi := range osFileInfos {
+				p := filepath.Join(path, fi.Name())
+				rec, ok := records[fi.Name()]
+				if !ok {
+					rec, dbErr = c.GetDBMetaData(c.GetVirtualPath(user, p), true, c.GetVirtualPath(user, "/"))
+					if dbErr != nil {
+						return dbErr
+					}
+				}
+				fileInfos = append(fileInfos, c.getObjectInfo(p, fi, rec))
+			}
+			if err := fn(fileInfos); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// getRecords returns the records in the database of the entries of the folder by name.
+func (c *Driver) getRecords(user lib.User, path string, osFileInfos []os.FileInfo) (map[string]*record, error) {
+	virtualPaths := []string{}
+	for _, fi := range osFileInfos {
+		virtualPaths = append(virtualPaths, c.GetVirtualPath(user, filepath.Join(path, fi.Name())))
+	}
+	records := []*record{}
+	if err := c.db.Where("virtualpath IN (?)", virtualPaths).Find(&records).Error; err != nil {
+		c.logger.Error().Log("error", err, "msg", "error reading records")
+		return nil, err
+	}
+	recordsByName := map[string]*record{}
+	for _, rec := range records {
+		recordsByName[filepath.Base(rec.VirtualPath)] = rec
+	}
+	return recordsByName, nil
+}
+
 // DeleteObject deletes an object.
 func (c *Driver) Delete(ctx context.Context, user lib.User, path string) error {
 	localPath := c.getLocalPath(user, path)
@@ -227,6 +630,205 @@ func (c *Driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath
 	return c.MoveDBMetaData(sourceVirtualPath, targetVirtualPath, c.GetVirtualPath(user, "/"))
 }
 
+// SyncPath reconciles the metadata of path with the state of the filesystem.
+// It is used to pick up changes done directly on the data folder, like rsync restores.
+// Files are reconciled whenever their stat fingerprint differs from the one of their record,
+// so changes done through this driver are not propagated twice.
+func (c *Driver) SyncPath(ctx context.Context, user lib.User, path string) error {
+	return c.syncPath(user, path, true)
+}
+
+// syncPath reconciles the metadata of path. event tells if path comes from an explicit
+// filesystem event, which is authoritative, or from a walk of the tree, see modifiedOutOfBand.
+func (c *Driver) syncPath(user lib.User, path string, event bool) error {
+	localPath := c.getLocalPath(user, path)
+	virtualPath := c.GetVirtualPath(user, path)
+	homeVirtualPath := c.GetVirtualPath(user, "/")
+
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if _, err := c.getByVirtualPath(virtualPath); err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+		c.logger.Info().Log("msg", "resource removed out-of-band", "virtualpath", virtualPath)
+		return c.removeInDB(virtualPath, homeVirtualPath)
+	}
+
+	rec, err := c.getByVirtualPath(virtualPath)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if err == nil && !modifiedOutOfBand(rec, osFileInfo, event) {
+		if rec.Stat == "" && !osFileInfo.IsDir() {
+			// records written before fingerprints were stored get one the first time they are seen unchanged.
+			return c.db.Model(&record{}).Where("virtualpath=?", virtualPath).UpdateColumn("stat", statFingerprint(osFileInfo)).Error
+		}
+		return nil
+	}
+
+	// the checksum is not valid anymore as the content has been modified out-of-band.
+	c.logger.Info().Log("msg", "resource modified out-of-band", "virtualpath", virtualPath)
+	return c.SetDBMetaData(virtualPath, "", homeVirtualPath)
+}
+
+// modifiedOutOfBand returns if the resource has been modified since its record was written.
+// Files are compared by their stat fingerprint. Records without one are always reconciled on explicit
+// events, while walks of the tree fall back on comparing their size and modification time.
+// Folders change whenever their children change, so they are only compared by modification time:
+// their content is reconciled through the children.
+func modifiedOutOfBand(rec *record, osFileInfo os.FileInfo, event bool) bool {
+	if osFileInfo.IsDir() {
+		return rec.ModTime < osFileInfo.ModTime().UnixNano()
+	}
+	if rec.Stat != "" {
+		return rec.Stat != statFingerprint(osFileInfo)
+	}
+	if event {
+		return true
+	}
+	return rec.Size != osFileInfo.Size() || rec.ModTime < osFileInfo.ModTime().UnixNano()
+}
+
+// SyncMove reconciles the metadata after a resource has been moved directly on the data folder.
+// The records are moved so the resources keep their IDs.
+func (c *Driver) SyncMove(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	sourceVirtualPath := c.GetVirtualPath(user, sourcePath)
+	targetVirtualPath := c.GetVirtualPath(user, targetPath)
+
+	if _, err := c.getByVirtualPath(sourceVirtualPath); err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		// the records have already been moved, usually by this driver.
+		return c.SyncPath(ctx, user, targetPath)
+	}
+
+	c.logger.Info().Log("msg", "resource moved out-of-band", "source", sourceVirtualPath, "target", targetVirtualPath)
+	if err := c.MoveDBMetaData(sourceVirtualPath, targetVirtualPath, c.GetVirtualPath(user, "/")); err != nil {
+		return err
+	}
+	if err := c.SyncPath(ctx, user, filepath.Dir(secureJoin("/", sourcePath))); err != nil {
+		return err
+	}
+	return c.SyncPath(ctx, user, targetPath)
+}
+
+// SyncTree reconciles the metadata of path and all its descendants.
+// Records without a counterpart on the filesystem are removed and
+// the sizes of the folders are computed again from the filesystem.
+func (c *Driver) SyncTree(ctx context.Context, user lib.User, path string) error {
+	localPath := c.getLocalPath(user, path)
+	virtualPath := c.GetVirtualPath(user, path)
+	root := secureJoin("/", path)
+
+	if _, err := os.Stat(localPath); err != nil {
+		if os.IsNotExist(err) {
+			return c.SyncPath(ctx, user, path)
+		}
+		return err
+	}
+
+	seen := map[string]bool{}
+	sizes := map[string]int64{}
+	err := filepath.Walk(localPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// the resource may have been removed during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		childPath := secureJoin(path, strings.TrimPrefix(p, localPath))
+		seen[c.GetVirtualPath(user, childPath)] = true
+		if fi.IsDir() {
+			// folders are walked before their children.
+			sizes[childPath] = 0
+		} else if childPath != root {
+			for folder := filepath.Dir(childPath); ; folder = filepath.Dir(folder) {
+				sizes[folder] += fi.Size()
+				if folder == root || folder == "/" {
+					break
+				}
+			}
+		}
+		return c.syncPath(user, childPath, false)
+	})
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error walking tree")
+		return err
+	}
+
+	records, err := c.getChildrenrecords(virtualPath)
+	if err != nil {
+		return err
+	}
+
+	// parents are sorted before their children, so removing a parent
+	// also removes the records of its children in one go.
+	sort.Slice(records, func(i, j int) bool { return records[i].VirtualPath < records[j].VirtualPath })
+	var removed []string
+	for _, rec := range records {
+		if seen[rec.VirtualPath] || hasAncestor(rec.VirtualPath, removed) {
+			continue
+		}
+		c.logger.Info().Log("msg", "resource removed out-of-band", "virtualpath", rec.VirtualPath)
+		if err := c.removeInDB(rec.VirtualPath, c.GetVirtualPath(user, "/")); err != nil {
+			return err
+		}
+		removed = append(removed, rec.VirtualPath)
+	}
+	return c.syncSizes(user, root, sizes)
+}
+
+// syncSizes sets the sizes of the folders below root to the ones computed from the filesystem
+// and propagates the difference in size of root to its ancestors.
+func (c *Driver) syncSizes(user lib.User, root string, sizes map[string]int64) error {
+	rootSize, ok := sizes[root]
+	if !ok {
+		// root is a file, its size has already been synced.
+		return nil
+	}
+	for path, size := range sizes {
+		if path == root {
+			continue
+		}
+		err := c.db.Model(&record{}).Where("virtualpath=?", c.GetVirtualPath(user, path)).UpdateColumn("size", size).Error
+		if err != nil {
+			c.logger.Error().Log("error", err, "msg", "error syncing size", "path", path)
+			return err
+		}
+	}
+
+	rootVirtualPath := c.GetVirtualPath(user, root)
+	rec, err := c.getByVirtualPath(rootVirtualPath)
+	if err != nil {
+		return err
+	}
+	if rec.Size == rootSize {
+		return nil
+	}
+	c.logger.Info().Log("msg", "folder size synced", "virtualpath", rootVirtualPath, "oldsize", rec.Size, "size", rootSize)
+	if err := c.db.Model(&record{}).Where("virtualpath=?", rootVirtualPath).UpdateColumn("size", rootSize).Error; err != nil {
+		return err
+	}
+	return c.updateSizesInDB(c.getVirtualPathsUntilAncestor(rootVirtualPath, c.GetVirtualPath(user, "/")), rootSize-rec.Size)
+}
+
+func hasAncestor(virtualPath string, ancestors []string) bool {
+	for _, ancestor := range ancestors {
+		if strings.HasPrefix(virtualPath, ancestor+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Driver) getLocalPath(user lib.User, path string) string {
 	homeDir := secureJoin("/", user.Username())
 	userPath := secureJoin(homeDir, path)
@@ -239,7 +841,14 @@ func (c *Driver) GetVirtualPath(user lib.User, path string) string {
 	return secureJoin(homeDir, path)
 }
 func (c *Driver) getObjectInfo(path string, osFileInfo os.FileInfo, rec *record) lib.FileInfo {
-	return &fileInfo{path: path, osFileInfo: osFileInfo, checksum: rec.Checksum, etag: rec.ETag, id: rec.ID, mtime: rec.ModTime}
+	return &fileInfo{path: path, osFileInfo: osFileInfo, checksum: rec.Checksum, etag: rec.ETag, id: rec.ID, mtime: rec.ModTime, size: rec.Size}
+}
+
+// getLocalPathFromVirtualPath returns the path on the data folder of virtualPath.
+// Ex: /d/demo/photos/1.png => <datafolder>/demo/photos/1.png
+func (c *Driver) getLocalPathFromVirtualPath(virtualPath string) string {
+	home, path := splitVirtualPath(virtualPath)
+	return secureJoin(c.dataFolder, secureJoin("/", filepath.Base(home)), path)
 }
 
 // secureJoin avoids path traversal attacks when joinning paths.
@@ -285,33 +894,130 @@ func (c *Driver) GetDBMetaData(virtualPath string, forceCreateOnMiss bool, ances
 	return r, nil
 }
 
-func (c *Driver) PropagateChanges(user lib.User, from, to, checksum string) error {
+// PropagateChanges sets the metadata of from and propagates the changes to its ancestors until to (included).
+// modTime is the modification time of from, zero means now.
+func (c *Driver) PropagateChanges(user lib.User, from, to, checksum string, modTime int64) error {
 	vp := c.GetVirtualPath(user, from)
 	ancestor := c.GetVirtualPath(user, to)
-	return c.SetDBMetaData(vp, checksum, ancestor)
+	return c.setDBMetaData(vp, checksum, ancestor, modTime)
+}
+
+// RecursiveSizes returns true, the sizes of folders are propagated from their children.
+func (c *Driver) RecursiveSizes() bool {
+	return true
+}
+
+// SetModTime sets the modification time of the resource on the filesystem and in its record.
+// The modification time of folders is used to compare-and-swap the propagation of changes,
+// so folders can not be set in the future.
+func (c *Driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	if now := time.Now().UnixNano(); osFileInfo.IsDir() && modTime > now {
+		modTime = now
+	}
+
+	rec, err := c.GetDBMetaData(c.GetVirtualPath(user, path), true, c.GetVirtualPath(user, "/"))
+	if err != nil {
+		return err
+	}
+
+	t := time.Unix(0, modTime)
+	if err := os.Chtimes(localPath, t, t); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting modification time")
+		return err
+	}
+	return c.setDBMetaData(c.GetVirtualPath(user, path), rec.Checksum, c.GetVirtualPath(user, "/"), modTime)
+}
+
+// SetFileID assigns the id to the resource. The id is the primary key of the records table,
+// so it can not be assigned when any resource of any user already has it.
+func (c *Driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	if id == "" {
+		return badInputError("id is empty")
+	}
+	if _, err := os.Stat(c.getLocalPath(user, path)); err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+
+	virtualPath := c.GetVirtualPath(user, path)
+	rec, err := c.GetDBMetaData(virtualPath, true, c.GetVirtualPath(user, "/"))
+	if err != nil {
+		return err
+	}
+	if rec.ID == id {
+		return nil
+	}
+
+	other := &record{}
+	err = c.db.Where("id=?", id).First(other).Error
+	if err == nil {
+		return alreadyExistError(fmt.Sprintf("id %s already assigned to %s", id, other.VirtualPath))
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err := c.db.Model(&record{}).Where("virtualpath=?", virtualPath).UpdateColumn("id", id).Error; err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting id")
+		return err
+	}
+	return nil
 }
 
 // SetDBMetaData sets the metatadata for this virtualPath.
 func (c *Driver) SetDBMetaData(virtualPath, checksum string, ancestorVirtualPath string) error {
+	return c.setDBMetaData(virtualPath, checksum, ancestorVirtualPath, 0)
+}
+
+// setDBMetaData sets the metadata for this virtualPath with the given modification time, zero means now.
+// The ancestors always get the current time, as the propagation only moves forward in time.
+func (c *Driver) setDBMetaData(virtualPath, checksum string, ancestorVirtualPath string, modTime int64) error {
 	etag := uuid.NewV4().String()
-	modTime := time.Now().UnixNano()
+	propagationTime := time.Now().UnixNano()
+	if modTime == 0 {
+		modTime = propagationTime
+	}
 	id := etag
 
 	// if the record already exists, we need to use its ID instead
 	// creating a new one
+	kind := lib.ChangeCreate
+	var size, oldSize int64
+	var stat string
 	r, err := c.getByVirtualPath(virtualPath)
 	if err == nil {
 		c.logger.Debug().Log("record", *r, "msg", "id set to record.ID")
 		id = r.ID
+		kind = lib.ChangeModify
+		size, oldSize = r.Size, r.Size
+	}
+
+	// the size of files is taken from the blob, folders keep the aggregate
+	// size of their children.
+	osFileInfo, err := os.Stat(c.getLocalPathFromVirtualPath(virtualPath))
+	if err == nil && !osFileInfo.IsDir() {
+		size = osFileInfo.Size()
+		stat = statFingerprint(osFileInfo)
 	}
 
-	err = c.insertOrUpdateIntoDB(id, virtualPath, checksum, etag, modTime)
+	err = c.insertOrUpdateIntoDB(id, virtualPath, checksum, etag, stat, modTime, size)
 	if err != nil {
 		c.logger.Error().Log("error", err, "error inserting record")
 		return err
 	}
+	c.recordChange(kind, id, virtualPath, "")
 
-	err = c.propagateChangesInDB(virtualPath, etag, modTime, ancestorVirtualPath)
+	err = c.propagateChangesInDB(virtualPath, etag, propagationTime, size-oldSize, ancestorVirtualPath)
 	if err != nil {
 		c.logger.Error().Log("error", err, "msg", "error propagating changes")
 		// we do not return an error here as it is quite
@@ -333,11 +1039,24 @@ func (c *Driver) MoveDBMetaData(sourceVirtualPath, targetVirtualPath, ancestorVi
 		return err
 	}
 
+	var id string
+	var size int64
 	tx := c.db.Begin()
 	for _, rec := range records {
+		if rec.VirtualPath == sourceVirtualPath {
+			id = rec.ID
+			size = rec.Size
+		}
 		newVirtualPath := secureJoin(targetVirtualPath, strings.TrimPrefix(rec.VirtualPath, sourceVirtualPath))
 		c.logger.Debug().Log("sourcevirtualpath", rec.VirtualPath, "targetvirtualpath", newVirtualPath, "msg", "record to be moved")
-		if err := c.db.Model(&record{}).Where("id=?", rec.ID).Updates(&record{VirtualPath: newVirtualPath}).Error; err != nil {
+		updates := &record{VirtualPath: newVirtualPath}
+		if rec.VirtualPath == sourceVirtualPath && rec.Stat != "" {
+			// renaming changes the change time of the moved resource.
+			if osFileInfo, err := os.Stat(c.getLocalPathFromVirtualPath(targetVirtualPath)); err == nil && !osFileInfo.IsDir() {
+				updates.Stat = statFingerprint(osFileInfo)
+			}
+		}
+		if err := c.db.Model(&record{}).Where("id=?", rec.ID).Updates(updates).Error; err != nil {
 			c.logger.Error().Log("error", err, "msg", "error updating virtualpath")
 			if err := tx.Rollback().Error; err != nil {
 				c.logger.Crit().Log("error", err, "msg", "error rollbacking operation")
@@ -347,11 +1066,20 @@ func (c *Driver) MoveDBMetaData(sourceVirtualPath, targetVirtualPath, ancestorVi
 		}
 	}
 	tx.Commit()
+	if id != "" {
+		c.recordChange(lib.ChangeMove, id, targetVirtualPath, sourceVirtualPath)
+	}
+
+	// the size of the resource leaves the source ancestors and it is
+	// added to the target ancestors by the propagation.
+	if err := c.updateSizesInDB(c.getVirtualPathsUntilAncestor(sourceVirtualPath, ancestorVirtualPath), -size); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error updating sizes of source ancestors")
+	}
 
 	etag := uuid.NewV4().String()
 	modTime := time.Now().UnixNano()
 
-	err = c.propagateChangesInDB(targetVirtualPath, etag, modTime, ancestorVirtualPath)
+	err = c.propagateChangesInDB(targetVirtualPath, etag, modTime, size, ancestorVirtualPath)
 	if err != nil {
 		c.logger.Error().Log("error", err, "error propagating changes")
 		// we do not return an error here as it is quite
@@ -367,7 +1095,7 @@ func (c *Driver) MoveDBMetaData(sourceVirtualPath, targetVirtualPath, ancestorVi
 func (c *Driver) getChildrenrecords(virtualPath string) ([]record, error) {
 	var records []record
 
-	err := c.db.Where("virtualpath LIKE ? or virtualpath=?", virtualPath+"/%", virtualPath).Find(&records).Error
+	err := c.db.Where("virtualpath LIKE ? or virtualpath=?", escapeLike(virtualPath)+"/%", virtualPath).Find(&records).Error
 	return records, err
 }
 
@@ -378,7 +1106,8 @@ func (c *Driver) getChildrenrecords(virtualPath string) ([]record, error) {
 // the etag and mtime values will be updated also at:
 // 1st) /d/demo/photos
 // 2nd) /d/demo
-func (c *Driver) propagateChangesInDB(virtualPath, etag string, modTime int64, ancestor string) error {
+// sizeDelta is added to the size of the same ancestors.
+func (c *Driver) propagateChangesInDB(virtualPath, etag string, modTime, sizeDelta int64, ancestor string) error {
 	c.logger.Debug().Log("virtualpath", virtualPath, "etag", etag, "mtime", modTime, "record that triggered propagation")
 	// virtualPathsToUpdate are sorted from largest to shortest virtual paths.
 	// Ex: "/d/demo/photos" comes before "/d/demo/"
@@ -386,6 +1115,19 @@ func (c *Driver) propagateChangesInDB(virtualPath, etag string, modTime int64, a
 	virtualPathsToUpdate := c.getVirtualPathsUntilAncestor(virtualPath, ancestor)
 	c.logger.Debug().Log("virtualpaths2update", virtualPathsToUpdate, "msg", "virtual paths to update")
 
+	err := c.propagateETagsInDB(virtualPathsToUpdate, etag, modTime)
+
+	// sizes are not protected by the CAS on the mtime, every change needs to reach
+	// all the ancestors even if the propagation of the etag has been aborted.
+	// Missing ancestors have been inserted by the etag propagation.
+	if sizeErr := c.updateSizesInDB(virtualPathsToUpdate, sizeDelta); sizeErr != nil {
+		c.logger.Error().Log("error", sizeErr, "msg", "error propagating size")
+		return sizeErr
+	}
+	return err
+}
+
+func (c *Driver) propagateETagsInDB(virtualPathsToUpdate []string, etag string, modTime int64) error {
 	for _, vp := range virtualPathsToUpdate {
 		affectedRows := c.updateInDB(vp, etag, modTime)
 		if affectedRows == 0 {
@@ -455,16 +1197,26 @@ func (c *Driver) getVirtualPathsUntilAncestor(virtualPath, ancestor string) []st
 	return virtualPaths
 }
 
-func (c *Driver) insertOrUpdateIntoDB(id, virtualPath, checksum, etag string, modTime int64) error {
-	c.logger.Debug().Log("msg", "record to be inserted", "id", id, "virtualpath", virtualPath, "etag", etag, "mtime", modTime, "checksum", checksum)
+func (c *Driver) insertOrUpdateIntoDB(id, virtualPath, checksum, etag, stat string, modTime, size int64) error {
+	c.logger.Debug().Log("msg", "record to be inserted", "id", id, "virtualpath", virtualPath, "etag", etag, "mtime", modTime, "checksum", checksum, "size", size, "stat", stat)
 	// this query only works on MySQL/MariaDB databases as it uses ON DUPLICATE KEY UPDATE feature
 	// to implement an atomic operation, either an insert or an update.
-	err := c.db.Exec(`INSERT INTO records (id,virtualpath,checksum, etag, modtime) VALUES (?,?,?,?,?)
-	ON DUPLICATE KEY UPDATE checksum=VALUES(checksum), etag=VALUES(etag), modtime=VALUES(modtime)`,
-		id, virtualPath, checksum, etag, modTime).Error
+	err := c.db.Exec(`INSERT INTO records (id,virtualpath,checksum, etag, modtime, size, stat) VALUES (?,?,?,?,?,?,?)
+	ON DUPLICATE KEY UPDATE checksum=VALUES(checksum), etag=VALUES(etag), modtime=VALUES(modtime), size=VALUES(size), stat=VALUES(stat)`,
+		id, virtualPath, checksum, etag, modTime, size, stat).Error
 	return err
 }
 
+// updateSizesInDB adds delta to the size of virtualPaths in a single atomic update,
+// so concurrent changes on different children are all accounted.
+func (c *Driver) updateSizesInDB(virtualPaths []string, delta int64) error {
+	if delta == 0 || len(virtualPaths) == 0 {
+		return nil
+	}
+	c.logger.Debug().Log("msg", "sizes to be updated", "virtualpaths", virtualPaths, "delta", delta)
+	return c.db.Model(&record{}).Where("virtualpath IN (?)", virtualPaths).UpdateColumn("size", gorm.Expr("size + ?", delta)).Error
+}
+
 func (c *Driver) updateInDB(virtualPath, etag string, modTime int64) int64 {
 	c.logger.Debug().Log("msg", "record to be updated", "virtualpath", virtualPath, "etag", etag, "mtime", modTime)
 	return c.db.Model(&record{}).Where("virtualpath=? AND modtime < ?", virtualPath, modTime).Updates(&record{ETag: etag, ModTime: modTime}).RowsAffected
@@ -480,14 +1232,20 @@ func (c *Driver) insertIntoDB(id, virtualPath, checksum, etag string, modTime in
 func (c *Driver) removeInDB(virtualPath, ancestorVirtualPath string) error {
 	c.logger.Debug().Log("msg", "record to be removed", "virtualpath", virtualPath)
 	removeBeforeTS := time.Now().UnixNano()
-	err := c.db.Where("(virtualpath LIKE ? OR virtualpath=? ) AND modtime < ?", virtualPath+"/%", virtualPath, removeBeforeTS).Delete(&record{}).Error
+	r, recErr := c.getByVirtualPath(virtualPath)
+	err := c.db.Where("(virtualpath LIKE ? OR virtualpath=? ) AND modtime < ?", escapeLike(virtualPath)+"/%", virtualPath, removeBeforeTS).Delete(&record{}).Error
 	if err != nil {
 		return err
 	}
+	var size int64
+	if recErr == nil {
+		c.recordChange(lib.ChangeDelete, r.ID, virtualPath, "")
+		size = r.Size
+	}
 
 	// after deleting a resource we need to propagate changes up in the tree
 	etag := uuid.NewV4().String()
-	err = c.propagateChangesInDB(virtualPath, etag, removeBeforeTS, ancestorVirtualPath)
+	err = c.propagateChangesInDB(virtualPath, etag, removeBeforeTS, -size, ancestorVirtualPath)
 	if err != nil {
 		c.logger.Error().Log("error", err, "msg", "error propagating changes")
 		// we do not return an error here as it is quite
@@ -500,6 +1258,284 @@ func (c *Driver) removeInDB(virtualPath, ancestorVirtualPath string) error {
 	return nil
 }
 
+// recordChange appends a change to the journal.
+// Errors are logged but not returned, as the change has already been applied.
+func (c *Driver) recordChange(kind lib.ChangeKind, id, virtualPath, sourceVirtualPath string) {
+	home, path := splitVirtualPath(virtualPath)
+	rec := &changeRecord{
+		Home:      home,
+		Kind:      kind,
+		FileID:    id,
+		Path:      path,
+		Timestamp: time.Now().UnixNano(),
+	}
+	if sourceVirtualPath != "" {
+		_, rec.SourcePath = splitVirtualPath(sourceVirtualPath)
+	}
+	if err := c.db.Create(rec).Error; err != nil {
+		c.logger.Error().Log("error", err, "msg", "error recording change", "virtualpath", virtualPath)
+	}
+}
+
+// GetChanges returns the changes in the namespace of the user after cursor.
+// An empty or expired cursor returns a reset change set with the latest cursor,
+// telling the client it needs to list its namespace again.
+// Seqs are shared by all the homes, a cursor expires when changes of its home after its seq have been purged.
+func (c *Driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	home := c.GetVirtualPath(user, "/")
+	now := time.Now()
+	if limit <= 0 {
+		limit = defaultChangesLimit
+	}
+
+	if err := c.purgeChanges(home, now.Add(-changeRetention).UnixNano()); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error purging journal")
+	}
+
+	var seq uint64
+	if cursor != "" {
+		var err error
+		seq, _, err = decodeCursor(cursor)
+		if err != nil {
+			c.logger.Error().Log("error", err, "cursor", cursor)
+			return nil, badCursorError("invalid cursor")
+		}
+	}
+
+	highWaterMark, err := c.getHighWaterMark(now.Add(-changeSettle).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	purge := &purgeRecord{}
+	if err := c.db.Where("home=?", home).First(purge).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if cursor == "" || seq < purge.Seq {
+		latest := &changeRecord{}
+		err := c.db.Where("home=? AND seq <= ?", home, highWaterMark).Order("seq desc").First(latest).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		// a home without changes left in the journal starts after the purged ones.
+		if latest.Seq < purge.Seq {
+			latest.Seq = purge.Seq
+		}
+		c.logger.Info().Log("msg", "change journal reset", "home", home, "cursor", cursor)
+		return &changeSet{cursor: encodeCursor(latest.Seq, now.UnixNano()), reset: true}, nil
+	}
+
+	var recs []changeRecord
+	err = c.db.Where("home=? AND seq > ? AND seq <= ?", home, seq, highWaterMark).Order("seq").Limit(limit + 1).Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	set := &changeSet{}
+	if len(recs) > limit {
+		recs = recs[:limit]
+		set.hasMore = true
+	}
+	for _, rec := range recs {
+		seq = rec.Seq
+		set.changes = append(set.changes, &change{
+			kind:       rec.Kind,
+			id:         rec.FileID,
+			path:       rec.Path,
+			sourcePath: rec.SourcePath,
+			timestamp:  rec.Timestamp,
+		})
+	}
+	set.cursor = encodeCursor(seq, now.UnixNano())
+	return set, nil
+}
+
+// getHighWaterMark returns the seq up to which the changes can be delivered, the one before
+// the first change recorded after settledBefore, as changes before it may not be committed yet.
+// Without recent changes it is the last seq of the journal.
+func (c *Driver) getHighWaterMark(settledBefore int64) (uint64, error) {
+	rec := &changeRecord{}
+	err := c.db.Where("timestamp >= ?", settledBefore).Order("seq").First(rec).Error
+	if err == nil {
+		return rec.Seq - 1, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	err = c.db.Order("seq desc").First(rec).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	return rec.Seq, nil
+}
+
+// purgeChanges removes the changes of the home recorded before expiredBefore
+// and keeps the highest seq removed, so cursors pointing before it are reset.
+func (c *Driver) purgeChanges(home string, expiredBefore int64) error {
+	last := &changeRecord{}
+	err := c.db.Where("home=? AND timestamp < ?", home, expiredBefore).Order("seq desc").First(last).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tx := c.db.Begin()
+	if err := tx.Where("home=? AND seq <= ?", home, last.Seq).Delete(&changeRecord{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	purge := &purgeRecord{}
+	if err := tx.Where("home=?", home).FirstOrInit(purge, purgeRecord{Home: home}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if last.Seq > purge.Seq {
+		purge.Seq = last.Seq
+		if err := tx.Save(purge).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// splitVirtualPath splits a virtual path into the virtual path of the home and the path relative to it.
+// Ex: /d/demo/photos/1.png => /d/demo, /photos/1.png
+func splitVirtualPath(virtualPath string) (string, string) {
+	tokens := strings.SplitN(strings.TrimPrefix(virtualPath, "/"), "/", 3)
+	if len(tokens) < 2 {
+		return virtualPath, "/"
+	}
+	home := "/" + tokens[0] + "/" + tokens[1]
+	if len(tokens) == 2 {
+		return home, "/"
+	}
+	return home, "/" + tokens[2]
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// nameToLike translates the name filter of a search to a LIKE pattern for the last component
+// of the virtual path. Character classes can not be translated, those names are only filtered in memory.
+func nameToLike(name string) (string, bool) {
+	if name == "" || strings.Contains(name, "[") {
+		return "", false
+	}
+	pattern := escapeLike(name)
+	if !strings.ContainsAny(name, "*?") {
+		return "%" + pattern + "%", true
+	}
+	return strings.NewReplacer("*", "%", "?", "_").Replace(pattern), true
+}
+
+// encodeCursor returns an opaque cursor pointing to seq, timestamp is when it was given to the client.
+func encodeCursor(seq uint64, timestamp int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", seq, timestamp)))
+}
+
+func decodeCursor(cursor string) (uint64, int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	tokens := strings.Split(string(data), ":")
+	if len(tokens) != 2 {
+		return 0, 0, fmt.Errorf("cursor has %d tokens", len(tokens))
+	}
+	seq, err := strconv.ParseUint(tokens[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	timestamp, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return seq, timestamp, nil
+}
+
+type change struct {
+	kind       lib.ChangeKind
+	id         string
+	path       string
+	sourcePath string
+	timestamp  int64
+}
+
+func (c *change) Kind() lib.ChangeKind {
+	return c.kind
+}
+
+func (c *change) ID() string {
+	return c.id
+}
+
+func (c *change) Path() string {
+	return c.path
+}
+
+func (c *change) SourcePath() string {
+	return c.sourcePath
+}
+
+func (c *change) Timestamp() int64 {
+	return c.timestamp
+}
+
+type changeSet struct {
+	changes []lib.Change
+	cursor  string
+	hasMore bool
+	reset   bool
+}
+
+func (s *changeSet) Changes() []lib.Change {
+	return s.changes
+}
+
+func (s *changeSet) Cursor() string {
+	return s.cursor
+}
+
+func (s *changeSet) HasMore() bool {
+	return s.hasMore
+}
+
+func (s *changeSet) Reset() bool {
+	return s.reset
+}
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
+type searchResult struct {
+	fileInfos []lib.FileInfo
+	hasMore   bool
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	return r.fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
 type fileInfo struct {
 	path       string
 	osFileInfo os.FileInfo
@@ -507,6 +1543,7 @@ type fileInfo struct {
 	etag       string
 	id         string
 	mtime      int64
+	size       int64
 }
 
 func (f *fileInfo) Path() string {
@@ -517,7 +1554,11 @@ func (f *fileInfo) Folder() bool {
 	return f.osFileInfo.IsDir()
 }
 
+// Size returns the aggregate size kept in the database for folders.
 func (f *fileInfo) Size() int64 {
+	if f.osFileInfo.IsDir() {
+		return f.size
+	}
 	return int64(f.osFileInfo.Size())
 }
 
@@ -573,6 +1614,42 @@ func (e isFolderError) Message() string {
 	return string(e)
 }
 
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type badCursorError string
+
+func (e badCursorError) Error() string {
+	return string(e)
+}
+func (e badCursorError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badCursorError) Message() string {
+	return string(e)
+}
+
 type renameError string
 
 func (e renameError) Error() string {
@@ -0,0 +1,17 @@
+package ocfsmdatadriver
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// statFingerprint returns the inode, change time, size and modification time of the file.
+// The change time can not be set from user space, so it detects restores that keep an older mtime.
+func statFingerprint(fi os.FileInfo) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Sprintf("%d:%d", fi.Size(), fi.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", st.Ino, st.Ctim.Nano(), fi.Size(), fi.ModTime().UnixNano())
+}
@@ -0,0 +1,1203 @@
+package ocsharewebservice
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// ownCloud share types.
+	shareTypeUser  = 0
+	shareTypeGroup = 1
+	shareTypeLink  = 3
+
+	// OCS status codes used by the ownCloud sharing API.
+	ocsCodeOK          = 100
+	ocsCodeBadRequest  = 400
+	ocsCodeForbidden   = 403
+	ocsCodeNotFound    = 404
+	ocsCodeServerError = 996
+
+	// expirationLayout is the format of the expiration dates in the sharing API.
+	expirationLayout = "2006-01-02"
+
+	publicWebDAVPrefix = "/ocwebdav/public.php/webdav"
+
+	// linkPermissions are the permissions a link share can have, links can not be reshared.
+	linkPermissions = lib.PermissionRead | lib.PermissionUpdate | lib.PermissionCreate | lib.PermissionDelete
+
+	// userSharePermissions are the permissions a share with a user or a group can have, resharing is not supported.
+	userSharePermissions = lib.PermissionRead | lib.PermissionUpdate | lib.PermissionCreate | lib.PermissionDelete
+
+	// defaultSharesFolder and defaultSpacesFolder are the virtual folders of the share and space drivers,
+	// which list resources of other users and can not be shared.
+	defaultSharesFolder = "/Shares"
+	defaultSpacesFolder = "/Spaces"
+)
+
+type service struct {
+	cm                lib.ContextManager
+	logger            levels.Levels
+	dataDriver        lib.DataDriver
+	metaDataDriver    lib.MetaDataDriver
+	shareDriver       lib.ShareDriver
+	bam               lib.BasicAuthMiddleware
+	mg                lib.MimeGuesser
+	archiver          lib.Archiver
+	uploadMaxFileSize int64
+	sharesFolder      string
+	spacesFolder      string
+}
+
+// New returns a web service that implements the ownCloud OCS sharing API
+// for public links and shares with users and groups, and the anonymous endpoints used to access the links.
+// Anonymous requests are served through the data and metadata drivers on behalf of the owner of the share.
+// sharesFolder and spacesFolder are the folders where shares and spaces are mounted, they can not be shared.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	dataDriver lib.DataDriver,
+	metaDataDriver lib.MetaDataDriver,
+	shareDriver lib.ShareDriver,
+	bam lib.BasicAuthMiddleware,
+	mg lib.MimeGuesser,
+	archiver lib.Archiver,
+	uploadMaxFileSize int64,
+	sharesFolder string,
+	spacesFolder string) lib.WebService {
+	if sharesFolder == "" {
+		sharesFolder = defaultSharesFolder
+	}
+	if spacesFolder == "" {
+		spacesFolder = defaultSpacesFolder
+	}
+	return &service{
+		cm:                cm,
+		logger:            logger,
+		dataDriver:        dataDriver,
+		metaDataDriver:    metaDataDriver,
+		shareDriver:       shareDriver,
+		bam:               bam,
+		mg:                mg,
+		archiver:          archiver,
+		uploadMaxFileSize: uploadMaxFileSize,
+		sharesFolder:      filepath.Clean("/" + sharesFolder),
+		spacesFolder:      filepath.Clean("/" + spacesFolder),
+	}
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/ocwebdav/ocs/v1.php/apps/files_sharing/api/v1/shares": {
+			"GET":  s.bam.HandlerFunc(s.listSharesEndpoint),
+			"POST": s.bam.HandlerFunc(s.createShareEndpoint),
+		},
+		"/ocwebdav/ocs/v1.php/apps/files_sharing/api/v1/shares/{id}": {
+			"GET":    s.bam.HandlerFunc(s.getShareEndpoint),
+			"PUT":    s.bam.HandlerFunc(s.updateShareEndpoint),
+			"DELETE": s.bam.HandlerFunc(s.deleteShareEndpoint),
+		},
+		"/ocwebdav/public.php/webdav{path:.*}": {
+			"GET":      s.publicGetEndpoint,
+			"HEAD":     s.publicHeadEndpoint,
+			"PUT":      s.publicPutEndpoint,
+			"PROPFIND": s.publicPropfindEndpoint,
+			"MKCOL":    s.publicMkcolEndpoint,
+			"DELETE":   s.publicDeleteEndpoint,
+			"OPTIONS":  s.publicOptionsEndpoint,
+		},
+		"/ocwebdav/s/{token}": {
+			"GET": s.publicDownloadEndpoint,
+		},
+	}
+}
+
+func (s *service) listSharesEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	data := &ocsElements{}
+	if r.URL.Query().Get("shared_with_me") == "true" {
+		shares, err := s.shareDriver.ListReceivedShares(r.Context(), user)
+		if err != nil {
+			s.handleOCSError(err, w, r)
+			return
+		}
+		for _, share := range shares {
+			data.Elements = append(data.Elements, s.userShareToShareData(r, share))
+		}
+		logger.Info().Log("msg", "received shares listed", "numshares", len(shares))
+		s.writeOCS(w, r, ocsCodeOK, "", data)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	linkShares, err := s.shareDriver.ListLinkShares(r.Context(), user, path)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	userShares, err := s.shareDriver.ListUserShares(r.Context(), user, path)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+
+	for _, share := range userShares {
+		data.Elements = append(data.Elements, s.userShareToShareData(r, share))
+	}
+	for _, share := range linkShares {
+		data.Elements = append(data.Elements, s.linkShareToShareData(r, share))
+	}
+	logger.Info().Log("msg", "shares listed", "numshares", len(data.Elements))
+	s.writeOCS(w, r, ocsCodeOK, "", data)
+}
+
+func (s *service) getShareEndpoint(w http.ResponseWriter, r *http.Request) {
+	user := s.cm.MustGetUser(r.Context())
+	id := mux.Vars(r)["id"]
+
+	// link shares and user shares have different ids, so both are looked up.
+	share, err := s.shareDriver.GetLinkShare(r.Context(), user, id)
+	if err == nil {
+		s.writeOCS(w, r, ocsCodeOK, "", &ocsElements{Elements: []*shareData{s.linkShareToShareData(r, share)}})
+		return
+	}
+	if !isNotFoundError(err) {
+		s.handleOCSError(err, w, r)
+		return
+	}
+
+	userShare, err := s.shareDriver.GetUserShare(r.Context(), user, id)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", &ocsElements{Elements: []*shareData{s.userShareToShareData(r, userShare)}})
+}
+
+func (s *service) createShareEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error().Log("error", err)
+		s.writeOCS(w, r, ocsCodeBadRequest, "invalid form", nil)
+		return
+	}
+
+	shareType, err := strconv.Atoi(r.PostForm.Get("shareType"))
+	if err != nil || (shareType != shareTypeLink && shareType != shareTypeUser && shareType != shareTypeGroup) {
+		logger.Warn().Log("msg", "share type not supported", "sharetype", r.PostForm.Get("shareType"))
+		s.writeOCS(w, r, ocsCodeBadRequest, "unknown share type", nil)
+		return
+	}
+
+	path := filepath.Clean("/" + r.PostForm.Get("path"))
+	if path == "/" {
+		s.writeOCS(w, r, ocsCodeForbidden, "you can not share your root folder", nil)
+		return
+	}
+	if path == s.sharesFolder || path == s.spacesFolder {
+		s.writeOCS(w, r, ocsCodeForbidden, "you can not share the shares or spaces folders", nil)
+		return
+	}
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil {
+		if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeNotFound {
+			s.writeOCS(w, r, ocsCodeNotFound, "wrong path, file/folder doesn't exist", nil)
+			return
+		}
+		s.handleOCSError(err, w, r)
+		return
+	}
+	if owner, _ := fileInfo.ExtraAttributes()["owner"].(string); owner != "" {
+		s.writeOCS(w, r, ocsCodeForbidden, "resharing is not supported", nil)
+		return
+	}
+	// resources of other users, like spaces, carry the permissions of the user on them.
+	if permissions, ok := fileInfo.ExtraAttributes()["permissions"].(lib.SharePermission); ok && permissions&lib.PermissionShare == 0 {
+		s.writeOCS(w, r, ocsCodeForbidden, "you are not allowed to share this resource", nil)
+		return
+	}
+
+	if shareType != shareTypeLink {
+		s.createUserShare(w, r, path, fileInfo, lib.ShareRecipientType(shareType))
+		return
+	}
+
+	permissions := lib.PermissionRead
+	if r.PostForm.Get("publicUpload") == "true" {
+		permissions = linkPermissions
+	}
+	if p := r.PostForm.Get("permissions"); p != "" {
+		permissions, err = parsePermissions(p)
+		if err != nil {
+			s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+			return
+		}
+	}
+	if !fileInfo.Folder() && permissions != lib.PermissionRead {
+		s.writeOCS(w, r, ocsCodeBadRequest, "public upload is only possible for publicly shared folders", nil)
+		return
+	}
+
+	var expiration int64
+	if d := r.PostForm.Get("expireDate"); d != "" {
+		expiration, err = parseExpiration(d)
+		if err != nil {
+			s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	share, err := s.shareDriver.CreateLinkShare(r.Context(), user, path, permissions, r.PostForm.Get("password"), expiration)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", s.linkShareToShareData(r, share))
+}
+
+// createUserShare shares the resource with the user or the group in the shareWith parameter.
+// Like ownCloud, files are shared with read and update permissions and folders with all the permissions by default.
+func (s *service) createUserShare(w http.ResponseWriter, r *http.Request, path string, fileInfo lib.FileInfo, recipientType lib.ShareRecipientType) {
+	user := s.cm.MustGetUser(r.Context())
+
+	recipient := r.PostForm.Get("shareWith")
+	if recipient == "" {
+		s.writeOCS(w, r, ocsCodeBadRequest, "please specify a valid user or group", nil)
+		return
+	}
+
+	permissions := userSharePermissions
+	if !fileInfo.Folder() {
+		permissions = lib.PermissionRead | lib.PermissionUpdate
+	}
+	if p := r.PostForm.Get("permissions"); p != "" {
+		var err error
+		permissions, err = parseUserSharePermissions(p, fileInfo.Folder())
+		if err != nil {
+			s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	share, err := s.shareDriver.CreateUserShare(r.Context(), user, path, recipient, recipientType, permissions)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", s.userShareToShareData(r, share))
+}
+
+// updateShareEndpoint updates the attributes present in the form.
+// ownCloud clients send one attribute per request.
+func (s *service) updateShareEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	id := mux.Vars(r)["id"]
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error().Log("error", err)
+		s.writeOCS(w, r, ocsCodeBadRequest, "invalid form", nil)
+		return
+	}
+
+	share, err := s.shareDriver.GetLinkShare(r.Context(), user, id)
+	if err != nil {
+		if isNotFoundError(err) {
+			s.updateUserShare(w, r, id)
+			return
+		}
+		s.handleOCSError(err, w, r)
+		return
+	}
+
+	var updated bool
+	if _, ok := r.PostForm["permissions"]; ok {
+		permissions, err := parsePermissions(r.PostForm.Get("permissions"))
+		if err != nil {
+			s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+			return
+		}
+		if !s.canHavePermissions(r.Context(), share, permissions) {
+			s.writeOCS(w, r, ocsCodeBadRequest, "public upload is only possible for publicly shared folders", nil)
+			return
+		}
+		if err := s.shareDriver.SetLinkSharePermissions(r.Context(), user, id, permissions); err != nil {
+			s.handleOCSError(err, w, r)
+			return
+		}
+		updated = true
+	}
+	if _, ok := r.PostForm["publicUpload"]; ok {
+		permissions := lib.PermissionRead
+		if r.PostForm.Get("publicUpload") == "true" {
+			permissions = linkPermissions
+		}
+		if !s.canHavePermissions(r.Context(), share, permissions) {
+			s.writeOCS(w, r, ocsCodeBadRequest, "public upload is only possible for publicly shared folders", nil)
+			return
+		}
+		if err := s.shareDriver.SetLinkSharePermissions(r.Context(), user, id, permissions); err != nil {
+			s.handleOCSError(err, w, r)
+			return
+		}
+		updated = true
+	}
+	if _, ok := r.PostForm["password"]; ok {
+		if err := s.shareDriver.SetLinkSharePassword(r.Context(), user, id, r.PostForm.Get("password")); err != nil {
+			s.handleOCSError(err, w, r)
+			return
+		}
+		updated = true
+	}
+	if _, ok := r.PostForm["expireDate"]; ok {
+		var expiration int64
+		if d := r.PostForm.Get("expireDate"); d != "" {
+			expiration, err = parseExpiration(d)
+			if err != nil {
+				s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+				return
+			}
+		}
+		if err := s.shareDriver.SetLinkShareExpiration(r.Context(), user, id, expiration); err != nil {
+			s.handleOCSError(err, w, r)
+			return
+		}
+		updated = true
+	}
+
+	if !updated {
+		s.writeOCS(w, r, ocsCodeBadRequest, "wrong or no update parameter given", nil)
+		return
+	}
+
+	share, err = s.shareDriver.GetLinkShare(r.Context(), user, id)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", s.linkShareToShareData(r, share))
+}
+
+// updateUserShare updates the permissions of a share with a user or a group, the only attribute they have.
+func (s *service) updateUserShare(w http.ResponseWriter, r *http.Request, id string) {
+	user := s.cm.MustGetUser(r.Context())
+
+	share, err := s.shareDriver.GetUserShare(r.Context(), user, id)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	if _, ok := r.PostForm["permissions"]; !ok {
+		s.writeOCS(w, r, ocsCodeBadRequest, "wrong or no update parameter given", nil)
+		return
+	}
+
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, share.Path())
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	permissions, err := parseUserSharePermissions(r.PostForm.Get("permissions"), fileInfo.Folder())
+	if err != nil {
+		s.writeOCS(w, r, ocsCodeBadRequest, err.Error(), nil)
+		return
+	}
+	if err := s.shareDriver.SetUserSharePermissions(r.Context(), user, id, permissions); err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+
+	share, err = s.shareDriver.GetUserShare(r.Context(), user, id)
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", s.userShareToShareData(r, share))
+}
+
+func (s *service) deleteShareEndpoint(w http.ResponseWriter, r *http.Request) {
+	user := s.cm.MustGetUser(r.Context())
+	id := mux.Vars(r)["id"]
+
+	err := s.shareDriver.RevokeLinkShare(r.Context(), user, id)
+	if err != nil && isNotFoundError(err) {
+		err = s.shareDriver.RevokeUserShare(r.Context(), user, id)
+	}
+	if err != nil {
+		s.handleOCSError(err, w, r)
+		return
+	}
+	s.writeOCS(w, r, ocsCodeOK, "", nil)
+}
+
+// canHavePermissions returns false when upload permissions are given to a link to a file.
+func (s *service) canHavePermissions(ctx context.Context, share lib.LinkShare, permissions lib.SharePermission) bool {
+	if permissions == lib.PermissionRead {
+		return true
+	}
+	fileInfo, err := s.metaDataDriver.Examine(ctx, share.Owner(), share.Path())
+	if err != nil {
+		return false
+	}
+	return fileInfo.Folder()
+}
+
+func (s *service) handleOCSError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		switch codeErr.Code() {
+		case lib.CodeNotFound:
+			s.writeOCS(w, r, ocsCodeNotFound, "wrong share ID, share doesn't exist", nil)
+			return
+		case lib.CodeBadInputData, lib.CodeInvalidName:
+			s.writeOCS(w, r, ocsCodeBadRequest, codeErr.Message(), nil)
+			return
+		case lib.CodeForbidden:
+			s.writeOCS(w, r, ocsCodeForbidden, codeErr.Message(), nil)
+			return
+		}
+	}
+	logger.Error().Log("msg", "unexpected error handling share request")
+	s.writeOCS(w, r, ocsCodeServerError, "server error", nil)
+}
+
+// writeOCS writes the OCS envelope in XML or in JSON if the client asks for it with format=json.
+// Like ownCloud OCS v1, the status is reported inside the envelope and the HTTP status is always 200.
+func (s *service) writeOCS(w http.ResponseWriter, r *http.Request, statusCode int, message string, data interface{}) {
+	logger := s.cm.MustGetLog(r.Context())
+	res := &ocsResponse{Meta: ocsMeta{Status: "ok", StatusCode: statusCode, Message: message}, Data: data}
+	if statusCode != ocsCodeOK {
+		res.Meta.Status = "failure"
+	}
+
+	var body []byte
+	var err error
+	if r.URL.Query().Get("format") == "json" {
+		body, err = json.Marshal(map[string]interface{}{"ocs": res})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		body, err = xml.Marshal(res)
+		body = append([]byte(xml.Header), body...)
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *service) linkShareToShareData(r *http.Request, share lib.LinkShare) *shareData {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	data := &shareData{
+		ID:               share.ID(),
+		ShareType:        shareTypeLink,
+		UIDOwner:         share.Owner().Username(),
+		DisplaynameOwner: share.Owner().DisplayName(),
+		Permissions:      share.Permissions(),
+		STime:            share.Created(),
+		Token:            share.Token(),
+		Path:             share.Path(),
+		FileTarget:       "/" + filepath.Base(share.Path()),
+		URL:              fmt.Sprintf("%s://%s/ocwebdav/s/%s", scheme, r.Host, share.Token()),
+		MimeType:         s.mg.FromString(share.Path()),
+	}
+	if share.Expiration() > 0 {
+		expiration := time.Unix(share.Expiration(), 0).Format("2006-01-02 00:00:00")
+		data.Expiration = &expiration
+	}
+
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), share.Owner(), share.Path())
+	if err == nil {
+		data.ItemType = "file"
+		if fileInfo.Folder() {
+			data.ItemType = "folder"
+		}
+		data.MimeType = s.mg.FromFileInfo(fileInfo)
+		if id, ok := fileInfo.ExtraAttributes()["id"].(string); ok {
+			data.ItemSource = id
+			data.FileSource = id
+		}
+	}
+	return data
+}
+
+func (s *service) userShareToShareData(r *http.Request, share lib.UserShare) *shareData {
+	data := &shareData{
+		ID:                   share.ID(),
+		ShareType:            int(share.RecipientType()),
+		UIDOwner:             share.Owner().Username(),
+		DisplaynameOwner:     share.Owner().DisplayName(),
+		Permissions:          share.Permissions(),
+		STime:                share.Created(),
+		Path:                 share.Path(),
+		FileTarget:           "/" + filepath.Base(share.Path()),
+		MimeType:             s.mg.FromString(share.Path()),
+		ShareWith:            share.Recipient(),
+		ShareWithDisplayname: share.Recipient(),
+	}
+
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), share.Owner(), share.Path())
+	if err == nil {
+		data.ItemType = "file"
+		if fileInfo.Folder() {
+			data.ItemType = "folder"
+		}
+		data.MimeType = s.mg.FromFileInfo(fileInfo)
+		if id, ok := fileInfo.ExtraAttributes()["id"].(string); ok {
+			data.ItemSource = id
+			data.FileSource = id
+		}
+	}
+	return data
+}
+
+func (s *service) publicGetEndpoint(w http.ResponseWriter, r *http.Request) {
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+	s.serveFile(w, r, share, mux.Vars(r)["path"], false)
+}
+
+func (s *service) publicDownloadEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	token := mux.Vars(r)["token"]
+
+	_, password, _ := r.BasicAuth()
+	share, err := s.shareDriver.ResolveLinkShare(r.Context(), token, password)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "link share resolved", "shareid", share.ID())
+
+	// files inside shared folders are selected with the path query parameter.
+	s.serveFile(w, r, share, r.URL.Query().Get("path"), true)
+}
+
+// serveFile writes the contents of the file at path inside the share,
+// folders are downloaded as an archive.
+func (s *service) serveFile(w http.ResponseWriter, r *http.Request, share lib.LinkShare, path string, attachment bool) {
+	logger := s.cm.MustGetLog(r.Context())
+	if !hasPermission(share, lib.PermissionRead) {
+		logger.Warn().Log("msg", "share does not allow to read", "shareid", share.ID())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ownerPath, fileInfo, err := s.getOwnerPath(r.Context(), share, path)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	if fileInfo.Folder() {
+		s.serveArchive(w, r, share, ownerPath)
+		return
+	}
+
+	readCloser, err := s.dataDriver.DownloadFile(r.Context(), share.Owner(), ownerPath)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	defer readCloser.Close()
+
+	s.setFileHeaders(w, fileInfo)
+	if attachment {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(fileInfo.Path())))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, readCloser); err != nil {
+		logger.Error().Log("error", err, "msg", "error writting response body")
+	}
+}
+
+// serveArchive writes the folder at ownerPath as an archive, in the format given
+// by the format query parameter, zip by default or tar.gz.
+func (s *service) serveArchive(w http.ResponseWriter, r *http.Request, share lib.LinkShare, ownerPath string) {
+	logger := s.cm.MustGetLog(r.Context())
+
+	format, ext, contentType := lib.ArchiveFormatZIP, ".zip", "application/zip"
+	switch r.URL.Query().Get("format") {
+	case "", "zip":
+	case "tar.gz", "tgz":
+		format, ext, contentType = lib.ArchiveFormatTarGz, ".tar.gz", "application/gzip"
+	default:
+		logger.Warn().Log("msg", "archive format not supported", "format", r.URL.Query().Get("format"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name := filepath.Base(filepath.Clean("/" + ownerPath))
+	if name == "/" {
+		name = share.Owner().Username()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+ext))
+	aw := &archiveResponseWriter{w: w}
+	if err := s.archiver.Archive(r.Context(), share.Owner(), []string{ownerPath}, format, aw); err != nil {
+		if !aw.written {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Disposition")
+			s.handlePublicError(err, w, r)
+			return
+		}
+		logger.Error().Log("error", err, "msg", "archive download aborted", "shareid", share.ID())
+		return
+	}
+	logger.Info().Log("msg", "shared folder downloaded as archive", "shareid", share.ID())
+}
+
+// archiveResponseWriter tracks if the archive has started to be written.
+type archiveResponseWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (a *archiveResponseWriter) Write(p []byte) (int, error) {
+	a.written = true
+	return a.w.Write(p)
+}
+
+func (s *service) publicHeadEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+	if !hasPermission(share, lib.PermissionRead) {
+		logger.Warn().Log("msg", "share does not allow to read", "shareid", share.ID())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	_, fileInfo, err := s.getOwnerPath(r.Context(), share, mux.Vars(r)["path"])
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	s.setFileHeaders(w, fileInfo)
+	w.WriteHeader(http.StatusOK)
+}
+
+// publicPutEndpoint uploads a file into the share.
+// Upload only shares, also known as drop boxes, never overwrite existing files
+// as the uploader can not see them, the upload is renamed instead.
+func (s *service) publicPutEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+	if r.Body == nil {
+		logger.Error().Log("error", "body is <nil>")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ownerPath, fileInfo, err := s.getOwnerPath(r.Context(), share, mux.Vars(r)["path"])
+	if err != nil && !isNotFoundError(err) {
+		s.handlePublicError(err, w, r)
+		return
+	}
+
+	if fileInfo != nil {
+		if fileInfo.Folder() {
+			logger.Warn().Log("msg", "file already exists and is a folder", "path", fileInfo.Path())
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if !hasPermission(share, lib.PermissionRead) && hasPermission(share, lib.PermissionCreate) {
+			ownerPath, err = s.getFreePath(r.Context(), share.Owner(), ownerPath)
+			if err != nil {
+				s.handlePublicError(err, w, r)
+				return
+			}
+			fileInfo = nil
+		} else if !hasPermission(share, lib.PermissionUpdate) {
+			logger.Warn().Log("msg", "share does not allow to overwrite files", "shareid", share.ID())
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	if fileInfo == nil && !hasPermission(share, lib.PermissionCreate) {
+		logger.Warn().Log("msg", "share does not allow to create files", "shareid", share.ID())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var readCloser io.ReadCloser = r.Body
+	if s.uploadMaxFileSize > 0 {
+		readCloser = http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
+	}
+	if err := s.dataDriver.UploadFile(r.Context(), share.Owner(), ownerPath, readCloser, "", 0); err != nil {
+		if err.Error() == "http: request body too large" {
+			logger.Error().Log("error", "request body max size exceed")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.handlePublicError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "file uploaded to link share", "shareid", share.ID(), "path", ownerPath)
+
+	if fileInfo == nil {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *service) publicMkcolEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+	if !hasPermission(share, lib.PermissionCreate) {
+		logger.Warn().Log("msg", "share does not allow to create folders", "shareid", share.ID())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ownerPath, _, err := s.getOwnerPath(r.Context(), share, mux.Vars(r)["path"])
+	if err == nil {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !isNotFoundError(err) {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	if err := s.metaDataDriver.CreateFolder(r.Context(), share.Owner(), ownerPath); err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *service) publicDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+	path := filepath.Clean("/" + mux.Vars(r)["path"])
+	if !hasPermission(share, lib.PermissionDelete) || path == "/" {
+		logger.Warn().Log("msg", "share does not allow to delete", "shareid", share.ID(), "path", path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ownerPath, _, err := s.getOwnerPath(r.Context(), share, path)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	if err := s.metaDataDriver.Delete(r.Context(), share.Owner(), ownerPath); err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *service) publicOptionsEndpoint(w http.ResponseWriter, r *http.Request) {
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+
+	allow := "OPTIONS, PROPFIND"
+	if hasPermission(share, lib.PermissionRead) {
+		allow += ", GET, HEAD"
+	}
+	if hasPermission(share, lib.PermissionCreate) || hasPermission(share, lib.PermissionUpdate) {
+		allow += ", PUT"
+	}
+	if hasPermission(share, lib.PermissionCreate) {
+		allow += ", MKCOL"
+	}
+	if hasPermission(share, lib.PermissionDelete) {
+		allow += ", DELETE"
+	}
+	w.Header().Set("Allow", allow)
+	w.Header().Set("DAV", "1")
+	w.WriteHeader(http.StatusOK)
+}
+
+// publicPropfindEndpoint lists the share.
+// Upload only shares only expose the root folder so the uploader can not see the contents.
+func (s *service) publicPropfindEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	share, ok := s.resolveWebDAVShare(w, r)
+	if !ok {
+		return
+	}
+
+	path := filepath.Clean("/" + mux.Vars(r)["path"])
+	canRead := hasPermission(share, lib.PermissionRead)
+	if !canRead && path != "/" {
+		logger.Warn().Log("msg", "share does not allow to read", "shareid", share.ID())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ownerPath, fileInfo, err := s.getOwnerPath(r.Context(), share, path)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+	fileInfos := []lib.FileInfo{fileInfo}
+	if canRead && fileInfo.Folder() && r.Header.Get("Depth") == "1" {
+		children, err := s.metaDataDriver.ListFolder(r.Context(), share.Owner(), ownerPath)
+		if err != nil {
+			s.handlePublicError(err, w, r)
+			return
+		}
+		fileInfos = append(fileInfos, children...)
+	}
+
+	responses := []*responseXML{}
+	for _, fi := range fileInfos {
+		responses = append(responses, s.fileInfoToPropResponse(share, fi))
+	}
+	responsesXML, err := xml.Marshal(&responses)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return
+	}
+
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responsesXML) + `</d:multistatus>`
+
+	w.Header().Set("DAV", "1")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	w.Write([]byte(msg))
+}
+
+// resolveWebDAVShare resolves the share of a public WebDAV request.
+// Like ownCloud, the token is sent as the basic auth username and the share password as the password.
+func (s *service) resolveWebDAVShare(w http.ResponseWriter, r *http.Request) (lib.LinkShare, bool) {
+	logger := s.cm.MustGetLog(r.Context())
+	token, password, ok := r.BasicAuth()
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="clawio public share"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	share, err := s.shareDriver.ResolveLinkShare(r.Context(), token, password)
+	if err != nil {
+		s.handlePublicError(err, w, r)
+		return nil, false
+	}
+	logger.Info().Log("msg", "link share resolved", "shareid", share.ID())
+	return share, true
+}
+
+// getOwnerPath maps a path inside the share to the path inside the home of the owner.
+// The file info is returned when the resource exists.
+func (s *service) getOwnerPath(ctx context.Context, share lib.LinkShare, path string) (string, lib.FileInfo, error) {
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		fileInfo, err := s.metaDataDriver.Examine(ctx, share.Owner(), share.Path())
+		if err != nil {
+			return "", nil, err
+		}
+		return share.Path(), fileInfo, nil
+	}
+
+	// a share of a file does not have children.
+	root, err := s.metaDataDriver.Examine(ctx, share.Owner(), share.Path())
+	if err != nil {
+		return "", nil, err
+	}
+	if !root.Folder() {
+		return "", nil, notFoundError("resource not found")
+	}
+
+	ownerPath := filepath.Join(share.Path(), path)
+	fileInfo, err := s.metaDataDriver.Examine(ctx, share.Owner(), ownerPath)
+	if err != nil {
+		return ownerPath, nil, err
+	}
+	return ownerPath, fileInfo, nil
+}
+
+// getFreePath returns a path that does not exist by appending a number to the name.
+// Ex: /drop/report.pdf => /drop/report (2).pdf
+func (s *service) getFreePath(ctx context.Context, owner lib.User, path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		_, err := s.metaDataDriver.Examine(ctx, owner, candidate)
+		if err != nil {
+			if isNotFoundError(err) {
+				return candidate, nil
+			}
+			return "", err
+		}
+	}
+}
+
+func (s *service) setFileHeaders(w http.ResponseWriter, fileInfo lib.FileInfo) {
+	w.Header().Set("Content-Type", s.mg.FromFileInfo(fileInfo))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+	if etag, ok := fileInfo.ExtraAttributes()["etag"].(string); ok {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("OC-ETag", etag)
+	}
+	t := time.Unix(fileInfo.Modified()/1000000000, fileInfo.Modified()%1000000000)
+	w.Header().Set("Last-Modified", t.Format(time.RFC1123))
+	if fileInfo.Checksum() != "" {
+		w.Header().Set("OC-Checksum", fileInfo.Checksum())
+	}
+}
+
+func (s *service) handlePublicError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		switch codeErr.Code() {
+		case lib.CodeNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case lib.CodeUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Basic realm="clawio public share"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case lib.CodeAlreadyExist:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		case lib.CodeBadChecksum:
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		case lib.CodeInvalidName:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	logger.Error().Log("msg", "unexpected error serving link share")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (s *service) fileInfoToPropResponse(share lib.LinkShare, fileInfo lib.FileInfo) *responseXML {
+	extraAttributes := fileInfo.ExtraAttributes()
+	etag, _ := extraAttributes["etag"].(string)
+	id, _ := extraAttributes["id"].(string)
+
+	t := time.Unix(fileInfo.Modified()/1000000000, fileInfo.Modified()%1000000000)
+	propList := []propertyXML{
+		{xml.Name{Space: "", Local: "d:getcontentlength"}, "", []byte(fmt.Sprintf("%d", fileInfo.Size()))},
+		{xml.Name{Space: "", Local: "d:getcontenttype"}, "", []byte(s.mg.FromFileInfo(fileInfo))},
+		{xml.Name{Space: "", Local: "d:getlastmodified"}, "", []byte(t.Format(time.RFC1123))},
+		{xml.Name{Space: "", Local: "d:getetag"}, "", []byte(etag)},
+		{xml.Name{Space: "", Local: "oc:id"}, "", []byte(id)},
+		{xml.Name{Space: "", Local: "oc:size"}, "", []byte(fmt.Sprintf("%d", fileInfo.Size()))},
+		{xml.Name{Space: "", Local: "oc:permissions"}, "", []byte(sharePermissionsToOCPermissions(share.Permissions(), fileInfo.Folder()))},
+	}
+
+	resourceType := propertyXML{xml.Name{Space: "", Local: "d:resourcetype"}, "", []byte("")}
+	if fileInfo.Folder() {
+		resourceType.InnerXML = []byte("<d:collection/>")
+	}
+	propList = append(propList, resourceType)
+
+	rel := strings.TrimPrefix(fileInfo.Path(), share.Path())
+	href := (&url.URL{Path: filepath.Join(publicWebDAVPrefix, "/"+rel)}).EscapedPath()
+	if fileInfo.Folder() {
+		href = strings.TrimSuffix(href, "/") + "/"
+	}
+	return &responseXML{
+		Href:     href,
+		Propstat: []propstatXML{{Prop: propList, Status: "HTTP/1.1 200 OK"}},
+	}
+}
+
+// sharePermissionsToOCPermissions converts the permissions of a share to
+// the letters used by ownCloud clients in the oc:permissions property.
+func sharePermissionsToOCPermissions(permissions lib.SharePermission, folder bool) string {
+	var p string
+	if permissions&lib.PermissionDelete != 0 {
+		p += "D"
+	}
+	if permissions&lib.PermissionUpdate != 0 {
+		p += "NV"
+		if !folder {
+			p += "W"
+		}
+	}
+	if folder && permissions&lib.PermissionCreate != 0 {
+		p += "CK"
+	}
+	return p
+}
+
+func hasPermission(share lib.LinkShare, permission lib.SharePermission) bool {
+	return share.Permissions()&permission != 0
+}
+
+func parsePermissions(p string) (lib.SharePermission, error) {
+	n, err := strconv.ParseUint(p, 10, 32)
+	if err != nil {
+		return 0, badRequestError("invalid permissions")
+	}
+	permissions := lib.SharePermission(n)
+	if permissions == 0 || permissions&^linkPermissions != 0 {
+		return 0, badRequestError("invalid permissions for a link share")
+	}
+	return permissions, nil
+}
+
+// parseUserSharePermissions parses the permissions of a share with a user or a group.
+// Read is mandatory and files can not have create and delete permissions.
+func parseUserSharePermissions(p string, folder bool) (lib.SharePermission, error) {
+	n, err := strconv.ParseUint(p, 10, 32)
+	if err != nil {
+		return 0, badRequestError("invalid permissions")
+	}
+	// resharing is not supported, so the share permission sent by default by clients is dropped.
+	permissions := lib.SharePermission(n) &^ lib.PermissionShare
+	if permissions&lib.PermissionRead == 0 || permissions&^userSharePermissions != 0 {
+		return 0, badRequestError("invalid permissions for a share")
+	}
+	if !folder && permissions&(lib.PermissionCreate|lib.PermissionDelete) != 0 {
+		return 0, badRequestError("files can not be shared with create or delete permissions")
+	}
+	return permissions, nil
+}
+
+// parseExpiration returns the unix time of the end of the day given in the date.
+func parseExpiration(date string) (int64, error) {
+	t, err := time.Parse(expirationLayout, date)
+	if err != nil {
+		return 0, badRequestError("invalid date, date format must be YYYY-MM-DD")
+	}
+	expiration := t.Add(24*time.Hour - time.Second).Unix()
+	if expiration < time.Now().Unix() {
+		return 0, badRequestError("expiration date is in the past")
+	}
+	return expiration, nil
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type ocsResponse struct {
+	XMLName xml.Name    `xml:"ocs" json:"-"`
+	Meta    ocsMeta     `xml:"meta" json:"meta"`
+	Data    interface{} `xml:"data,omitempty" json:"data"`
+}
+
+type ocsMeta struct {
+	Status     string `xml:"status" json:"status"`
+	StatusCode int    `xml:"statuscode" json:"statuscode"`
+	Message    string `xml:"message" json:"message"`
+}
+
+// ocsElements is a list of shares, encoded as a list of element nodes in XML.
+type ocsElements struct {
+	Elements []*shareData `xml:"element"`
+}
+
+func (e *ocsElements) MarshalJSON() ([]byte, error) {
+	if e.Elements == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(e.Elements)
+}
+
+type shareData struct {
+	ID               string              `xml:"id" json:"id"`
+	ShareType        int                 `xml:"share_type" json:"share_type"`
+	UIDOwner         string              `xml:"uid_owner" json:"uid_owner"`
+	DisplaynameOwner string              `xml:"displayname_owner" json:"displayname_owner"`
+	Permissions      lib.SharePermission `xml:"permissions" json:"permissions"`
+	STime            int64               `xml:"stime" json:"stime"`
+	Expiration       *string             `xml:"expiration" json:"expiration"`
+	Token            string              `xml:"token,omitempty" json:"token,omitempty"`
+	Path             string              `xml:"path" json:"path"`
+	ItemType         string              `xml:"item_type" json:"item_type"`
+	ItemSource       string              `xml:"item_source" json:"item_source"`
+	FileSource       string              `xml:"file_source" json:"file_source"`
+	FileTarget       string              `xml:"file_target" json:"file_target"`
+	MimeType         string              `xml:"mimetype" json:"mimetype"`
+	URL              string              `xml:"url,omitempty" json:"url,omitempty"`
+
+	ShareWith            string `xml:"share_with,omitempty" json:"share_with,omitempty"`
+	ShareWithDisplayname string `xml:"share_with_displayname,omitempty" json:"share_with_displayname,omitempty"`
+}
+
+type responseXML struct {
+	XMLName  xml.Name      `xml:"d:response"`
+	Href     string        `xml:"d:href"`
+	Propstat []propstatXML `xml:"d:propstat"`
+}
+
+type propstatXML struct {
+	Prop   []propertyXML `xml:"d:prop>_ignored_"`
+	Status string        `xml:"d:status"`
+}
+
+type propertyXML struct {
+	XMLName  xml.Name
+	Lang     string `xml:"xml:lang,attr,omitempty"`
+	InnerXML []byte `xml:",innerxml"`
+}
+
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+func (e badRequestError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badRequestError) Message() string {
+	return string(e)
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
@@ -3,17 +3,27 @@ package ocwebservice
 import (
 	"net/http"
 
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
 	"github.com/go-kit/kit/log/levels"
 	"github.com/gorilla/mux"
+	"hash"
 	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/url"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -27,6 +37,7 @@ type service struct {
 	bam               lib.BasicAuthMiddleware
 	wec               lib.WebErrorConverter
 	mg                lib.MimeGuesser
+	archiver          lib.Archiver
 	uploadMaxFileSize int64
 }
 
@@ -38,6 +49,7 @@ func New(
 	bam lib.BasicAuthMiddleware,
 	wec lib.WebErrorConverter,
 	mg lib.MimeGuesser,
+	archiver lib.Archiver,
 	uploadMaxFileSize int64) lib.WebService {
 	return &service{
 		cm:                cm,
@@ -47,6 +59,7 @@ func New(
 		bam:               bam,
 		wec:               wec,
 		mg:                mg,
+		archiver:          archiver,
 		uploadMaxFileSize: uploadMaxFileSize,
 	}
 }
@@ -75,6 +88,23 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 			"PROPFIND":  s.bam.HandlerFunc(s.propfindEndpoint),
 			"DELETE":    s.bam.HandlerFunc(s.deleteEndpoint),
 			"MOVE":      s.bam.HandlerFunc(s.moveEndpoint),
+			"REPORT":    s.bam.HandlerFunc(s.reportEndpoint),
+			"SEARCH":    s.bam.HandlerFunc(s.searchEndpoint),
+		},
+		"/ocwebdav/remote.php/dav/": {
+			"SEARCH": s.bam.HandlerFunc(s.searchEndpoint),
+		},
+		"/ocwebdav/remote.php/dav/meta/{id}": {
+			"PROPFIND": s.bam.HandlerFunc(s.metaPropfindEndpoint),
+		},
+		"/ocwebdav/remote.php/dav/bulk": {
+			"POST": s.bam.HandlerFunc(s.bulkUploadEndpoint),
+		},
+		"/ocwebdav/f/{id}": {
+			"GET": s.bam.HandlerFunc(s.fileIDEndpoint),
+		},
+		"/ocwebdav/index.php/apps/files/ajax/download.php": {
+			"GET": s.bam.HandlerFunc(s.downloadSelectionEndpoint),
 		},
 	}
 }
@@ -153,6 +183,154 @@ func (s *service) capabilitiesEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(capabilities))
 }
 
+// downloadSelectionEndpoint implements the download of the ownCloud web interface,
+// the files parameter is a JSON list of names inside the dir folder or a single name.
+// A single file is downloaded as is, anything else as an archive.
+func (s *service) downloadSelectionEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	dir := filepath.Clean("/" + r.URL.Query().Get("dir"))
+
+	var files []string
+	if rawFiles := r.URL.Query().Get("files"); rawFiles != "" {
+		if err := json.Unmarshal([]byte(rawFiles), &files); err != nil {
+			files = []string{rawFiles}
+		}
+	}
+	if len(files) == 0 {
+		files = []string{""}
+	}
+
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, filepath.Join(dir, filepath.Clean("/"+f)))
+	}
+
+	name := filepath.Base(dir)
+	if dir == "/" {
+		name = user.Username()
+	}
+	if len(paths) == 1 {
+		fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, paths[0])
+		if err != nil {
+			s.handleGetEndpointError(err, w, r)
+			return
+		}
+		if !fileInfo.Folder() {
+			readCloser, err := s.dataDriver.DownloadFile(r.Context(), user, paths[0])
+			if err != nil {
+				s.handleGetEndpointError(err, w, r)
+				return
+			}
+			defer readCloser.Close()
+			w.Header().Set("Content-Type", s.mg.FromFileInfo(fileInfo))
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(fileInfo.Path())))
+			w.WriteHeader(http.StatusOK)
+			if _, err := io.Copy(w, readCloser); err != nil {
+				logger.Error().Log("error", err, "msg", "error writting response body")
+			}
+			return
+		}
+		if paths[0] != "/" {
+			name = filepath.Base(paths[0])
+		}
+	}
+	s.writeArchive(w, r, paths, name)
+}
+
+// writeArchive streams an archive with the resources at paths, in the format given by the format
+// query parameter, zip by default or tar.gz, name is the name of the archive without extension.
+// Once the archive has started to be written the status can not be changed, so errors after that
+// are only logged and the client gets a truncated archive.
+func (s *service) writeArchive(w http.ResponseWriter, r *http.Request, paths []string, name string) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	format, ext, contentType := lib.ArchiveFormatZIP, ".zip", "application/zip"
+	switch r.URL.Query().Get("format") {
+	case "", "zip":
+	case "tar.gz", "tgz":
+		format, ext, contentType = lib.ArchiveFormatTarGz, ".tar.gz", "application/gzip"
+	default:
+		logger.Warn().Log("msg", "archive format not supported", "format", r.URL.Query().Get("format"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+ext))
+	aw := &archiveResponseWriter{w: w}
+	if err := s.archiver.Archive(r.Context(), user, paths, format, aw); err != nil {
+		if !aw.written {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Content-Type")
+			s.handleGetEndpointError(err, w, r)
+			return
+		}
+		logger.Error().Log("error", err, "msg", "archive download aborted")
+		return
+	}
+	logger.Info().Log("msg", "archive downloaded", "numpaths", len(paths))
+}
+
+// partLimitReader fails when a part of a bulk upload is bigger than the maximum upload size.
+// Like http.MaxBytesReader it reads one byte past the limit, so a part of exactly the maximum size is accepted.
+type partLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (p *partLimitReader) Read(b []byte) (int, error) {
+	if p.n < 0 {
+		return 0, tooBigError("file exceeds the maximum upload size")
+	}
+	if int64(len(b)) > p.n+1 {
+		b = b[:p.n+1]
+	}
+	n, err := p.r.Read(b)
+	if int64(n) <= p.n {
+		p.n -= int64(n)
+		return n, err
+	}
+	n = int(p.n)
+	p.n = -1
+	return n, tooBigError("file exceeds the maximum upload size")
+}
+
+// md5Reader fails at the end of a part of a bulk upload when its MD5 does not match
+// the X-File-MD5 header, so the data driver discards the upload.
+type md5Reader struct {
+	r    io.Reader
+	hash hash.Hash
+	md5  string
+}
+
+func newMD5Reader(r io.Reader, md5sum string) *md5Reader {
+	h := md5.New()
+	return &md5Reader{r: io.TeeReader(r, h), hash: h, md5: strings.ToLower(md5sum)}
+}
+
+func (m *md5Reader) Read(b []byte) (int, error) {
+	n, err := m.r.Read(b)
+	if err == io.EOF {
+		if computed := fmt.Sprintf("%x", m.hash.Sum(nil)); computed != m.md5 {
+			return n, checksumError(fmt.Sprintf("wrong md5 computed:%q expected:%q", computed, m.md5))
+		}
+	}
+	return n, err
+}
+
+// archiveResponseWriter tracks if the archive has started to be written.
+type archiveResponseWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (a *archiveResponseWriter) Write(p []byte) (int, error) {
+	a.written = true
+	return a.w.Write(p)
+}
+
 func (s *service) getEndpoint(w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
@@ -196,8 +374,11 @@ func (s *service) getEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if fileInfo.Folder() {
-		logger.Warn().Log("file is a folder")
-		w.WriteHeader(http.StatusNotImplemented)
+		name := filepath.Base(filepath.Clean("/" + path))
+		if name == "/" {
+			name = user.Username()
+		}
+		s.writeArchive(w, r, []string{path}, name)
 		return
 	}
 
@@ -316,6 +497,13 @@ func (s *service) optionsEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	allow := "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY,"
 	allow += " MOVE, UNLOCK, PROPFIND"
+	if capability.Supports(s.metaDataDriver, lib.CapabilityChangeFeed) && fileInfo.Folder() {
+		allow += ", REPORT"
+	}
+	if capability.Supports(s.metaDataDriver, lib.CapabilitySearcher) && fileInfo.Folder() {
+		allow += ", SEARCH"
+		w.Header().Set("DASL", "<DAV:basicsearch>")
+	}
 	if !fileInfo.Folder() {
 		allow += ", PUT"
 	}
@@ -376,9 +564,155 @@ func (s *service) mkcolEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// proppatchEndpoint applies d:getlastmodified, and d:lastmodified used by the ownCloud clients,
+// when the metadata driver implements lib.ModTimeSetter. The rest of properties are not stored,
+// so they are answered with 403 Forbidden.
+// Like RFC 4918 requires, either all the properties are applied or none.
 func (s *service) proppatchEndpoint(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	path := mux.Vars(r)["path"]
+
+	update := &propertyupdateXML{}
+	if err := xml.NewDecoder(r.Body).Decode(update); err != nil {
+		if err == io.EOF {
+			// nothing to update.
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		logger.Warn().Log("error", err, "msg", "invalid proppatch body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil {
+		s.handleProppatchEndpointError(err, w, r)
+		return
+	}
+
+	// only the modification time can be set, dead properties are not stored so they are forbidden.
+	var modTime int64
+	var applied, conflicts, forbidden []propertyXML
+	for _, set := range update.Set {
+		for _, prop := range set.Prop.Props {
+			if !isModTimeProperty(prop.XMLName) {
+				forbidden = append(forbidden, propertyXML{XMLName: prop.XMLName})
+				continue
+			}
+			t, err := parsePropertyModTime(prop.Value)
+			if err != nil {
+				logger.Warn().Log("error", err, "msg", "invalid modification time", "value", prop.Value)
+				conflicts = append(conflicts, propertyXML{XMLName: prop.XMLName})
+				continue
+			}
+			modTime = t
+			applied = append(applied, propertyXML{XMLName: prop.XMLName})
+		}
+	}
+	for _, remove := range update.Remove {
+		for _, prop := range remove.Prop.Props {
+			forbidden = append(forbidden, propertyXML{XMLName: prop.XMLName})
+		}
+	}
+
+	if len(conflicts) == 0 && len(forbidden) == 0 && modTime != 0 {
+		if modTimeSetter, ok := s.metaDataDriver.(lib.ModTimeSetter); ok && capability.Supports(s.metaDataDriver, lib.CapabilityModTimeSetter) {
+			err = modTimeSetter.SetModTime(r.Context(), user, path, modTime)
+		} else {
+			err = notSupportedError("metadata driver does not support setting modification times")
+		}
+		if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeNotSupported {
+			// only modification time properties are applied, all of them are forbidden.
+			forbidden = append(forbidden, applied...)
+			applied = nil
+		} else if err != nil {
+			s.handleProppatchEndpointError(err, w, r)
+			return
+		} else {
+			logger.Info().Log("msg", "modification time set", "path", path, "mtime", modTime)
+		}
+	}
+
+	response := &responseXML{Href: pathToHref(path, fileInfo.Folder())}
+	appliedStatus := "HTTP/1.1 200 OK"
+	if len(conflicts) > 0 || len(forbidden) > 0 {
+		appliedStatus = "HTTP/1.1 424 Failed Dependency"
+	}
+	for _, propstat := range []propstatXML{
+		{Prop: applied, Status: appliedStatus},
+		{Prop: forbidden, Status: "HTTP/1.1 403 Forbidden"},
+		{Prop: conflicts, Status: "HTTP/1.1 409 Conflict"},
+	} {
+		if len(propstat.Prop) > 0 {
+			response.Propstat = append(response.Propstat, propstat)
+		}
+	}
+	responseXML, err := xml.Marshal(response)
+	if err != nil {
+		s.handleProppatchEndpointError(err, w, r)
+		return
+	}
+
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responseXML) + `</d:multistatus>`
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	w.Write([]byte(msg))
+}
+
+// isModTimeProperty tells if name is one of the properties used by clients to set the modification time.
+func isModTimeProperty(name xml.Name) bool {
+	return name.Space == "DAV:" && (name.Local == "getlastmodified" || name.Local == "lastmodified")
+}
+
+// parsePropertyModTime parses a modification time given as an HTTP date or,
+// like the ownCloud clients do, as seconds since the epoch.
+func parsePropertyModTime(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if modTime, err := parseOCMTime(value); err == nil {
+		return modTime, nil
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
+// parseOCMTime parses the X-OC-MTime header, the modification time of the file in seconds since the epoch.
+// Like the ownCloud server, the fractional part is ignored. An empty header returns zero.
+func parseOCMTime(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		return 0, err
+	}
+	if seconds <= 0 || seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, fmt.Errorf("modification time %q out of range", header)
+	}
+	return int64(seconds) * int64(time.Second), nil
+}
+
+func (s *service) handleProppatchEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusInternalServerError)
 }
+
 func (s *service) moveEndpoint(w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
@@ -495,6 +829,13 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	clientModTime, err := parseOCMTime(r.Header.Get("X-OC-MTime"))
+	if err != nil {
+		logger.Warn().Log("error", err, "msg", "invalid X-OC-MTime header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
 	// if err is not found it is okay to continue
 	if err != nil {
@@ -549,7 +890,7 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
-	if err := s.dataDriver.UploadFile(r.Context(), user, path, readCloser, ""); err != nil {
+	if err := s.dataDriver.UploadFile(r.Context(), user, path, readCloser, "", clientModTime); err != nil {
 		s.handlePutEndpointError(err, w, r)
 		return
 	}
@@ -593,7 +934,9 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 	t := time.Unix(newInfo.Modified()/1000000000, newInfo.Modified()%1000000000)
 	lastModifiedString := t.Format(time.RFC1123)
 	w.Header().Set("Last-Modified", lastModifiedString)
-	w.Header().Set("X-OC-MTime", "accepted")
+	if clientModTime != 0 {
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
 
 	// if object did not exist, http code is 201, else 204.
 	if fileInfo == nil {
@@ -621,8 +964,16 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the modification time is applied when the last chunk completes the file.
+	clientModTime, err := parseOCMTime(r.Header.Get("X-OC-MTime"))
+	if err != nil {
+		logger.Warn().Log("error", err, "msg", "invalid X-OC-MTime header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
-	err = s.dataDriver.UploadFile(r.Context(), user, path, readCloser, "")
+	err = s.dataDriver.UploadFile(r.Context(), user, path, readCloser, "", clientModTime)
 	if err != nil {
 		s.handlePutChunkedEndpointError(err, w, r)
 		return
@@ -667,7 +1018,9 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 	t := time.Unix(fileInfo.Modified()/1000000000, fileInfo.Modified()%1000000000)
 	lastModifiedString := t.Format(time.RFC1123)
 	w.Header().Set("Last-Modified", lastModifiedString)
-	w.Header().Set("X-OC-MTime", "accepted")
+	if clientModTime != 0 {
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
 
 	// if object did not exist, http code is 201, else 204.
 	if fileInfo == nil {
@@ -676,7 +1029,13 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// propfindEndpoint streams the multistatus response, the children of folders are read from the
+// driver a page at a time and every response is written as soon as it is encoded.
+// Errors after the first response can not change the status code, so the document is left unfinished
+// for the client to detect the failure.
 func (s *service) propfindEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
 	path := mux.Vars(r)["path"]
 
@@ -687,87 +1046,692 @@ func (s *service) propfindEndpoint(w http.ResponseWriter, r *http.Request) {
 		children = true
 	}
 
-	var fileInfos []lib.FileInfo
 	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
 	if err != nil {
 		s.handlePropfindEndpointError(err, w, r)
 		return
 	}
-	fileInfos = append(fileInfos, fileInfo)
+	root, err := s.fileInfoToPropResponse(r.Context(), fileInfo)
+	if err != nil {
+		s.handlePropfindEndpointError(err, w, r)
+		return
+	}
 
-	if children && fileInfo.Folder() {
-		childrenInfos, err := s.metaDataDriver.ListFolder(r.Context(), user, path)
-		if err != nil {
+	w.Header().Set("DAV", "1, 3, extended-mkcol")
+	mw := newMultistatusWriter(w)
+	if !children || !fileInfo.Folder() {
+		if err := mw.writeResponse(root); err != nil {
+			logger.Error().Log("error", err, "msg", "error writing multistatus response")
+			return
+		}
+		if err := mw.close(); err != nil {
+			logger.Error().Log("error", err, "msg", "error writing multistatus response")
+		}
+		return
+	}
+
+	// the root is written with the first page, so errors reading it still get their status code.
+	var numChildren int
+	err = s.readFolder(r.Context(), user, path, func(fileInfos []lib.FileInfo) error {
+		if !mw.started {
+			if err := mw.writeResponse(root); err != nil {
+				return err
+			}
+		}
+		for _, fi := range fileInfos {
+			res, err := s.fileInfoToPropResponse(r.Context(), fi)
+			if err != nil {
+				return err
+			}
+			if err := mw.writeResponse(res); err != nil {
+				return err
+			}
+		}
+		numChildren += len(fileInfos)
+		return mw.flush()
+	})
+	if err != nil {
+		if !mw.started {
 			s.handlePropfindEndpointError(err, w, r)
 			return
 		}
-		fileInfos = append(fileInfos, childrenInfos...)
+		logger.Error().Log("error", err, "msg", "propfind response aborted", "numchildren", numChildren)
+		return
+	}
+	if !mw.started {
+		if err := mw.writeResponse(root); err != nil {
+			logger.Error().Log("error", err, "msg", "error writing multistatus response")
+			return
+		}
+	}
+	if err := mw.close(); err != nil {
+		logger.Error().Log("error", err, "msg", "error writing multistatus response")
+		return
+	}
+	logger.Info().Log("msg", "propfind done", "numchildren", numChildren)
+}
+
+// readFolder calls fn with the children of the folder as they are read, so drivers that can walk
+// folders never have the whole folder in memory. Other drivers return the whole folder in a single batch.
+func (s *service) readFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	if folderWalker, ok := s.metaDataDriver.(lib.FolderWalker); ok && capability.Supports(s.metaDataDriver, lib.CapabilityFolderWalker) {
+		return folderWalker.WalkFolder(ctx, user, path, fn)
 	}
 
-	fileInfosInXML, err := s.fileInfosToXML(r.Context(), fileInfos)
+	fileInfos, err := s.metaDataDriver.ListFolder(ctx, user, path)
 	if err != nil {
-		s.handlePropfindEndpointError(err, w, r)
+		return err
+	}
+	return fn(fileInfos)
+}
+
+// metaPropfindEndpoint returns the properties of the resource with the given id,
+// including its current path in the oc:meta-path-for-user property.
+func (s *service) metaPropfindEndpoint(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := s.examineByID(r)
+	if err != nil {
+		s.handleExamineByIDError(err, w, r)
+		return
+	}
+
+	response, err := s.fileInfoToPropResponse(r.Context(), fileInfo)
+	if err != nil {
+		s.handleExamineByIDError(err, w, r)
+		return
+	}
+	response.Href = "/ocwebdav/remote.php/dav/meta/" + mux.Vars(r)["id"] + "/"
+	metaPath := propertyXML{xml.Name{Space: "", Local: "oc:meta-path-for-user"}, "", nil}
+	xmlPath := &bytes.Buffer{}
+	xml.EscapeText(xmlPath, []byte(filepath.Clean("/"+fileInfo.Path())))
+	metaPath.InnerXML = xmlPath.Bytes()
+	response.Propstat[0].Prop = append(response.Propstat[0].Prop, metaPath)
+
+	responseXML, err := xml.Marshal(response)
+	if err != nil {
+		s.handleExamineByIDError(err, w, r)
 		return
 	}
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responseXML) + `</d:multistatus>`
 
 	w.Header().Set("DAV", "1, 3, extended-mkcol")
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	w.WriteHeader(207)
-	w.Write([]byte(fileInfosInXML))
+	w.Write([]byte(msg))
+}
+
+// fileIDEndpoint redirects the private links of ownCloud, /f/<fileid>,
+// to the current location of the resource.
+func (s *service) fileIDEndpoint(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := s.examineByID(r)
+	if err != nil {
+		s.handleExamineByIDError(err, w, r)
+		return
+	}
 
+	location := &url.URL{Path: filepath.Join("/ocwebdav/remote.php/webdav", fileInfo.Path())}
+	if fileInfo.Folder() {
+		location.Path += "/"
+	}
+	http.Redirect(w, r, location.String(), http.StatusFound)
 }
 
-func (s *service) isChunkedUpload(path string) (bool, error) {
-	return regexp.MatchString(`-chunking-\w+-[0-9]+-[0-9]+$`, path)
+func (s *service) examineByID(r *http.Request) (lib.FileInfo, error) {
+	user := s.cm.MustGetUser(r.Context())
+	fileIDResolver, ok := s.metaDataDriver.(lib.FileIDResolver)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityFileIDResolver) {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+	return fileIDResolver.ExamineByID(r.Context(), user, mux.Vars(r)["id"])
 }
 
-func (s *service) handleIfMatchHeader(clientETag, serverETag string, w http.ResponseWriter, r *http.Request) error {
+func (s *service) handleExamineByIDError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
-
-	// ownCloud adds double quotes around ETag value
-	serverETag = fmt.Sprintf(`"%s"`, serverETag)
-	if clientETag != serverETag {
-		err := fmt.Errorf("etags do not match")
-		logger.Error().Log("msg", "can not accept conditional request", "client-etag", clientETag, "server-etag", serverETag)
-		w.WriteHeader(http.StatusPreconditionFailed)
-		return err
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeNotSupported {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
 	}
-
-	return nil
+	logger.Error().Log("msg", "unexpected error examining file by id")
+	w.WriteHeader(http.StatusInternalServerError)
 }
 
-func (s *service) handleFinderRequest(w http.ResponseWriter, r *http.Request) error {
+// reportEndpoint implements the sync-collection REPORT defined in RFC 6578.
+// Clients send the sync token obtained in their previous sync and receive the members
+// of the collection that have changed since then, removed members are reported with a 404 status.
+// An empty sync token returns all the members of the collection.
+func (s *service) reportEndpoint(w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	path := filepath.Clean("/" + mux.Vars(r)["path"])
 
-	/*
-	   Many webservers will not cooperate well with Finder PUT requests,
-	   because it uses 'Chunked' transfer encoding for the request body.
-	   The symptom of this problem is that Finder sends files to the
-	   server, but they arrive as 0-length files in PHP.
-	   If we don't do anything, the user might think they are uploading
-	   files successfully, but they end up empty on the server. Instead,
-	   we throw back an error if we detect this.
-	   The reason Finder uses Chunked, is because it thinks the files
-	   might change as it's being uploaded, and therefore the
-	   Content-Length can vary.
-	   Instead it sends the X-Expected-Entity-Length header with the size
-	   of the file at the very start of the request. If this header is set,
-	   but we don't get a request body we will fail the request to
-	   protect the end-user.
-	*/
-	logger.Warn().Log("msg", "finder problem intercepted", "content-length", r.Header.Get("Content-Length"), "x-expected-entity-length", r.Header.Get("X-Expected-Entity-Length"))
+	changeFeed, ok := s.metaDataDriver.(lib.ChangeFeed)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilityChangeFeed) {
+		logger.Warn().Log("msg", "metadata driver does not support change feeds")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
 
-	// The best mitigation to this problem is to tell users to not use crappy Finder.
-	// Another possible mitigation is to change the use the value of X-Expected-Entity-Length header in the Content-Length header.
-	expected := r.Header.Get("X-Expected-Entity-Length")
-	expectedInt, err := strconv.ParseInt(expected, 10, 64)
-	if err != nil {
-		logger.Error().Log("error", err)
+	report := &syncCollectionXML{}
+	if err := xml.NewDecoder(r.Body).Decode(report); err != nil {
+		logger.Error().Log("error", err, "msg", "invalid report body")
 		w.WriteHeader(http.StatusBadRequest)
-		return err
+		return
 	}
-	r.ContentLength = expectedInt
-	return nil
+	if report.XMLName.Space != "DAV:" || report.XMLName.Local != "sync-collection" {
+		logger.Warn().Log("msg", "report not supported", "report", report.XMLName.Local)
+		s.writeErrorXML(w, http.StatusForbidden, "<d:supported-report/>")
+		return
+	}
+
+	// the sync-collection report only works with depth 0, the scope is given by the sync level.
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "0" {
+		logger.Warn().Log("msg", "invalid depth for sync-collection", "depth", depth)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var infinite bool
+	switch strings.TrimSpace(report.SyncLevel) {
+	case "", "1":
+	case "infinite":
+		infinite = true
+	default:
+		logger.Warn().Log("msg", "invalid sync level", "synclevel", report.SyncLevel)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if report.Limit != nil {
+		limit = report.Limit.NResults
+	}
+
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil {
+		s.handleReportEndpointError(err, w, r)
+		return
+	}
+	if !fileInfo.Folder() {
+		logger.Warn().Log("msg", "sync-collection on a file")
+		s.writeErrorXML(w, http.StatusForbidden, "<d:supported-report/>")
+		return
+	}
+
+	var fileInfos []lib.FileInfo
+	var removed []string
+	var truncated bool
+	var changeSet lib.ChangeSet
+
+	syncToken := strings.TrimSpace(report.SyncToken)
+	if syncToken == "" {
+		// the cursor is obtained before listing, so changes done meanwhile are reported in the next sync.
+		changeSet, err = changeFeed.GetChanges(r.Context(), user, "", 0)
+		if err != nil {
+			s.handleReportEndpointError(err, w, r)
+			return
+		}
+		fileInfos, err = s.listMembers(r.Context(), user, path, infinite)
+		if err != nil {
+			s.handleReportEndpointError(err, w, r)
+			return
+		}
+		if limit > 0 && len(fileInfos) > limit {
+			logger.Warn().Log("msg", "initial sync exceeds client limit", "limit", limit, "nummembers", len(fileInfos))
+			s.writeErrorXML(w, http.StatusInsufficientStorage, "<d:number-of-matches-within-limits/>")
+			return
+		}
+	} else {
+		if !strings.HasPrefix(syncToken, syncTokenPrefix) {
+			logger.Warn().Log("msg", "invalid sync token", "synctoken", syncToken)
+			s.writeErrorXML(w, http.StatusForbidden, "<d:valid-sync-token/>")
+			return
+		}
+		changeSet, err = changeFeed.GetChanges(r.Context(), user, strings.TrimPrefix(syncToken, syncTokenPrefix), limit)
+		if err != nil {
+			if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeBadInputData {
+				logger.Warn().Log("msg", "invalid sync token", "synctoken", syncToken)
+				s.writeErrorXML(w, http.StatusForbidden, "<d:valid-sync-token/>")
+				return
+			}
+			s.handleReportEndpointError(err, w, r)
+			return
+		}
+		// the changes for the token are not available anymore, the client needs to do an initial sync.
+		if changeSet.Reset() {
+			logger.Info().Log("msg", "sync token expired", "synctoken", syncToken)
+			s.writeErrorXML(w, http.StatusForbidden, "<d:valid-sync-token/>")
+			return
+		}
+		truncated = changeSet.HasMore()
+
+		for _, p := range getChangedMembers(path, changeSet.Changes(), infinite) {
+			fi, err := s.metaDataDriver.Examine(r.Context(), user, p)
+			if err != nil {
+				if s.isNotFoundError(err) {
+					removed = append(removed, p)
+					continue
+				}
+				s.handleReportEndpointError(err, w, r)
+				return
+			}
+			fileInfos = append(fileInfos, fi)
+		}
+	}
+
+	responses := []*responseXML{}
+	for _, fi := range fileInfos {
+		res, err := s.fileInfoToPropResponse(r.Context(), fi)
+		if err != nil {
+			s.handleReportEndpointError(err, w, r)
+			return
+		}
+		responses = append(responses, res)
+	}
+	for _, p := range removed {
+		responses = append(responses, &responseXML{
+			Href:   pathToHref(p, false),
+			Status: "HTTP/1.1 404 Not Found",
+		})
+	}
+	if truncated {
+		// tells the client to repeat the report with the new sync token to get the rest of the changes.
+		responses = append(responses, &responseXML{
+			Href:   pathToHref(path, true),
+			Status: "HTTP/1.1 507 Insufficient Storage",
+			Error:  &errorXML{InnerXML: []byte("<d:number-of-matches-within-limits/>")},
+		})
+	}
+	responsesXML, err := xml.Marshal(&responses)
+	if err != nil {
+		s.handleReportEndpointError(err, w, r)
+		return
+	}
+
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responsesXML)
+	msg += `<d:sync-token>` + syncTokenPrefix + changeSet.Cursor() + `</d:sync-token></d:multistatus>`
+
+	logger.Info().Log("msg", "collection synced", "numchanged", len(fileInfos), "numremoved", len(removed), "truncated", truncated)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	w.Write([]byte(msg))
+}
+
+// searchEndpoint implements the DAV:basicsearch grammar of WebDAV SEARCH (RFC 5323).
+// The where clause is a conjunction of comparisons on d:displayname and d:getcontenttype (like and eq)
+// and on d:getcontentlength and d:getlastmodified (gt, gte, lt and lte). The scope is the href of the query,
+// the request path when it is missing, and is always searched with infinite depth.
+// Results are paged with d:nresults and the firstresult extension used by the ownCloud and Nextcloud clients.
+func (s *service) searchEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	searcher, ok := s.metaDataDriver.(lib.Searcher)
+	if !ok || !capability.Supports(s.metaDataDriver, lib.CapabilitySearcher) {
+		logger.Warn().Log("msg", "metadata driver does not support search")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	searchRequest := &searchRequestXML{}
+	if err := xml.NewDecoder(r.Body).Decode(searchRequest); err != nil {
+		logger.Error().Log("error", err, "msg", "invalid search body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if searchRequest.BasicSearch == nil {
+		logger.Warn().Log("msg", "search grammar not supported")
+		s.writeErrorXML(w, http.StatusForbidden, "<d:search-grammar-supported/>")
+		return
+	}
+	basicSearch := searchRequest.BasicSearch
+
+	query := lib.SearchQuery{Scope: mux.Vars(r)["path"], Limit: defaultSearchLimit}
+	if basicSearch.Scope != nil {
+		if depth := strings.TrimSpace(basicSearch.Scope.Depth); depth != "" && depth != "infinity" {
+			logger.Warn().Log("msg", "search depth not supported", "depth", depth)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if href := strings.TrimSpace(basicSearch.Scope.Href); href != "" {
+			query.Scope = hrefToPath(href, user)
+		}
+	}
+	if basicSearch.Where != nil {
+		for _, e := range basicSearch.Where.Children {
+			if err := applySearchExpression(&query, e); err != nil {
+				logger.Warn().Log("msg", "search expression not supported", "error", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if basicSearch.Limit != nil {
+		if basicSearch.Limit.NResults > 0 {
+			query.Limit = basicSearch.Limit.NResults
+		}
+		if basicSearch.Limit.FirstResult > 0 {
+			query.Offset = basicSearch.Limit.FirstResult
+		}
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+
+	result, err := searcher.Search(r.Context(), user, query)
+	if err != nil {
+		s.handleSearchEndpointError(err, w, r)
+		return
+	}
+
+	responses := []*responseXML{}
+	for _, fi := range result.FileInfos() {
+		res, err := s.fileInfoToPropResponse(r.Context(), fi)
+		if err != nil {
+			s.handleSearchEndpointError(err, w, r)
+			return
+		}
+		responses = append(responses, res)
+	}
+	if result.HasMore() {
+		// tells the client there are more results in the next page.
+		responses = append(responses, &responseXML{
+			Href:   strings.TrimSuffix(filepath.Join("/ocwebdav/remote.php/webdav", query.Scope), "/") + "/",
+			Status: "HTTP/1.1 507 Insufficient Storage",
+			Error:  &errorXML{InnerXML: []byte("<d:number-of-matches-within-limits/>")},
+		})
+	}
+	responsesXML, err := xml.Marshal(&responses)
+	if err != nil {
+		s.handleSearchEndpointError(err, w, r)
+		return
+	}
+
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responsesXML) + `</d:multistatus>`
+
+	logger.Info().Log("msg", "search done", "numresults", len(result.FileInfos()), "hasmore", result.HasMore())
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	w.Write([]byte(msg))
+}
+
+// hrefToPath returns the path of the user for the href of a search scope,
+// which can be relative to the WebDAV root or to the files of the user in the new DAV endpoint.
+func hrefToPath(href string, user lib.User) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	for _, prefix := range []string{
+		"/ocwebdav/remote.php/webdav",
+		"/ocwebdav/remote.php/dav/files/" + user.Username(),
+		"/files/" + user.Username(),
+	} {
+		if href == prefix || strings.HasPrefix(href, prefix+"/") {
+			return filepath.Clean("/" + strings.TrimPrefix(href, prefix))
+		}
+	}
+	return filepath.Clean("/" + href)
+}
+
+// applySearchExpression adds the filter of a where expression to the query.
+func applySearchExpression(query *lib.SearchQuery, e searchExpressionXML) error {
+	if e.XMLName.Space != "DAV:" {
+		return fmt.Errorf("operator %s not supported", e.XMLName.Local)
+	}
+	if e.XMLName.Local == "and" {
+		for _, child := range e.Children {
+			if err := applySearchExpression(query, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var prop, literal string
+	var hasLiteral bool
+	for _, child := range e.Children {
+		switch child.XMLName.Local {
+		case "prop":
+			if len(child.Children) == 1 {
+				prop = child.Children[0].XMLName.Local
+			}
+		case "literal":
+			literal, hasLiteral = strings.TrimSpace(child.Text), true
+		}
+	}
+	if prop == "" || !hasLiteral {
+		return fmt.Errorf("operator %s needs a property and a literal", e.XMLName.Local)
+	}
+
+	op := e.XMLName.Local
+	switch prop {
+	case "displayname":
+		switch op {
+		case "like":
+			query.Name = strings.NewReplacer("%", "*", "_", "?").Replace(literal)
+			return nil
+		case "eq":
+			// a name without wildcards would match as a substring, so the first
+			// character is put in a class to make it an exact match.
+			if literal == "" {
+				return fmt.Errorf("empty name")
+			}
+			name := escapeGlob(literal)
+			if !strings.HasPrefix(name, "[") {
+				name = "[" + name[:1] + "]" + name[1:]
+			}
+			query.Name = name
+			return nil
+		}
+	case "getcontenttype":
+		switch op {
+		case "like":
+			query.MimeType = strings.TrimSuffix(literal, "%")
+			return nil
+		case "eq":
+			query.MimeType = literal
+			return nil
+		}
+	case "getcontentlength":
+		size, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "gt":
+			query.MinSize = size + 1
+			return nil
+		case "gte":
+			query.MinSize = size
+			return nil
+		case "lt":
+			query.MaxSize = size - 1
+			return nil
+		case "lte":
+			query.MaxSize = size
+			return nil
+		}
+	case "getlastmodified":
+		t, err := parseSearchDate(literal)
+		if err != nil {
+			return err
+		}
+		// dates have a precision of seconds.
+		switch op {
+		case "gt":
+			query.ModifiedAfter = t.Add(time.Second).UnixNano() - 1
+			return nil
+		case "gte":
+			query.ModifiedAfter = t.UnixNano() - 1
+			return nil
+		case "lt":
+			query.ModifiedBefore = t.UnixNano()
+			return nil
+		case "lte":
+			query.ModifiedBefore = t.Add(time.Second).UnixNano()
+			return nil
+		}
+	}
+	return fmt.Errorf("operator %s on %s not supported", op, prop)
+}
+
+// parseSearchDate parses the dates of search literals, which can be HTTP
+// dates like in d:getlastmodified, ISO 8601 dates or unix timestamps.
+func parseSearchDate(literal string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, http.TimeFormat, time.RFC1123, time.RFC1123Z} {
+		if t, err := time.Parse(layout, literal); err == nil {
+			return t, nil
+		}
+	}
+	seconds, err := strconv.ParseInt(literal, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", literal)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// escapeGlob escapes the pattern characters of name so it only matches itself.
+func escapeGlob(name string) string {
+	return strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]", "\\", "[\\]").Replace(name)
+}
+
+// listMembers returns the members of the folder, all its descendants if infinite is true.
+func (s *service) listMembers(ctx context.Context, user lib.User, path string, infinite bool) ([]lib.FileInfo, error) {
+	fileInfos, err := s.metaDataDriver.ListFolder(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if !infinite {
+		return fileInfos, nil
+	}
+	members := []lib.FileInfo{}
+	for _, fi := range fileInfos {
+		members = append(members, fi)
+		if fi.Folder() {
+			descendants, err := s.listMembers(ctx, user, fi.Path(), true)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, descendants...)
+		}
+	}
+	return members, nil
+}
+
+// getChangedMembers returns the members of the collection affected by the changes,
+// sorted so parents come before their children.
+// A change deep inside a member changes the ETag of all its ancestors, so with sync level 1
+// the member containing the change is reported and with sync level infinite all the
+// ancestors inside the collection are reported.
+func getChangedMembers(collection string, changes []lib.Change, infinite bool) []string {
+	seen := map[string]bool{}
+	members := []string{}
+	add := func(p string) {
+		if p == "" {
+			return
+		}
+		p = filepath.Clean("/" + p)
+		if p == collection {
+			return
+		}
+		prefix := strings.TrimSuffix(collection, "/") + "/"
+		if !strings.HasPrefix(p, prefix) {
+			return
+		}
+		tokens := strings.Split(strings.TrimPrefix(p, prefix), "/")
+		if !infinite {
+			tokens = tokens[:1]
+		}
+		for i := range tokens {
+			member := prefix + strings.Join(tokens[:i+1], "/")
+			if !seen[member] {
+				seen[member] = true
+				members = append(members, member)
+			}
+		}
+	}
+	for _, c := range changes {
+		add(c.Path())
+		add(c.SourcePath())
+	}
+	sort.Strings(members)
+	return members
+}
+
+// writeErrorXML writes a WebDAV error body with the given precondition or postcondition.
+func (s *service) writeErrorXML(w http.ResponseWriter, statusCode int, condition string) {
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:error xmlns:d="DAV:">` + condition + `</d:error>`
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(msg))
+}
+
+func (s *service) isChunkedUpload(path string) (bool, error) {
+	return regexp.MatchString(`-chunking-\w+-[0-9]+-[0-9]+$`, path)
+}
+
+func (s *service) handleIfMatchHeader(clientETag, serverETag string, w http.ResponseWriter, r *http.Request) error {
+	logger := s.cm.MustGetLog(r.Context())
+
+	// ownCloud adds double quotes around ETag value
+	serverETag = fmt.Sprintf(`"%s"`, serverETag)
+	if clientETag != serverETag {
+		err := fmt.Errorf("etags do not match")
+		logger.Error().Log("msg", "can not accept conditional request", "client-etag", clientETag, "server-etag", serverETag)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) handleFinderRequest(w http.ResponseWriter, r *http.Request) error {
+	logger := s.cm.MustGetLog(r.Context())
+
+	/*
+	   Many webservers will not cooperate well with Finder PUT requests,
+	   because it uses 'Chunked' transfer encoding for the request body.
+	   The symptom of this problem is that Finder sends files to the
+	   server, but they arrive as 0-length files in PHP.
+	   If we don't do anything, the user might think they are uploading
+	   files successfully, but they end up empty on the server. Instead,
+	   we throw back an error if we detect this.
+	   The reason Finder uses Chunked, is because it thinks the files
+	   might change as it's being uploaded, and therefore the
+	   Content-Length can vary.
+	   Instead it sends the X-Expected-Entity-Length header with the size
+	   of the file at the very start of the request. If this header is set,
+	   but we don't get a request body we will fail the request to
+	   protect the end-user.
+	*/
+	logger.Warn().Log("msg", "finder problem intercepted", "content-length", r.Header.Get("Content-Length"), "x-expected-entity-length", r.Header.Get("X-Expected-Entity-Length"))
+
+	// The best mitigation to this problem is to tell users to not use crappy Finder.
+	// Another possible mitigation is to change the use the value of X-Expected-Entity-Length header in the Content-Length header.
+	expected := r.Header.Get("X-Expected-Entity-Length")
+	expectedInt, err := strconv.ParseInt(expected, 10, 64)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	r.ContentLength = expectedInt
+	return nil
 }
 
 func (s *service) requestSuffersFinderProblem(r *http.Request) bool {
@@ -869,6 +1833,10 @@ func (s *service) handleGetEndpointError(err error, w http.ResponseWriter, r *ht
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	}
 	logger.Error().Log("error", "unexpected error getting file")
 	w.WriteHeader(http.StatusInternalServerError)
@@ -882,6 +1850,10 @@ func (s *service) handleHeadEndpointError(err error, w http.ResponseWriter, r *h
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	}
 	logger.Error().Log("error", "unexpected error heading file")
 	w.WriteHeader(http.StatusInternalServerError)
@@ -890,6 +1862,12 @@ func (s *service) handleHeadEndpointError(err error, w http.ResponseWriter, r *h
 
 func (s *service) handleDeleteEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
 	logger.Error().Log("error", "unexpected error deleting file")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
@@ -897,6 +1875,16 @@ func (s *service) handleDeleteEndpointError(err error, w http.ResponseWriter, r
 
 func (s *service) handleMkcolEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeInvalidName {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	logger.Error().Log("error", "unexpected error creating folder")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
@@ -904,6 +1892,16 @@ func (s *service) handleMkcolEndpointError(err error, w http.ResponseWriter, r *
 
 func (s *service) handleMoveEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeInvalidName {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	logger.Error().Log("error", "unexpected error moving file")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
@@ -916,12 +1914,118 @@ func (s *service) handleOptionsEndpointError(err error, w http.ResponseWriter, r
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	}
 	logger.Error().Log("error", "unexpected error optioning file")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
 }
 
+// bulkUploadEndpoint implements the bulk upload of ownCloud, where many small files are uploaded
+// in a single multipart/related request. Every part carries the path of the file in the X-File-Path header
+// and optionally its checksum in X-File-MD5, verified while the part is read, or in OC-Checksum,
+// which is verified by the data driver.
+// Files are committed one by one as they are read, so the response always has a result for every part read.
+func (s *service) bulkUploadEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || params["boundary"] == "" {
+		logger.Warn().Log("msg", "bulk upload needs a multipart/related body", "contenttype", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results := map[string]*bulkUploadResult{}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error().Log("error", err, "msg", "error reading multipart body")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		path := part.Header.Get("X-File-Path")
+		if path == "" {
+			part.Close()
+			logger.Warn().Log("msg", "part without X-File-Path header")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		result := s.bulkUploadFile(r, user, path, part)
+		part.Close()
+		if result.Error {
+			logger.Warn().Log("msg", "bulk upload of file failed", "path", path, "error", result.Message)
+		}
+		results[path] = result
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Info().Log("msg", "bulk upload finished", "numfiles", len(results))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// bulkUploadFile uploads a single part of a bulk upload.
+func (s *service) bulkUploadFile(r *http.Request, user lib.User, path string, part *multipart.Part) *bulkUploadResult {
+	if filepath.Clean("/"+path) == "/" {
+		return &bulkUploadResult{Error: true, Message: "can not upload to the root folder"}
+	}
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil && !s.isNotFoundError(err) {
+		return &bulkUploadResult{Error: true, Message: err.Error()}
+	}
+	if fileInfo != nil && fileInfo.Folder() {
+		return &bulkUploadResult{Error: true, Message: "file already exists and is a folder"}
+	}
+
+	// the checksum of the data drivers is prefixed by its type in lower case.
+	var checksum string
+	if ocChecksum := part.Header.Get("OC-Checksum"); ocChecksum != "" {
+		if tokens := strings.SplitN(ocChecksum, ":", 2); len(tokens) == 2 {
+			checksum = strings.ToLower(tokens[0]) + ":" + tokens[1]
+		}
+	}
+
+	clientModTime, err := parseOCMTime(part.Header.Get("X-File-MTime"))
+	if err != nil {
+		return &bulkUploadResult{Error: true, Message: "invalid X-File-MTime header"}
+	}
+
+	// X-File-MD5 is verified here because the checksum type of the data driver may not be md5.
+	var reader io.Reader = &partLimitReader{r: part, n: s.uploadMaxFileSize}
+	if md5sum := part.Header.Get("X-File-MD5"); md5sum != "" {
+		reader = newMD5Reader(reader, md5sum)
+	}
+	readCloser := ioutil.NopCloser(reader)
+	if err := s.dataDriver.UploadFile(r.Context(), user, path, readCloser, checksum, clientModTime); err != nil {
+		return &bulkUploadResult{Error: true, Message: err.Error()}
+	}
+
+	newInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil {
+		return &bulkUploadResult{Error: true, Message: err.Error()}
+	}
+	etag, _ := newInfo.ExtraAttributes()["etag"].(string)
+	id, _ := newInfo.ExtraAttributes()["id"].(string)
+	return &bulkUploadResult{ETag: etag, FileID: id}
+}
+
 func (s *service) handlePutEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	logger.Error().Log("error", err)
@@ -954,6 +2058,14 @@ func (s *service) handlePutEndpointError(err error, w http.ResponseWriter, r *ht
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		if codeErr.Code() == lib.CodeInvalidName || codeErr.Code() == lib.CodeBadInputData {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if codeErr.Code() == lib.CodeTooBig {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
 	}
 
 	logger.Error().Log("unexpected error puting file")
@@ -983,30 +2095,106 @@ func (s *service) handlePropfindEndpointError(err error, w http.ResponseWriter,
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	}
 	logger.Error().Log("msg", "unexpected error propfinding file")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
 }
 
-func (s *service) fileInfosToXML(ctx context.Context, fileInfos []lib.FileInfo) (string, error) {
-	responses := []*responseXML{}
-	for _, fileInfo := range fileInfos {
-		res, err := s.fileInfoToPropResponse(ctx, fileInfo)
-		if err != nil {
-			return "", err
+func (s *service) handleReportEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeNotSupported {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
 		}
-		responses = append(responses, res)
 	}
-	responsesXML, err := xml.Marshal(&responses)
-	if err != nil {
-		return "", err
+	logger.Error().Log("msg", "unexpected error reporting collection")
+	w.WriteHeader(http.StatusInternalServerError)
+	return
+}
+
+func (s *service) handleSearchEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		switch codeErr.Code() {
+		case lib.CodeNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case lib.CodeForbidden:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case lib.CodeBadInputData:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case lib.CodeNotSupported:
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
 	}
+	logger.Error().Log("msg", "unexpected error searching")
+	w.WriteHeader(http.StatusInternalServerError)
+	return
+}
 
-	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
-	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
-	msg += string(responsesXML) + `</d:multistatus>`
-	return msg, nil
+// multistatusWriter streams a multistatus document, responses are sent as they are encoded.
+// Nothing is written until the first response, so errors before it can still be returned with their status code.
+type multistatusWriter struct {
+	w       http.ResponseWriter
+	bw      *bufio.Writer
+	encoder *xml.Encoder
+	started bool
+}
+
+func newMultistatusWriter(w http.ResponseWriter) *multistatusWriter {
+	bw := bufio.NewWriter(w)
+	return &multistatusWriter{w: w, bw: bw, encoder: xml.NewEncoder(bw)}
+}
+
+func (m *multistatusWriter) writeResponse(res *responseXML) error {
+	if !m.started {
+		m.started = true
+		m.w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		m.w.WriteHeader(207)
+		msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+		msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+		if _, err := m.bw.WriteString(msg); err != nil {
+			return err
+		}
+	}
+	return m.encoder.Encode(res)
+}
+
+// flush sends to the client what has been encoded so far.
+func (m *multistatusWriter) flush() error {
+	if err := m.bw.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := m.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (m *multistatusWriter) close() error {
+	if _, err := m.bw.WriteString(`</d:multistatus>`); err != nil {
+		return err
+	}
+	return m.flush()
 }
 
 func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileInfo) (*responseXML, error) {
@@ -1050,7 +2238,7 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 		"", []byte(etag)}
 
 	ocPermissions := propertyXML{xml.Name{Space: "", Local: "oc:permissions"},
-		"", []byte("RDNVW")}
+		"", []byte(getOCPermissions(fileInfo))}
 
 	quotaUsedBytes := propertyXML{
 		xml.Name{Space: "", Local: "d:quota-used-bytes"}, "", []byte("0")}
@@ -1081,7 +2269,6 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 	if fileInfo.Folder() {
 		getResourceType.InnerXML = []byte("<d:collection/>")
 		getContentType.InnerXML = []byte(s.mg.FromFileInfo(fileInfo))
-		ocPermissions.InnerXML = []byte("RDNVCK")
 	}
 
 	ocID := propertyXML{xml.Name{Space: "", Local: "oc:id"}, "",
@@ -1093,8 +2280,13 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 	ocDC := propertyXML{xml.Name{Space: "", Local: "oc:dDC"},
 		"", []byte("")}
 
+	// oc:size is the recursive size of folders when the metadata driver keeps it,
+	// the ownCloud clients use it to show the space taken by a folder.
+	ocSize := propertyXML{xml.Name{Space: "", Local: "oc:size"},
+		"", []byte(fmt.Sprintf("%d", fileInfo.Size()))}
+
 	propList = append(propList, getResourceType, getContentLegnth, getContentType, getLastModified, // general WebDAV properties
-		getETag, quotaAvailableBytes, quotaUsedBytes, ocID, ocDownloadURL, ocDC) // properties needed by ownCloud
+		getETag, quotaAvailableBytes, quotaUsedBytes, ocID, ocPermissions, ocDownloadURL, ocDC, ocSize) // properties needed by ownCloud
 
 	// PropStat, only HTTP/1.1 200 is sent.
 	propStatList := []propstatXML{}
@@ -1106,10 +2298,7 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 
 	response := responseXML{}
 
-	response.Href = filepath.Join("/ocwebdav/remote.php/webdav", fileInfo.Path())
-	if fileInfo.Folder() {
-		response.Href = filepath.Join("/ocwebdav/remote.php/webdav", fileInfo.Path()) + "/"
-	}
+	response.Href = pathToHref(fileInfo.Path(), fileInfo.Folder())
 
 	response.Propstat = propStatList
 
@@ -1117,6 +2306,52 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 
 }
 
+// pathToHref returns the URL-escaped href of the resource, folders end with a slash.
+func pathToHref(path string, folder bool) string {
+	href := (&url.URL{Path: filepath.Join("/ocwebdav/remote.php/webdav", path)}).EscapedPath()
+	if folder {
+		href = strings.TrimSuffix(href, "/") + "/"
+	}
+	return href
+}
+
+// getOCPermissions returns the ownCloud permissions of the resource.
+// Resources of the user have all the permissions, resources shared with the user
+// have the "permissions" extra attribute set by share aware drivers.
+// S: shared, R: reshare, D: delete, NV: rename and move, W: write, CK: create files and folders.
+func getOCPermissions(fileInfo lib.FileInfo) string {
+	var p string
+	extraAttributes := fileInfo.ExtraAttributes()
+	if shared, _ := extraAttributes["shared"].(bool); shared {
+		p += "S"
+	}
+
+	permissions, ok := extraAttributes["permissions"].(lib.SharePermission)
+	if !ok {
+		if fileInfo.Folder() {
+			return p + "RDNVCK"
+		}
+		return p + "RDNVW"
+	}
+
+	if permissions&lib.PermissionShare != 0 {
+		p += "R"
+	}
+	if permissions&lib.PermissionDelete != 0 {
+		p += "D"
+	}
+	if permissions&lib.PermissionUpdate != 0 {
+		p += "NV"
+		if !fileInfo.Folder() {
+			p += "W"
+		}
+	}
+	if fileInfo.Folder() && permissions&lib.PermissionCreate != 0 {
+		p += "CK"
+	}
+	return p
+}
+
 type responseXML struct {
 	XMLName             xml.Name      `xml:"d:response"`
 	Href                string        `xml:"d:href"`
@@ -1159,6 +2394,64 @@ type propertyXML struct {
 	InnerXML []byte `xml:",innerxml"`
 }
 
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// syncTokenPrefix turns the cursors of the change feed into URIs, as RFC 6578 requires sync tokens to be URIs.
+const syncTokenPrefix = "http://clawio.github.io/ns/sync/"
+
+// http://www.webdav.org/specs/rfc4918.html#ELEMENT_propertyupdate
+type propertyupdateXML struct {
+	XMLName xml.Name
+	Set     []propertyupdateActionXML `xml:"DAV: set"`
+	Remove  []propertyupdateActionXML `xml:"DAV: remove"`
+}
+
+type propertyupdateActionXML struct {
+	Prop struct {
+		Props []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// https://tools.ietf.org/html/rfc6578#section-6.1
+type syncCollectionXML struct {
+	XMLName   xml.Name
+	SyncToken string `xml:"DAV: sync-token"`
+	SyncLevel string `xml:"DAV: sync-level"`
+	Limit     *struct {
+		NResults int `xml:"DAV: nresults"`
+	} `xml:"DAV: limit"`
+}
+
+// https://tools.ietf.org/html/rfc5323#section-5.2
+type searchRequestXML struct {
+	XMLName     xml.Name
+	BasicSearch *struct {
+		Scope *struct {
+			Href  string `xml:"DAV: href"`
+			Depth string `xml:"DAV: depth"`
+		} `xml:"DAV: from>scope"`
+		Where *searchExpressionXML `xml:"DAV: where"`
+		Limit *struct {
+			NResults int `xml:"DAV: nresults"`
+			// FirstResult is an extension, clients use different namespaces for it.
+			FirstResult int `xml:"firstresult"`
+		} `xml:"DAV: limit"`
+	} `xml:"DAV: basicsearch"`
+}
+
+// searchExpressionXML is a node of the where clause of a search.
+type searchExpressionXML struct {
+	XMLName  xml.Name
+	Children []searchExpressionXML `xml:",any"`
+	Text     string                `xml:",chardata"`
+}
+
 // http://www.ocwebdav.org/specs/rfc4918.html#ELEMENT_error
 type errorXML struct {
 	XMLName  xml.Name `xml:"d:error"`
@@ -1176,3 +2469,46 @@ func (e metaDataDriverNotSupportedError) Code() lib.Code {
 func (e metaDataDriverNotSupportedError) Message() string {
 	return string(e)
 }
+
+type bulkUploadResult struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message,omitempty"`
+	ETag    string `json:"etag,omitempty"`
+	FileID  string `json:"fileid,omitempty"`
+}
+
+type tooBigError string
+
+func (e tooBigError) Error() string {
+	return string(e)
+}
+func (e tooBigError) Code() lib.Code {
+	return lib.Code(lib.CodeTooBig)
+}
+func (e tooBigError) Message() string {
+	return string(e)
+}
+
+type checksumError string
+
+func (e checksumError) Error() string {
+	return string(e)
+}
+func (e checksumError) Code() lib.Code {
+	return lib.Code(lib.CodeBadChecksum)
+}
+func (e checksumError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,1280 @@
+package ocxattrmdatadriver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	"github.com/satori/go.uuid"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// xattrName is the extended attribute where the metadata is kept.
+	// The user namespace is the only one writable by unprivileged processes.
+	xattrName = "user.clawio.meta"
+
+	// sidecarPrefix and sidecarSuffix wrap the name of the resource
+	// to build the name of its sidecar file when extended attributes
+	// are not supported by the underlying filesystem.
+	// Ex: the sidecar for /photos/1.png is /photos/.clawio.1.png.meta
+	sidecarPrefix = ".clawio."
+	sidecarSuffix = ".meta"
+
+	// temporarySuffix is appended to the names of sidecars while they are staged next to them.
+	temporarySuffix = ".tmp"
+
+	// journalFolder is the folder inside the data folder where the change journals
+	// of the users are kept, one file per user. Being hidden, it does not collide with any home.
+	journalFolder = ".clawio.journal"
+
+	// changeRetention is how long the changes are kept in the journal.
+	// Cursors whose next change has been removed are expired and their clients need to start over.
+	changeRetention = 30 * 24 * time.Hour
+
+	defaultChangesLimit = 1000
+
+	// idIndexTTL is how long the index of the ids of a home is used to assign ids before being
+	// built again, so ids copied into the home directly on the data folder are eventually seen.
+	idIndexTTL = time.Minute
+)
+
+// meta is the metadata kept for every resource, either inside
+// an extended attribute or inside a sidecar file.
+type meta struct {
+	// ID is the unique identifier for a resource.
+	// ownCloud uses this ID to track remote moves in the
+	// sync clients in order to avoid a delete+download operation
+	ID string `json:"id"`
+
+	// ETag changes every time the resource or any of its children changes.
+	ETag string `json:"etag"`
+
+	// Checksum is the checksum for the blob in type:sum format.
+	Checksum string `json:"checksum"`
+
+	// ModTime is propagated like the ETag, see ocfsmdatadriver for the rationale.
+	ModTime int64 `json:"modtime"`
+
+	// Stat is the fingerprint of the blob of files when the metadata was written, see statFingerprint.
+	Stat string `json:"stat"`
+}
+
+// Driver implements the MetaDataDriver interface without the need of a database.
+// It is compatible with the ownCloud sync protocol as it exposes IDs, ETags and
+// checksums, but it keeps them in the filesystem itself.
+type Driver struct {
+	logger          levels.Levels
+	dataFolder      string
+	temporaryFolder string
+	useSidecar      bool
+
+	// journalMu serializes the writes to the journals and protects journalSeqs.
+	journalMu   sync.Mutex
+	journalSeqs map[string]uint64 // username => last seq
+
+	// idIndexMu protects idIndexes.
+	idIndexMu sync.Mutex
+	idIndexes map[string]*idIndex // username => index
+}
+
+// idIndex maps the ids of the resources of a home to their paths, so importing a home
+// with SetFileID walks it once instead of once per resource.
+type idIndex struct {
+	paths map[string]string
+	built time.Time
+}
+
+// journalEntry is a line of the change journal of a user.
+type journalEntry struct {
+	Seq        uint64         `json:"seq"`
+	Kind       lib.ChangeKind `json:"kind"`
+	ID         string         `json:"id"`
+	Path       string         `json:"path"`
+	SourcePath string         `json:"source_path,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+}
+
+// New returns an implementation of MetaDataDriver
+func New(logger levels.Levels, dataFolder, temporaryFolder string) (lib.MetaDataDriver, error) {
+	logger = logger.With("pkg", "ocxattrmdatadriver")
+	c := &Driver{
+		logger:          logger,
+		dataFolder:      dataFolder,
+		temporaryFolder: temporaryFolder,
+		journalSeqs:     map[string]uint64{},
+		idIndexes:       map[string]*idIndex{},
+	}
+
+	if err := os.MkdirAll(dataFolder, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(dataFolder, journalFolder), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(temporaryFolder, 0755); err != nil {
+		return nil, err
+	}
+
+	// probe the data folder to know if we can rely on extended attributes
+	// or if we need to fallback to sidecar files.
+	if err := setXattr(dataFolder, xattrName+".probe", []byte("1")); err != nil {
+		if err != errXattrUnsupported {
+			return nil, err
+		}
+		logger.Warn().Log("msg", "extended attributes not supported, using sidecar files", "datafolder", dataFolder)
+		c.useSidecar = true
+	} else {
+		removeXattr(dataFolder, xattrName+".probe")
+	}
+	return c, nil
+}
+
+// Init initializes the user home directory.
+func (c *Driver) Init(ctx context.Context, user lib.User) error {
+	localPath := c.getLocalPath(user, "/")
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		c.logger.Error().Log("error", err)
+		return err
+	}
+
+	_, err := c.getMeta(localPath, true)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateFolder creates a new folder, names reserved for the sidecars are rejected.
+func (c *Driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	if err := c.ValidateName(path); err != nil {
+		return err
+	}
+	localPath := c.getLocalPath(user, path)
+	if err := os.Mkdir(localPath, 0755); err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsExist(err) {
+			return alreadyExistError("folder already exist")
+		}
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	return c.PropagateChanges(user, path, "/", "", 0)
+}
+
+// Examine returns the metadata associated with the resource.
+func (c *Driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		c.logger.Error().Log("error", err)
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+
+	m, err := c.getMeta(localPath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getObjectInfo(path, osFileInfo, m), nil
+}
+
+// ExamineByID returns the resource of the user with the given id, wherever it has been moved.
+// The path is looked up in the index of the ids of the home, which is built again when the
+// resource is not where the index says, as it may have been created or moved since.
+func (c *Driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	c.idIndexMu.Lock()
+	defer c.idIndexMu.Unlock()
+	return c.examineByID(ctx, user, id)
+}
+
+// examineByID is ExamineByID with idIndexMu held.
+func (c *Driver) examineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	index, built, err := c.getIDIndex(user, false)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error walking home")
+		return nil, err
+	}
+	if fi, ok := c.lookupID(ctx, user, index, id); ok {
+		return fi, nil
+	}
+	if !built {
+		index, _, err = c.getIDIndex(user, true)
+		if err != nil {
+			c.logger.Error().Log("error", err, "msg", "error walking home")
+			return nil, err
+		}
+		if fi, ok := c.lookupID(ctx, user, index, id); ok {
+			return fi, nil
+		}
+	}
+	return nil, notFoundError("resource with id " + id + " not found")
+}
+
+// lookupID returns the resource the index has for the id, if it still has that id.
+func (c *Driver) lookupID(ctx context.Context, user lib.User, index *idIndex, id string) (lib.FileInfo, bool) {
+	path, ok := index.paths[id]
+	if !ok {
+		return nil, false
+	}
+	fi, err := c.Examine(ctx, user, path)
+	if err != nil {
+		return nil, false
+	}
+	if resourceID, _ := fi.ExtraAttributes()["id"].(string); resourceID != id {
+		return nil, false
+	}
+	return fi, true
+}
+
+// walkIDs calls fn with the id and the path of every resource of the home that has metadata.
+func (c *Driver) walkIDs(user lib.User, fn func(id, path string) error) error {
+	homePath := c.getLocalPath(user, "/")
+	return filepath.Walk(homePath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// the resource may have been removed during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if isReserved(fi.Name()) {
+			return nil
+		}
+		m, err := c.getMeta(p, false)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(m.ID, secureJoin("/", strings.TrimPrefix(p, homePath)))
+	})
+}
+
+// ListFolder returns the contents of the folder, hiding the sidecar files.
+func (c *Driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	if !osFileInfo.IsDir() {
+		return nil, isFolderError("file is not a folder")
+	}
+	fd, err := os.Open(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFoundError(err.Error())
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	osFileInfos, err := fd.Readdir(-1) // read all files inside the directory.
+	if err != nil {
+		return nil, err
+	}
+	var fileInfos []lib.FileInfo
+	for _, fi := range osFileInfos {
+		if isReserved(fi.Name()) {
+			continue
+		}
+		p := filepath.Join(path, filepath.Base(fi.Name()))
+		m, err := c.getMeta(filepath.Join(localPath, fi.Name()), true)
+		if err != nil {
+			return nil, err
+		}
+		fileInfos = append(fileInfos, c.getObjectInfo(p, fi, m))
+	}
+	return fileInfos, nil
+}
+
+// Delete deletes a resource and propagates the change to its ancestors.
+func (c *Driver) Delete(ctx context.Context, user lib.User, path string) error {
+	localPath := c.getLocalPath(user, path)
+	var id string
+	if m, err := c.getMeta(localPath, false); err == nil {
+		id = m.ID
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		c.logger.Error().Log("error", err)
+		return err
+	}
+	if c.useSidecar {
+		if err := os.Remove(sidecarPath(localPath)); err != nil && !os.IsNotExist(err) {
+			c.logger.Error().Log("error", err, "msg", "error removing sidecar")
+		}
+	}
+	c.propagateChanges(user, filepath.Dir(filepath.Clean("/"+path)), "/", time.Now().UnixNano())
+	c.recordChange(user, lib.ChangeDelete, id, path, "")
+	return nil
+}
+
+// Move moves a resource from source to target, names reserved for the sidecars are rejected.
+// The ID of the resource is kept, so sync clients can detect the move.
+func (c *Driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	if err := c.ValidateName(targetPath); err != nil {
+		return err
+	}
+	sourceLocalPath := c.getLocalPath(user, sourcePath)
+	targetLocalPath := c.getLocalPath(user, targetPath)
+
+	// make sure the source has metadata before being moved, else
+	// the target will be assigned a new ID.
+	m, err := c.getMeta(sourceLocalPath, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+
+	err = os.Rename(sourceLocalPath, targetLocalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		} else if _, ok := err.(*os.LinkError); ok {
+			return renameError(err.Error())
+		}
+		return err
+	}
+
+	if c.useSidecar {
+		if err := os.Rename(sidecarPath(sourceLocalPath), sidecarPath(targetLocalPath)); err != nil {
+			c.logger.Error().Log("error", err, "msg", "error moving sidecar")
+		}
+	}
+
+	c.moveIDIndex(user, sourcePath, targetPath)
+	modTime := time.Now().UnixNano()
+	c.propagateChanges(user, filepath.Dir(filepath.Clean("/"+sourcePath)), "/", modTime)
+	c.propagateChanges(user, targetPath, "/", modTime)
+	c.recordChange(user, lib.ChangeMove, m.ID, targetPath, sourcePath)
+	return nil
+}
+
+// InheritMetaData copies the metadata of the resource at path into localFile,
+// a file that is going to replace it, so the ID survives the upload of a new version.
+// Data drivers that write the blob into a temporary file and then
+// rename it into place call this method before the rename.
+func (c *Driver) InheritMetaData(user lib.User, path, localFile string) error {
+	if c.useSidecar {
+		// the sidecar is not tied to the inode, so it survives the rename.
+		return nil
+	}
+	m, err := c.getMeta(c.getLocalPath(user, path), false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return c.setMeta(localFile, m)
+}
+
+// PropagateChanges assigns a new ETag to the resource at from, sets its checksum and modification time,
+// zero meaning now, and propagates the ETag and the current time to its ancestors until to (included).
+func (c *Driver) PropagateChanges(user lib.User, from, to, checksum string, modTime int64) error {
+	localPath := c.getLocalPath(user, from)
+	kind := lib.ChangeModify
+	m, err := c.getMeta(localPath, false)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		m = &meta{ID: uuid.NewV4().String()}
+		kind = lib.ChangeCreate
+	}
+
+	propagationTime := time.Now().UnixNano()
+	if modTime == 0 {
+		modTime = propagationTime
+	}
+	m.ETag = uuid.NewV4().String()
+	m.ModTime = modTime
+	m.Checksum = checksum
+	if err := c.setMeta(localPath, m); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting metadata")
+		return err
+	}
+	c.recordChange(user, kind, m.ID, from, "")
+
+	from = filepath.Clean("/" + from)
+	if from != filepath.Clean("/"+to) {
+		c.propagateChanges(user, filepath.Dir(from), to, propagationTime)
+	}
+	return nil
+}
+
+// SetModTime sets the modification time of the resource on the filesystem and in its metadata.
+// The modification time of folders is used to compare-and-swap the propagation of changes,
+// so folders can not be set in the future.
+func (c *Driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	localPath := c.getLocalPath(user, path)
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	if now := time.Now().UnixNano(); osFileInfo.IsDir() && modTime > now {
+		modTime = now
+	}
+
+	m, err := c.getMeta(localPath, true)
+	if err != nil {
+		return err
+	}
+
+	t := time.Unix(0, modTime)
+	if err := os.Chtimes(localPath, t, t); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting modification time")
+		return err
+	}
+	return c.PropagateChanges(user, path, "/", m.Checksum, modTime)
+}
+
+// SetFileID assigns the id to the resource. IDs are only unique inside the home of a user,
+// which is checked against an index of the ids of the home built with a single walk and kept for idIndexTTL.
+func (c *Driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	if id == "" {
+		return badInputError("id is empty")
+	}
+	path = filepath.Clean("/" + path)
+	localPath := c.getLocalPath(user, path)
+	m, err := c.getMeta(localPath, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return notFoundError(err.Error())
+		}
+		return err
+	}
+	if m.ID == id {
+		return nil
+	}
+
+	c.idIndexMu.Lock()
+	defer c.idIndexMu.Unlock()
+	index, _, err := c.getIDIndex(user, false)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error walking home")
+		return err
+	}
+	if other, ok := index.paths[id]; ok && other != path {
+		// the index may be stale, the resource could have been moved or its id changed.
+		fi, err := c.examineByID(ctx, user, id)
+		if err == nil {
+			return alreadyExistError(fmt.Sprintf("id %s already assigned to %s", id, fi.Path()))
+		}
+		if codeErr, ok := err.(lib.Error); !ok || codeErr.Code() != lib.CodeNotFound {
+			return err
+		}
+		// examineByID may have built the index again.
+		index = c.idIndexes[user.Username()]
+	}
+
+	oldID := m.ID
+	m.ID = id
+	if err := c.setMeta(localPath, m); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error setting id")
+		return err
+	}
+	delete(index.paths, oldID)
+	index.paths[id] = path
+	return nil
+}
+
+// getIDIndex returns the index of the ids of the home of the user, building it again when it is
+// older than idIndexTTL or when rebuild is set. The returned bool tells if the index has just been built.
+// idIndexMu must be held.
+func (c *Driver) getIDIndex(user lib.User, rebuild bool) (*idIndex, bool, error) {
+	if index, ok := c.idIndexes[user.Username()]; ok && !rebuild && time.Since(index.built) < idIndexTTL {
+		return index, false, nil
+	}
+	for username, index := range c.idIndexes {
+		if time.Since(index.built) >= idIndexTTL {
+			delete(c.idIndexes, username)
+		}
+	}
+	index := &idIndex{paths: map[string]string{}, built: time.Now()}
+	err := c.walkIDs(user, func(id, path string) error {
+		index.paths[id] = path
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	c.idIndexes[user.Username()] = index
+	return index, true, nil
+}
+
+// moveIDIndex updates the paths of the index of the ids of the home after sourcePath
+// has been moved to targetPath, so moved resources are found without building the index again.
+func (c *Driver) moveIDIndex(user lib.User, sourcePath, targetPath string) {
+	sourcePath = filepath.Clean("/" + sourcePath)
+	targetPath = filepath.Clean("/" + targetPath)
+
+	c.idIndexMu.Lock()
+	defer c.idIndexMu.Unlock()
+	index, ok := c.idIndexes[user.Username()]
+	if !ok {
+		return
+	}
+	for id, path := range index.paths {
+		if path == sourcePath {
+			index.paths[id] = targetPath
+		} else if strings.HasPrefix(path, sourcePath+"/") {
+			index.paths[id] = targetPath + strings.TrimPrefix(path, sourcePath)
+		}
+	}
+}
+
+// SyncPath reconciles the metadata of path with the state of the filesystem.
+// It is used to pick up changes done directly on the data folder, like rsync restores.
+// Files are reconciled whenever their stat fingerprint differs from the one of their metadata,
+// so changes done through this driver are not propagated twice.
+func (c *Driver) SyncPath(ctx context.Context, user lib.User, path string) error {
+	return c.syncPath(user, path, true)
+}
+
+// syncPath reconciles the metadata of path. event tells if path comes from an explicit
+// filesystem event, which is authoritative, or from a walk of the tree, see modifiedOutOfBand.
+func (c *Driver) syncPath(user lib.User, path string, event bool) error {
+	path = filepath.Clean("/" + path)
+	localPath := c.getLocalPath(user, path)
+	if isReserved(filepath.Base(localPath)) {
+		return nil
+	}
+
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if path == "/" {
+			return nil
+		}
+		if c.useSidecar {
+			return c.removeOrphanSidecar(user, path)
+		}
+		// the ID is lost with the inode, but the path is enough for clients to forget the resource.
+		c.recordChange(user, lib.ChangeDelete, "", path, "")
+		// the removal changed the modification time of the parent.
+		return c.syncPath(user, filepath.Dir(path), event)
+	}
+
+	m, err := c.getMeta(localPath, false)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		// writing sidecars changes the modification time of the folders, so in sidecar mode
+		// changes inside a folder are only detected through its children.
+		if osFileInfo.IsDir() && c.useSidecar {
+			return nil
+		}
+		if !c.modifiedOutOfBand(m, osFileInfo, event) {
+			return nil
+		}
+	}
+
+	// the checksum is not valid anymore as the content has been modified out-of-band.
+	c.logger.Info().Log("msg", "resource modified out-of-band", "file", localPath)
+	return c.PropagateChanges(user, path, "/", "", 0)
+}
+
+// modifiedOutOfBand returns if the resource has been modified since its metadata was written.
+// Files are compared by their stat fingerprint. Metadata without one is always reconciled on explicit
+// events, while walks of the tree fall back on comparing the modification time.
+// Folders change whenever their children change, so they are only compared by modification time.
+func (c *Driver) modifiedOutOfBand(m *meta, osFileInfo os.FileInfo, event bool) bool {
+	if osFileInfo.IsDir() {
+		return m.ModTime < osFileInfo.ModTime().UnixNano()
+	}
+	if m.Stat != "" {
+		return m.Stat != statFingerprint(osFileInfo, c.useSidecar)
+	}
+	if event {
+		return true
+	}
+	return m.ModTime < osFileInfo.ModTime().UnixNano()
+}
+
+// SyncMove reconciles the metadata after a resource has been moved directly on the data folder.
+// Extended attributes travel with the inode, so only sidecars need to be moved.
+func (c *Driver) SyncMove(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	sourcePath = filepath.Clean("/" + sourcePath)
+	targetPath = filepath.Clean("/" + targetPath)
+	c.moveIDIndex(user, sourcePath, targetPath)
+
+	if c.useSidecar {
+		sourceSidecar := sidecarPath(c.getLocalPath(user, sourcePath))
+		if _, err := os.Stat(sourceSidecar); err == nil {
+			c.logger.Info().Log("msg", "resource moved out-of-band", "source", sourcePath, "target", targetPath)
+			if err := os.Rename(sourceSidecar, sidecarPath(c.getLocalPath(user, targetPath))); err != nil {
+				return err
+			}
+			modTime := time.Now().UnixNano()
+			c.propagateChanges(user, filepath.Dir(sourcePath), "/", modTime)
+			c.propagateChanges(user, filepath.Dir(targetPath), "/", modTime)
+			c.recordMove(user, sourcePath, targetPath)
+		}
+		return c.SyncPath(ctx, user, targetPath)
+	}
+
+	c.recordMove(user, sourcePath, targetPath)
+	if err := c.SyncPath(ctx, user, targetPath); err != nil {
+		return err
+	}
+	if err := c.SyncPath(ctx, user, filepath.Dir(targetPath)); err != nil {
+		return err
+	}
+	return c.SyncPath(ctx, user, filepath.Dir(sourcePath))
+}
+
+// SyncTree reconciles the metadata of path and all its descendants.
+func (c *Driver) SyncTree(ctx context.Context, user lib.User, path string) error {
+	path = filepath.Clean("/" + path)
+	localPath := c.getLocalPath(user, path)
+
+	if _, err := os.Stat(localPath); err != nil {
+		if os.IsNotExist(err) {
+			return c.SyncPath(ctx, user, path)
+		}
+		return err
+	}
+
+	err := filepath.Walk(localPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// the resource may have been removed during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		childPath := secureJoin(path, strings.TrimPrefix(p, localPath))
+		if isReserved(fi.Name()) && !isSidecar(fi.Name()) {
+			return nil
+		}
+		if isSidecar(fi.Name()) {
+			if !c.useSidecar {
+				return nil
+			}
+			resourcePath := filepath.Join(filepath.Dir(childPath), strings.TrimSuffix(strings.TrimPrefix(fi.Name(), sidecarPrefix), sidecarSuffix))
+			if _, err := os.Stat(c.getLocalPath(user, resourcePath)); os.IsNotExist(err) {
+				return c.removeOrphanSidecar(user, resourcePath)
+			}
+			return nil
+		}
+		return c.syncPath(user, childPath, false)
+	})
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error walking tree")
+		return err
+	}
+	return nil
+}
+
+// removeOrphanSidecar removes the sidecar of a resource that has been
+// removed out-of-band and propagates the change to its ancestors.
+func (c *Driver) removeOrphanSidecar(user lib.User, path string) error {
+	localPath := c.getLocalPath(user, path)
+	var id string
+	if m, err := c.getMeta(localPath, false); err == nil {
+		id = m.ID
+	}
+	err := os.Remove(sidecarPath(localPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	c.logger.Info().Log("msg", "resource removed out-of-band", "path", path)
+	c.propagateChanges(user, filepath.Dir(path), "/", time.Now().UnixNano())
+	c.recordChange(user, lib.ChangeDelete, id, path, "")
+	return nil
+}
+
+// recordMove records the move of a resource already in place at targetPath.
+func (c *Driver) recordMove(user lib.User, sourcePath, targetPath string) {
+	m, err := c.getMeta(c.getLocalPath(user, targetPath), false)
+	if err != nil {
+		return
+	}
+	c.recordChange(user, lib.ChangeMove, m.ID, targetPath, sourcePath)
+}
+
+// recordChange appends a change to the journal of the user.
+// Errors are logged but not returned, as the change has already been applied.
+func (c *Driver) recordChange(user lib.User, kind lib.ChangeKind, id, path, sourcePath string) {
+	entry := &journalEntry{
+		Kind:      kind,
+		ID:        id,
+		Path:      filepath.Clean("/" + path),
+		Timestamp: time.Now().UnixNano(),
+	}
+	if sourcePath != "" {
+		entry.SourcePath = filepath.Clean("/" + sourcePath)
+	}
+
+	c.journalMu.Lock()
+	defer c.journalMu.Unlock()
+
+	seq, err := c.getLastSeq(user)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error reading journal", "user", user.Username())
+		return
+	}
+	entry.Seq = seq + 1
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error encoding change")
+		return
+	}
+	fd, err := os.OpenFile(c.getJournalPath(user), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error opening journal", "user", user.Username())
+		return
+	}
+	defer fd.Close()
+	if _, err := fd.Write(append(data, '\n')); err != nil {
+		c.logger.Error().Log("error", err, "msg", "error recording change", "user", user.Username())
+		return
+	}
+	c.journalSeqs[user.Username()] = entry.Seq
+}
+
+// GetChanges returns the changes in the namespace of the user after cursor.
+// An empty cursor, or one whose next change has been removed, returns a reset change set
+// with the latest cursor, telling the client it needs to list its namespace again.
+func (c *Driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	now := time.Now()
+	if limit <= 0 {
+		limit = defaultChangesLimit
+	}
+
+	var seq uint64
+	if cursor != "" {
+		var err error
+		seq, _, err = decodeCursor(cursor)
+		if err != nil {
+			c.logger.Error().Log("error", err, "cursor", cursor)
+			return nil, badCursorError("invalid cursor")
+		}
+	}
+
+	c.journalMu.Lock()
+	defer c.journalMu.Unlock()
+
+	entries, err := c.readJournal(user)
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error reading journal", "user", user.Username())
+		return nil, err
+	}
+	entries, err = c.compactJournal(user, entries, now.Add(-changeRetention).UnixNano())
+	if err != nil {
+		c.logger.Error().Log("error", err, "msg", "error reading journal", "user", user.Username())
+		return nil, err
+	}
+
+	// seqs are consecutive, the cursor has expired when the change after it has been removed.
+	if cursor == "" || (len(entries) > 0 && entries[0].Seq > seq+1) {
+		latest, err := c.getLastSeq(user)
+		if err != nil {
+			return nil, err
+		}
+		c.logger.Info().Log("msg", "change journal reset", "user", user.Username(), "cursor", cursor)
+		return &changeSet{cursor: encodeCursor(latest, now.UnixNano()), reset: true}, nil
+	}
+
+	set := &changeSet{}
+	for _, entry := range entries {
+		if entry.Seq <= seq {
+			continue
+		}
+		if len(set.changes) == limit {
+			set.hasMore = true
+			break
+		}
+		seq = entry.Seq
+		set.changes = append(set.changes, &change{
+			kind:       entry.Kind,
+			id:         entry.ID,
+			path:       entry.Path,
+			sourcePath: entry.SourcePath,
+			timestamp:  entry.Timestamp,
+		})
+	}
+	set.cursor = encodeCursor(seq, now.UnixNano())
+	return set, nil
+}
+
+// compactJournal removes the entries older than expiredBefore from the journal of the user
+// and returns the remaining ones. The journal is only rewritten once at least half of it has expired,
+// so the cost of rewriting it is spread over the changes appended since. journalMu must be held.
+func (c *Driver) compactJournal(user lib.User, entries []*journalEntry, expiredBefore int64) ([]*journalEntry, error) {
+	n := 0
+	for n < len(entries) && entries[n].Timestamp < expiredBefore {
+		n++
+	}
+
+	// the last entry is kept, even if expired, so seqs are never reused.
+	if n == len(entries) {
+		n--
+	}
+	if n <= 0 || n < len(entries)/2 {
+		return entries, nil
+	}
+	entries = entries[n:]
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	if err := c.writeFile(c.getJournalPath(user), data); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// getLastSeq returns the seq of the last change recorded for the user. journalMu must be held.
+func (c *Driver) getLastSeq(user lib.User) (uint64, error) {
+	if seq, ok := c.journalSeqs[user.Username()]; ok {
+		return seq, nil
+	}
+	entries, err := c.readJournal(user)
+	if err != nil {
+		return 0, err
+	}
+	var seq uint64
+	if len(entries) > 0 {
+		seq = entries[len(entries)-1].Seq
+	}
+	c.journalSeqs[user.Username()] = seq
+	return seq, nil
+}
+
+func (c *Driver) readJournal(user lib.User) ([]*journalEntry, error) {
+	fd, err := os.Open(c.getJournalPath(user))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	var entries []*journalEntry
+	dec := json.NewDecoder(fd)
+	for {
+		entry := &journalEntry{}
+		if err := dec.Decode(entry); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			// a partial line left by a crash is ignored.
+			c.logger.Warn().Log("error", err, "msg", "journal is truncated", "user", user.Username())
+			return entries, nil
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (c *Driver) getJournalPath(user lib.User) string {
+	return secureJoin(filepath.Join(c.dataFolder, journalFolder), user.Username())
+}
+
+// propagateChanges propagates a new etag and modTime from path until ancestor (included).
+// Like in ocfsmdatadriver, the propagation stops when a node has already been
+// updated with a more recent modification time by a concurrent request.
+func (c *Driver) propagateChanges(user lib.User, path, ancestor string, modTime int64) {
+	path = filepath.Clean("/" + path)
+	ancestor = filepath.Clean("/" + ancestor)
+	if !strings.HasPrefix(path, ancestor) {
+		return
+	}
+
+	etag := uuid.NewV4().String()
+	for {
+		localPath := c.getLocalPath(user, path)
+		m, err := c.getMeta(localPath, false)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				c.logger.Error().Log("error", err, "msg", "error propagating changes", "path", path)
+				return
+			}
+			m = &meta{ID: uuid.NewV4().String()}
+		}
+
+		if m.ModTime >= modTime {
+			c.logger.Debug().Log("msg", "path already updated, propagation aborted", "path", path)
+			return
+		}
+
+		m.ETag = etag
+		m.ModTime = modTime
+		if err := c.setMeta(localPath, m); err != nil {
+			c.logger.Error().Log("error", err, "msg", "error propagating changes", "path", path)
+			return
+		}
+
+		if path == ancestor || path == "/" {
+			return
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// getMeta returns the metadata of the resource at localPath.
+// If the resource does not have metadata yet and forceCreateOnMiss is true
+// new metadata is assigned to it.
+// When the resource does not have metadata an error satisfying os.IsNotExist is returned.
+func (c *Driver) getMeta(localPath string, forceCreateOnMiss bool) (*meta, error) {
+	var data []byte
+	var err error
+	if c.useSidecar {
+		data, err = ioutil.ReadFile(sidecarPath(localPath))
+	} else {
+		data, err = getXattr(localPath, xattrName)
+	}
+
+	if err == nil {
+		m := &meta{}
+		if err := json.Unmarshal(data, m); err != nil {
+			c.logger.Error().Log("error", err, "msg", "corrupted metadata, assigning new one", "file", localPath)
+		} else if m.ID != "" && m.ETag != "" {
+			return m, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !forceCreateOnMiss {
+		return nil, os.ErrNotExist
+	}
+
+	// the resource may have been created out-of-band, so we make sure
+	// it exists before assigning metadata to it.
+	osFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &meta{
+		ID:      uuid.NewV4().String(),
+		ETag:    uuid.NewV4().String(),
+		ModTime: osFileInfo.ModTime().UnixNano(),
+	}
+	if err := c.setMeta(localPath, m); err != nil {
+		return nil, err
+	}
+	c.logger.Debug().Log("msg", "metadata assigned", "file", localPath, "id", m.ID)
+	return m, nil
+}
+
+func (c *Driver) setMeta(localPath string, m *meta) error {
+	if osFileInfo, err := os.Stat(localPath); err == nil && !osFileInfo.IsDir() {
+		m.Stat = statFingerprint(osFileInfo, c.useSidecar)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if c.useSidecar {
+		return c.writeFile(sidecarPath(localPath), data)
+	}
+	return setXattr(localPath, xattrName, data)
+}
+
+// writeFile writes the file atomically to avoid readers seeing partial writes.
+// The file is staged in the same folder, so the rename does not cross filesystems.
+func (c *Driver) writeFile(fn string, data []byte) error {
+	fd, err := ioutil.TempFile(filepath.Dir(fn), sidecarPrefix+"*"+sidecarSuffix+temporarySuffix)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err := fd.Write(data); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), fn)
+}
+
+func (c *Driver) getLocalPath(user lib.User, path string) string {
+	homeDir := secureJoin("/", user.Username())
+	userPath := secureJoin(homeDir, path)
+	return secureJoin(c.dataFolder, userPath)
+}
+
+func (c *Driver) getObjectInfo(path string, osFileInfo os.FileInfo, m *meta) lib.FileInfo {
+	return &fileInfo{path: path, osFileInfo: osFileInfo, checksum: m.Checksum, etag: m.ETag, id: m.ID, mtime: m.ModTime}
+}
+
+func sidecarPath(localPath string) string {
+	dir, base := filepath.Split(filepath.Clean(localPath))
+	return filepath.Join(dir, fmt.Sprintf("%s%s%s", sidecarPrefix, base, sidecarSuffix))
+}
+
+func isSidecar(name string) bool {
+	return strings.HasPrefix(name, sidecarPrefix) && strings.HasSuffix(name, sidecarSuffix)
+}
+
+// isReserved returns if name is used by the driver for its own files, the sidecars and
+// the temporary files they are staged in, which are hidden from the users.
+func isReserved(name string) bool {
+	return isSidecar(name) || (strings.HasPrefix(name, sidecarPrefix) && strings.HasSuffix(name, sidecarSuffix+temporarySuffix))
+}
+
+// ValidateName returns an error with CodeInvalidName when the name of path is reserved by the driver.
+// Data drivers call it before uploading a file.
+func (c *Driver) ValidateName(path string) error {
+	if name := filepath.Base(filepath.Clean("/" + path)); isReserved(name) {
+		return invalidNameError(fmt.Sprintf("%q is reserved", name))
+	}
+	return nil
+}
+
+// secureJoin avoids path traversal attacks when joinning paths.
+func secureJoin(args ...string) string {
+	if len(args) > 1 {
+		s := []string{"/"}
+		s = append(s, args[1:]...)
+		jailedPath := filepath.Join(s...)
+		return filepath.Join(args[0], jailedPath)
+	}
+	return filepath.Join(args...)
+}
+
+// encodeCursor returns an opaque cursor pointing to seq, issued at timestamp.
+// The timestamp is informative, expiration is detected with the seqs.
+func encodeCursor(seq uint64, timestamp int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", seq, timestamp)))
+}
+
+func decodeCursor(cursor string) (uint64, int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	tokens := strings.Split(string(data), ":")
+	if len(tokens) != 2 {
+		return 0, 0, fmt.Errorf("cursor has %d tokens", len(tokens))
+	}
+	seq, err := strconv.ParseUint(tokens[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	timestamp, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return seq, timestamp, nil
+}
+
+type change struct {
+	kind       lib.ChangeKind
+	id         string
+	path       string
+	sourcePath string
+	timestamp  int64
+}
+
+func (c *change) Kind() lib.ChangeKind {
+	return c.kind
+}
+
+func (c *change) ID() string {
+	return c.id
+}
+
+func (c *change) Path() string {
+	return c.path
+}
+
+func (c *change) SourcePath() string {
+	return c.sourcePath
+}
+
+func (c *change) Timestamp() int64 {
+	return c.timestamp
+}
+
+type changeSet struct {
+	changes []lib.Change
+	cursor  string
+	hasMore bool
+	reset   bool
+}
+
+func (s *changeSet) Changes() []lib.Change {
+	return s.changes
+}
+
+func (s *changeSet) Cursor() string {
+	return s.cursor
+}
+
+func (s *changeSet) HasMore() bool {
+	return s.hasMore
+}
+
+func (s *changeSet) Reset() bool {
+	return s.reset
+}
+
+type fileInfo struct {
+	path       string
+	osFileInfo os.FileInfo
+	checksum   string
+	etag       string
+	id         string
+	mtime      int64
+}
+
+func (f *fileInfo) Path() string {
+	return f.path
+}
+
+func (f *fileInfo) Folder() bool {
+	return f.osFileInfo.IsDir()
+}
+
+func (f *fileInfo) Size() int64 {
+	return int64(f.osFileInfo.Size())
+}
+
+func (f *fileInfo) Modified() int64 {
+	if f.mtime == 0 {
+		return f.osFileInfo.ModTime().UnixNano()
+	}
+	return f.mtime
+}
+
+func (f *fileInfo) Checksum() string {
+	return f.checksum
+}
+
+func (f *fileInfo) ExtraAttributes() map[string]interface{} {
+	return map[string]interface{}{
+		"id":   f.id,
+		"etag": f.etag,
+	}
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type isFolderError string
+
+func (e isFolderError) Error() string {
+	return string(e)
+}
+func (e isFolderError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e isFolderError) Message() string {
+	return string(e)
+}
+
+type badCursorError string
+
+func (e badCursorError) Error() string {
+	return string(e)
+}
+func (e badCursorError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badCursorError) Message() string {
+	return string(e)
+}
+
+type invalidNameError string
+
+func (e invalidNameError) Error() string {
+	return string(e)
+}
+func (e invalidNameError) Code() lib.Code {
+	return lib.Code(lib.CodeInvalidName)
+}
+func (e invalidNameError) Message() string {
+	return string(e)
+}
+
+type renameError string
+
+func (e renameError) Error() string {
+	return string(e)
+}
+func (e renameError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e renameError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,21 @@
+package ocxattrmdatadriver
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// statFingerprint returns the inode, size and modification time of the file and, when ctime is true,
+// its change time. Writing extended attributes changes the change time, so it is only
+// usable when the metadata is kept in sidecars.
+func statFingerprint(fi os.FileInfo, ctime bool) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Sprintf("%d:%d", fi.Size(), fi.ModTime().UnixNano())
+	}
+	if !ctime {
+		return fmt.Sprintf("%d:%d:%d", st.Ino, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", st.Ino, st.Ctim.Nano(), fi.Size(), fi.ModTime().UnixNano())
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package ocxattrmdatadriver
+
+import (
+	"fmt"
+	"os"
+)
+
+// statFingerprint returns the size and modification time of the file.
+// On non Linux platforms the inode and change time are not used.
+func statFingerprint(fi os.FileInfo, ctime bool) string {
+	return fmt.Sprintf("%d:%d", fi.Size(), fi.ModTime().UnixNano())
+}
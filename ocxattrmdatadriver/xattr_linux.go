@@ -0,0 +1,45 @@
+package ocxattrmdatadriver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errXattrUnsupported is returned when the filesystem does not support extended attributes.
+var errXattrUnsupported = errors.New("extended attributes not supported")
+
+// getXattr returns the value of the extended attribute.
+// When the attribute is not set an error satisfying os.IsNotExist is returned.
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, convertXattrError(err)
+	}
+	data := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, data)
+	if err != nil {
+		return nil, convertXattrError(err)
+	}
+	return data[:size], nil
+}
+
+func setXattr(path, name string, data []byte) error {
+	return convertXattrError(syscall.Setxattr(path, name, data, 0))
+}
+
+func removeXattr(path, name string) error {
+	return convertXattrError(syscall.Removexattr(path, name))
+}
+
+func convertXattrError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case syscall.ENODATA:
+		return os.ErrNotExist
+	case syscall.ENOTSUP:
+		return errXattrUnsupported
+	}
+	return err
+}
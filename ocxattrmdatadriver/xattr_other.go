@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package ocxattrmdatadriver
+
+import (
+	"errors"
+)
+
+// errXattrUnsupported is returned when the filesystem does not support extended attributes.
+// On non Linux platforms the driver always uses sidecar files.
+var errXattrUnsupported = errors.New("extended attributes not supported")
+
+func getXattr(path, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func setXattr(path, name string, data []byte) error {
+	return errXattrUnsupported
+}
+
+func removeXattr(path, name string) error {
+	return errXattrUnsupported
+}
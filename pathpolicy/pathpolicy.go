@@ -0,0 +1,147 @@
+package pathpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	defaultMaxNameLength = 255
+	defaultMaxPathLength = 4096
+)
+
+// forbiddenCharacters can not be used in names on Windows, they are mapped to their fullwidth forms
+// when the policy maps characters instead of rejecting them.
+var forbiddenCharacters = map[rune]rune{
+	'\\': '＼',
+	':':  '：',
+	'*':  '＊',
+	'?':  '？',
+	'"':  '＂',
+	'<':  '＜',
+	'>':  '＞',
+	'|':  '｜',
+}
+
+// reservedNames are device names on Windows, they can not be used even with an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+type policy struct {
+	logger                 levels.Levels
+	mapForbiddenCharacters bool
+	maxNameLength          int
+	maxPathLength          int
+	blockedPatterns        []string
+}
+
+// New returns an implementation of PathPolicy that normalizes paths to Unicode NFC and rejects
+// the names that can not be synced to every client: reserved Windows names, names ending with a dot
+// or a space, control characters and, unless mapForbiddenCharacters is set, the characters forbidden on Windows.
+// When mapForbiddenCharacters is set these characters are mapped to their fullwidth forms instead.
+// maxNameLength and maxPathLength are in bytes, zero means the defaults of 255 and 4096.
+// blockedPatterns is a comma separated list of glob patterns, like .DS_Store,._*, matched against the names.
+func New(logger levels.Levels, mapForbiddenCharacters bool, maxNameLength, maxPathLength int, blockedPatterns string) (lib.PathPolicy, error) {
+	logger = logger.With("pkg", "pathpolicy")
+	if maxNameLength <= 0 {
+		maxNameLength = defaultMaxNameLength
+	}
+	if maxPathLength <= 0 {
+		maxPathLength = defaultMaxPathLength
+	}
+	patterns := []string{}
+	for _, pattern := range strings.Split(blockedPatterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid blocked pattern %q: %s", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return &policy{
+		logger:                 logger,
+		mapForbiddenCharacters: mapForbiddenCharacters,
+		maxNameLength:          maxNameLength,
+		maxPathLength:          maxPathLength,
+		blockedPatterns:        patterns,
+	}, nil
+}
+
+func (p *policy) Normalize(path string) string {
+	path = norm.NFC.String(path)
+	if p.mapForbiddenCharacters {
+		path = strings.Map(func(r rune) rune {
+			if mapped, ok := forbiddenCharacters[r]; ok {
+				return mapped
+			}
+			return r
+		}, path)
+	}
+	return filepath.Clean("/" + path)
+}
+
+// Validate only checks the last component of path, the parents already exist
+// and were validated when they were created.
+func (p *policy) Validate(path string) error {
+	path = filepath.Clean("/" + path)
+	if len(path) > p.maxPathLength {
+		return invalidNameError(fmt.Sprintf("path is longer than %d bytes", p.maxPathLength))
+	}
+	name := filepath.Base(path)
+	if name == "/" {
+		return nil
+	}
+	if len(name) > p.maxNameLength {
+		return invalidNameError(fmt.Sprintf("name is longer than %d bytes", p.maxNameLength))
+	}
+	if !utf8.ValidString(name) {
+		return invalidNameError("name is not valid UTF-8")
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return invalidNameError("name contains control characters")
+		}
+		if _, ok := forbiddenCharacters[r]; ok {
+			return invalidNameError(fmt.Sprintf("name contains the forbidden character %q", r))
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return invalidNameError("name can not end with a dot or a space")
+	}
+	base := strings.TrimRight(strings.SplitN(name, ".", 2)[0], " ")
+	if reservedNames[strings.ToUpper(base)] {
+		return invalidNameError(fmt.Sprintf("name %q is reserved", name))
+	}
+	for _, pattern := range p.blockedPatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			p.logger.Debug().Log("msg", "name is blocked", "name", name, "pattern", pattern)
+			return invalidNameError(fmt.Sprintf("name %q is blocked", name))
+		}
+	}
+	return nil
+}
+
+type invalidNameError string
+
+func (e invalidNameError) Error() string {
+	return string(e)
+}
+func (e invalidNameError) Code() lib.Code {
+	return lib.Code(lib.CodeInvalidName)
+}
+func (e invalidNameError) Message() string {
+	return string(e)
+}
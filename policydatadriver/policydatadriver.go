@@ -0,0 +1,77 @@
+package policydatadriver
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type driver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	policy         lib.PathPolicy
+}
+
+// New returns an implementation of DataDriver that normalizes with policy the paths
+// sent to dataDriver and validates the names of the files uploaded.
+// metaDataDriver must see the same namespace as dataDriver, it is used to find the files
+// created before the policy was enabled, which are overwritten and downloaded with the path as sent by the client.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, policy lib.PathPolicy) lib.DataDriver {
+	logger = logger.With("pkg", "policydatadriver")
+	return &driver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		policy:         policy,
+	}
+}
+
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	normalizedPath := d.policy.Normalize(path)
+	if normalizedPath != filepath.Clean("/"+path) && !d.exists(ctx, user, normalizedPath) && d.exists(ctx, user, path) {
+		// the file was created before the policy was enabled, it is updated in place
+		// instead of creating a second file that looks the same to the user.
+		return d.dataDriver.UploadFile(ctx, user, path, r, clientChecksum, clientModTime)
+	}
+	if err := d.policy.Validate(normalizedPath); err != nil {
+		r.Close()
+		return err
+	}
+	return d.dataDriver.UploadFile(ctx, user, normalizedPath, r, clientChecksum, clientModTime)
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	normalizedPath := d.policy.Normalize(path)
+	reader, err := d.dataDriver.DownloadFile(ctx, user, normalizedPath)
+	if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeNotFound && normalizedPath != filepath.Clean("/"+path) {
+		return d.dataDriver.DownloadFile(ctx, user, path)
+	}
+	return reader, err
+}
+
+// AvailableBytes reports no limit when the wrapped driver has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, user, d.policy.Normalize(path))
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+func (d *driver) exists(ctx context.Context, user lib.User, path string) bool {
+	_, err := d.metaDataDriver.Examine(ctx, user, path)
+	return err == nil
+}
@@ -0,0 +1,227 @@
+package policymdatadriver
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/go-kit/kit/log/levels"
+	"golang.org/x/text/unicode/norm"
+)
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	policy         lib.PathPolicy
+}
+
+// New returns an implementation of MetaDataDriver that normalizes with policy the paths
+// sent to metaDataDriver and validates the names of the resources created or moved.
+// Resources created before the policy was enabled may not be normalized, so operations on
+// existing resources are retried with the path as sent by the client when the normalized one is not found.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, policy lib.PathPolicy) lib.MetaDataDriver {
+	logger = logger.With("pkg", "policymdatadriver")
+	d := &driver{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+		policy:         policy,
+	}
+	return d
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	fileInfo, err := d.metaDataDriver.Examine(ctx, user, d.policy.Normalize(path))
+	if d.retry(path, err) {
+		return d.metaDataDriver.Examine(ctx, user, path)
+	}
+	return fileInfo, err
+}
+
+// ExamineByID returns a notSupportedError when the wrapped driver can not resolve ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+	return fileIDResolver.ExamineByID(ctx, user, id)
+}
+
+// Search returns a notSupportedError when the wrapped driver can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	searcher, ok := d.metaDataDriver.(lib.Searcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+	scope := query.Scope
+	if query.Scope != "" {
+		query.Scope = d.policy.Normalize(scope)
+	}
+	if query.Name != "" {
+		// names are stored in NFC, the pattern characters must not be mapped so only the form is normalized.
+		query.Name = norm.NFC.String(query.Name)
+	}
+	result, err := searcher.Search(ctx, user, query)
+	if scope != "" && d.retry(scope, err) {
+		query.Scope = scope
+		return searcher.Search(ctx, user, query)
+	}
+	return result, err
+}
+
+// SearchContent returns a notSupportedError when the wrapped driver can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	contentSearcher, ok := d.metaDataDriver.(lib.ContentSearcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+	scope := query.Scope
+	if query.Scope != "" {
+		query.Scope = d.policy.Normalize(scope)
+	}
+	result, err := contentSearcher.SearchContent(ctx, user, query)
+	if scope != "" && d.retry(scope, err) {
+		query.Scope = scope
+		return contentSearcher.SearchContent(ctx, user, query)
+	}
+	return result, err
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	fileInfos, err := d.metaDataDriver.ListFolder(ctx, user, d.policy.Normalize(path))
+	if d.retry(path, err) {
+		return d.metaDataDriver.ListFolder(ctx, user, path)
+	}
+	return fileInfos, err
+}
+
+// ListFolderPage returns a notSupportedError when the wrapped driver can not page folders.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	folderPager, ok := d.metaDataDriver.(lib.FolderPager)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support paging folders")
+	}
+	page, err := folderPager.ListFolderPage(ctx, user, d.policy.Normalize(path), query)
+	if d.retry(path, err) {
+		return folderPager.ListFolderPage(ctx, user, path, query)
+	}
+	return page, err
+}
+
+// WalkFolder returns a notSupportedError when the wrapped driver can not walk folders.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	folderWalker, ok := d.metaDataDriver.(lib.FolderWalker)
+	if !ok {
+		return notSupportedError("metadata driver does not support walking folders")
+	}
+	err := folderWalker.WalkFolder(ctx, user, d.policy.Normalize(path), fn)
+	if d.retry(path, err) {
+		return folderWalker.WalkFolder(ctx, user, path, fn)
+	}
+	return err
+}
+
+// SetModTime returns a notSupportedError when the wrapped driver can not set modification times.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	modTimeSetter, ok := d.metaDataDriver.(lib.ModTimeSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+	err := modTimeSetter.SetModTime(ctx, user, d.policy.Normalize(path), modTime)
+	if d.retry(path, err) {
+		return modTimeSetter.SetModTime(ctx, user, path, modTime)
+	}
+	return err
+}
+
+// GetChanges returns a notSupportedError when the wrapped driver has no change feed.
+func (d *driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	changeFeed, ok := d.metaDataDriver.(lib.ChangeFeed)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support change feeds")
+	}
+	return changeFeed.GetChanges(ctx, user, cursor, limit)
+}
+
+// SetFileID returns a notSupportedError when the wrapped driver can not set ids.
+func (d *driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	fileIDSetter, ok := d.metaDataDriver.(lib.FileIDSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting file ids")
+	}
+	err := fileIDSetter.SetFileID(ctx, user, d.policy.Normalize(path), id)
+	if d.retry(path, err) {
+		return fileIDSetter.SetFileID(ctx, user, path, id)
+	}
+	return err
+}
+
+// RecursiveSizes returns false when the wrapped driver can not compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer)
+	return ok && recursiveSizer.RecursiveSizes()
+}
+
+// Supports tells if the wrapped driver supports the optional interface.
+func (d *driver) Supports(c lib.Capability) bool {
+	return capability.Supports(d.metaDataDriver, c)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.metaDataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	path = d.policy.Normalize(path)
+	if err := d.policy.Validate(path); err != nil {
+		return err
+	}
+	return d.metaDataDriver.CreateFolder(ctx, user, path)
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	err := d.metaDataDriver.Delete(ctx, user, d.policy.Normalize(path))
+	if d.retry(path, err) {
+		return d.metaDataDriver.Delete(ctx, user, path)
+	}
+	return err
+}
+
+// Move validates only the target, so resources with names rejected by the policy can still be renamed.
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	targetPath = d.policy.Normalize(targetPath)
+	if err := d.policy.Validate(targetPath); err != nil {
+		return err
+	}
+	err := d.metaDataDriver.Move(ctx, user, d.policy.Normalize(sourcePath), targetPath)
+	if d.retry(sourcePath, err) {
+		return d.metaDataDriver.Move(ctx, user, sourcePath, targetPath)
+	}
+	return err
+}
+
+// retry returns true when err is a not found error and path is not already normalized.
+func (d *driver) retry(path string, err error) bool {
+	codeErr, ok := err.(lib.Error)
+	if !ok || codeErr.Code() != lib.CodeNotFound {
+		return false
+	}
+	return d.policy.Normalize(path) != filepath.Clean("/"+path)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
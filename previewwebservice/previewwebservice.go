@@ -0,0 +1,602 @@
+package previewwebservice
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSize = 36
+	maxSize     = 2048
+
+	// maxPixels protects against images that are small on disk but huge once decoded.
+	maxPixels = 50 * 1000 * 1000
+
+	// cacheMaxAge is sent in the Cache-Control header, previews are revalidated with the ETag after it.
+	cacheMaxAge = 7 * 24 * 60 * 60
+
+	// defaultMaxCacheSize is the size of the cache folder used when none is given.
+	defaultMaxCacheSize = 1024 * 1024 * 1024
+
+	// cacheTemporaryPrefix is the prefix of the files being written to the cache folder.
+	cacheTemporaryPrefix = ".tmp"
+)
+
+// previewer generates the preview of an image of a given mime type.
+type previewer func(r io.Reader) (image.Image, error)
+
+type service struct {
+	cm             lib.ContextManager
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	am             lib.AuthenticationMiddleware
+	bam            lib.BasicAuthMiddleware
+	mg             lib.MimeGuesser
+	cacheFolder    string
+	maxCacheSize   int64
+	maxFileSize    int64
+	previewers     map[string]previewer
+
+	// cacheSize is the size of the cache folder, evicting is set while old previews are removed.
+	cacheMu   sync.Mutex
+	cacheSize int64
+	evicting  bool
+
+	// icons caches the generated mime type icons by type and size.
+	iconsMu sync.Mutex
+	icons   map[string][]byte
+}
+
+// New returns a web service that serves thumbnails of the images of the user
+// and mime type icons for the rest of the files.
+// Thumbnails are cached in cacheFolder keyed by the user and the id and the etag of the file, so they are
+// regenerated when the file changes. The least recently used thumbnails are removed when the cache
+// folder grows over maxCacheSize bytes. Images bigger than maxFileSize bytes get an icon.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	dataDriver lib.DataDriver,
+	metaDataDriver lib.MetaDataDriver,
+	am lib.AuthenticationMiddleware,
+	bam lib.BasicAuthMiddleware,
+	mg lib.MimeGuesser,
+	cacheFolder string,
+	maxCacheSize int64,
+	maxFileSize int64) (lib.WebService, error) {
+	if err := os.MkdirAll(cacheFolder, 0755); err != nil {
+		return nil, err
+	}
+	if maxCacheSize <= 0 {
+		maxCacheSize = defaultMaxCacheSize
+	}
+	s := &service{
+		cm:             cm,
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		am:             am,
+		bam:            bam,
+		mg:             mg,
+		cacheFolder:    cacheFolder,
+		maxCacheSize:   maxCacheSize,
+		maxFileSize:    maxFileSize,
+		icons:          map[string][]byte{},
+	}
+	cacheSize, err := s.scanCache()
+	if err != nil {
+		return nil, err
+	}
+	s.cacheSize = cacheSize
+	s.previewers = map[string]previewer{
+		"image/jpeg": decodeImage,
+		"image/png":  decodeImage,
+		"image/gif":  decodeImage,
+	}
+	return s, nil
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/preview/thumbnail": {
+			"GET": s.am.HandlerFunc(s.thumbnailEndpoint),
+		},
+		"/ocwebdav/index.php/core/preview.png": {
+			"GET": s.bam.HandlerFunc(s.ocPreviewEndpoint),
+		},
+		"/ocwebdav/index.php/apps/files/api/v1/thumbnail/{x:[0-9]+}/{y:[0-9]+}/{path:.*}": {
+			"GET": s.bam.HandlerFunc(s.ocThumbnailEndpoint),
+		},
+	}
+}
+
+// thumbnailEndpoint serves the thumbnail of the file in the path query parameter,
+// x and y are the maximum width and height and the aspect ratio is kept unless crop is true.
+func (s *service) thumbnailEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.servePreview(w, r, q.Get("path"), atoi(q.Get("x")), atoi(q.Get("y")), q.Get("crop") != "true", false)
+}
+
+// ocPreviewEndpoint implements the preview.png endpoint of ownCloud.
+// Previews are cropped to fill the requested size unless a is set.
+func (s *service) ocPreviewEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	keepAspect := q.Get("a") == "1" || q.Get("a") == "true"
+	forceIcon := q.Get("forceIcon") == "1" || q.Get("forceIcon") == "true"
+	s.servePreview(w, r, q.Get("file"), atoi(q.Get("x")), atoi(q.Get("y")), keepAspect, forceIcon)
+}
+
+// ocThumbnailEndpoint implements the thumbnail endpoint of the ownCloud files app.
+func (s *service) ocThumbnailEndpoint(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s.servePreview(w, r, vars["path"], atoi(vars["x"]), atoi(vars["y"]), true, false)
+}
+
+func (s *service) servePreview(w http.ResponseWriter, r *http.Request, path string, width, height int, keepAspect, forceIcon bool) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	width, height = normalizeSize(width, height)
+	fileInfo, err := s.metaDataDriver.Examine(r.Context(), user, path)
+	if err != nil {
+		s.handleError(err, w, r)
+		return
+	}
+
+	// text types come with their charset.
+	mimeType := strings.TrimSpace(strings.Split(s.mg.FromFileInfo(fileInfo), ";")[0])
+	p, ok := s.previewers[mimeType]
+	if forceIcon || !ok || fileInfo.Folder() || (s.maxFileSize > 0 && fileInfo.Size() > s.maxFileSize) {
+		s.serveIcon(w, r, fileInfo, mimeType, width, height)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s"`, s.cacheKey(user, fileInfo, width, height, keepAspect))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", cacheMaxAge))
+	w.Header().Set("Last-Modified", time.Unix(0, fileInfo.Modified()).UTC().Format(http.TimeFormat))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// jpeg thumbnails are much smaller for photos, the rest are png to keep the transparency.
+	contentType := "image/png"
+	if mimeType == "image/jpeg" {
+		contentType = "image/jpeg"
+	}
+
+	cachePath := filepath.Join(s.cacheFolder, strings.Trim(etag, `"`))
+	data, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		data, err = s.generate(r, fileInfo, p, contentType, width, height, keepAspect)
+		if err != nil {
+			logger.Warn().Log("error", err, "msg", "error generating preview, falling back to icon", "path", fileInfo.Path())
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+			s.serveIcon(w, r, fileInfo, mimeType, width, height)
+			return
+		}
+		if err := writeCacheFile(s.cacheFolder, cachePath, data); err != nil {
+			logger.Error().Log("error", err, "msg", "error caching preview")
+		} else {
+			s.addToCache(int64(len(data)))
+		}
+	} else {
+		// the modification time tells the eviction which previews have been used recently.
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		logger.Info().Log("msg", "preview served from cache", "path", fileInfo.Path())
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// generate creates the thumbnail of the file.
+func (s *service) generate(r *http.Request, fileInfo lib.FileInfo, p previewer, contentType string, width, height int, keepAspect bool) ([]byte, error) {
+	user := s.cm.MustGetUser(r.Context())
+	readCloser, err := s.dataDriver.DownloadFile(r.Context(), user, fileInfo.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer readCloser.Close()
+
+	img, err := p(readCloser)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail := resize(img, width, height, keepAspect)
+
+	buf := &bytes.Buffer{}
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(buf, thumbnail, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(buf, thumbnail)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cacheKey identifies a thumbnail, the etag changes when the contents of the file change.
+// The user is part of the key because ids and paths are only unique inside a home for some drivers.
+// Drivers without ids or etags fall back to the path and the modification time.
+func (s *service) cacheKey(user lib.User, fileInfo lib.FileInfo, width, height int, keepAspect bool) string {
+	extraAttributes := fileInfo.ExtraAttributes()
+	id, _ := extraAttributes["id"].(string)
+	etag, _ := extraAttributes["etag"].(string)
+	if id == "" {
+		id = fileInfo.Path()
+	}
+	if etag == "" {
+		etag = fmt.Sprintf("%d-%d", fileInfo.Modified(), fileInfo.Size())
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%s:%s:%dx%d:%t", user.Username(), id, etag, width, height, keepAspect)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serveIcon writes an icon for the mime type, icons do not depend on the contents of the file.
+func (s *service) serveIcon(w http.ResponseWriter, r *http.Request, fileInfo lib.FileInfo, mimeType string, width, height int) {
+	logger := s.cm.MustGetLog(r.Context())
+	if fileInfo.Folder() {
+		mimeType = "httpd/unix-directory"
+	}
+	size := width
+	if height < size {
+		size = height
+	}
+	key := fmt.Sprintf("%s:%d", iconCategory(mimeType), size)
+
+	s.iconsMu.Lock()
+	data, ok := s.icons[key]
+	if !ok {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, drawIcon(iconCategory(mimeType), size)); err != nil {
+			s.iconsMu.Unlock()
+			logger.Error().Log("error", err, "msg", "error encoding icon")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+		s.icons[key] = data
+	}
+	s.iconsMu.Unlock()
+
+	etag := fmt.Sprintf(`"icon-%s-%d"`, iconCategory(mimeType), size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", cacheMaxAge))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *service) handleError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error serving preview")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// decodeImage decodes images supported by the standard library,
+// the dimensions are checked before decoding to not allocate huge images.
+func decodeImage(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image of %dx%d is too big to be previewed", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// resize scales the image down to fit in width x height, averaging the pixels of the source.
+// When keepAspect is false the image is cropped from the center to fill the whole box.
+// Images are never scaled up.
+func resize(img image.Image, width, height int, keepAspect bool) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return img
+	}
+
+	src := bounds
+	if keepAspect {
+		if width*srcH < height*srcW {
+			height = maxInt(1, srcH*width/srcW)
+		} else {
+			width = maxInt(1, srcW*height/srcH)
+		}
+	} else {
+		// crop the source to the aspect ratio of the box.
+		if srcW*height > srcH*width {
+			cropW := srcH * width / height
+			src.Min.X += (srcW - cropW) / 2
+			src.Max.X = src.Min.X + cropW
+		} else {
+			cropH := srcW * height / width
+			src.Min.Y += (srcH - cropH) / 2
+			src.Max.Y = src.Min.Y + cropH
+		}
+	}
+	if width > src.Dx() || height > src.Dy() {
+		width, height = src.Dx(), src.Dy()
+	}
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, src.Dx(), src.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, src.Min, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*src.Dy()/height, maxInt((y+1)*src.Dy()/height, y*src.Dy()/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*src.Dx()/width, maxInt((x+1)*src.Dx()/width, x*src.Dx()/width+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				off := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					pa := uint64(rgba.Pix[off+3])
+					r += uint64(rgba.Pix[off]) * pa
+					g += uint64(rgba.Pix[off+1]) * pa
+					b += uint64(rgba.Pix[off+2]) * pa
+					a += pa
+					n++
+					off += 4
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{uint8(r / a), uint8(g / a), uint8(b / a), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// iconCategory groups mime types in the categories that have an icon.
+func iconCategory(mimeType string) string {
+	if mimeType == "httpd/unix-directory" {
+		return "folder"
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "text/"):
+		return "text"
+	case strings.Contains(mimeType, "zip"), strings.Contains(mimeType, "tar"), strings.Contains(mimeType, "compressed"):
+		return "package"
+	}
+	return "file"
+}
+
+var iconColors = map[string]color.NRGBA{
+	"folder":  {0x1d, 0x2d, 0x44, 0xff},
+	"image":   {0x4a, 0x9b, 0x4a, 0xff},
+	"video":   {0xc0, 0x39, 0x2b, 0xff},
+	"audio":   {0x8e, 0x44, 0xad, 0xff},
+	"text":    {0x34, 0x6a, 0xa8, 0xff},
+	"package": {0xd3, 0x8b, 0x1a, 0xff},
+	"file":    {0x7f, 0x8c, 0x8d, 0xff},
+}
+
+// drawIcon draws a flat icon of the category: a folder shape for folders and a page with its corner folded for files.
+func drawIcon(category string, size int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	c := iconColors[category]
+	margin := size / 8
+	if category == "folder" {
+		tab := image.Rect(margin, margin+size/8, size/2, margin+size/4)
+		draw.Draw(img, tab, &image.Uniform{c}, image.ZP, draw.Src)
+		body := image.Rect(margin, margin+size/4, size-margin, size-margin)
+		draw.Draw(img, body, &image.Uniform{c}, image.ZP, draw.Src)
+		return img
+	}
+
+	left, right := margin+size/16, size-margin-size/16
+	fold := size / 4
+	for y := margin; y < size-margin; y++ {
+		for x := left; x < right; x++ {
+			// cut the top right corner.
+			if x-(right-fold) > y-margin {
+				continue
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// normalizeSize applies the defaults and limits to the requested size, a missing side takes the value of the other.
+func normalizeSize(width, height int) (int, int) {
+	if width <= 0 && height <= 0 {
+		width, height = defaultSize, defaultSize
+	} else if width <= 0 {
+		width = height
+	} else if height <= 0 {
+		height = width
+	}
+	if width > maxSize {
+		width = maxSize
+	}
+	if height > maxSize {
+		height = maxSize
+	}
+	return width, height
+}
+
+// addToCache accounts for a new preview and starts removing the least recently used
+// previews when the cache folder is over its maximum size.
+func (s *service) addToCache(size int64) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cacheSize += size
+	if s.cacheSize <= s.maxCacheSize || s.evicting {
+		return
+	}
+	s.evicting = true
+	go s.evictCache()
+}
+
+// evictCache removes the least recently used previews until the cache folder is
+// under nine tenths of its maximum size, so eviction does not run on every new preview.
+func (s *service) evictCache() {
+	s.cacheMu.Lock()
+	before := s.cacheSize
+	s.cacheMu.Unlock()
+
+	defer func() {
+		s.cacheMu.Lock()
+		s.evicting = false
+		s.cacheMu.Unlock()
+	}()
+
+	fileInfos, err := ioutil.ReadDir(s.cacheFolder)
+	if err != nil {
+		s.logger.Error().Log("error", err, "msg", "error reading cache folder")
+		return
+	}
+	sort.Slice(fileInfos, func(i, j int) bool {
+		return fileInfos[i].ModTime().Before(fileInfos[j].ModTime())
+	})
+
+	var size int64
+	for _, fi := range fileInfos {
+		if !isCacheFile(fi) {
+			continue
+		}
+		size += fi.Size()
+	}
+
+	var removed int
+	limit := s.maxCacheSize / 10 * 9
+	for _, fi := range fileInfos {
+		if size <= limit {
+			break
+		}
+		if !isCacheFile(fi) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cacheFolder, fi.Name())); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Log("error", err, "msg", "error removing preview from cache")
+			continue
+		}
+		size -= fi.Size()
+		removed++
+	}
+
+	// the size is recomputed from the listing, plus the previews added while evicting.
+	s.cacheMu.Lock()
+	s.cacheSize = size + s.cacheSize - before
+	s.cacheMu.Unlock()
+	s.logger.Info().Log("msg", "preview cache evicted", "numremoved", removed, "size", size)
+}
+
+// scanCache returns the size of the cache folder and removes the temporary files
+// left by previous runs.
+func (s *service) scanCache() (int64, error) {
+	fileInfos, err := ioutil.ReadDir(s.cacheFolder)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, fi := range fileInfos {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), cacheTemporaryPrefix) {
+			os.Remove(filepath.Join(s.cacheFolder, fi.Name()))
+			continue
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+// isCacheFile returns true for the previews stored in the cache folder.
+func isCacheFile(fi os.FileInfo) bool {
+	return !fi.IsDir() && !strings.HasPrefix(fi.Name(), cacheTemporaryPrefix)
+}
+
+// writeCacheFile writes the file atomically so concurrent requests never read partial previews.
+func writeCacheFile(cacheFolder, cachePath string, data []byte) error {
+	fd, err := ioutil.TempFile(cacheFolder, cacheTemporaryPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), cachePath)
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
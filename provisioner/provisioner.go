@@ -0,0 +1,207 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type provisioner struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	archiver       lib.Archiver
+	skeletonFolder string
+	archiveFolder  string
+
+	mu          sync.Mutex
+	provisioned map[string]bool
+}
+
+// New returns an implementation of Provisioner. Provisioning initializes the drivers that implement
+// Initializer and, when the home of the user did not exist, copies the local folder skeletonFolder into it.
+// Users are only provisioned once per process, later calls return at once.
+// Deprovisioning with DeprovisionArchive writes a ZIP archive of the home to archiveFolder with archiver
+// before removing the data.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, archiver lib.Archiver, skeletonFolder, archiveFolder string) (lib.Provisioner, error) {
+	logger = logger.With("pkg", "provisioner")
+	if skeletonFolder != "" {
+		fi, err := os.Stat(skeletonFolder)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("skeleton folder %s is not a folder", skeletonFolder)
+		}
+	}
+	if archiveFolder != "" {
+		if err := os.MkdirAll(archiveFolder, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &provisioner{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		archiver:       archiver,
+		skeletonFolder: skeletonFolder,
+		archiveFolder:  archiveFolder,
+		provisioned:    map[string]bool{},
+	}, nil
+}
+
+// Provision copies the skeleton on a best effort basis: a failure is logged and the user is
+// still provisioned, because the home already exists and the skeleton would not be copied again.
+func (p *provisioner) Provision(ctx context.Context, user lib.User) error {
+	if p.isProvisioned(user) {
+		return nil
+	}
+
+	_, err := p.metaDataDriver.Examine(ctx, user, "/")
+	isNew := isNotFoundError(err)
+	if err != nil && !isNew {
+		return err
+	}
+
+	for _, driver := range []interface{}{p.metaDataDriver, p.dataDriver} {
+		if initializer, ok := driver.(lib.Initializer); ok {
+			if err := initializer.Init(ctx, user); err != nil {
+				p.logger.Error().Log("error", err, "msg", "error initializing home", "user", user.Username())
+				return err
+			}
+		}
+	}
+
+	if isNew && p.skeletonFolder != "" {
+		if err := p.copySkeleton(ctx, user); err != nil {
+			p.logger.Error().Log("error", err, "msg", "error copying skeleton", "user", user.Username())
+		}
+	}
+
+	p.mu.Lock()
+	p.provisioned[user.Username()] = true
+	p.mu.Unlock()
+	p.logger.Info().Log("msg", "user provisioned", "user", user.Username(), "new", isNew)
+	return nil
+}
+
+// Deprovision removes all the resources of the home of the user. The empty home is kept,
+// so provisioning the user again does not copy the skeleton.
+func (p *provisioner) Deprovision(ctx context.Context, user lib.User, mode lib.DeprovisionMode) error {
+	switch mode {
+	case lib.DeprovisionArchive:
+		if p.archiveFolder == "" {
+			return badInputError("archive folder not configured")
+		}
+		if err := p.archive(ctx, user); err != nil {
+			p.logger.Error().Log("error", err, "msg", "error archiving home", "user", user.Username())
+			return err
+		}
+	case lib.DeprovisionDelete:
+	default:
+		return badInputError(fmt.Sprintf("deprovision mode %d not supported", mode))
+	}
+
+	fileInfos, err := p.metaDataDriver.ListFolder(ctx, user, "/")
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		if err := p.metaDataDriver.Delete(ctx, user, fileInfo.Path()); err != nil {
+			p.logger.Error().Log("error", err, "msg", "error deleting resource", "user", user.Username(), "path", fileInfo.Path())
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	delete(p.provisioned, user.Username())
+	p.mu.Unlock()
+	p.logger.Info().Log("msg", "user deprovisioned", "user", user.Username(), "mode", mode)
+	return nil
+}
+
+func (p *provisioner) isProvisioned(user lib.User) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.provisioned[user.Username()]
+}
+
+// copySkeleton uploads the files of the skeleton folder keeping their modification times.
+// Symbolic links and other special files are skipped.
+func (p *provisioner) copySkeleton(ctx context.Context, user lib.User) error {
+	return filepath.Walk(p.skeletonFolder, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(localPath, p.skeletonFolder)
+		path := filepath.Clean("/" + filepath.ToSlash(rel))
+		if path == "/" {
+			return nil
+		}
+
+		if fi.IsDir() {
+			err := p.metaDataDriver.CreateFolder(ctx, user, path)
+			if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeAlreadyExist {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		fd, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		return p.dataDriver.UploadFile(ctx, user, path, fd, "", fi.ModTime().UnixNano())
+	})
+}
+
+// archive writes the archive to a temporary name and renames it when it is complete,
+// so a partial archive is never mistaken for a good one.
+func (p *provisioner) archive(ctx context.Context, user lib.User) error {
+	name := filepath.Join(p.archiveFolder, fmt.Sprintf("%s-%d.zip", user.Username(), time.Now().Unix()))
+	fd, err := os.Create(name + ".part")
+	if err != nil {
+		return err
+	}
+	if err := p.archiver.Archive(ctx, user, []string{"/"}, lib.ArchiveFormatZIP, fd); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := os.Rename(fd.Name(), name); err != nil {
+		return err
+	}
+	p.logger.Info().Log("msg", "home archived", "user", user.Username(), "archive", name)
+	return nil
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,205 @@
+package provisioningwebservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type service struct {
+	cm             lib.ContextManager
+	logger         levels.Levels
+	provisioner    lib.Provisioner
+	am             lib.AuthenticationMiddleware
+	wec            lib.WebErrorConverter
+	administrators map[string]bool
+}
+
+// New returns a web service that lets administrators provision and deprovision users,
+// for example when an account is created or removed in the user directory.
+// administrators is a comma separated list of usernames.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	provisioner lib.Provisioner,
+	am lib.AuthenticationMiddleware,
+	wec lib.WebErrorConverter,
+	administrators string) lib.WebService {
+	admins := map[string]bool{}
+	for _, username := range strings.Split(administrators, ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			admins[username] = true
+		}
+	}
+	return &service{
+		cm:             cm,
+		logger:         logger,
+		provisioner:    provisioner,
+		am:             am,
+		wec:            wec,
+		administrators: admins,
+	}
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/provisioning/provision": {
+			"POST": s.am.HandlerFunc(s.provisionEndpoint),
+		},
+		"/provisioning/deprovision": {
+			"POST": s.am.HandlerFunc(s.deprovisionEndpoint),
+		},
+	}
+}
+
+func (s *service) provisionEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+
+	req := &provisionRequest{}
+	if !s.authorizeAndDecode(w, r, req) {
+		return
+	}
+
+	if err := s.provisioner.Provision(r.Context(), &user{username: req.Username}); err != nil {
+		s.handleProvisioningEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "user provisioned", "username", req.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deprovisionEndpoint removes the data of the user, mode is "archive", the default, or "delete".
+// The user must also be removed from the user directory, or the next login provisions it again.
+func (s *service) deprovisionEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+
+	req := &deprovisionRequest{}
+	if !s.authorizeAndDecode(w, r, req) {
+		return
+	}
+	mode := lib.DeprovisionArchive
+	switch req.Mode {
+	case "", "archive":
+	case "delete":
+		mode = lib.DeprovisionDelete
+	default:
+		s.handleProvisioningEndpointError(badRequestError("mode must be archive or delete"), w, r)
+		return
+	}
+
+	if err := s.provisioner.Deprovision(r.Context(), &user{username: req.Username}, mode); err != nil {
+		s.handleProvisioningEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "user deprovisioned", "username", req.Username, "mode", req.Mode)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeAndDecode checks that the user is an administrator and reads the json request into v,
+// writing the error response when any of them fails.
+func (s *service) authorizeAndDecode(w http.ResponseWriter, r *http.Request, v usernameGetter) bool {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	if !s.administrators[user.Username()] {
+		logger.Warn().Log("msg", "provisioning is only allowed to administrators")
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		logger.Error().Log("error", err)
+		s.handleProvisioningEndpointError(badRequestError("invalid json"), w, r)
+		return false
+	}
+	if strings.TrimSpace(v.username()) == "" {
+		s.handleProvisioningEndpointError(badRequestError("username is empty"), w, r)
+		return false
+	}
+	return true
+}
+
+func (s *service) handleProvisioningEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeBadInputData {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error provisioning")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+func (e badRequestError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badRequestError) Message() string {
+	return string(e)
+}
+
+// user is the user to provision, drivers only need its username to find its home.
+type user struct {
+	username string
+}
+
+func (u *user) Username() string {
+	return u.username
+}
+
+func (u *user) Email() string {
+	return ""
+}
+
+func (u *user) DisplayName() string {
+	return u.username
+}
+
+func (u *user) ExtraAttributes() map[string]interface{} {
+	return nil
+}
+
+type usernameGetter interface {
+	username() string
+}
+
+type provisionRequest struct {
+	Username string `json:"username"`
+}
+
+func (r *provisionRequest) username() string {
+	return r.Username
+}
+
+type deprovisionRequest struct {
+	Username string `json:"username"`
+	Mode     string `json:"mode"`
+}
+
+func (r *deprovisionRequest) username() string {
+	return r.Username
+}
@@ -71,6 +71,12 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/data/download": {
 			"POST": s.downloadEndpoint(),
 		},
+		"/data/archive": {
+			"POST": s.archiveEndpoint(),
+		},
+		"/data/extract": {
+			"POST": s.extractEndpoint(),
+		},
 	}
 }
 
@@ -99,3 +105,29 @@ func (s *service) downloadEndpoint() http.HandlerFunc {
 		return
 	}
 }
+
+func (s *service) archiveEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) extractEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
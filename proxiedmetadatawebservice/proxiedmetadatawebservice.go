@@ -80,6 +80,24 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/meta/makefolder": {
 			"POST": s.makeFolderEndpoint(),
 		},
+		"/meta/changes": {
+			"POST": s.changesEndpoint(),
+		},
+		"/meta/examine-by-id": {
+			"POST": s.examineByIDEndpoint(),
+		},
+		"/meta/batch": {
+			"POST": s.batchEndpoint(),
+		},
+		"/meta/search": {
+			"POST": s.searchEndpoint(),
+		},
+		"/meta/searchcontent": {
+			"POST": s.searchContentEndpoint(),
+		},
+		"/meta/setmodtime": {
+			"POST": s.setModTimeEndpoint(),
+		},
 	}
 }
 
@@ -147,3 +165,81 @@ func (s *service) makeFolderEndpoint() http.HandlerFunc {
 		return
 	}
 }
+
+func (s *service) changesEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) examineByIDEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) batchEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) searchEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) setModTimeEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) searchContentEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
@@ -83,6 +83,23 @@ func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
 			"PROPFIND":  s.propfindEndpoint(),
 			"DELETE":    s.deleteEndpoint(),
 			"MOVE":      s.moveEndpoint(),
+			"REPORT":    s.reportEndpoint(),
+			"SEARCH":    s.searchEndpoint(),
+		},
+		"/ocwebdav/remote.php/dav/": {
+			"SEARCH": s.searchEndpoint(),
+		},
+		"/ocwebdav/remote.php/dav/meta/{id}": {
+			"PROPFIND": s.metaPropfindEndpoint(),
+		},
+		"/ocwebdav/f/{id}": {
+			"GET": s.fileIDEndpoint(),
+		},
+		"/ocwebdav/remote.php/dav/bulk": {
+			"POST": s.bulkUploadEndpoint(),
+		},
+		"/ocwebdav/index.php/apps/files/ajax/download.php": {
+			"GET": s.downloadSelectionEndpoint(),
 		},
 	}
 }
@@ -246,3 +263,81 @@ func (s *service) moveEndpoint() http.HandlerFunc {
 		return
 	}
 }
+
+func (s *service) reportEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) searchEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) metaPropfindEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) fileIDEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) downloadSelectionEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+func (s *service) bulkUploadEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy, err := s.getProxy(r.Context())
+		if err != nil {
+			s.logger.Crit().Log("error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+}
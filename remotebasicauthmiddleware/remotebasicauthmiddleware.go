@@ -12,6 +12,8 @@ type middleware struct {
 	tokenDriver                    lib.TokenDriver
 }
 
+// New returns a BasicAuthMiddleware that authenticates against a remote authentication web service.
+// Users are not provisioned here, the remote services provision them when they authenticate the token.
 func New(cm lib.ContextManager, authenticationWebServiceClient lib.AuthenticationWebServiceClient, tokenDriver lib.TokenDriver, cookieName string) lib.BasicAuthMiddleware {
 	return &middleware{cm: cm, authenticationWebServiceClient: authenticationWebServiceClient, tokenDriver: tokenDriver, cookieName: cookieName}
 }
@@ -1,6 +1,7 @@
 package remoteocwebservice
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"encoding/xml"
@@ -9,6 +10,7 @@ import (
 	"github.com/go-kit/kit/log/levels"
 	"github.com/gorilla/mux"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -18,6 +20,9 @@ import (
 	"time"
 )
 
+// propfindPageSize is the number of children read from the metadata web service at once while answering PROPFIND.
+const propfindPageSize = 1000
+
 type service struct {
 	cm                       lib.ContextManager
 	logger                   levels.Levels
@@ -375,9 +380,160 @@ func (s *service) mkcolEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// proppatchEndpoint applies d:getlastmodified, and d:lastmodified used by the ownCloud clients,
+// through the metadata web service. The rest of properties are accepted but not stored,
+// as some clients fail when they can not set them.
+// Like RFC 4918 requires, either all the properties are applied or none.
 func (s *service) proppatchEndpoint(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	path := mux.Vars(r)["path"]
+
+	update := &propertyupdateXML{}
+	if err := xml.NewDecoder(r.Body).Decode(update); err != nil {
+		if err == io.EOF {
+			// nothing to update.
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		logger.Warn().Log("error", err, "msg", "invalid proppatch body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.metaDataWebServiceClient.Examine(r.Context(), user, path); err != nil {
+		s.handleProppatchEndpointError(err, w, r)
+		return
+	}
+
+	var modTime int64
+	var applied, conflicts, forbidden []propertyXML
+	for _, set := range update.Set {
+		for _, prop := range set.Prop.Props {
+			if !isModTimeProperty(prop.XMLName) {
+				applied = append(applied, propertyXML{XMLName: prop.XMLName})
+				continue
+			}
+			t, err := parsePropertyModTime(prop.Value)
+			if err != nil {
+				logger.Warn().Log("error", err, "msg", "invalid modification time", "value", prop.Value)
+				conflicts = append(conflicts, propertyXML{XMLName: prop.XMLName})
+				continue
+			}
+			modTime = t
+			applied = append(applied, propertyXML{XMLName: prop.XMLName})
+		}
+	}
+	for _, remove := range update.Remove {
+		for _, prop := range remove.Prop.Props {
+			if isModTimeProperty(prop.XMLName) {
+				forbidden = append(forbidden, propertyXML{XMLName: prop.XMLName})
+				continue
+			}
+			applied = append(applied, propertyXML{XMLName: prop.XMLName})
+		}
+	}
+
+	if len(conflicts) == 0 && len(forbidden) == 0 && modTime != 0 {
+		err := s.metaDataWebServiceClient.SetModTime(r.Context(), user, path, modTime)
+		if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeNotSupported {
+			// the modification time properties are forbidden and the rest fail because of them.
+			var failed []propertyXML
+			for _, prop := range applied {
+				if isModTimeProperty(prop.XMLName) {
+					forbidden = append(forbidden, prop)
+				} else {
+					failed = append(failed, prop)
+				}
+			}
+			applied = failed
+		} else if err != nil {
+			s.handleProppatchEndpointError(err, w, r)
+			return
+		} else {
+			logger.Info().Log("msg", "modification time set", "path", path, "mtime", modTime)
+		}
+	}
+
+	response := &responseXML{Href: r.URL.Path}
+	appliedStatus := "HTTP/1.1 200 OK"
+	if len(conflicts) > 0 || len(forbidden) > 0 {
+		appliedStatus = "HTTP/1.1 424 Failed Dependency"
+	}
+	for _, propstat := range []propstatXML{
+		{Prop: applied, Status: appliedStatus},
+		{Prop: forbidden, Status: "HTTP/1.1 403 Forbidden"},
+		{Prop: conflicts, Status: "HTTP/1.1 409 Conflict"},
+	} {
+		if len(propstat.Prop) > 0 {
+			response.Propstat = append(response.Propstat, propstat)
+		}
+	}
+	responseXML, err := xml.Marshal(response)
+	if err != nil {
+		s.handleProppatchEndpointError(err, w, r)
+		return
+	}
+
+	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+	msg += string(responseXML) + `</d:multistatus>`
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	w.Write([]byte(msg))
+}
+
+// isModTimeProperty tells if name is one of the properties used by clients to set the modification time.
+func isModTimeProperty(name xml.Name) bool {
+	return name.Space == "DAV:" && (name.Local == "getlastmodified" || name.Local == "lastmodified")
+}
+
+// parsePropertyModTime parses a modification time given as an HTTP date or,
+// like the ownCloud clients do, as seconds since the epoch.
+func parsePropertyModTime(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if modTime, err := parseOCMTime(value); err == nil {
+		return modTime, nil
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
+// parseOCMTime parses the X-OC-MTime header, the modification time of the file in seconds since the epoch.
+// Like the ownCloud server, the fractional part is ignored. An empty header returns zero.
+func parseOCMTime(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		return 0, err
+	}
+	if seconds <= 0 || seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, fmt.Errorf("modification time %q out of range", header)
+	}
+	return int64(seconds) * int64(time.Second), nil
 }
+
+func (s *service) handleProppatchEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
 func (s *service) moveEndpoint(w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
@@ -494,6 +650,13 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	clientModTime, err := parseOCMTime(r.Header.Get("X-OC-MTime"))
+	if err != nil {
+		logger.Warn().Log("error", err, "msg", "invalid X-OC-MTime header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	fileInfo, err := s.metaDataWebServiceClient.Examine(r.Context(), user, path)
 	// if err is not found it is okay to continue
 	if err != nil {
@@ -548,7 +711,7 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
-	if err := s.dataWebServiceClient.UploadFile(r.Context(), user, path, readCloser, ""); err != nil {
+	if err := s.dataWebServiceClient.UploadFile(r.Context(), user, path, readCloser, "", clientModTime); err != nil {
 		s.handlePutEndpointError(err, w, r)
 		return
 	}
@@ -592,7 +755,9 @@ func (s *service) putEndpoint(w http.ResponseWriter, r *http.Request) {
 	t := time.Unix(newInfo.Modified()/1000000000, newInfo.Modified()%1000000000)
 	lastModifiedString := t.Format(time.RFC1123)
 	w.Header().Set("Last-Modified", lastModifiedString)
-	w.Header().Set("X-OC-MTime", "accepted")
+	if clientModTime != 0 {
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
 
 	// if object did not exist, http code is 201, else 204.
 	if fileInfo == nil {
@@ -620,8 +785,16 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the modification time is applied when the last chunk completes the file.
+	clientModTime, err := parseOCMTime(r.Header.Get("X-OC-MTime"))
+	if err != nil {
+		logger.Warn().Log("error", err, "msg", "invalid X-OC-MTime header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	readCloser := http.MaxBytesReader(w, r.Body, s.uploadMaxFileSize)
-	err = s.dataWebServiceClient.UploadFile(r.Context(), user, path, readCloser, "")
+	err = s.dataWebServiceClient.UploadFile(r.Context(), user, path, readCloser, "", clientModTime)
 	if err != nil {
 		s.handlePutChunkedEndpointError(err, w, r)
 		return
@@ -666,7 +839,9 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 	t := time.Unix(fileInfo.Modified()/1000000000, fileInfo.Modified()%1000000000)
 	lastModifiedString := t.Format(time.RFC1123)
 	w.Header().Set("Last-Modified", lastModifiedString)
-	w.Header().Set("X-OC-MTime", "accepted")
+	if clientModTime != 0 {
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
 
 	// if object did not exist, http code is 201, else 204.
 	if fileInfo == nil {
@@ -675,7 +850,13 @@ func (s *service) putChunkedEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// propfindEndpoint streams the multistatus response, the children of folders are read from the
+// metadata web service a page at a time and every response is written as soon as it is encoded.
+// Errors after the first response can not change the status code, so the document is left unfinished
+// for the client to detect the failure.
 func (s *service) propfindEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
 	user := s.cm.MustGetUser(r.Context())
 	path := mux.Vars(r)["path"]
 
@@ -686,33 +867,72 @@ func (s *service) propfindEndpoint(w http.ResponseWriter, r *http.Request) {
 		children = true
 	}
 
-	var fileInfos []lib.FileInfo
 	fileInfo, err := s.metaDataWebServiceClient.Examine(r.Context(), user, path)
 	if err != nil {
 		s.handlePropfindEndpointError(err, w, r)
 		return
 	}
-	fileInfos = append(fileInfos, fileInfo)
+	root, err := s.fileInfoToPropResponse(r.Context(), fileInfo)
+	if err != nil {
+		s.handlePropfindEndpointError(err, w, r)
+		return
+	}
 
+	w.Header().Set("DAV", "1, 3, extended-mkcol")
+	mw := newMultistatusWriter(w)
+
+	// the root is written with the first page, so errors reading it still get their status code.
+	var numChildren int
 	if children && fileInfo.Folder() {
-		childrenInfos, err := s.metaDataWebServiceClient.ListFolder(r.Context(), user, path)
-		if err != nil {
-			s.handlePropfindEndpointError(err, w, r)
+		query := lib.ListQuery{Limit: propfindPageSize}
+		for {
+			page, err := s.metaDataWebServiceClient.ListFolderPage(r.Context(), user, path, query)
+			if err != nil {
+				if !mw.started {
+					s.handlePropfindEndpointError(err, w, r)
+					return
+				}
+				logger.Error().Log("error", err, "msg", "propfind response aborted", "numchildren", numChildren)
+				return
+			}
+			if !mw.started {
+				if err := mw.writeResponse(root); err != nil {
+					logger.Error().Log("error", err, "msg", "error writing multistatus response")
+					return
+				}
+			}
+			for _, fi := range page.FileInfos() {
+				res, err := s.fileInfoToPropResponse(r.Context(), fi)
+				if err == nil {
+					err = mw.writeResponse(res)
+				}
+				if err != nil {
+					logger.Error().Log("error", err, "msg", "propfind response aborted", "numchildren", numChildren)
+					return
+				}
+				numChildren++
+			}
+			if err := mw.flush(); err != nil {
+				logger.Error().Log("error", err, "msg", "propfind response aborted", "numchildren", numChildren)
+				return
+			}
+			if page.ContinuationToken() == "" {
+				break
+			}
+			query.ContinuationToken = page.ContinuationToken()
+		}
+	}
+	if !mw.started {
+		if err := mw.writeResponse(root); err != nil {
+			logger.Error().Log("error", err, "msg", "error writing multistatus response")
 			return
 		}
-		fileInfos = append(fileInfos, childrenInfos...)
 	}
-
-	fileInfosInXML, err := s.fileInfosToXML(r.Context(), fileInfos)
-	if err != nil {
-		s.handlePropfindEndpointError(err, w, r)
+	if err := mw.close(); err != nil {
+		logger.Error().Log("error", err, "msg", "error writing multistatus response")
 		return
 	}
-
-	w.Header().Set("DAV", "1, 3, extended-mkcol")
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	w.WriteHeader(207)
-	w.Write([]byte(fileInfosInXML))
+	logger.Info().Log("msg", "propfind done", "numchildren", numChildren)
 }
 
 func (s *service) isChunkedUpload(path string) (bool, error) {
@@ -895,6 +1115,12 @@ func (s *service) handleDeleteEndpointError(err error, w http.ResponseWriter, r
 
 func (s *service) handleMkcolEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeInvalidName || codeErr.Code() == lib.CodeBadInputData {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	logger.Error().Log("error", "unexpected error creating folder")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
@@ -902,6 +1128,12 @@ func (s *service) handleMkcolEndpointError(err error, w http.ResponseWriter, r *
 
 func (s *service) handleMoveEndpointError(err error, w http.ResponseWriter, r *http.Request) {
 	logger := s.cm.MustGetLog(r.Context())
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeInvalidName || codeErr.Code() == lib.CodeBadInputData {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	logger.Error().Log("error", "unexpected error moving file")
 	w.WriteHeader(http.StatusInternalServerError)
 	return
@@ -952,6 +1184,14 @@ func (s *service) handlePutEndpointError(err error, w http.ResponseWriter, r *ht
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		if codeErr.Code() == lib.CodeInvalidName || codeErr.Code() == lib.CodeBadInputData {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if codeErr.Code() == lib.CodeTooBig {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
 	}
 
 	logger.Error().Log("unexpected error puting file")
@@ -987,24 +1227,50 @@ func (s *service) handlePropfindEndpointError(err error, w http.ResponseWriter,
 	return
 }
 
-func (s *service) fileInfosToXML(ctx context.Context, fileInfos []lib.FileInfo) (string, error) {
-	responses := []*responseXML{}
-	for _, fileInfo := range fileInfos {
-		res, err := s.fileInfoToPropResponse(ctx, fileInfo)
-		if err != nil {
-			return "", err
+// multistatusWriter streams a multistatus document, responses are sent as they are encoded.
+// Nothing is written until the first response, so errors before it can still be returned with their status code.
+type multistatusWriter struct {
+	w       http.ResponseWriter
+	bw      *bufio.Writer
+	encoder *xml.Encoder
+	started bool
+}
+
+func newMultistatusWriter(w http.ResponseWriter) *multistatusWriter {
+	bw := bufio.NewWriter(w)
+	return &multistatusWriter{w: w, bw: bw, encoder: xml.NewEncoder(bw)}
+}
+
+func (m *multistatusWriter) writeResponse(res *responseXML) error {
+	if !m.started {
+		m.started = true
+		m.w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		m.w.WriteHeader(207)
+		msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
+		msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
+		if _, err := m.bw.WriteString(msg); err != nil {
+			return err
 		}
-		responses = append(responses, res)
 	}
-	responsesXML, err := xml.Marshal(&responses)
-	if err != nil {
-		return "", err
+	return m.encoder.Encode(res)
+}
+
+// flush sends to the client what has been encoded so far.
+func (m *multistatusWriter) flush() error {
+	if err := m.bw.Flush(); err != nil {
+		return err
 	}
+	if flusher, ok := m.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
 
-	msg := `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" `
-	msg += `xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns">`
-	msg += string(responsesXML) + `</d:multistatus>`
-	return msg, nil
+func (m *multistatusWriter) close() error {
+	if _, err := m.bw.WriteString(`</d:multistatus>`); err != nil {
+		return err
+	}
+	return m.flush()
 }
 
 func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileInfo) (*responseXML, error) {
@@ -1091,8 +1357,13 @@ func (s *service) fileInfoToPropResponse(ctx context.Context, fileInfo lib.FileI
 	ocDC := propertyXML{xml.Name{Space: "", Local: "oc:dDC"},
 		"", []byte("")}
 
+	// oc:size is the recursive size of folders when the metadata driver keeps it,
+	// the ownCloud clients use it to show the space taken by a folder.
+	ocSize := propertyXML{xml.Name{Space: "", Local: "oc:size"},
+		"", []byte(fmt.Sprintf("%d", fileInfo.Size()))}
+
 	propList = append(propList, getResourceType, getContentLegnth, getContentType, getLastModified, // general WebDAV properties
-		getETag, quotaAvailableBytes, quotaUsedBytes, ocID, ocDownloadURL, ocDC) // properties needed by ownCloud
+		getETag, quotaAvailableBytes, quotaUsedBytes, ocID, ocDownloadURL, ocDC, ocSize) // properties needed by ownCloud
 
 	// PropStat, only HTTP/1.1 200 is sent.
 	propStatList := []propstatXML{}
@@ -1157,6 +1428,22 @@ type propertyXML struct {
 	InnerXML []byte `xml:",innerxml"`
 }
 
+// http://www.webdav.org/specs/rfc4918.html#ELEMENT_propertyupdate
+type propertyupdateXML struct {
+	XMLName xml.Name
+	Set     []propertyupdateActionXML `xml:"DAV: set"`
+	Remove  []propertyupdateActionXML `xml:"DAV: remove"`
+}
+
+type propertyupdateActionXML struct {
+	Prop struct {
+		Props []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
 // http://www.ocwebdav.org/specs/rfc4918.html#ELEMENT_error
 type errorXML struct {
 	XMLName  xml.Name `xml:"d:error"`
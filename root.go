@@ -36,11 +36,206 @@ const (
 	CodeUploadIsPartial
 	// CodeForbidden is used when something is forbidden, like uploading to lib
 	CodeForbidden
+	// CodeNotSupported is returned when the operation is not supported by the underlying driver.
+	CodeNotSupported
+	// CodeInvalidName is returned when a path is rejected by the path policy, like names
+	// with forbidden characters, reserved names or components that are too long.
+	CodeInvalidName
+)
+
+const (
+	// WARNING: ADD NEW KINDS TO THE END TO NOT BREAK THE API
+
+	// ChangeCreate is recorded when a resource is created.
+	ChangeCreate ChangeKind = iota
+	// ChangeModify is recorded when the content of a resource changes.
+	ChangeModify
+	// ChangeDelete is recorded when a resource is deleted.
+	ChangeDelete
+	// ChangeMove is recorded when a resource is moved, the ID is kept.
+	ChangeMove
+)
+
+const (
+	// Share permissions use the same bits as the ownCloud OCS sharing API.
+
+	// PermissionRead allows to list and download.
+	PermissionRead SharePermission = 1 << iota
+	// PermissionUpdate allows to overwrite existing files.
+	PermissionUpdate
+	// PermissionCreate allows to upload new files and create folders.
+	PermissionCreate
+	// PermissionDelete allows to delete.
+	PermissionDelete
+	// PermissionShare allows to reshare.
+	PermissionShare
+)
+
+const (
+	// WARNING: ADD NEW KINDS TO THE END TO NOT BREAK THE API
+
+	// BatchExamine examines Path.
+	BatchExamine BatchOperationKind = iota
+	// BatchListFolder lists the folder at Path.
+	BatchListFolder
+	// BatchMove moves Source to Target.
+	BatchMove
+	// BatchDelete deletes Path.
+	BatchDelete
+	// BatchCreateFolder creates the folder at Path.
+	BatchCreateFolder
+)
+
+const (
+	// WARNING: ADD NEW KEYS TO THE END TO NOT BREAK THE API
+
+	// ListSortByName sorts by name, comparing the bytes of the names.
+	ListSortByName ListSortKey = iota
+	// ListSortBySize sorts by size and then by name.
+	ListSortBySize
+	// ListSortByModified sorts by modification time and then by name.
+	ListSortByModified
+)
+
+const (
+	// ArchiveFormatZIP is a ZIP archive, Zip64 is used when the archive needs it.
+	ArchiveFormatZIP ArchiveFormat = iota
+	// ArchiveFormatTarGz is a TAR archive compressed with gzip.
+	ArchiveFormatTarGz
+)
+
+const (
+	// Share recipient types use the same values as the ownCloud OCS sharing API.
+
+	// ShareRecipientUser is used when the share is for a single user.
+	ShareRecipientUser ShareRecipientType = 0
+	// ShareRecipientGroup is used when the share is for all the members of a group.
+	ShareRecipientGroup ShareRecipientType = 1
+)
+
+const (
+	// Space roles are ordered, every role can do what the previous ones can.
+
+	// SpaceRoleViewer allows to list and download.
+	SpaceRoleViewer SpaceRole = iota + 1
+	// SpaceRoleEditor allows to upload, create, move and delete resources.
+	SpaceRoleEditor
+	// SpaceRoleManager allows to add and remove members and to change their roles.
+	SpaceRoleManager
+)
+
+const (
+	// DeprovisionArchive keeps an archive of the data of the user before removing it.
+	DeprovisionArchive DeprovisionMode = iota
+	// DeprovisionDelete removes the data of the user without keeping a copy.
+	DeprovisionDelete
+)
+
+const (
+	// Capabilities name the optional interfaces of the metadata drivers.
+
+	CapabilityFileIDResolver Capability = iota
+	CapabilitySearcher
+	CapabilityContentSearcher
+	CapabilityFolderPager
+	CapabilityFolderWalker
+	CapabilityChangeFeed
+	CapabilityModTimeSetter
+	CapabilityFileIDSetter
 )
 
 type (
 	Code uint32
 
+	ChangeKind uint32
+
+	SharePermission uint32
+
+	ShareRecipientType uint32
+
+	SpaceRole uint32
+
+	DeprovisionMode uint32
+
+	ArchiveFormat uint32
+
+	BatchOperationKind uint32
+
+	ListSortKey uint32
+
+	Capability uint32
+
+	// ListQuery describes a page of the entries of a folder.
+	ListQuery struct {
+		SortBy     ListSortKey `json:"sort_by"`
+		Descending bool        `json:"descending"`
+		// FilesOnly and FoldersOnly can not be both set.
+		FilesOnly   bool `json:"files_only"`
+		FoldersOnly bool `json:"folders_only"`
+		// NamePrefix is matched against the names of the entries, case is not ignored.
+		NamePrefix string `json:"name_prefix"`
+		// Limit is the maximum number of entries of the page, zero means no limit.
+		Limit int `json:"limit"`
+		// ContinuationToken is the token of the previous page, empty for the first page.
+		// Entries created or deleted while paging may be missed or returned twice.
+		ContinuationToken string `json:"continuation_token"`
+	}
+
+	// ListKey is the position of an entry of a folder in the order of a ListQuery.
+	ListKey struct {
+		Name     string
+		Size     int64
+		Modified int64
+	}
+
+	// SearchQuery describes the resources to find, empty fields do not filter.
+	SearchQuery struct {
+		// Scope is the folder to search in, the whole namespace of the user when empty. The scope itself is not returned.
+		Scope string `json:"scope"`
+		// Name is a glob pattern matched against the names of the resources, or a substring when
+		// it has no pattern characters. Case is ignored.
+		Name string `json:"name"`
+		// MimeType is matched against the type guessed from the extension of files,
+		// a type ending with a slash like image/ matches all its subtypes. Folders never match it.
+		MimeType string `json:"mime_type"`
+		// MinSize and MaxSize are inclusive limits in bytes, a MaxSize of zero means no limit.
+		MinSize int64 `json:"min_size"`
+		MaxSize int64 `json:"max_size"`
+		// ModifiedAfter and ModifiedBefore are exclusive limits in nanoseconds since the epoch.
+		ModifiedAfter  int64 `json:"modified_after"`
+		ModifiedBefore int64 `json:"modified_before"`
+		// Offset and Limit page the results, which are ordered by path. A Limit of zero means no limit.
+		Offset int `json:"offset"`
+		Limit  int `json:"limit"`
+	}
+
+	// ContentQuery describes the files to find by the text of their contents.
+	ContentQuery struct {
+		// Text is split in words and a file matches when its contents have all of them. Case is ignored.
+		Text string `json:"text"`
+		// Scope is the folder to search in, the whole namespace of the user when empty.
+		Scope string `json:"scope"`
+		// Offset and Limit page the results, which are ordered by relevance. A Limit of zero means no limit.
+		Offset int `json:"offset"`
+		Limit  int `json:"limit"`
+	}
+
+	// BatchOperation is a metadata operation executed as part of a batch.
+	BatchOperation struct {
+		Kind   BatchOperationKind `json:"kind"`
+		Path   string             `json:"path,omitempty"`
+		Source string             `json:"source,omitempty"`
+		Target string             `json:"target,omitempty"`
+	}
+
+	// Mount attaches the namespace of a pair of drivers to the folder Prefix of a mount table.
+	// The drivers see the paths relative to Prefix, the mounted folder is their root.
+	Mount struct {
+		Prefix         string
+		DataDriver     DataDriver
+		MetaDataDriver MetaDataDriver
+	}
+
 	Error interface {
 		error
 		Code() Code
@@ -64,7 +259,9 @@ type (
 	}
 
 	DataDriver interface {
-		UploadFile(ctx context.Context, user User, path string, r io.ReadCloser, clientChecksum string) error
+		// clientModTime is the modification time given by the client in nanoseconds since the epoch,
+		// zero means the time of the upload.
+		UploadFile(ctx context.Context, user User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error
 		DownloadFile(ctx context.Context, user User, path string) (io.ReadCloser, error)
 	}
 
@@ -76,6 +273,301 @@ type (
 		CreateFolder(ctx context.Context, user User, path string) error
 	}
 
+	// Storage is implemented by drivers that provide both the data and the metadata of a namespace.
+	Storage interface {
+		DataDriver
+		MetaDataDriver
+	}
+
+	MetaDataSyncer interface {
+		SyncPath(ctx context.Context, user User, path string) error
+		SyncMove(ctx context.Context, user User, sourcePath, targetPath string) error
+		SyncTree(ctx context.Context, user User, path string) error
+	}
+
+	FSWatcher interface {
+		Start() error
+		Stop() error
+	}
+
+	Change interface {
+		Kind() ChangeKind
+		ID() string
+		Path() string
+		SourcePath() string
+		Timestamp() int64
+	}
+
+	ChangeSet interface {
+		Changes() []Change
+		Cursor() string
+		HasMore() bool
+		Reset() bool
+	}
+
+	// FileIDResolver is implemented by metadata drivers that can find a resource
+	// by the ID exposed in its "id" extra attribute, which is kept across moves.
+	FileIDResolver interface {
+		ExamineByID(ctx context.Context, user User, id string) (FileInfo, error)
+	}
+
+	// Searcher is implemented by metadata drivers that can find resources without listing every folder.
+	Searcher interface {
+		Search(ctx context.Context, user User, query SearchQuery) (SearchResult, error)
+	}
+
+	SearchResult interface {
+		FileInfos() []FileInfo
+		// HasMore is true when there are more results after this page.
+		HasMore() bool
+	}
+
+	// ContentSearcher is implemented by metadata drivers that can find files by the text of their contents.
+	ContentSearcher interface {
+		SearchContent(ctx context.Context, user User, query ContentQuery) (ContentSearchResult, error)
+	}
+
+	ContentSearchResult interface {
+		Matches() []ContentMatch
+		// HasMore is true when there are more results after this page.
+		HasMore() bool
+	}
+
+	ContentMatch interface {
+		Path() string
+		// Score is the relevance of the file for the query, higher is better.
+		Score() float64
+		// Snippet is a fragment of the text of the file around the first occurrence of a word of the query.
+		Snippet() string
+	}
+
+	// ContentIndex keeps the text extracted from the contents of the files of the users.
+	// Changes are applied in the background in the order they are received,
+	// so they take a while to show up in the results.
+	ContentIndex interface {
+		ContentSearcher
+		// Index extracts the text of the file at path and replaces its entry,
+		// files without a supported text format are removed from the index.
+		Index(ctx context.Context, user User, path string) error
+		// Remove removes the entries of the resource at path and of everything below it.
+		Remove(ctx context.Context, user User, path string) error
+		// Move rewrites the paths of the entries of the resource at sourcePath and of everything below it.
+		Move(ctx context.Context, user User, sourcePath, targetPath string) error
+	}
+
+	// FolderPager is implemented by metadata drivers that can list a folder a page at a time
+	// without loading all its entries in memory.
+	FolderPager interface {
+		ListFolderPage(ctx context.Context, user User, path string, query ListQuery) (FolderPage, error)
+	}
+
+	// FolderWalker is implemented by metadata drivers that can yield the entries of a folder
+	// as they are read, in no particular order, without loading all of them in memory.
+	FolderWalker interface {
+		// WalkFolder calls fn with each batch of entries, an error returned by fn stops the walk and is returned.
+		WalkFolder(ctx context.Context, user User, path string, fn func(fileInfos []FileInfo) error) error
+	}
+
+	FolderPage interface {
+		FileInfos() []FileInfo
+		// ContinuationToken returns the token for the next page, empty on the last page.
+		ContinuationToken() string
+	}
+
+	ChangeFeed interface {
+		GetChanges(ctx context.Context, user User, cursor string, limit int) (ChangeSet, error)
+	}
+
+	// ModTimeSetter is implemented by metadata drivers that can change the modification time
+	// of a resource, modTime is in nanoseconds since the epoch.
+	ModTimeSetter interface {
+		SetModTime(ctx context.Context, user User, path string, modTime int64) error
+	}
+
+	// FileIDSetter is implemented by metadata drivers that can assign the ID of a resource,
+	// so IDs survive moving the data of a user to another storage.
+	// An error with CodeAlreadyExist is returned when another resource has the ID.
+	FileIDSetter interface {
+		SetFileID(ctx context.Context, user User, path, id string) error
+	}
+
+	// CapabilityReporter is implemented by decorators, which implement every optional interface
+	// and return an error with CodeNotSupported when the driver they wrap does not support it.
+	// Supports tells if the optional interface named by capability is really supported.
+	CapabilityReporter interface {
+		Supports(capability Capability) bool
+	}
+
+	// RecursiveSizer is implemented by metadata drivers that can report the size of a folder
+	// as the size of all its descendants, which space quotas are checked against.
+	// RecursiveSizes returns false when the driver is configured not to compute them.
+	RecursiveSizer interface {
+		RecursiveSizes() bool
+	}
+
+	// QuotaReporter is implemented by data drivers that limit the number of bytes that can be stored,
+	// so the space needed by a batch of uploads can be checked before any of them starts.
+	QuotaReporter interface {
+		// AvailableBytes returns the number of bytes that can still be uploaded to path, -1 means no limit.
+		AvailableBytes(ctx context.Context, user User, path string) (int64, error)
+	}
+
+	// PathPolicy normalizes and validates the paths sent by clients before they reach the drivers.
+	// Normalize returns the canonical form of the path, Validate returns an error with
+	// CodeInvalidName when a normalized path can not be used to create a resource.
+	PathPolicy interface {
+		Normalize(path string) string
+		Validate(path string) error
+	}
+
+	Notification interface {
+		Change
+		Username() string
+	}
+
+	NotificationBroker interface {
+		Publish(ctx context.Context, notification Notification) error
+		// Subscribe returns the notifications for the user until the returned func is called.
+		Subscribe(user User) (<-chan Notification, func())
+	}
+
+	LinkShare interface {
+		ID() string
+		Token() string
+		Owner() User
+		Path() string
+		Permissions() SharePermission
+		HasPassword() bool
+		// Expiration is the unix time after which the link is not valid, 0 means never.
+		Expiration() int64
+		Created() int64
+	}
+
+	ShareDriver interface {
+		CreateLinkShare(ctx context.Context, user User, path string, permissions SharePermission, password string, expiration int64) (LinkShare, error)
+		GetLinkShare(ctx context.Context, user User, id string) (LinkShare, error)
+		ListLinkShares(ctx context.Context, user User, path string) ([]LinkShare, error)
+		SetLinkSharePermissions(ctx context.Context, user User, id string, permissions SharePermission) error
+		SetLinkSharePassword(ctx context.Context, user User, id, password string) error
+		SetLinkShareExpiration(ctx context.Context, user User, id string, expiration int64) error
+		RevokeLinkShare(ctx context.Context, user User, id string) error
+		// ResolveLinkShare returns the share for the token, if it has not expired
+		// and the password matches.
+		ResolveLinkShare(ctx context.Context, token, password string) (LinkShare, error)
+
+		CreateUserShare(ctx context.Context, user User, path, recipient string, recipientType ShareRecipientType, permissions SharePermission) (UserShare, error)
+		GetUserShare(ctx context.Context, user User, id string) (UserShare, error)
+		// ListUserShares returns the shares created by the user, only the ones for path if path is not empty.
+		ListUserShares(ctx context.Context, user User, path string) ([]UserShare, error)
+		// ListReceivedShares returns the shares with the user or with any of the groups of the user.
+		ListReceivedShares(ctx context.Context, user User) ([]UserShare, error)
+		SetUserSharePermissions(ctx context.Context, user User, id string, permissions SharePermission) error
+		RevokeUserShare(ctx context.Context, user User, id string) error
+	}
+
+	UserShare interface {
+		ID() string
+		Owner() User
+		Path() string
+		// Recipient is the username or the group name depending on the recipient type.
+		Recipient() string
+		RecipientType() ShareRecipientType
+		Permissions() SharePermission
+		Created() int64
+	}
+
+	// Space is a storage root not tied to a user, shared by its members.
+	Space interface {
+		ID() string
+		Name() string
+		// Quota is the maximum number of bytes stored in the space, 0 means no limit.
+		Quota() int64
+		// Members maps the usernames of the members to their roles.
+		Members() map[string]SpaceRole
+		// StorageUser is the user the resources of the space are stored for in the drivers,
+		// so ETags propagate inside the space independently of the homes of the members.
+		StorageUser() User
+		Created() int64
+	}
+
+	// SpaceDriver keeps the spaces and their members. Administrators create and delete spaces
+	// and set their quotas, managers and administrators change the members.
+	SpaceDriver interface {
+		// CreateSpace creates a space with the user manager as its first manager.
+		CreateSpace(ctx context.Context, user User, name string, quota int64, manager string) (Space, error)
+		// GetSpace returns the space if the user is a member or an administrator.
+		GetSpace(ctx context.Context, user User, id string) (Space, error)
+		// ListSpaces returns the spaces the user is a member of, or every space if all is set,
+		// which is only allowed to administrators.
+		ListSpaces(ctx context.Context, user User, all bool) ([]Space, error)
+		SetSpaceQuota(ctx context.Context, user User, id string, quota int64) error
+		// SetSpaceMember adds the member to the space or changes its role.
+		SetSpaceMember(ctx context.Context, user User, id, member string, role SpaceRole) error
+		// RemoveSpaceMember removes the member from the space, members can always leave a space.
+		RemoveSpaceMember(ctx context.Context, user User, id, member string) error
+		DeleteSpace(ctx context.Context, user User, id string) error
+	}
+
+	// Initializer is implemented by drivers that must prepare the namespace of a user,
+	// like creating its home folder, before it can be used.
+	Initializer interface {
+		Init(ctx context.Context, user User) error
+	}
+
+	// Provisioner manages the lifecycle of the namespaces of the users.
+	Provisioner interface {
+		// Provision prepares the namespace of the user the first time it is seen,
+		// it must be cheap to call on every authenticated request.
+		Provision(ctx context.Context, user User) error
+		// Deprovision removes the data of the user, archiving it first if the mode asks for it.
+		Deprovision(ctx context.Context, user User, mode DeprovisionMode) error
+	}
+
+	// Archiver builds archives of resources on the fly.
+	Archiver interface {
+		// Archive writes to w an archive with the resources at paths, folders are added with all their contents.
+		// Resources are added under their base names, so paths do not need to share the same parent.
+		Archive(ctx context.Context, user User, paths []string, format ArchiveFormat, w io.Writer) error
+	}
+
+	// Extractor unpacks archives into the storage of the user.
+	Extractor interface {
+		// Extract validates the whole archive read from r before writing anything and
+		// then extracts it into the folder at path, creating it if needed.
+		// An error is returned when the archive is rejected, failures of single entries are reported in their results.
+		Extract(ctx context.Context, user User, path string, format ArchiveFormat, r io.Reader) ([]ExtractResult, error)
+	}
+
+	// ExtractResult is the outcome of extracting a single entry of an archive.
+	ExtractResult interface {
+		Path() string
+		Folder() bool
+		Size() int64
+		// Err returns why the entry was not extracted, nil if it was.
+		Err() error
+	}
+
+	// UserExporter moves all the data of a user between storages.
+	UserExporter interface {
+		// Export writes to w an archive with the resources of the user and their metadata.
+		Export(ctx context.Context, user User, w io.Writer) error
+		// Import restores an archive written by Export into the namespace of the user.
+		// An error is returned when the archive is rejected, failures of single resources are reported in their results.
+		Import(ctx context.Context, user User, r io.Reader) ([]ImportResult, error)
+	}
+
+	// ImportResult is the outcome of importing a single resource.
+	ImportResult interface {
+		Path() string
+		Folder() bool
+		// ID returns the ID the resource has after the import, empty when the driver does not expose IDs.
+		ID() string
+		// IDPreserved returns true when the resource kept the ID it had when it was exported.
+		IDPreserved() bool
+		// Err returns why the resource was not imported, nil if it was.
+		Err() error
+	}
+
 	UserDriver interface {
 		GetByCredentials(username, password string) (User, error)
 	}
@@ -149,7 +641,7 @@ type (
 	}
 
 	DataWebServiceClient interface {
-		UploadFile(ctx context.Context, user User, path string, r io.ReadCloser, clientChecksum string) error
+		UploadFile(ctx context.Context, user User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error
 		DownloadFile(ctx context.Context, user User, path string) (io.ReadCloser, error)
 	}
 
@@ -159,6 +651,27 @@ type (
 		Delete(ctx context.Context, user User, path string) error
 		ListFolder(ctx context.Context, user User, path string) ([]FileInfo, error)
 		CreateFolder(ctx context.Context, user User, path string) error
+		GetChanges(ctx context.Context, user User, cursor string, limit int) (ChangeSet, error)
+		ExamineByID(ctx context.Context, user User, id string) (FileInfo, error)
+		// Batch executes the operations in order, when stopOnError is true the operations
+		// after the first failure are skipped. There is a result for every operation.
+		Batch(ctx context.Context, user User, operations []BatchOperation, stopOnError bool) ([]BatchResult, error)
+		Search(ctx context.Context, user User, query SearchQuery) (SearchResult, error)
+		ListFolderPage(ctx context.Context, user User, path string, query ListQuery) (FolderPage, error)
+		SearchContent(ctx context.Context, user User, query ContentQuery) (ContentSearchResult, error)
+		SetModTime(ctx context.Context, user User, path string, modTime int64) error
+	}
+
+	// BatchResult is the outcome of an operation of a batch.
+	BatchResult interface {
+		// Err returns why the operation failed, nil if it succeeded or was skipped.
+		Err() Error
+		// Skipped is true for the operations not executed because a previous one failed.
+		Skipped() bool
+		// FileInfo is set for examine operations.
+		FileInfo() FileInfo
+		// FileInfos is set for list operations.
+		FileInfos() []FileInfo
 	}
 
 	MimeGuesser interface {
@@ -208,11 +721,19 @@ type (
 		GetMetaDataDriver() string
 		GetFSMDataDriverDataFolder() string
 		GetFSMDataDriverTemporaryFolder() string
+		GetFSMDataDriverRecursiveSize() bool
 		GetOCFSMDataDriverDataFolder() string
 		GetOCFSMDataDriverTemporaryFolder() string
 		GetOCFSMDataDriverMaxSQLIddle() int
 		GetOCFSMDataDriverMaxSQLConcurrent() int
 		GetOCFSMDataDriverDSN() string
+		GetOCXattrMDataDriverDataFolder() string
+		GetOCXattrMDataDriverTemporaryFolder() string
+
+		GetFSWatcher() string
+		GetInotifyFSWatcherDataFolder() string
+		GetInotifyFSWatcherDebounce() int
+		GetInotifyFSWatcherRescanInterval() int
 
 		GetTokenDriver() string
 		GetJWTTokenDriverKey() string
@@ -223,6 +744,42 @@ type (
 		GetETCDRegistryDriverPassword() string
 		GetETCDRegistryDriverKey() string
 
+		GetNotificationBroker() string
+		GetETCDNotificationBrokerKey() string
+
+		GetShareDriver() string
+		GetSQLShareDriverMaxSQLIddle() int
+		GetSQLShareDriverMaxSQLConcurrent() int
+		GetSQLShareDriverDSN() string
+		GetSharesFolder() string
+
+		GetSpaceDriver() string
+		GetSQLSpaceDriverMaxSQLIddle() int
+		GetSQLSpaceDriverMaxSQLConcurrent() int
+		GetSQLSpaceDriverDSN() string
+		GetSpaceAdministrators() string
+		GetSpacesFolder() string
+
+		GetProvisionerSkeletonFolder() string
+		GetProvisionerArchiveFolder() string
+		GetProvisionerAdministrators() string
+
+		GetUserExportAdministrators() string
+
+		GetArchiveExtractorTemporaryFolder() string
+		GetArchiveExtractorMaxEntries() int
+		GetArchiveExtractorMaxRatio() int
+		GetArchiveExtractorMaxSize() int64
+
+		GetContentIndexFolder() string
+		GetContentIndexMaxFileSize() int64
+		GetContentIndexQueueSize() int
+
+		GetPathPolicyMapForbiddenCharacters() bool
+		GetPathPolicyMaxNameLength() int
+		GetPathPolicyMaxPathLength() int
+		GetPathPolicyBlockedPatterns() string
+
 		GetBasicAuthMiddleware() string
 		GetBasicAuthMiddlewareCookieName() string
 
@@ -239,9 +796,26 @@ type (
 
 		GetMetaDataWebService() string
 
+		GetNotificationWebService() string
+		GetNotificationWebServiceHeartbeat() int
+
 		GetOCWebService() string
 		GetOCWebServiceMaxUploadFileSize() int64
 		GetRemoteOCWebServiceMaxUploadFileSize() int64
+
+		GetOCShareWebService() string
+		GetOCShareWebServiceMaxUploadFileSize() int64
+
+		GetSpaceWebService() string
+
+		GetProvisioningWebService() string
+
+		GetUserExportWebService() string
+		GetUserExportWebServiceMaxImportSize() int64
+
+		GetPreviewWebService() string
+		GetPreviewWebServiceCacheFolder() string
+		GetPreviewWebServiceMaxFileSize() int64
 	}
 
 	ConfigurationSource interface {
@@ -0,0 +1,52 @@
+package searchfilter
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+)
+
+// MatchName returns if the name of a resource matches the Name of the query.
+func MatchName(query lib.SearchQuery, name string) bool {
+	if query.Name == "" {
+		return true
+	}
+	pattern, name := strings.ToLower(query.Name), strings.ToLower(name)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return strings.Contains(name, pattern)
+	}
+	ok, _ := filepath.Match(pattern, name)
+	return ok
+}
+
+// Match returns if the resource matches all the filters of the query but the scope.
+func Match(query lib.SearchQuery, fileInfo lib.FileInfo) bool {
+	if !MatchName(query, filepath.Base(fileInfo.Path())) {
+		return false
+	}
+	if query.MimeType != "" {
+		if fileInfo.Folder() {
+			return false
+		}
+		mimeType := strings.TrimSpace(strings.Split(mime.TypeByExtension(filepath.Ext(fileInfo.Path())), ";")[0])
+		if strings.HasSuffix(query.MimeType, "/") {
+			if !strings.HasPrefix(mimeType, query.MimeType) {
+				return false
+			}
+		} else if mimeType != query.MimeType {
+			return false
+		}
+	}
+	if fileInfo.Size() < query.MinSize || (query.MaxSize > 0 && fileInfo.Size() > query.MaxSize) {
+		return false
+	}
+	if query.ModifiedAfter > 0 && fileInfo.Modified() <= query.ModifiedAfter {
+		return false
+	}
+	if query.ModifiedBefore > 0 && fileInfo.Modified() >= query.ModifiedBefore {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package sharedatadriver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const defaultSharesFolder = "/Shares"
+
+type driver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	shareDriver    lib.ShareDriver
+	sharesFolder   string
+}
+
+// New returns an implementation of DataDriver that uploads and downloads the resources shared
+// with the user inside the folder sharesFolder on behalf of their owner, checking the permissions of the share.
+// metaDataDriver must not be share aware, it is used to tell creations from modifications in the namespace of the owner.
+// sharesFolder must be the same one used by the sharemdatadriver.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, shareDriver lib.ShareDriver, sharesFolder string) lib.DataDriver {
+	logger = logger.With("pkg", "sharedatadriver")
+	if sharesFolder == "" {
+		sharesFolder = defaultSharesFolder
+	}
+	return &driver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		shareDriver:    shareDriver,
+		sharesFolder:   filepath.Clean("/" + sharesFolder),
+	}
+}
+
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	owner, ownerPath, permissions, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) {
+			return forbiddenError("files can not be uploaded to the shares folder")
+		}
+		return err
+	}
+	if owner != user {
+		required := lib.PermissionCreate
+		if _, err := d.metaDataDriver.Examine(ctx, owner, ownerPath); err == nil {
+			required = lib.PermissionUpdate
+		}
+		if permissions&required == 0 {
+			return forbiddenError("share does not allow to upload")
+		}
+	}
+	return d.dataDriver.UploadFile(ctx, owner, ownerPath, r, clientChecksum, clientModTime)
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	owner, ownerPath, permissions, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if owner != user && permissions&lib.PermissionRead == 0 {
+		return nil, forbiddenError("share does not allow to read")
+	}
+	return d.dataDriver.DownloadFile(ctx, owner, ownerPath)
+}
+
+// AvailableBytes reports the bytes available to the owner of the resource, nothing can be uploaded to the shares folder.
+// It reports no limit when the wrapped driver has no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	owner, ownerPath, _, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+	if !ok {
+		return -1, nil
+	}
+	return quotaReporter.AvailableBytes(ctx, owner, ownerPath)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+// resolve returns the owner, the path in the namespace of the owner and the
+// permissions the user has on it, for a path of the user.
+// Like the sharemdatadriver, the permissions of shares of the same resource are joined
+// and name clashes are solved appending a number to the name.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) (lib.User, string, lib.SharePermission, error) {
+	path = filepath.Clean("/" + path)
+	if !strings.HasPrefix(path, d.sharesFolder+"/") {
+		if path == d.sharesFolder {
+			return nil, "", 0, forbiddenError("the shares folder is not a file")
+		}
+		return user, path, 0, nil
+	}
+
+	shares, err := d.shareDriver.ListReceivedShares(ctx, user)
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing received shares")
+		return nil, "", 0, err
+	}
+
+	var found lib.UserShare
+	var permissions lib.SharePermission
+	names := map[string]bool{}
+	seen := map[string]bool{}
+	for _, share := range shares {
+		key := share.Owner().Username() + ":" + share.Path()
+		if seen[key] {
+			if found != nil && found.Owner().Username()+":"+found.Path() == key {
+				permissions |= share.Permissions()
+			}
+			continue
+		}
+		seen[key] = true
+
+		name := filepath.Base(share.Path())
+		candidate := name
+		for i := 2; names[candidate]; i++ {
+			candidate = fmt.Sprintf("%s (%d)", name, i)
+		}
+		names[candidate] = true
+
+		mountPath := filepath.Join(d.sharesFolder, candidate)
+		if found == nil && (path == mountPath || strings.HasPrefix(path, mountPath+"/")) {
+			found = share
+			permissions = share.Permissions()
+			path = filepath.Join(share.Path(), strings.TrimPrefix(path, mountPath))
+		}
+	}
+	if found == nil {
+		return nil, "", 0, notFoundError("share not found")
+	}
+	return found.Owner(), path, permissions, nil
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,808 @@
+package sharemdatadriver
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/clawio/lib/folderpage"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const defaultSharesFolder = "/Shares"
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	shareDriver    lib.ShareDriver
+	sharesFolder   string
+}
+
+// New returns an implementation of MetaDataDriver that exposes the resources shared with
+// the user inside the virtual folder sharesFolder, by default /Shares, and that checks every
+// operation on them against the permissions of the share.
+// Operations on shared resources are done on metaDataDriver on behalf of the owner.
+// A resource shared several times with the user, like with the user and with one of their groups,
+// appears once with the union of the permissions.
+// Resources of the user inside a folder with the same name as sharesFolder are hidden.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, shareDriver lib.ShareDriver, sharesFolder string) lib.MetaDataDriver {
+	logger = logger.With("pkg", "sharemdatadriver")
+	if sharesFolder == "" {
+		sharesFolder = defaultSharesFolder
+	}
+	d := &driver{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+		shareDriver:    shareDriver,
+		sharesFolder:   filepath.Clean("/" + sharesFolder),
+	}
+	return d
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return d.getSharesFolderInfo(ctx, user, rp.mounts)
+	}
+	if !rp.can(lib.PermissionRead) {
+		return nil, forbiddenError("share does not allow to read")
+	}
+
+	fileInfo, err := d.metaDataDriver.Examine(ctx, rp.user, rp.path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.mount != nil {
+		return rp.mount.toRecipientFileInfo(fileInfo), nil
+	}
+	return d.markShared(ctx, user, []lib.FileInfo{fileInfo})[0], nil
+}
+
+// ExamineByID looks for the id in the resources of the user and then
+// inside the shares received by the user, where it must be below the shared resource.
+// It returns a notSupportedError when the wrapped driver can not resolve ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+
+	fi, err := fileIDResolver.ExamineByID(ctx, user, id)
+	if err == nil {
+		if filepath.Clean("/"+fi.Path()) == d.sharesFolder || strings.HasPrefix(filepath.Clean("/"+fi.Path()), d.sharesFolder+"/") {
+			return nil, notFoundError("resource hidden by the shares folder")
+		}
+		return d.markShared(ctx, user, []lib.FileInfo{fi})[0], nil
+	}
+	if !isNotFoundError(err) {
+		return nil, err
+	}
+
+	mounts, err := d.getMounts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		if m.permissions&lib.PermissionRead == 0 {
+			continue
+		}
+		fi, err := fileIDResolver.ExamineByID(ctx, m.share.Owner(), id)
+		if err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return nil, err
+		}
+		path := filepath.Clean("/" + fi.Path())
+		if path == m.share.Path() || strings.HasPrefix(path, m.share.Path()+"/") {
+			return m.toRecipientFileInfo(fi), nil
+		}
+	}
+	return nil, notFoundError("resource with id " + id + " not found")
+}
+
+// Search searches the resources of the user, received shares are only searched
+// when the scope is inside one of them. It returns a notSupportedError when the wrapped driver can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	searcher, ok := d.metaDataDriver.(lib.Searcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+
+	rp, err := d.resolve(ctx, user, query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return nil, notSupportedError("the shares folder can not be searched, search inside a share instead")
+	}
+	if !rp.can(lib.PermissionRead) {
+		return nil, forbiddenError("share does not allow to read")
+	}
+
+	query.Scope = rp.path
+	result, err := searcher.Search(ctx, rp.user, query)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := []lib.FileInfo{}
+	if rp.mount != nil {
+		for _, fi := range result.FileInfos() {
+			fileInfos = append(fileInfos, rp.mount.toRecipientFileInfo(fi))
+		}
+		return &searchResult{fileInfos: fileInfos, hasMore: result.HasMore()}, nil
+	}
+	for _, fi := range result.FileInfos() {
+		p := filepath.Clean("/" + fi.Path())
+		if p == d.sharesFolder || strings.HasPrefix(p, d.sharesFolder+"/") {
+			continue
+		}
+		fileInfos = append(fileInfos, fi)
+	}
+	return &searchResult{fileInfos: d.markShared(ctx, user, fileInfos), hasMore: result.HasMore()}, nil
+}
+
+// SearchContent searches the contents of the files of the user, received shares are only searched
+// when the scope is inside one of them, like in Search. It returns a notSupportedError when the wrapped
+// driver can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	contentSearcher, ok := d.metaDataDriver.(lib.ContentSearcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+
+	rp, err := d.resolve(ctx, user, query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return nil, notSupportedError("the shares folder can not be searched, search inside a share instead")
+	}
+	if !rp.can(lib.PermissionRead) {
+		return nil, forbiddenError("share does not allow to read")
+	}
+
+	query.Scope = rp.path
+	result, err := contentSearcher.SearchContent(ctx, rp.user, query)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []lib.ContentMatch{}
+	for _, m := range result.Matches() {
+		p := filepath.Clean("/" + m.Path())
+		if rp.mount != nil {
+			rel := strings.TrimPrefix(p, rp.mount.share.Path())
+			matches = append(matches, &contentMatch{ContentMatch: m, path: filepath.Join(rp.mount.path, rel)})
+			continue
+		}
+		if p == d.sharesFolder || strings.HasPrefix(p, d.sharesFolder+"/") {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	return &contentSearchResult{matches: matches, hasMore: result.HasMore()}, nil
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return d.listSharesFolder(ctx, rp.mounts), nil
+	}
+	if !rp.can(lib.PermissionRead) {
+		return nil, forbiddenError("share does not allow to read")
+	}
+
+	fileInfos, err := d.metaDataDriver.ListFolder(ctx, rp.user, rp.path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.mount != nil {
+		for i, fileInfo := range fileInfos {
+			fileInfos[i] = rp.mount.toRecipientFileInfo(fileInfo)
+		}
+		return fileInfos, nil
+	}
+
+	// own resources inside the shares folder are hidden by it.
+	visible := []lib.FileInfo{}
+	for _, fileInfo := range fileInfos {
+		if filepath.Clean("/"+fileInfo.Path()) != d.sharesFolder {
+			visible = append(visible, fileInfo)
+		}
+	}
+	if filepath.Dir(d.sharesFolder) == rp.path && len(rp.mounts) > 0 {
+		sharesFolderInfo, err := d.getSharesFolderInfo(ctx, user, rp.mounts)
+		if err != nil {
+			return nil, err
+		}
+		visible = append(visible, sharesFolderInfo)
+	}
+	return d.markShared(ctx, user, visible), nil
+}
+
+// WalkFolder walks the folder like ListFolder lists it, the virtual shares folder is yielded after the own entries.
+// It returns a notSupportedError when the wrapped driver can not walk folders.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	folderWalker, ok := d.metaDataDriver.(lib.FolderWalker)
+	if !ok {
+		return notSupportedError("metadata driver does not support walking folders")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual {
+		return fn(d.listSharesFolder(ctx, rp.mounts))
+	}
+	if !rp.can(lib.PermissionRead) {
+		return forbiddenError("share does not allow to read")
+	}
+
+	if rp.mount != nil {
+		return folderWalker.WalkFolder(ctx, rp.user, rp.path, func(fileInfos []lib.FileInfo) error {
+			for i, fileInfo := range fileInfos {
+				fileInfos[i] = rp.mount.toRecipientFileInfo(fileInfo)
+			}
+			return fn(fileInfos)
+		})
+	}
+
+	err = folderWalker.WalkFolder(ctx, rp.user, rp.path, func(fileInfos []lib.FileInfo) error {
+		visible := []lib.FileInfo{}
+		for _, fileInfo := range d.markShared(ctx, user, fileInfos) {
+			if filepath.Clean("/"+fileInfo.Path()) != d.sharesFolder {
+				visible = append(visible, fileInfo)
+			}
+		}
+		return fn(visible)
+	})
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(d.sharesFolder) == rp.path && len(rp.mounts) > 0 {
+		sharesFolderInfo, err := d.getSharesFolderInfo(ctx, user, rp.mounts)
+		if err != nil {
+			return err
+		}
+		return fn([]lib.FileInfo{sharesFolderInfo})
+	}
+	return nil
+}
+
+// ListFolderPage pages the folder like ListFolder lists it. The shares folder, which only has a few entries,
+// is paged in memory. In the parent of the shares folder the own resource with the same name is hidden,
+// so its page has one entry less, and the virtual shares folder is added to the page it belongs to.
+// It returns a notSupportedError when the wrapped driver can not page folders.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	folderPager, ok := d.metaDataDriver.(lib.FolderPager)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support paging folders")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badInputError(err.Error())
+	}
+	if rp.virtual {
+		for _, fileInfo := range d.listSharesFolder(ctx, rp.mounts) {
+			builder.Add(folderpage.NewKey(fileInfo), fileInfo.Folder(), fileInfo)
+		}
+		return newFolderPage(builder), nil
+	}
+	if !rp.can(lib.PermissionRead) {
+		return nil, forbiddenError("share does not allow to read")
+	}
+
+	page, err := folderPager.ListFolderPage(ctx, rp.user, rp.path, query)
+	if err != nil {
+		return nil, err
+	}
+	fileInfos := page.FileInfos()
+	if rp.mount != nil {
+		for i, fileInfo := range fileInfos {
+			fileInfos[i] = rp.mount.toRecipientFileInfo(fileInfo)
+		}
+		return &folderPage{fileInfos: fileInfos, continuationToken: page.ContinuationToken()}, nil
+	}
+
+	for _, fileInfo := range d.markShared(ctx, user, fileInfos) {
+		if filepath.Clean("/"+fileInfo.Path()) != d.sharesFolder {
+			builder.Add(folderpage.NewKey(fileInfo), fileInfo.Folder(), fileInfo)
+		}
+	}
+	if filepath.Dir(d.sharesFolder) == rp.path && len(rp.mounts) > 0 {
+		sharesFolderInfo, err := d.getSharesFolderInfo(ctx, user, rp.mounts)
+		if err != nil {
+			return nil, err
+		}
+		// when there are more pages the shares folder belongs to this one
+		// only if it does not go after the last entry.
+		key := folderpage.NewKey(sharesFolderInfo)
+		if page.ContinuationToken() == "" || len(fileInfos) == 0 || !folderpage.Less(query, folderpage.NewKey(fileInfos[len(fileInfos)-1]), key) {
+			builder.Add(key, true, sharesFolderInfo)
+		}
+	}
+	result := newFolderPage(builder)
+	// the builder only issues a token when the shares folder pushes an entry to the next page.
+	if result.continuationToken == "" {
+		result.continuationToken = page.ContinuationToken()
+	}
+	return result, nil
+}
+
+// SetModTime sets the modification time of shared resources on the namespace of their owner,
+// it requires the update permission.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	modTimeSetter, ok := d.metaDataDriver.(lib.ModTimeSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual {
+		return forbiddenError("the modification time of the shares folder can not be set")
+	}
+	if !rp.can(lib.PermissionUpdate) {
+		return forbiddenError("share does not allow to update")
+	}
+	return modTimeSetter.SetModTime(ctx, rp.user, rp.path, modTime)
+}
+
+// GetChanges returns a notSupportedError when the wrapped driver has no change feed.
+func (d *driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	changeFeed, ok := d.metaDataDriver.(lib.ChangeFeed)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support change feeds")
+	}
+	return changeFeed.GetChanges(ctx, user, cursor, limit)
+}
+
+// SetFileID only sets the ids of resources of the user, not of the ones in shares.
+func (d *driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	fileIDSetter, ok := d.metaDataDriver.(lib.FileIDSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting file ids")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual || rp.mount != nil {
+		return forbiddenError("ids can only be set on resources of the user")
+	}
+	return fileIDSetter.SetFileID(ctx, user, rp.path, id)
+}
+
+// RecursiveSizes returns false when the wrapped driver can not compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer)
+	return ok && recursiveSizer.RecursiveSizes()
+}
+
+// Supports tells if the wrapped driver supports the optional interface.
+func (d *driver) Supports(c lib.Capability) bool {
+	return capability.Supports(d.metaDataDriver, c)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.metaDataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) && filepath.Dir(filepath.Clean("/"+path)) == d.sharesFolder {
+			return forbiddenError("folders can not be created in the shares folder")
+		}
+		return err
+	}
+	if rp.virtual || rp.isMountPoint() {
+		return alreadyExistError("folder already exists")
+	}
+	if !rp.can(lib.PermissionCreate) {
+		return forbiddenError("share does not allow to create")
+	}
+	return d.metaDataDriver.CreateFolder(ctx, rp.user, rp.path)
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual || rp.isMountPoint() {
+		return forbiddenError("shared resources can only be removed by their owner")
+	}
+	if !rp.can(lib.PermissionDelete) {
+		return forbiddenError("share does not allow to delete")
+	}
+	return d.metaDataDriver.Delete(ctx, rp.user, rp.path)
+}
+
+// Move moves resources inside the same namespace, moving resources
+// between the own namespace and a share or between shares is forbidden.
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	source, err := d.resolve(ctx, user, sourcePath)
+	if err != nil {
+		return err
+	}
+	target, err := d.resolve(ctx, user, targetPath)
+	if err != nil && !isNotFoundError(err) {
+		return err
+	}
+	if target == nil || source.virtual || target.virtual || source.isMountPoint() || target.isMountPoint() {
+		return forbiddenError("shared resources can only be moved inside their share")
+	}
+
+	if source.mount == nil && target.mount == nil {
+		return d.metaDataDriver.Move(ctx, user, source.path, target.path)
+	}
+	if source.mount == nil || target.mount == nil || source.mount.path != target.mount.path {
+		return forbiddenError("resources can not be moved between shares")
+	}
+	if !source.can(lib.PermissionUpdate) {
+		return forbiddenError("share does not allow to move")
+	}
+	return d.metaDataDriver.Move(ctx, source.user, source.path, target.path)
+}
+
+// resolve returns the owner and the path in the namespace of the owner for a path of the user.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) (*resolvedPath, error) {
+	path = filepath.Clean("/" + path)
+	if path != d.sharesFolder && !strings.HasPrefix(path, d.sharesFolder+"/") && path != filepath.Dir(d.sharesFolder) {
+		return &resolvedPath{user: user, path: path}, nil
+	}
+
+	mounts, err := d.getMounts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if path == d.sharesFolder {
+		return &resolvedPath{user: user, path: path, virtual: true, mounts: mounts}, nil
+	}
+	for _, m := range mounts {
+		if path == m.path || strings.HasPrefix(path, m.path+"/") {
+			return &resolvedPath{
+				user:  m.share.Owner(),
+				path:  filepath.Join(m.share.Path(), strings.TrimPrefix(path, m.path)),
+				mount: m,
+			}, nil
+		}
+	}
+	// the parent folder of the shares folder.
+	if path == filepath.Dir(d.sharesFolder) {
+		return &resolvedPath{user: user, path: path, mounts: mounts}, nil
+	}
+	return nil, notFoundError("share not found")
+}
+
+// getMounts returns the shares received by the user with their mount points.
+// Name clashes are solved appending a number to the name, older shares keep their names.
+func (d *driver) getMounts(ctx context.Context, user lib.User) ([]*mount, error) {
+	shares, err := d.shareDriver.ListReceivedShares(ctx, user)
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing received shares")
+		return nil, err
+	}
+
+	mounts := []*mount{}
+	byResource := map[string]*mount{}
+	names := map[string]bool{}
+	for _, share := range shares {
+		key := share.Owner().Username() + ":" + share.Path()
+		if m, ok := byResource[key]; ok {
+			m.permissions |= share.Permissions()
+			continue
+		}
+
+		name := filepath.Base(share.Path())
+		candidate := name
+		for i := 2; names[candidate]; i++ {
+			candidate = fmt.Sprintf("%s (%d)", name, i)
+		}
+		names[candidate] = true
+
+		m := &mount{path: filepath.Join(d.sharesFolder, candidate), share: share, permissions: share.Permissions()}
+		byResource[key] = m
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+func (d *driver) listSharesFolder(ctx context.Context, mounts []*mount) []lib.FileInfo {
+	fileInfos := []lib.FileInfo{}
+	for _, m := range mounts {
+		fileInfo, err := d.metaDataDriver.Examine(ctx, m.share.Owner(), m.share.Path())
+		if err != nil {
+			// the owner may have removed or moved the shared resource.
+			d.logger.Warn().Log("error", err, "msg", "shared resource not available", "shareid", m.share.ID())
+			continue
+		}
+		fileInfos = append(fileInfos, m.toRecipientFileInfo(fileInfo))
+	}
+	return fileInfos
+}
+
+// getSharesFolderInfo returns the virtual folder containing the shares.
+// Its etag changes every time the etag of one of the shares changes, so sync clients detect changes inside shares.
+func (d *driver) getSharesFolderInfo(ctx context.Context, user lib.User, mounts []*mount) (lib.FileInfo, error) {
+	info := &sharesFolderInfo{path: d.sharesFolder}
+	h := md5.New()
+	for _, fileInfo := range d.listSharesFolder(ctx, mounts) {
+		info.size += fileInfo.Size()
+		if fileInfo.Modified() > info.modified {
+			info.modified = fileInfo.Modified()
+		}
+		etag, _ := fileInfo.ExtraAttributes()["etag"].(string)
+		fmt.Fprintf(h, "%s:%s\n", fileInfo.Path(), etag)
+	}
+	info.etag = fmt.Sprintf("%x", h.Sum(nil))
+	info.id = "shares-" + user.Username()
+	return info, nil
+}
+
+// markShared flags the resources of the user that are shared with others.
+func (d *driver) markShared(ctx context.Context, user lib.User, fileInfos []lib.FileInfo) []lib.FileInfo {
+	shares, err := d.shareDriver.ListUserShares(ctx, user, "")
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing shares")
+		return fileInfos
+	}
+
+	sharedPaths := map[string]bool{}
+	for _, share := range shares {
+		sharedPaths[share.Path()] = true
+	}
+	for i, fi := range fileInfos {
+		if sharedPaths[filepath.Clean("/"+fi.Path())] {
+			extraAttributes := copyExtraAttributes(fi)
+			extraAttributes["shared"] = true
+			fileInfos[i] = &fileInfo{FileInfo: fi, path: fi.Path(), extraAttributes: extraAttributes}
+		}
+	}
+	return fileInfos
+}
+
+type resolvedPath struct {
+	user    lib.User
+	path    string
+	mount   *mount
+	virtual bool
+	mounts  []*mount
+}
+
+func (rp *resolvedPath) can(permission lib.SharePermission) bool {
+	if rp.virtual {
+		return permission == lib.PermissionRead
+	}
+	return rp.mount == nil || rp.mount.permissions&permission != 0
+}
+
+func (rp *resolvedPath) isMountPoint() bool {
+	return rp.mount != nil && rp.path == rp.mount.share.Path()
+}
+
+// mount is a share received by the user and the path where it appears.
+type mount struct {
+	path        string
+	share       lib.UserShare
+	permissions lib.SharePermission
+}
+
+// toRecipientFileInfo returns the file info of the owner as seen by the recipient.
+// The "permissions" and "owner" extra attributes are set so web services can expose them.
+func (m *mount) toRecipientFileInfo(fi lib.FileInfo) lib.FileInfo {
+	rel := strings.TrimPrefix(filepath.Clean("/"+fi.Path()), m.share.Path())
+	extraAttributes := copyExtraAttributes(fi)
+	extraAttributes["permissions"] = m.permissions
+	extraAttributes["owner"] = m.share.Owner().Username()
+	extraAttributes["shared"] = true
+	return &fileInfo{FileInfo: fi, path: filepath.Join(m.path, rel), extraAttributes: extraAttributes}
+}
+
+func copyExtraAttributes(fi lib.FileInfo) map[string]interface{} {
+	extraAttributes := map[string]interface{}{}
+	for k, v := range fi.ExtraAttributes() {
+		extraAttributes[k] = v
+	}
+	return extraAttributes
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type searchResult struct {
+	fileInfos []lib.FileInfo
+	hasMore   bool
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	return r.fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func newFolderPage(builder *folderpage.Builder) *folderPage {
+	items, continuationToken := builder.Page()
+	fileInfos := []lib.FileInfo{}
+	for _, item := range items {
+		fileInfos = append(fileInfos, item.(lib.FileInfo))
+	}
+	return &folderPage{fileInfos: fileInfos, continuationToken: continuationToken}
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
+type contentSearchResult struct {
+	matches []lib.ContentMatch
+	hasMore bool
+}
+
+func (r *contentSearchResult) Matches() []lib.ContentMatch {
+	return r.matches
+}
+
+func (r *contentSearchResult) HasMore() bool {
+	return r.hasMore
+}
+
+// contentMatch is a match inside a share with the path of the recipient.
+type contentMatch struct {
+	lib.ContentMatch
+	path string
+}
+
+func (m *contentMatch) Path() string {
+	return m.path
+}
+
+type fileInfo struct {
+	lib.FileInfo
+	path            string
+	extraAttributes map[string]interface{}
+}
+
+func (f *fileInfo) Path() string {
+	return f.path
+}
+
+func (f *fileInfo) ExtraAttributes() map[string]interface{} {
+	return f.extraAttributes
+}
+
+type sharesFolderInfo struct {
+	path     string
+	size     int64
+	modified int64
+	etag     string
+	id       string
+}
+
+func (f *sharesFolderInfo) Path() string {
+	return f.path
+}
+
+func (f *sharesFolderInfo) Folder() bool {
+	return true
+}
+
+func (f *sharesFolderInfo) Size() int64 {
+	return f.size
+}
+
+func (f *sharesFolderInfo) Modified() int64 {
+	return f.modified
+}
+
+func (f *sharesFolderInfo) Checksum() string {
+	return ""
+}
+
+func (f *sharesFolderInfo) ExtraAttributes() map[string]interface{} {
+	return map[string]interface{}{
+		"etag":        f.etag,
+		"id":          f.id,
+		"permissions": lib.PermissionRead,
+	}
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,323 @@
+package spacedatadriver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/occhunk"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	defaultSpacesFolder = "/Spaces"
+
+	// transferTTL is how long the bytes received for an ownCloud chunked upload are remembered
+	// after its last chunk, abandoned uploads are forgotten after it.
+	transferTTL = 24 * time.Hour
+)
+
+type driver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	spaceDriver    lib.SpaceDriver
+	spacesFolder   string
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+}
+
+// transfer is a chunked upload in progress with the size of every chunk already uploaded,
+// a chunk sent again replaces the previous one.
+type transfer struct {
+	chunks  map[int64]int64
+	updated time.Time
+}
+
+// New returns an implementation of DataDriver that uploads and downloads the resources of the spaces
+// of the user inside the folder spacesFolder on behalf of the storage user of the space, checking the role of the user.
+// metaDataDriver must not be space aware, it is used to compute the usage of the space to enforce its quota
+// and must compute the sizes of folders, uploads to spaces with a quota are refused otherwise.
+// spacesFolder must be the same one used by the spacemdatadriver.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver, spaceDriver lib.SpaceDriver, spacesFolder string) lib.DataDriver {
+	logger = logger.With("pkg", "spacedatadriver")
+	if spacesFolder == "" {
+		spacesFolder = defaultSpacesFolder
+	}
+	return &driver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		spaceDriver:    spaceDriver,
+		spacesFolder:   filepath.Clean("/" + spacesFolder),
+		transfers:      map[string]*transfer{},
+	}
+}
+
+// UploadFile requires the editor role to upload inside a space.
+// The quota of the space is checked against the size of the root of the space while the file is read,
+// so concurrent uploads, or metadata drivers that cache folder sizes, can let it be exceeded by the
+// size of the files uploaded meanwhile. The chunks of ownCloud chunked uploads are stored outside of the
+// space until the file is assembled, so every chunk is charged the size of the chunks before it.
+func (d *driver) UploadFile(ctx context.Context, user lib.User, path string, r io.ReadCloser, clientChecksum string, clientModTime int64) error {
+	space, spacePath, role, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) {
+			return forbiddenError("files can not be uploaded to the spaces folder")
+		}
+		return err
+	}
+	if space == nil {
+		return d.dataDriver.UploadFile(ctx, user, spacePath, r, clientChecksum, clientModTime)
+	}
+	if role < lib.SpaceRoleEditor {
+		return forbiddenError("space role does not allow to upload")
+	}
+
+	storageUser := space.StorageUser()
+	if space.Quota() <= 0 {
+		return d.dataDriver.UploadFile(ctx, storageUser, spacePath, r, clientChecksum, clientModTime)
+	}
+	if recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer); !ok || !recursiveSizer.RecursiveSizes() {
+		return notSupportedError("the quota of the space can not be enforced, the metadata driver does not compute the sizes of folders")
+	}
+
+	target := spacePath
+	chunkInfo, isChunk := occhunk.Parse(spacePath)
+	if isChunk {
+		target = chunkInfo.Path
+	}
+	rootInfo, err := d.metaDataDriver.Examine(ctx, storageUser, "/")
+	if err != nil {
+		return err
+	}
+	available := space.Quota() - rootInfo.Size()
+	// the file being replaced frees its size.
+	if fileInfo, err := d.metaDataDriver.Examine(ctx, storageUser, target); err == nil && !fileInfo.Folder() {
+		available += fileInfo.Size()
+	}
+	if !isChunk {
+		return d.dataDriver.UploadFile(ctx, storageUser, spacePath, &quotaReader{ReadCloser: r, n: available}, clientChecksum, clientModTime)
+	}
+
+	key := storageUser.Username() + ":" + chunkInfo.TransferID
+	n := available - d.getReceived(key, chunkInfo.CurrentChunk)
+	qr := &quotaReader{ReadCloser: r, n: n}
+	err = d.dataDriver.UploadFile(ctx, storageUser, spacePath, qr, clientChecksum, clientModTime)
+	if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeUploadIsPartial {
+		d.setReceived(key, chunkInfo.CurrentChunk, n-qr.n)
+	} else {
+		d.forgetTransfer(key)
+	}
+	return err
+}
+
+// AvailableBytes returns the bytes left in the quota of the space of path, outside of spaces the
+// wrapped driver is asked. Nothing can be uploaded to the spaces folder, nor by members without the editor role.
+// It reports no limit when there is no quota.
+func (d *driver) AvailableBytes(ctx context.Context, user lib.User, path string) (int64, error) {
+	space, spacePath, role, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if space == nil {
+		quotaReporter, ok := d.dataDriver.(lib.QuotaReporter)
+		if !ok {
+			return -1, nil
+		}
+		return quotaReporter.AvailableBytes(ctx, user, spacePath)
+	}
+	if role < lib.SpaceRoleEditor {
+		return 0, nil
+	}
+	if space.Quota() <= 0 {
+		return -1, nil
+	}
+	if recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer); !ok || !recursiveSizer.RecursiveSizes() {
+		return 0, notSupportedError("the quota of the space can not be enforced, the metadata driver does not compute the sizes of folders")
+	}
+	rootInfo, err := d.metaDataDriver.Examine(ctx, space.StorageUser(), "/")
+	if err != nil {
+		return 0, err
+	}
+	if available := space.Quota() - rootInfo.Size(); available > 0 {
+		return available, nil
+	}
+	return 0, nil
+}
+
+// getReceived returns the size of the chunks of the chunked upload but chunk, forgetting abandoned uploads.
+func (d *driver) getReceived(key string, chunk int64) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, t := range d.transfers {
+		if now.Sub(t.updated) > transferTTL {
+			delete(d.transfers, k)
+		}
+	}
+	var received int64
+	if t, ok := d.transfers[key]; ok {
+		for i, size := range t.chunks {
+			if i != chunk {
+				received += size
+			}
+		}
+	}
+	return received
+}
+
+func (d *driver) setReceived(key string, chunk, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.transfers[key]
+	if !ok {
+		t = &transfer{chunks: map[int64]int64{}}
+		d.transfers[key] = t
+	}
+	t.chunks[chunk] = size
+	t.updated = time.Now()
+}
+
+func (d *driver) forgetTransfer(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.transfers, key)
+}
+
+func (d *driver) DownloadFile(ctx context.Context, user lib.User, path string) (io.ReadCloser, error) {
+	space, spacePath, role, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if space == nil {
+		return d.dataDriver.DownloadFile(ctx, user, spacePath)
+	}
+	if role < lib.SpaceRoleViewer {
+		return nil, forbiddenError("space role does not allow to read")
+	}
+	return d.dataDriver.DownloadFile(ctx, space.StorageUser(), spacePath)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.dataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+// resolve returns the space, the path inside it and the role of the user for a path of the user.
+// The space is nil for paths outside the spaces folder.
+// Like the spacemdatadriver, name clashes are solved appending a number to the name.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) (lib.Space, string, lib.SpaceRole, error) {
+	path = filepath.Clean("/" + path)
+	if !strings.HasPrefix(path, d.spacesFolder+"/") {
+		if path == d.spacesFolder {
+			return nil, "", 0, forbiddenError("the spaces folder is not a file")
+		}
+		return nil, path, 0, nil
+	}
+
+	spaces, err := d.spaceDriver.ListSpaces(ctx, user, false)
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing spaces")
+		return nil, "", 0, err
+	}
+
+	names := map[string]bool{}
+	for _, space := range spaces {
+		candidate := space.Name()
+		for i := 2; names[candidate]; i++ {
+			candidate = fmt.Sprintf("%s (%d)", space.Name(), i)
+		}
+		names[candidate] = true
+
+		mountPath := filepath.Join(d.spacesFolder, candidate)
+		if path == mountPath {
+			return nil, "", 0, forbiddenError("a space is not a file")
+		}
+		if strings.HasPrefix(path, mountPath+"/") {
+			return space, filepath.Clean("/" + strings.TrimPrefix(path, mountPath)), space.Members()[user.Username()], nil
+		}
+	}
+	return nil, "", 0, notFoundError("space not found")
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+// quotaReader fails when more than n bytes are read.
+type quotaReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (q *quotaReader) Read(b []byte) (int, error) {
+	n, err := q.ReadCloser.Read(b)
+	q.n -= int64(n)
+	if q.n < 0 {
+		return n, tooBigError("space quota exceeded")
+	}
+	return n, err
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
+
+type tooBigError string
+
+func (e tooBigError) Error() string {
+	return string(e)
+}
+func (e tooBigError) Code() lib.Code {
+	return lib.Code(lib.CodeTooBig)
+}
+func (e tooBigError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,754 @@
+package spacemdatadriver
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/clawio/lib/folderpage"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const defaultSpacesFolder = "/Spaces"
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	spaceDriver    lib.SpaceDriver
+	spacesFolder   string
+}
+
+// New returns an implementation of MetaDataDriver that mounts the spaces the user is a member of
+// inside the virtual folder spacesFolder, by default /Spaces, and that checks every operation on them
+// against the role of the user. Operations on spaces are done on metaDataDriver on behalf of the storage user of the space.
+// Spaces with the same name get a number appended, older spaces keep their names.
+// Resources of the user inside a folder with the same name as spacesFolder are hidden.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, spaceDriver lib.SpaceDriver, spacesFolder string) lib.MetaDataDriver {
+	logger = logger.With("pkg", "spacemdatadriver")
+	if spacesFolder == "" {
+		spacesFolder = defaultSpacesFolder
+	}
+	d := &driver{
+		logger:         logger,
+		metaDataDriver: metaDataDriver,
+		spaceDriver:    spaceDriver,
+		spacesFolder:   filepath.Clean("/" + spacesFolder),
+	}
+	return d
+}
+
+func (d *driver) Examine(ctx context.Context, user lib.User, path string) (lib.FileInfo, error) {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return d.getSpacesFolderInfo(ctx, user, rp.mounts), nil
+	}
+	if !rp.can(lib.SpaceRoleViewer) {
+		return nil, forbiddenError("not a member of the space")
+	}
+
+	fileInfo, err := d.metaDataDriver.Examine(ctx, rp.user, rp.path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.mount != nil {
+		return rp.mount.toMemberFileInfo(fileInfo), nil
+	}
+	return fileInfo, nil
+}
+
+// ExamineByID looks for the id in the resources of the user and then inside the spaces of the user.
+// It returns a notSupportedError when the wrapped driver can not resolve ids.
+func (d *driver) ExamineByID(ctx context.Context, user lib.User, id string) (lib.FileInfo, error) {
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support file ids")
+	}
+
+	fi, err := fileIDResolver.ExamineByID(ctx, user, id)
+	if err == nil {
+		if d.isHidden(fi.Path()) {
+			return nil, notFoundError("resource hidden by the spaces folder")
+		}
+		return fi, nil
+	}
+	if !isNotFoundError(err) {
+		return nil, err
+	}
+
+	mounts, err := d.getMounts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		fi, err := fileIDResolver.ExamineByID(ctx, m.space.StorageUser(), id)
+		if err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return nil, err
+		}
+		return m.toMemberFileInfo(fi), nil
+	}
+	return nil, notFoundError("resource with id " + id + " not found")
+}
+
+// Search searches the resources of the user, spaces are only searched when the scope is inside one of them.
+// It returns a notSupportedError when the wrapped driver can not search.
+func (d *driver) Search(ctx context.Context, user lib.User, query lib.SearchQuery) (lib.SearchResult, error) {
+	searcher, ok := d.metaDataDriver.(lib.Searcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support search")
+	}
+
+	rp, err := d.resolve(ctx, user, query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return nil, notSupportedError("the spaces folder can not be searched, search inside a space instead")
+	}
+
+	query.Scope = rp.path
+	result, err := searcher.Search(ctx, rp.user, query)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := []lib.FileInfo{}
+	for _, fi := range result.FileInfos() {
+		if rp.mount != nil {
+			fileInfos = append(fileInfos, rp.mount.toMemberFileInfo(fi))
+		} else if !d.isHidden(fi.Path()) {
+			fileInfos = append(fileInfos, fi)
+		}
+	}
+	return &searchResult{fileInfos: fileInfos, hasMore: result.HasMore()}, nil
+}
+
+// SearchContent searches the contents of the files of the user, spaces are only searched
+// when the scope is inside one of them, like in Search. It returns a notSupportedError when the wrapped
+// driver can not search contents.
+func (d *driver) SearchContent(ctx context.Context, user lib.User, query lib.ContentQuery) (lib.ContentSearchResult, error) {
+	contentSearcher, ok := d.metaDataDriver.(lib.ContentSearcher)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support content search")
+	}
+
+	rp, err := d.resolve(ctx, user, query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return nil, notSupportedError("the spaces folder can not be searched, search inside a space instead")
+	}
+
+	query.Scope = rp.path
+	result, err := contentSearcher.SearchContent(ctx, rp.user, query)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []lib.ContentMatch{}
+	for _, m := range result.Matches() {
+		if rp.mount != nil {
+			matches = append(matches, &contentMatch{ContentMatch: m, path: rp.mount.toMemberPath(m.Path())})
+		} else if !d.isHidden(m.Path()) {
+			matches = append(matches, m)
+		}
+	}
+	return &contentSearchResult{matches: matches, hasMore: result.HasMore()}, nil
+}
+
+func (d *driver) ListFolder(ctx context.Context, user lib.User, path string) ([]lib.FileInfo, error) {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.virtual {
+		return d.listSpacesFolder(ctx, rp.mounts), nil
+	}
+
+	fileInfos, err := d.metaDataDriver.ListFolder(ctx, rp.user, rp.path)
+	if err != nil {
+		return nil, err
+	}
+	if rp.mount != nil {
+		for i, fileInfo := range fileInfos {
+			fileInfos[i] = rp.mount.toMemberFileInfo(fileInfo)
+		}
+		return fileInfos, nil
+	}
+
+	// own resources inside the spaces folder are hidden by it.
+	visible := []lib.FileInfo{}
+	for _, fileInfo := range fileInfos {
+		if filepath.Clean("/"+fileInfo.Path()) != d.spacesFolder {
+			visible = append(visible, fileInfo)
+		}
+	}
+	if filepath.Dir(d.spacesFolder) == rp.path && len(rp.mounts) > 0 {
+		visible = append(visible, d.getSpacesFolderInfo(ctx, user, rp.mounts))
+	}
+	return visible, nil
+}
+
+// WalkFolder walks the folder like ListFolder lists it, the virtual spaces folder is yielded after the own entries.
+// It returns a notSupportedError when the wrapped driver can not walk folders.
+func (d *driver) WalkFolder(ctx context.Context, user lib.User, path string, fn func(fileInfos []lib.FileInfo) error) error {
+	folderWalker, ok := d.metaDataDriver.(lib.FolderWalker)
+	if !ok {
+		return notSupportedError("metadata driver does not support walking folders")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual {
+		return fn(d.listSpacesFolder(ctx, rp.mounts))
+	}
+
+	if rp.mount != nil {
+		return folderWalker.WalkFolder(ctx, rp.user, rp.path, func(fileInfos []lib.FileInfo) error {
+			for i, fileInfo := range fileInfos {
+				fileInfos[i] = rp.mount.toMemberFileInfo(fileInfo)
+			}
+			return fn(fileInfos)
+		})
+	}
+
+	err = folderWalker.WalkFolder(ctx, rp.user, rp.path, func(fileInfos []lib.FileInfo) error {
+		visible := []lib.FileInfo{}
+		for _, fileInfo := range fileInfos {
+			if filepath.Clean("/"+fileInfo.Path()) != d.spacesFolder {
+				visible = append(visible, fileInfo)
+			}
+		}
+		return fn(visible)
+	})
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(d.spacesFolder) == rp.path && len(rp.mounts) > 0 {
+		return fn([]lib.FileInfo{d.getSpacesFolderInfo(ctx, user, rp.mounts)})
+	}
+	return nil
+}
+
+// ListFolderPage pages the folder like ListFolder lists it. The spaces folder, which only has a few entries,
+// is paged in memory. In the parent of the spaces folder the own resource with the same name is hidden,
+// so its page has one entry less, and the virtual spaces folder is added to the page it belongs to.
+// It returns a notSupportedError when the wrapped driver can not page folders.
+func (d *driver) ListFolderPage(ctx context.Context, user lib.User, path string, query lib.ListQuery) (lib.FolderPage, error) {
+	folderPager, ok := d.metaDataDriver.(lib.FolderPager)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support paging folders")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := folderpage.New(query)
+	if err != nil {
+		return nil, badInputError(err.Error())
+	}
+	if rp.virtual {
+		for _, fileInfo := range d.listSpacesFolder(ctx, rp.mounts) {
+			builder.Add(folderpage.NewKey(fileInfo), fileInfo.Folder(), fileInfo)
+		}
+		return newFolderPage(builder), nil
+	}
+
+	page, err := folderPager.ListFolderPage(ctx, rp.user, rp.path, query)
+	if err != nil {
+		return nil, err
+	}
+	fileInfos := page.FileInfos()
+	if rp.mount != nil {
+		for i, fileInfo := range fileInfos {
+			fileInfos[i] = rp.mount.toMemberFileInfo(fileInfo)
+		}
+		return &folderPage{fileInfos: fileInfos, continuationToken: page.ContinuationToken()}, nil
+	}
+
+	for _, fileInfo := range fileInfos {
+		if filepath.Clean("/"+fileInfo.Path()) != d.spacesFolder {
+			builder.Add(folderpage.NewKey(fileInfo), fileInfo.Folder(), fileInfo)
+		}
+	}
+	if filepath.Dir(d.spacesFolder) == rp.path && len(rp.mounts) > 0 {
+		spacesFolderInfo := d.getSpacesFolderInfo(ctx, user, rp.mounts)
+		// when there are more pages the spaces folder belongs to this one
+		// only if it does not go after the last entry.
+		key := folderpage.NewKey(spacesFolderInfo)
+		if page.ContinuationToken() == "" || len(fileInfos) == 0 || !folderpage.Less(query, folderpage.NewKey(fileInfos[len(fileInfos)-1]), key) {
+			builder.Add(key, true, spacesFolderInfo)
+		}
+	}
+	result := newFolderPage(builder)
+	// the builder only issues a token when the spaces folder pushes an entry to the next page.
+	if result.continuationToken == "" {
+		result.continuationToken = page.ContinuationToken()
+	}
+	return result, nil
+}
+
+// SetModTime sets the modification time of resources of spaces on the namespace of their storage user,
+// it requires the editor role.
+func (d *driver) SetModTime(ctx context.Context, user lib.User, path string, modTime int64) error {
+	modTimeSetter, ok := d.metaDataDriver.(lib.ModTimeSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting modification times")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual {
+		return forbiddenError("the modification time of the spaces folder can not be set")
+	}
+	if !rp.can(lib.SpaceRoleEditor) {
+		return forbiddenError("space role does not allow to update")
+	}
+	return modTimeSetter.SetModTime(ctx, rp.user, rp.path, modTime)
+}
+
+// GetChanges returns a notSupportedError when the wrapped driver has no change feed.
+func (d *driver) GetChanges(ctx context.Context, user lib.User, cursor string, limit int) (lib.ChangeSet, error) {
+	changeFeed, ok := d.metaDataDriver.(lib.ChangeFeed)
+	if !ok {
+		return nil, notSupportedError("metadata driver does not support change feeds")
+	}
+	return changeFeed.GetChanges(ctx, user, cursor, limit)
+}
+
+// SetFileID only sets the ids of resources of the user, not of the ones in spaces.
+func (d *driver) SetFileID(ctx context.Context, user lib.User, path, id string) error {
+	fileIDSetter, ok := d.metaDataDriver.(lib.FileIDSetter)
+	if !ok {
+		return notSupportedError("metadata driver does not support setting file ids")
+	}
+
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual || rp.mount != nil {
+		return forbiddenError("ids can only be set on resources of the user")
+	}
+	return fileIDSetter.SetFileID(ctx, user, rp.path, id)
+}
+
+// RecursiveSizes returns false when the wrapped driver can not compute the sizes of folders.
+func (d *driver) RecursiveSizes() bool {
+	recursiveSizer, ok := d.metaDataDriver.(lib.RecursiveSizer)
+	return ok && recursiveSizer.RecursiveSizes()
+}
+
+// Supports tells if the wrapped driver supports the optional interface.
+func (d *driver) Supports(c lib.Capability) bool {
+	return capability.Supports(d.metaDataDriver, c)
+}
+
+// Init does nothing when the wrapped driver needs no initialization.
+func (d *driver) Init(ctx context.Context, user lib.User) error {
+	initializer, ok := d.metaDataDriver.(lib.Initializer)
+	if !ok {
+		return nil
+	}
+	return initializer.Init(ctx, user)
+}
+
+func (d *driver) CreateFolder(ctx context.Context, user lib.User, path string) error {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		if isNotFoundError(err) && filepath.Dir(filepath.Clean("/"+path)) == d.spacesFolder {
+			return forbiddenError("folders can not be created in the spaces folder")
+		}
+		return err
+	}
+	if rp.virtual || rp.isMountPoint() {
+		return alreadyExistError("folder already exists")
+	}
+	if !rp.can(lib.SpaceRoleEditor) {
+		return forbiddenError("space role does not allow to create")
+	}
+	return d.metaDataDriver.CreateFolder(ctx, rp.user, rp.path)
+}
+
+func (d *driver) Delete(ctx context.Context, user lib.User, path string) error {
+	rp, err := d.resolve(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	if rp.virtual || rp.isMountPoint() {
+		return forbiddenError("spaces can only be removed by an administrator")
+	}
+	if !rp.can(lib.SpaceRoleEditor) {
+		return forbiddenError("space role does not allow to delete")
+	}
+	return d.metaDataDriver.Delete(ctx, rp.user, rp.path)
+}
+
+// Move moves resources inside the same namespace, moving resources
+// between the own namespace and a space or between spaces is forbidden.
+func (d *driver) Move(ctx context.Context, user lib.User, sourcePath, targetPath string) error {
+	source, err := d.resolve(ctx, user, sourcePath)
+	if err != nil {
+		return err
+	}
+	target, err := d.resolve(ctx, user, targetPath)
+	if err != nil && !isNotFoundError(err) {
+		return err
+	}
+	if target == nil || source.virtual || target.virtual || source.isMountPoint() || target.isMountPoint() {
+		return forbiddenError("resources of spaces can only be moved inside their space")
+	}
+
+	if source.mount == nil && target.mount == nil {
+		return d.metaDataDriver.Move(ctx, user, source.path, target.path)
+	}
+	if source.mount == nil || target.mount == nil {
+		return forbiddenError("resources can not be moved in or out of spaces")
+	}
+	if source.mount.path != target.mount.path {
+		return forbiddenError("resources can not be moved between spaces")
+	}
+	if !source.can(lib.SpaceRoleEditor) {
+		return forbiddenError("space role does not allow to move")
+	}
+	return d.metaDataDriver.Move(ctx, source.user, source.path, target.path)
+}
+
+// resolve returns the storage user and the path in its namespace for a path of the user.
+func (d *driver) resolve(ctx context.Context, user lib.User, path string) (*resolvedPath, error) {
+	path = filepath.Clean("/" + path)
+	if path != d.spacesFolder && !strings.HasPrefix(path, d.spacesFolder+"/") && path != filepath.Dir(d.spacesFolder) {
+		return &resolvedPath{user: user, path: path}, nil
+	}
+
+	mounts, err := d.getMounts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if path == d.spacesFolder {
+		return &resolvedPath{user: user, path: path, virtual: true, mounts: mounts}, nil
+	}
+	for _, m := range mounts {
+		if path == m.path || strings.HasPrefix(path, m.path+"/") {
+			return &resolvedPath{
+				user:  m.space.StorageUser(),
+				path:  filepath.Clean("/" + strings.TrimPrefix(path, m.path)),
+				mount: m,
+			}, nil
+		}
+	}
+	// the parent folder of the spaces folder.
+	if path == filepath.Dir(d.spacesFolder) {
+		return &resolvedPath{user: user, path: path, mounts: mounts}, nil
+	}
+	return nil, notFoundError("space not found")
+}
+
+// getMounts returns the spaces of the user with their mount points.
+// Name clashes are solved appending a number to the name, older spaces keep their names.
+func (d *driver) getMounts(ctx context.Context, user lib.User) ([]*mount, error) {
+	spaces, err := d.spaceDriver.ListSpaces(ctx, user, false)
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing spaces")
+		return nil, err
+	}
+
+	mounts := []*mount{}
+	names := map[string]bool{}
+	for _, space := range spaces {
+		candidate := space.Name()
+		for i := 2; names[candidate]; i++ {
+			candidate = fmt.Sprintf("%s (%d)", space.Name(), i)
+		}
+		names[candidate] = true
+		mounts = append(mounts, &mount{
+			path:  filepath.Join(d.spacesFolder, candidate),
+			space: space,
+			role:  space.Members()[user.Username()],
+		})
+	}
+	return mounts, nil
+}
+
+func (d *driver) listSpacesFolder(ctx context.Context, mounts []*mount) []lib.FileInfo {
+	fileInfos := []lib.FileInfo{}
+	for _, m := range mounts {
+		fileInfo, err := d.metaDataDriver.Examine(ctx, m.space.StorageUser(), "/")
+		if err != nil {
+			// the storage of the space may not have been initialized.
+			d.logger.Warn().Log("error", err, "msg", "space not available", "spaceid", m.space.ID())
+			continue
+		}
+		fileInfos = append(fileInfos, m.toMemberFileInfo(fileInfo))
+	}
+	return fileInfos
+}
+
+// getSpacesFolderInfo returns the virtual folder containing the spaces.
+// Its etag changes every time the etag of one of the spaces changes, so sync clients detect changes inside spaces.
+func (d *driver) getSpacesFolderInfo(ctx context.Context, user lib.User, mounts []*mount) lib.FileInfo {
+	info := &spacesFolderInfo{path: d.spacesFolder}
+	h := md5.New()
+	for _, fileInfo := range d.listSpacesFolder(ctx, mounts) {
+		info.size += fileInfo.Size()
+		if fileInfo.Modified() > info.modified {
+			info.modified = fileInfo.Modified()
+		}
+		etag, _ := fileInfo.ExtraAttributes()["etag"].(string)
+		fmt.Fprintf(h, "%s:%s\n", fileInfo.Path(), etag)
+	}
+	info.etag = fmt.Sprintf("%x", h.Sum(nil))
+	info.id = "spaces-" + user.Username()
+	return info
+}
+
+// isHidden returns true for the own resources of the user inside the spaces folder.
+func (d *driver) isHidden(path string) bool {
+	path = filepath.Clean("/" + path)
+	return path == d.spacesFolder || strings.HasPrefix(path, d.spacesFolder+"/")
+}
+
+func isNotFoundError(err error) bool {
+	codeErr, ok := err.(lib.Error)
+	return ok && codeErr.Code() == lib.CodeNotFound
+}
+
+type resolvedPath struct {
+	user    lib.User
+	path    string
+	mount   *mount
+	virtual bool
+	mounts  []*mount
+}
+
+func (rp *resolvedPath) can(role lib.SpaceRole) bool {
+	if rp.virtual {
+		return role == lib.SpaceRoleViewer
+	}
+	return rp.mount == nil || rp.mount.role >= role
+}
+
+func (rp *resolvedPath) isMountPoint() bool {
+	return rp.mount != nil && rp.path == "/"
+}
+
+// mount is a space of the user and the path where it appears.
+type mount struct {
+	path  string
+	space lib.Space
+	role  lib.SpaceRole
+}
+
+func (m *mount) toMemberPath(path string) string {
+	return filepath.Join(m.path, filepath.Clean("/"+path))
+}
+
+// toMemberFileInfo returns the file info of the storage user as seen by the member.
+// The "permissions" and "space" extra attributes are set so web services can expose them.
+// Only managers get PermissionShare, the sharing web services refuse to share space content without it.
+func (m *mount) toMemberFileInfo(fi lib.FileInfo) lib.FileInfo {
+	extraAttributes := map[string]interface{}{}
+	for k, v := range fi.ExtraAttributes() {
+		extraAttributes[k] = v
+	}
+	extraAttributes["permissions"] = rolePermissions(m.role)
+	extraAttributes["space"] = m.space.ID()
+	return &fileInfo{FileInfo: fi, path: m.toMemberPath(fi.Path()), extraAttributes: extraAttributes}
+}
+
+// rolePermissions returns the share permissions equivalent to the role, managers can share the space
+// adding members to it.
+func rolePermissions(role lib.SpaceRole) lib.SharePermission {
+	switch role {
+	case lib.SpaceRoleViewer:
+		return lib.PermissionRead
+	case lib.SpaceRoleEditor:
+		return lib.PermissionRead | lib.PermissionUpdate | lib.PermissionCreate | lib.PermissionDelete
+	case lib.SpaceRoleManager:
+		return lib.PermissionRead | lib.PermissionUpdate | lib.PermissionCreate | lib.PermissionDelete | lib.PermissionShare
+	}
+	return 0
+}
+
+type searchResult struct {
+	fileInfos []lib.FileInfo
+	hasMore   bool
+}
+
+func (r *searchResult) FileInfos() []lib.FileInfo {
+	return r.fileInfos
+}
+
+func (r *searchResult) HasMore() bool {
+	return r.hasMore
+}
+
+type folderPage struct {
+	fileInfos         []lib.FileInfo
+	continuationToken string
+}
+
+func newFolderPage(builder *folderpage.Builder) *folderPage {
+	items, continuationToken := builder.Page()
+	fileInfos := []lib.FileInfo{}
+	for _, item := range items {
+		fileInfos = append(fileInfos, item.(lib.FileInfo))
+	}
+	return &folderPage{fileInfos: fileInfos, continuationToken: continuationToken}
+}
+
+func (p *folderPage) FileInfos() []lib.FileInfo {
+	return p.fileInfos
+}
+
+func (p *folderPage) ContinuationToken() string {
+	return p.continuationToken
+}
+
+type contentSearchResult struct {
+	matches []lib.ContentMatch
+	hasMore bool
+}
+
+func (r *contentSearchResult) Matches() []lib.ContentMatch {
+	return r.matches
+}
+
+func (r *contentSearchResult) HasMore() bool {
+	return r.hasMore
+}
+
+// contentMatch is a match inside a space with the path of the member.
+type contentMatch struct {
+	lib.ContentMatch
+	path string
+}
+
+func (m *contentMatch) Path() string {
+	return m.path
+}
+
+type fileInfo struct {
+	lib.FileInfo
+	path            string
+	extraAttributes map[string]interface{}
+}
+
+func (f *fileInfo) Path() string {
+	return f.path
+}
+
+func (f *fileInfo) ExtraAttributes() map[string]interface{} {
+	return f.extraAttributes
+}
+
+type spacesFolderInfo struct {
+	path     string
+	size     int64
+	modified int64
+	etag     string
+	id       string
+}
+
+func (f *spacesFolderInfo) Path() string {
+	return f.path
+}
+
+func (f *spacesFolderInfo) Folder() bool {
+	return true
+}
+
+func (f *spacesFolderInfo) Size() int64 {
+	return f.size
+}
+
+func (f *spacesFolderInfo) Modified() int64 {
+	return f.modified
+}
+
+func (f *spacesFolderInfo) Checksum() string {
+	return ""
+}
+
+func (f *spacesFolderInfo) ExtraAttributes() map[string]interface{} {
+	return map[string]interface{}{
+		"etag":        f.etag,
+		"id":          f.id,
+		"permissions": lib.PermissionRead,
+	}
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
+
+type notSupportedError string
+
+func (e notSupportedError) Error() string {
+	return string(e)
+}
+func (e notSupportedError) Code() lib.Code {
+	return lib.Code(lib.CodeNotSupported)
+}
+func (e notSupportedError) Message() string {
+	return string(e)
+}
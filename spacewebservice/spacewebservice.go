@@ -0,0 +1,381 @@
+package spacewebservice
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type service struct {
+	cm             lib.ContextManager
+	logger         levels.Levels
+	spaceDriver    lib.SpaceDriver
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+	am             lib.AuthenticationMiddleware
+	wec            lib.WebErrorConverter
+}
+
+// New returns a web service to manage project spaces and their members.
+// dataDriver and metaDataDriver must not be space aware, they store the files of the spaces
+// on behalf of their storage users and are initialized for them when a space is created.
+// Quotas are refused when metaDataDriver does not compute the sizes of folders, they could not be enforced.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	spaceDriver lib.SpaceDriver,
+	dataDriver lib.DataDriver,
+	metaDataDriver lib.MetaDataDriver,
+	am lib.AuthenticationMiddleware,
+	wec lib.WebErrorConverter) lib.WebService {
+	return &service{
+		cm:             cm,
+		logger:         logger,
+		spaceDriver:    spaceDriver,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+		am:             am,
+		wec:            wec,
+	}
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/spaces/create": {
+			"POST": s.am.HandlerFunc(s.createEndpoint),
+		},
+		"/spaces/list": {
+			"POST": s.am.HandlerFunc(s.listEndpoint),
+		},
+		"/spaces/get": {
+			"POST": s.am.HandlerFunc(s.getEndpoint),
+		},
+		"/spaces/setquota": {
+			"POST": s.am.HandlerFunc(s.setQuotaEndpoint),
+		},
+		"/spaces/setmember": {
+			"POST": s.am.HandlerFunc(s.setMemberEndpoint),
+		},
+		"/spaces/removemember": {
+			"POST": s.am.HandlerFunc(s.removeMemberEndpoint),
+		},
+		"/spaces/delete": {
+			"POST": s.am.HandlerFunc(s.deleteEndpoint),
+		},
+	}
+}
+
+// createEndpoint creates the space and initializes the storage of its storage user
+// when the drivers need it.
+func (s *service) createEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &createRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	if err := s.checkQuota(req.Quota); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	space, err := s.spaceDriver.CreateSpace(r.Context(), user, req.Name, req.Quota, req.Manager)
+	if err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	for _, driver := range []interface{}{s.metaDataDriver, s.dataDriver} {
+		if initializer, ok := driver.(lib.Initializer); ok {
+			if err := initializer.Init(r.Context(), space.StorageUser()); err != nil {
+				logger.Error().Log("error", err, "msg", "error initializing storage of space", "spaceid", space.ID())
+				s.handleSpaceEndpointError(err, w, r)
+				return
+			}
+		}
+	}
+	logger.Info().Log("msg", "space created", "spaceid", space.ID())
+	s.writeSpace(w, r, http.StatusCreated, space)
+}
+
+func (s *service) listEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &listRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	spaces, err := s.spaceDriver.ListSpaces(r.Context(), user, req.All)
+	if err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	spaceResponses := []*spaceResponse{}
+	for _, space := range spaces {
+		spaceResponses = append(spaceResponses, spaceToSpaceResponse(space))
+	}
+	data, err := json.Marshal(spaceResponses)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *service) getEndpoint(w http.ResponseWriter, r *http.Request) {
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &idRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	space, err := s.spaceDriver.GetSpace(r.Context(), user, req.ID)
+	if err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	s.writeSpace(w, r, http.StatusOK, space)
+}
+
+func (s *service) setQuotaEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &setQuotaRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	if err := s.checkQuota(req.Quota); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	if err := s.spaceDriver.SetSpaceQuota(r.Context(), user, req.ID, req.Quota); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "space quota set", "spaceid", req.ID, "quota", req.Quota)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkQuota returns a badRequestError when a quota is set and the sizes of folders are not computed.
+func (s *service) checkQuota(quota int64) error {
+	if quota <= 0 {
+		return nil
+	}
+	if recursiveSizer, ok := s.metaDataDriver.(lib.RecursiveSizer); !ok || !recursiveSizer.RecursiveSizes() {
+		return badRequestError("quotas are not supported, the metadata driver does not compute the sizes of folders")
+	}
+	return nil
+}
+
+func (s *service) setMemberEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &setMemberRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+	role, ok := roles[req.Role]
+	if !ok {
+		s.handleSpaceEndpointError(badRequestError("role must be viewer, editor or manager"), w, r)
+		return
+	}
+
+	if err := s.spaceDriver.SetSpaceMember(r.Context(), user, req.ID, req.Member, role); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "space member set", "spaceid", req.ID, "member", req.Member, "role", req.Role)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *service) removeMemberEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &memberRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	if err := s.spaceDriver.RemoveSpaceMember(r.Context(), user, req.ID, req.Member); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "space member removed", "spaceid", req.ID, "member", req.Member)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteEndpoint removes the space, the files of its storage user are kept
+// so they can be recovered by an administrator.
+func (s *service) deleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+
+	req := &idRequest{}
+	if !s.decode(w, r, req) {
+		return
+	}
+
+	if err := s.spaceDriver.DeleteSpace(r.Context(), user, req.ID); err != nil {
+		s.handleSpaceEndpointError(err, w, r)
+		return
+	}
+	logger.Info().Log("msg", "space deleted", "spaceid", req.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decode reads the json request into v, writing the error response when it is not valid.
+func (s *service) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	logger := s.cm.MustGetLog(r.Context())
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		logger.Error().Log("error", err)
+		codeErr := badRequestError("invalid json")
+		jsonError, err := s.wec.ErrorToJSON(codeErr)
+		if err != nil {
+			logger.Error().Log("error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonError)
+		return false
+	}
+	return true
+}
+
+func (s *service) writeSpace(w http.ResponseWriter, r *http.Request, status int, space lib.Space) {
+	logger := s.cm.MustGetLog(r.Context())
+	data, err := json.Marshal(spaceToSpaceResponse(space))
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (s *service) handleSpaceEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if codeErr.Code() == lib.CodeAlreadyExist {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if codeErr.Code() == lib.CodeBadInputData {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error managing spaces")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+var roles = map[string]lib.SpaceRole{
+	"viewer":  lib.SpaceRoleViewer,
+	"editor":  lib.SpaceRoleEditor,
+	"manager": lib.SpaceRoleManager,
+}
+
+func roleToString(role lib.SpaceRole) string {
+	for name, r := range roles {
+		if r == role {
+			return name
+		}
+	}
+	return ""
+}
+
+func spaceToSpaceResponse(space lib.Space) *spaceResponse {
+	members := map[string]string{}
+	for member, role := range space.Members() {
+		members[member] = roleToString(role)
+	}
+	return &spaceResponse{
+		ID:      space.ID(),
+		Name:    space.Name(),
+		Quota:   space.Quota(),
+		Members: members,
+		Created: space.Created(),
+	}
+}
+
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+func (e badRequestError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badRequestError) Message() string {
+	return string(e)
+}
+
+type spaceResponse struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Quota   int64             `json:"quota"`
+	Members map[string]string `json:"members"`
+	Created int64             `json:"created"`
+}
+
+type createRequest struct {
+	Name    string `json:"name"`
+	Quota   int64  `json:"quota"`
+	Manager string `json:"manager"`
+}
+
+type listRequest struct {
+	All bool `json:"all"`
+}
+
+type idRequest struct {
+	ID string `json:"id"`
+}
+
+type setQuotaRequest struct {
+	ID    string `json:"id"`
+	Quota int64  `json:"quota"`
+}
+
+type setMemberRequest struct {
+	ID     string `json:"id"`
+	Member string `json:"member"`
+	Role   string `json:"role"`
+}
+
+type memberRequest struct {
+	ID     string `json:"id"`
+	Member string `json:"member"`
+}
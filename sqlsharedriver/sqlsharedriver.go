@@ -0,0 +1,669 @@
+package sqlsharedriver
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/go-kit/kit/log/levels"
+	_ "github.com/go-sql-Driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// tokenLength and tokenAlphabet follow the format of the ownCloud link tokens.
+	tokenLength   = 15
+	tokenAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// linkShareRecord is the link share stored on the SQL database.
+type linkShareRecord struct {
+	ID    string `gorm:"primary_key"`
+	Token string `sql:"unique_index"`
+
+	// Owner is the username of the owner, the rest of the owner
+	// attributes are kept to resolve the token without the user driver.
+	Owner            string `sql:"index"`
+	OwnerEmail       string
+	OwnerDisplayName string
+
+	// Path is the path of the shared resource relative to the home of the owner.
+	// FileID is the ID of the resource, the path is updated from it when the owner moves the resource.
+	Path         string `sql:"index"`
+	FileID       string `sql:"index"`
+	Permissions  lib.SharePermission
+	PasswordHash string
+	Expiration   int64
+	Created      int64
+}
+
+// TableName returns the name of the SQL table.
+func (r *linkShareRecord) TableName() string { return "link_shares" }
+
+// userShareRecord is the share with a user or a group stored on the SQL database.
+type userShareRecord struct {
+	ID               string `gorm:"primary_key"`
+	Owner            string `sql:"index"`
+	OwnerEmail       string
+	OwnerDisplayName string
+	Path             string `sql:"index"`
+	FileID           string `sql:"index"`
+	Recipient        string `sql:"index"`
+	RecipientType    lib.ShareRecipientType
+	Permissions      lib.SharePermission
+	Created          int64
+}
+
+// TableName returns the name of the SQL table.
+func (r *userShareRecord) TableName() string { return "user_shares" }
+
+type driver struct {
+	logger         levels.Levels
+	metaDataDriver lib.MetaDataDriver
+	db             *gorm.DB
+}
+
+// New returns an implementation of ShareDriver that keeps the shares on a SQL database.
+// Shares keep the ID of the resource, when metaDataDriver can find resources by ID the shares follow
+// the resources moved by their owners. metaDataDriver must not be share aware.
+func New(logger levels.Levels, metaDataDriver lib.MetaDataDriver, maxSQLIdleConnections, maxSQLConcurrentConnections int, dsn string) (lib.ShareDriver, error) {
+	logger = logger.With("pkg", "sqlsharedriver")
+	db, err := gorm.Open("mysql", dsn)
+	if err != nil {
+		logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	logger.Info().Log("maxidle", maxSQLIdleConnections, "maxopen", maxSQLConcurrentConnections)
+	db.LogMode(false)
+	db.DB().SetMaxIdleConns(maxSQLIdleConnections)
+	db.DB().SetMaxOpenConns(maxSQLConcurrentConnections)
+
+	if err := db.AutoMigrate(&linkShareRecord{}, &userShareRecord{}).Error; err != nil {
+		return nil, err
+	}
+
+	return &driver{logger: logger, metaDataDriver: metaDataDriver, db: db}, nil
+}
+
+// CreateLinkShare creates a link share for the resource at path.
+// An empty password creates a link without password and a zero expiration a link that never expires.
+func (d *driver) CreateLinkShare(ctx context.Context, user lib.User, path string, permissions lib.SharePermission, password string, expiration int64) (lib.LinkShare, error) {
+	fileID, err := d.getFileID(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &linkShareRecord{
+		ID:               uuid.NewV4().String(),
+		Token:            token,
+		Owner:            user.Username(),
+		OwnerEmail:       user.Email(),
+		OwnerDisplayName: user.DisplayName(),
+		Path:             filepath.Clean("/" + path),
+		FileID:           fileID,
+		Permissions:      permissions,
+		Expiration:       expiration,
+		Created:          time.Now().Unix(),
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		rec.PasswordHash = string(hash)
+	}
+
+	if err := d.db.Create(rec).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error creating link share")
+		return nil, err
+	}
+	d.logger.Info().Log("msg", "link share created", "id", rec.ID, "owner", rec.Owner, "path", rec.Path, "permissions", rec.Permissions)
+	return recordToLinkShare(rec), nil
+}
+
+func (d *driver) GetLinkShare(ctx context.Context, user lib.User, id string) (lib.LinkShare, error) {
+	rec, err := d.getRecord(user, id)
+	if err != nil {
+		return nil, err
+	}
+	d.resolveLinkShare(ctx, rec)
+	return recordToLinkShare(rec), nil
+}
+
+// ListLinkShares returns the link shares of the user, only the ones for path if path is not empty.
+func (d *driver) ListLinkShares(ctx context.Context, user lib.User, path string) ([]lib.LinkShare, error) {
+	var recs []linkShareRecord
+	query, err := d.whereResource(ctx, d.db.Where("owner=?", user.Username()), user, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.Order("created").Find(&recs).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing link shares")
+		return nil, err
+	}
+
+	shares := []lib.LinkShare{}
+	for i := range recs {
+		if path != "" {
+			recs[i].Path = filepath.Clean("/" + path)
+		} else {
+			d.resolveLinkShare(ctx, &recs[i])
+		}
+		shares = append(shares, recordToLinkShare(&recs[i]))
+	}
+	return shares, nil
+}
+
+func (d *driver) SetLinkSharePermissions(ctx context.Context, user lib.User, id string, permissions lib.SharePermission) error {
+	return d.update(user, id, "permissions", permissions)
+}
+
+// SetLinkSharePassword sets the password of the link share, an empty password removes it.
+func (d *driver) SetLinkSharePassword(ctx context.Context, user lib.User, id, password string) error {
+	var hash string
+	if password != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hash = string(h)
+	}
+	return d.update(user, id, "password_hash", hash)
+}
+
+// SetLinkShareExpiration sets the expiration of the link share, zero removes it.
+func (d *driver) SetLinkShareExpiration(ctx context.Context, user lib.User, id string, expiration int64) error {
+	return d.update(user, id, "expiration", expiration)
+}
+
+func (d *driver) RevokeLinkShare(ctx context.Context, user lib.User, id string) error {
+	res := d.db.Where("id=? AND owner=?", id, user.Username()).Delete(&linkShareRecord{})
+	if res.Error != nil {
+		d.logger.Error().Log("error", res.Error, "msg", "error revoking link share")
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return notFoundError("share not found")
+	}
+	d.logger.Info().Log("msg", "link share revoked", "id", id, "owner", user.Username())
+	return nil
+}
+
+// ResolveLinkShare returns the link share for the token.
+// Expired links are reported as not found, so their existence is not leaked.
+func (d *driver) ResolveLinkShare(ctx context.Context, token, password string) (lib.LinkShare, error) {
+	rec := &linkShareRecord{}
+	err := d.db.Where("token=?", token).First(rec).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("share not found")
+		}
+		d.logger.Error().Log("error", err, "msg", "error resolving link share")
+		return nil, err
+	}
+
+	if rec.Expiration > 0 && rec.Expiration < time.Now().Unix() {
+		d.logger.Info().Log("msg", "link share expired", "id", rec.ID)
+		return nil, notFoundError("share not found")
+	}
+
+	if rec.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(password)); err != nil {
+			d.logger.Warn().Log("msg", "invalid link share password", "id", rec.ID)
+			return nil, unauthorizedError("invalid password")
+		}
+	}
+	d.resolveLinkShare(ctx, rec)
+	return recordToLinkShare(rec), nil
+}
+
+// CreateUserShare shares the resource at path with a user or with all the members of a group.
+func (d *driver) CreateUserShare(ctx context.Context, user lib.User, path, recipient string, recipientType lib.ShareRecipientType, permissions lib.SharePermission) (lib.UserShare, error) {
+	if recipientType == lib.ShareRecipientUser && recipient == user.Username() {
+		return nil, badInputError("can not share with yourself")
+	}
+
+	path = filepath.Clean("/" + path)
+	fileID, err := d.getFileID(ctx, user, path)
+	if err != nil {
+		return nil, err
+	}
+	query, err := d.whereResource(ctx, d.db.Model(&userShareRecord{}).Where("owner=? AND recipient=? AND recipient_type=?", user.Username(), recipient, recipientType), user, path)
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	if err := query.Count(&count).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error checking user share")
+		return nil, err
+	}
+	if count > 0 {
+		return nil, alreadyExistError("resource already shared with " + recipient)
+	}
+
+	rec := &userShareRecord{
+		ID:               uuid.NewV4().String(),
+		Owner:            user.Username(),
+		OwnerEmail:       user.Email(),
+		OwnerDisplayName: user.DisplayName(),
+		Path:             path,
+		FileID:           fileID,
+		Recipient:        recipient,
+		RecipientType:    recipientType,
+		Permissions:      permissions,
+		Created:          time.Now().Unix(),
+	}
+	if err := d.db.Create(rec).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error creating user share")
+		return nil, err
+	}
+	d.logger.Info().Log("msg", "user share created", "id", rec.ID, "owner", rec.Owner, "path", rec.Path, "recipient", rec.Recipient, "recipienttype", rec.RecipientType, "permissions", rec.Permissions)
+	return recordToUserShare(rec), nil
+}
+
+func (d *driver) GetUserShare(ctx context.Context, user lib.User, id string) (lib.UserShare, error) {
+	rec, err := d.getUserShareRecord(user, id)
+	if err != nil {
+		return nil, err
+	}
+	d.resolveUserShare(ctx, rec)
+	return recordToUserShare(rec), nil
+}
+
+func (d *driver) ListUserShares(ctx context.Context, user lib.User, path string) ([]lib.UserShare, error) {
+	query, err := d.whereResource(ctx, d.db.Where("owner=?", user.Username()), user, path)
+	if err != nil {
+		return nil, err
+	}
+	return d.findUserShares(ctx, query, path)
+}
+
+// ListReceivedShares returns the shares with the user and with the groups
+// found in the "groups" extra attribute of the user.
+func (d *driver) ListReceivedShares(ctx context.Context, user lib.User) ([]lib.UserShare, error) {
+	groups, _ := user.ExtraAttributes()["groups"].([]string)
+	query := d.db.Where("owner<>?", user.Username())
+	if len(groups) > 0 {
+		query = query.Where("(recipient_type=? AND recipient=?) OR (recipient_type=? AND recipient IN (?))",
+			lib.ShareRecipientUser, user.Username(), lib.ShareRecipientGroup, groups)
+	} else {
+		query = query.Where("recipient_type=? AND recipient=?", lib.ShareRecipientUser, user.Username())
+	}
+	return d.findUserShares(ctx, query, "")
+}
+
+func (d *driver) SetUserSharePermissions(ctx context.Context, user lib.User, id string, permissions lib.SharePermission) error {
+	if _, err := d.getUserShareRecord(user, id); err != nil {
+		return err
+	}
+	err := d.db.Model(&userShareRecord{}).Where("id=? AND owner=?", id, user.Username()).Update("permissions", permissions).Error
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating user share")
+		return err
+	}
+	d.logger.Info().Log("msg", "user share updated", "id", id, "permissions", permissions)
+	return nil
+}
+
+func (d *driver) RevokeUserShare(ctx context.Context, user lib.User, id string) error {
+	res := d.db.Where("id=? AND owner=?", id, user.Username()).Delete(&userShareRecord{})
+	if res.Error != nil {
+		d.logger.Error().Log("error", res.Error, "msg", "error revoking user share")
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return notFoundError("share not found")
+	}
+	d.logger.Info().Log("msg", "user share revoked", "id", id, "owner", user.Username())
+	return nil
+}
+
+// findUserShares returns the shares found by query, all of them of the resource at path if path is not empty.
+func (d *driver) findUserShares(ctx context.Context, query *gorm.DB, path string) ([]lib.UserShare, error) {
+	var recs []userShareRecord
+	if err := query.Order("created").Find(&recs).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing user shares")
+		return nil, err
+	}
+
+	shares := []lib.UserShare{}
+	for i := range recs {
+		if path != "" {
+			recs[i].Path = filepath.Clean("/" + path)
+		} else {
+			d.resolveUserShare(ctx, &recs[i])
+		}
+		shares = append(shares, recordToUserShare(&recs[i]))
+	}
+	return shares, nil
+}
+
+// getFileID returns the ID of the resource at path, empty when the metadata driver does not expose IDs.
+func (d *driver) getFileID(ctx context.Context, user lib.User, path string) (string, error) {
+	fileInfo, err := d.metaDataDriver.Examine(ctx, user, path)
+	if err != nil {
+		return "", err
+	}
+	id, _ := fileInfo.ExtraAttributes()["id"].(string)
+	return id, nil
+}
+
+// whereResource restricts query to the shares of the resource at path, found by its ID,
+// or by its path for the shares created without one. An empty path does not restrict the query.
+func (d *driver) whereResource(ctx context.Context, query *gorm.DB, user lib.User, path string) (*gorm.DB, error) {
+	if path == "" {
+		return query, nil
+	}
+	path = filepath.Clean("/" + path)
+	fileID, err := d.getFileID(ctx, user, path)
+	if err != nil {
+		if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeNotFound {
+			return query.Where("path=? AND file_id=?", path, ""), nil
+		}
+		return nil, err
+	}
+	if fileID == "" {
+		return query.Where("path=?", path), nil
+	}
+	return query.Where("file_id=? OR (file_id=? AND path=?)", fileID, "", path), nil
+}
+
+// resolvePath returns the current path of the shared resource with the given ID in the home of owner.
+// The stored path is returned when the resource is still there, when it can not be found by ID,
+// as the owner may have removed it, or when the share has no ID.
+func (d *driver) resolvePath(ctx context.Context, owner lib.User, path, fileID string) string {
+	if fileID == "" {
+		return path
+	}
+	if fileInfo, err := d.metaDataDriver.Examine(ctx, owner, path); err == nil {
+		if id, _ := fileInfo.ExtraAttributes()["id"].(string); id == fileID {
+			return path
+		}
+	}
+	fileIDResolver, ok := d.metaDataDriver.(lib.FileIDResolver)
+	if !ok || !capability.Supports(d.metaDataDriver, lib.CapabilityFileIDResolver) {
+		return path
+	}
+	fileInfo, err := fileIDResolver.ExamineByID(ctx, owner, fileID)
+	if err != nil {
+		d.logger.Warn().Log("msg", "shared resource not found", "owner", owner.Username(), "path", path, "fileid", fileID, "error", err)
+		return path
+	}
+	return filepath.Clean("/" + fileInfo.Path())
+}
+
+// resolveLinkShare updates the path of the link share when the owner has moved the resource.
+func (d *driver) resolveLinkShare(ctx context.Context, rec *linkShareRecord) {
+	owner := &user{username: rec.Owner, email: rec.OwnerEmail, displayName: rec.OwnerDisplayName}
+	path := d.resolvePath(ctx, owner, rec.Path, rec.FileID)
+	if path == rec.Path {
+		return
+	}
+	if err := d.db.Model(&linkShareRecord{}).Where("id=?", rec.ID).Update("path", path).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating path of link share")
+	}
+	d.logger.Info().Log("msg", "link share follows moved resource", "id", rec.ID, "source", rec.Path, "target", path)
+	rec.Path = path
+}
+
+// resolveUserShare updates the path of the user share when the owner has moved the resource.
+func (d *driver) resolveUserShare(ctx context.Context, rec *userShareRecord) {
+	owner := &user{username: rec.Owner, email: rec.OwnerEmail, displayName: rec.OwnerDisplayName}
+	path := d.resolvePath(ctx, owner, rec.Path, rec.FileID)
+	if path == rec.Path {
+		return
+	}
+	if err := d.db.Model(&userShareRecord{}).Where("id=?", rec.ID).Update("path", path).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating path of user share")
+	}
+	d.logger.Info().Log("msg", "user share follows moved resource", "id", rec.ID, "source", rec.Path, "target", path)
+	rec.Path = path
+}
+
+func (d *driver) getUserShareRecord(user lib.User, id string) (*userShareRecord, error) {
+	rec := &userShareRecord{}
+	err := d.db.Where("id=? AND owner=?", id, user.Username()).First(rec).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("share not found")
+		}
+		d.logger.Error().Log("error", err, "msg", "error getting user share")
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (d *driver) getRecord(user lib.User, id string) (*linkShareRecord, error) {
+	rec := &linkShareRecord{}
+	err := d.db.Where("id=? AND owner=?", id, user.Username()).First(rec).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("share not found")
+		}
+		d.logger.Error().Log("error", err, "msg", "error getting link share")
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (d *driver) update(user lib.User, id, column string, value interface{}) error {
+	if _, err := d.getRecord(user, id); err != nil {
+		return err
+	}
+	err := d.db.Model(&linkShareRecord{}).Where("id=? AND owner=?", id, user.Username()).Update(column, value).Error
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating link share", "column", column)
+		return err
+	}
+	d.logger.Info().Log("msg", "link share updated", "id", id, "column", column)
+	return nil
+}
+
+func newToken() (string, error) {
+	max := big.NewInt(int64(len(tokenAlphabet)))
+	token := make([]string, tokenLength)
+	for i := range token {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		token[i] = string(tokenAlphabet[n.Int64()])
+	}
+	return strings.Join(token, ""), nil
+}
+
+func recordToLinkShare(rec *linkShareRecord) lib.LinkShare {
+	return &linkShare{
+		id:    rec.ID,
+		token: rec.Token,
+		owner: &user{
+			username:    rec.Owner,
+			email:       rec.OwnerEmail,
+			displayName: rec.OwnerDisplayName,
+		},
+		path:        rec.Path,
+		permissions: rec.Permissions,
+		hasPassword: rec.PasswordHash != "",
+		expiration:  rec.Expiration,
+		created:     rec.Created,
+	}
+}
+
+func recordToUserShare(rec *userShareRecord) lib.UserShare {
+	return &userShare{
+		id: rec.ID,
+		owner: &user{
+			username:    rec.Owner,
+			email:       rec.OwnerEmail,
+			displayName: rec.OwnerDisplayName,
+		},
+		path:          rec.Path,
+		recipient:     rec.Recipient,
+		recipientType: rec.RecipientType,
+		permissions:   rec.Permissions,
+		created:       rec.Created,
+	}
+}
+
+type linkShare struct {
+	id          string
+	token       string
+	owner       lib.User
+	path        string
+	permissions lib.SharePermission
+	hasPassword bool
+	expiration  int64
+	created     int64
+}
+
+func (s *linkShare) ID() string {
+	return s.id
+}
+
+func (s *linkShare) Token() string {
+	return s.token
+}
+
+func (s *linkShare) Owner() lib.User {
+	return s.owner
+}
+
+func (s *linkShare) Path() string {
+	return s.path
+}
+
+func (s *linkShare) Permissions() lib.SharePermission {
+	return s.permissions
+}
+
+func (s *linkShare) HasPassword() bool {
+	return s.hasPassword
+}
+
+func (s *linkShare) Expiration() int64 {
+	return s.expiration
+}
+
+func (s *linkShare) Created() int64 {
+	return s.created
+}
+
+type userShare struct {
+	id            string
+	owner         lib.User
+	path          string
+	recipient     string
+	recipientType lib.ShareRecipientType
+	permissions   lib.SharePermission
+	created       int64
+}
+
+func (s *userShare) ID() string {
+	return s.id
+}
+
+func (s *userShare) Owner() lib.User {
+	return s.owner
+}
+
+func (s *userShare) Path() string {
+	return s.path
+}
+
+func (s *userShare) Recipient() string {
+	return s.recipient
+}
+
+func (s *userShare) RecipientType() lib.ShareRecipientType {
+	return s.recipientType
+}
+
+func (s *userShare) Permissions() lib.SharePermission {
+	return s.permissions
+}
+
+func (s *userShare) Created() int64 {
+	return s.created
+}
+
+type user struct {
+	username    string
+	email       string
+	displayName string
+}
+
+func (u *user) Username() string {
+	return u.username
+}
+
+func (u *user) Email() string {
+	return u.email
+}
+
+func (u *user) DisplayName() string {
+	return u.displayName
+}
+
+func (u *user) ExtraAttributes() map[string]interface{} {
+	return nil
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type unauthorizedError string
+
+func (e unauthorizedError) Error() string {
+	return string(e)
+}
+func (e unauthorizedError) Code() lib.Code {
+	return lib.Code(lib.CodeUnauthorized)
+}
+func (e unauthorizedError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type alreadyExistError string
+
+func (e alreadyExistError) Error() string {
+	return string(e)
+}
+func (e alreadyExistError) Code() lib.Code {
+	return lib.Code(lib.CodeAlreadyExist)
+}
+func (e alreadyExistError) Message() string {
+	return string(e)
+}
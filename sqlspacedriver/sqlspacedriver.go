@@ -0,0 +1,433 @@
+package sqlspacedriver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+	_ "github.com/go-sql-Driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+)
+
+// storageUserPrefix is prepended to the id of a space to get the username its resources are stored for,
+// real usernames must not start with it.
+const storageUserPrefix = "space-"
+
+// spaceRecord is the space stored on the SQL database.
+type spaceRecord struct {
+	ID      string `gorm:"primary_key"`
+	Name    string `sql:"index"`
+	Quota   int64
+	Created int64
+}
+
+// TableName returns the name of the SQL table.
+func (r *spaceRecord) TableName() string { return "spaces" }
+
+// spaceMemberRecord is a member of a space stored on the SQL database.
+type spaceMemberRecord struct {
+	SpaceID  string `gorm:"primary_key"`
+	Username string `gorm:"primary_key" sql:"index"`
+	Role     lib.SpaceRole
+}
+
+// TableName returns the name of the SQL table.
+func (r *spaceMemberRecord) TableName() string { return "space_members" }
+
+type driver struct {
+	logger         levels.Levels
+	db             *gorm.DB
+	administrators map[string]bool
+}
+
+// New returns an implementation of SpaceDriver that keeps the spaces on a SQL database.
+// administrators is a comma separated list of the usernames allowed to create and delete spaces and to set their quotas.
+func New(logger levels.Levels, maxSQLIdleConnections, maxSQLConcurrentConnections int, dsn string, administrators string) (lib.SpaceDriver, error) {
+	logger = logger.With("pkg", "sqlspacedriver")
+	db, err := gorm.Open("mysql", dsn)
+	if err != nil {
+		logger.Error().Log("error", err)
+		return nil, err
+	}
+
+	logger.Info().Log("maxidle", maxSQLIdleConnections, "maxopen", maxSQLConcurrentConnections)
+	db.LogMode(false)
+	db.DB().SetMaxIdleConns(maxSQLIdleConnections)
+	db.DB().SetMaxOpenConns(maxSQLConcurrentConnections)
+
+	if err := db.AutoMigrate(&spaceRecord{}, &spaceMemberRecord{}).Error; err != nil {
+		return nil, err
+	}
+
+	admins := map[string]bool{}
+	for _, username := range strings.Split(administrators, ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			admins[username] = true
+		}
+	}
+	return &driver{logger: logger, db: db, administrators: admins}, nil
+}
+
+func (d *driver) CreateSpace(ctx context.Context, user lib.User, name string, quota int64, manager string) (lib.Space, error) {
+	if !d.administrators[user.Username()] {
+		return nil, forbiddenError("only administrators can create spaces")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, "/") || name == "." || name == ".." {
+		return nil, badInputError("invalid space name")
+	}
+	if quota < 0 {
+		return nil, badInputError("invalid quota")
+	}
+	if manager == "" {
+		manager = user.Username()
+	}
+
+	rec := &spaceRecord{
+		ID:      uuid.NewV4().String(),
+		Name:    name,
+		Quota:   quota,
+		Created: time.Now().Unix(),
+	}
+	member := &spaceMemberRecord{SpaceID: rec.ID, Username: manager, Role: lib.SpaceRoleManager}
+	tx := d.db.Begin()
+	if err := tx.Create(rec).Error; err != nil {
+		tx.Rollback()
+		d.logger.Error().Log("error", err, "msg", "error creating space")
+		return nil, err
+	}
+	if err := tx.Create(member).Error; err != nil {
+		tx.Rollback()
+		d.logger.Error().Log("error", err, "msg", "error adding space manager")
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error creating space")
+		return nil, err
+	}
+	d.logger.Info().Log("msg", "space created", "id", rec.ID, "name", rec.Name, "quota", rec.Quota, "manager", manager)
+	return recordToSpace(rec, []spaceMemberRecord{*member}), nil
+}
+
+func (d *driver) GetSpace(ctx context.Context, user lib.User, id string) (lib.Space, error) {
+	rec, members, err := d.getSpaceRecords(id)
+	if err != nil {
+		return nil, err
+	}
+	space := recordToSpace(rec, members)
+	if !d.administrators[user.Username()] && space.Members()[user.Username()] == 0 {
+		return nil, notFoundError("space not found")
+	}
+	return space, nil
+}
+
+func (d *driver) ListSpaces(ctx context.Context, user lib.User, all bool) ([]lib.Space, error) {
+	query := d.db
+	if all {
+		if !d.administrators[user.Username()] {
+			return nil, forbiddenError("only administrators can list all spaces")
+		}
+	} else {
+		query = query.Where("id IN (?)", d.db.Table("space_members").Select("space_id").Where("username=?", user.Username()).QueryExpr())
+	}
+
+	var recs []spaceRecord
+	if err := query.Order("created").Find(&recs).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing spaces")
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return []lib.Space{}, nil
+	}
+
+	ids := []string{}
+	for _, rec := range recs {
+		ids = append(ids, rec.ID)
+	}
+	var memberRecs []spaceMemberRecord
+	if err := d.db.Where("space_id IN (?)", ids).Find(&memberRecs).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error listing space members")
+		return nil, err
+	}
+	members := map[string][]spaceMemberRecord{}
+	for _, member := range memberRecs {
+		members[member.SpaceID] = append(members[member.SpaceID], member)
+	}
+
+	spaces := []lib.Space{}
+	for i := range recs {
+		spaces = append(spaces, recordToSpace(&recs[i], members[recs[i].ID]))
+	}
+	return spaces, nil
+}
+
+func (d *driver) SetSpaceQuota(ctx context.Context, user lib.User, id string, quota int64) error {
+	if !d.administrators[user.Username()] {
+		return forbiddenError("only administrators can set the quota of spaces")
+	}
+	if quota < 0 {
+		return badInputError("invalid quota")
+	}
+	if _, _, err := d.getSpaceRecords(id); err != nil {
+		return err
+	}
+	if err := d.db.Model(&spaceRecord{}).Where("id=?", id).Update("quota", quota).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating space quota")
+		return err
+	}
+	d.logger.Info().Log("msg", "space quota updated", "id", id, "quota", quota)
+	return nil
+}
+
+// SetSpaceMember does not allow to demote the last manager of the space.
+func (d *driver) SetSpaceMember(ctx context.Context, user lib.User, id, member string, role lib.SpaceRole) error {
+	if role < lib.SpaceRoleViewer || role > lib.SpaceRoleManager {
+		return badInputError("invalid role")
+	}
+	if member == "" {
+		return badInputError("invalid member")
+	}
+	space, err := d.getManagedSpace(user, id)
+	if err != nil {
+		return err
+	}
+
+	current, ok := space.members[member]
+	if !ok {
+		rec := &spaceMemberRecord{SpaceID: id, Username: member, Role: role}
+		if err := d.db.Create(rec).Error; err != nil {
+			d.logger.Error().Log("error", err, "msg", "error adding space member")
+			return err
+		}
+		d.logger.Info().Log("msg", "space member added", "id", id, "member", member, "role", role)
+		return nil
+	}
+
+	if current == lib.SpaceRoleManager && role != lib.SpaceRoleManager && space.countManagers() == 1 {
+		return badInputError("a space must have a manager")
+	}
+	err = d.db.Model(&spaceMemberRecord{}).Where("space_id=? AND username=?", id, member).Update("role", role).Error
+	if err != nil {
+		d.logger.Error().Log("error", err, "msg", "error updating space member")
+		return err
+	}
+	d.logger.Info().Log("msg", "space member updated", "id", id, "member", member, "role", role)
+	return nil
+}
+
+// RemoveSpaceMember does not allow to remove the last manager of the space.
+func (d *driver) RemoveSpaceMember(ctx context.Context, user lib.User, id, member string) error {
+	var space *space
+	if member == user.Username() {
+		rec, members, err := d.getSpaceRecords(id)
+		if err != nil {
+			return err
+		}
+		space = recordToSpace(rec, members)
+	} else {
+		s, err := d.getManagedSpace(user, id)
+		if err != nil {
+			return err
+		}
+		space = s
+	}
+
+	role, ok := space.members[member]
+	if !ok {
+		return notFoundError("member not found")
+	}
+	if role == lib.SpaceRoleManager && space.countManagers() == 1 {
+		return badInputError("a space must have a manager")
+	}
+	if err := d.db.Where("space_id=? AND username=?", id, member).Delete(&spaceMemberRecord{}).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error removing space member")
+		return err
+	}
+	d.logger.Info().Log("msg", "space member removed", "id", id, "member", member)
+	return nil
+}
+
+// DeleteSpace removes the space and its members, the resources of the space are kept
+// in the storage of its storage user.
+func (d *driver) DeleteSpace(ctx context.Context, user lib.User, id string) error {
+	if !d.administrators[user.Username()] {
+		return forbiddenError("only administrators can delete spaces")
+	}
+	if _, _, err := d.getSpaceRecords(id); err != nil {
+		return err
+	}
+
+	tx := d.db.Begin()
+	if err := tx.Where("space_id=?", id).Delete(&spaceMemberRecord{}).Error; err != nil {
+		tx.Rollback()
+		d.logger.Error().Log("error", err, "msg", "error removing space members")
+		return err
+	}
+	if err := tx.Where("id=?", id).Delete(&spaceRecord{}).Error; err != nil {
+		tx.Rollback()
+		d.logger.Error().Log("error", err, "msg", "error deleting space")
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error deleting space")
+		return err
+	}
+	d.logger.Info().Log("msg", "space deleted", "id", id, "storageuser", storageUserPrefix+id)
+	return nil
+}
+
+// getManagedSpace returns the space if the user is an administrator or a manager of it.
+// Spaces the user is not a member of are reported as not found, so their existence is not leaked.
+func (d *driver) getManagedSpace(user lib.User, id string) (*space, error) {
+	rec, members, err := d.getSpaceRecords(id)
+	if err != nil {
+		return nil, err
+	}
+	space := recordToSpace(rec, members)
+	if d.administrators[user.Username()] {
+		return space, nil
+	}
+	switch space.members[user.Username()] {
+	case lib.SpaceRoleManager:
+		return space, nil
+	case 0:
+		return nil, notFoundError("space not found")
+	default:
+		return nil, forbiddenError("only managers can change the members of the space")
+	}
+}
+
+func (d *driver) getSpaceRecords(id string) (*spaceRecord, []spaceMemberRecord, error) {
+	rec := &spaceRecord{}
+	err := d.db.Where("id=?", id).First(rec).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, notFoundError("space not found")
+		}
+		d.logger.Error().Log("error", err, "msg", "error getting space")
+		return nil, nil, err
+	}
+
+	var members []spaceMemberRecord
+	if err := d.db.Where("space_id=?", id).Find(&members).Error; err != nil {
+		d.logger.Error().Log("error", err, "msg", "error getting space members")
+		return nil, nil, err
+	}
+	return rec, members, nil
+}
+
+func recordToSpace(rec *spaceRecord, memberRecs []spaceMemberRecord) *space {
+	members := map[string]lib.SpaceRole{}
+	for _, member := range memberRecs {
+		members[member.Username] = member.Role
+	}
+	return &space{
+		id:      rec.ID,
+		name:    rec.Name,
+		quota:   rec.Quota,
+		members: members,
+		created: rec.Created,
+	}
+}
+
+type space struct {
+	id      string
+	name    string
+	quota   int64
+	members map[string]lib.SpaceRole
+	created int64
+}
+
+func (s *space) ID() string {
+	return s.id
+}
+
+func (s *space) Name() string {
+	return s.name
+}
+
+func (s *space) Quota() int64 {
+	return s.quota
+}
+
+func (s *space) Members() map[string]lib.SpaceRole {
+	return s.members
+}
+
+func (s *space) StorageUser() lib.User {
+	return &user{username: storageUserPrefix + s.id, displayName: s.name}
+}
+
+func (s *space) Created() int64 {
+	return s.created
+}
+
+func (s *space) countManagers() int {
+	managers := 0
+	for _, role := range s.members {
+		if role == lib.SpaceRoleManager {
+			managers++
+		}
+	}
+	return managers
+}
+
+type user struct {
+	username    string
+	displayName string
+}
+
+func (u *user) Username() string {
+	return u.username
+}
+
+func (u *user) Email() string {
+	return ""
+}
+
+func (u *user) DisplayName() string {
+	return u.displayName
+}
+
+func (u *user) ExtraAttributes() map[string]interface{} {
+	return nil
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+func (e forbiddenError) Code() lib.Code {
+	return lib.Code(lib.CodeForbidden)
+}
+func (e forbiddenError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,189 @@
+package streamarchiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type archiver struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+}
+
+// New returns an implementation of Archiver that streams the archive while it is built,
+// reading the tree with metaDataDriver and the contents with dataDriver, so nothing is staged on disk.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver) lib.Archiver {
+	logger = logger.With("pkg", "streamarchiver")
+	return &archiver{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+	}
+}
+
+// Archive writes the archive to w.
+// The paths are examined before anything is written, so the caller can still report
+// a not found resource to its client, errors after that leave a truncated archive.
+func (a *archiver) Archive(ctx context.Context, user lib.User, paths []string, format lib.ArchiveFormat, w io.Writer) error {
+	if len(paths) == 0 {
+		return badInputError("no paths to archive")
+	}
+
+	var aw archiveWriter
+	switch format {
+	case lib.ArchiveFormatZIP:
+		aw = &zipWriter{zw: zip.NewWriter(w)}
+	case lib.ArchiveFormatTarGz:
+		gw := gzip.NewWriter(w)
+		aw = &tarGzWriter{gw: gw, tw: tar.NewWriter(gw)}
+	default:
+		return badInputError(fmt.Sprintf("archive format %d not supported", format))
+	}
+
+	fileInfos := []lib.FileInfo{}
+	for _, p := range paths {
+		fileInfo, err := a.metaDataDriver.Examine(ctx, user, p)
+		if err != nil {
+			return err
+		}
+		fileInfos = append(fileInfos, fileInfo)
+	}
+
+	for _, fileInfo := range fileInfos {
+		// the contents of the root folder go to the top of the archive.
+		var name string
+		if p := filepath.Clean("/" + fileInfo.Path()); p != "/" {
+			name = filepath.Base(p)
+		}
+		if err := a.add(ctx, user, aw, fileInfo, name); err != nil {
+			a.logger.Error().Log("error", err, "msg", "error adding resource to archive", "path", fileInfo.Path())
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// add adds the resource to the archive under name, folders are walked recursively.
+func (a *archiver) add(ctx context.Context, user lib.User, aw archiveWriter, fileInfo lib.FileInfo, name string) error {
+	// stop as soon as the client goes away.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	modified := time.Unix(0, fileInfo.Modified())
+	if !fileInfo.Folder() {
+		readCloser, err := a.dataDriver.DownloadFile(ctx, user, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		defer readCloser.Close()
+		return aw.writeFile(filepath.ToSlash(name), fileInfo.Size(), modified, readCloser)
+	}
+
+	if name != "" {
+		if err := aw.writeFolder(filepath.ToSlash(name), modified); err != nil {
+			return err
+		}
+	}
+	children, err := a.metaDataDriver.ListFolder(ctx, user, fileInfo.Path())
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := a.add(ctx, user, aw, child, filepath.Join(name, filepath.Base(child.Path()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type archiveWriter interface {
+	writeFolder(name string, modified time.Time) error
+	writeFile(name string, size int64, modified time.Time, r io.Reader) error
+	Close() error
+}
+
+// zipWriter writes ZIP archives.
+// Sizes are written in data descriptors after the contents, so they do not need to
+// be known in advance, and Zip64 records are added when the archive is over the ZIP limits.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) writeFolder(name string, modified time.Time) error {
+	header := &zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: modified}
+	header.SetMode(os.ModeDir | 0755)
+	_, err := z.zw.CreateHeader(header)
+	return err
+}
+
+func (z *zipWriter) writeFile(name string, size int64, modified time.Time, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}
+	header.SetMode(0644)
+	fw, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarGzWriter writes TAR archives compressed with gzip.
+type tarGzWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) writeFolder(name string, modified time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755, ModTime: modified})
+}
+
+// writeFile writes the file, TAR headers contain the size so a file that
+// changes size while it is archived makes the archive fail.
+func (t *tarGzWriter) writeFile(name string, size int64, modified time.Time, r io.Reader) error {
+	if err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: modified}); err != nil {
+		return err
+	}
+	n, err := io.Copy(t.tw, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("file %s changed while being archived: expected %d bytes, got %d", name, size, n)
+	}
+	return nil
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gw.Close()
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
@@ -0,0 +1,402 @@
+package userexporter
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clawio/lib"
+	"github.com/clawio/lib/capability"
+	"github.com/go-kit/kit/log/levels"
+)
+
+const (
+	// manifestName is the first entry of the archive, it describes all the resources
+	// so the archive can be imported while it is read.
+	manifestName = "manifest.json"
+
+	// filesFolder is the folder of the archive with the contents of the files.
+	filesFolder = "files"
+
+	// manifestVersion changes when the format of the archive changes.
+	manifestVersion = 1
+)
+
+type exporter struct {
+	logger         levels.Levels
+	dataDriver     lib.DataDriver
+	metaDataDriver lib.MetaDataDriver
+}
+
+// New returns an implementation of UserExporter that writes TAR archives compressed with gzip.
+// The archive starts with a manifest with the metadata of every resource, IDs, ETags, checksums
+// and modification times, followed by the contents of the files under the files folder.
+// The drivers do not keep versions or trash, so they are not part of the archive.
+// Importing preserves the IDs when metaDataDriver can set them, otherwise new IDs are assigned.
+// ETags are recorded for reference but new ones are assigned.
+func New(logger levels.Levels, dataDriver lib.DataDriver, metaDataDriver lib.MetaDataDriver) lib.UserExporter {
+	logger = logger.With("pkg", "userexporter")
+	return &exporter{
+		logger:         logger,
+		dataDriver:     dataDriver,
+		metaDataDriver: metaDataDriver,
+	}
+}
+
+// Export walks the whole home before writing anything, so a resource that changes
+// while its contents are written leaves a truncated archive and an error.
+func (e *exporter) Export(ctx context.Context, user lib.User, w io.Writer) error {
+	m := &manifest{Version: manifestVersion, Username: user.Username(), Created: time.Now().Unix(), Entries: []*entry{}}
+	if err := e.walk(ctx, user, "/", m); err != nil {
+		e.logger.Error().Log("error", err, "msg", "error walking home", "user", user.Username())
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(data)), ModTime: time.Unix(m.Created, 0)}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for _, en := range m.Entries {
+		if en.Folder {
+			continue
+		}
+		// stop as soon as the client goes away.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.addFile(ctx, user, tw, en); err != nil {
+			e.logger.Error().Log("error", err, "msg", "error adding file to export", "path", en.Path)
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	e.logger.Info().Log("msg", "user exported", "user", user.Username(), "numentries", len(m.Entries))
+	return nil
+}
+
+// walk adds the resources inside the folder to the manifest, parents before their children.
+func (e *exporter) walk(ctx context.Context, user lib.User, path string, m *manifest) error {
+	fileInfos, err := e.metaDataDriver.ListFolder(ctx, user, path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fileInfos {
+		m.Entries = append(m.Entries, fileInfoToEntry(fi))
+		if fi.Folder() {
+			if err := e.walk(ctx, user, fi.Path(), m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *exporter) addFile(ctx context.Context, user lib.User, tw *tar.Writer, en *entry) error {
+	r, err := e.dataDriver.DownloadFile(ctx, user, en.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hdr := &tar.Header{
+		Name:    filesFolder + en.Path,
+		Mode:    0644,
+		Size:    en.Size,
+		ModTime: time.Unix(0, en.Modified),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, r)
+	if err != nil {
+		return err
+	}
+	if n != en.Size {
+		return fmt.Errorf("file %s changed during the export", en.Path)
+	}
+	return nil
+}
+
+// Import creates the folders, then uploads the files while they are read from the archive,
+// verifying their checksums, and finally assigns the exported IDs and the modification times
+// of the folders, which uploading their children has changed.
+// Existing resources are kept and files with the same path are replaced.
+func (e *exporter) Import(ctx context.Context, user lib.User, r io.Reader) ([]lib.ImportResult, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, badInputError("archive is not compressed with gzip")
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, badInputError("archive does not start with a manifest")
+	}
+	m := &manifest{}
+	if err := json.NewDecoder(tr).Decode(m); err != nil {
+		return nil, badInputError("invalid manifest: " + err.Error())
+	}
+	if m.Version != manifestVersion {
+		return nil, badInputError(fmt.Sprintf("manifest version %d not supported", m.Version))
+	}
+
+	results := []*result{}
+	byPath := map[string]*result{}
+	for _, en := range m.Entries {
+		path := filepath.Clean("/" + en.Path)
+		if path == "/" || path != en.Path || byPath[path] != nil {
+			return nil, badInputError(fmt.Sprintf("invalid path %q in manifest", en.Path))
+		}
+		res := &result{entry: en}
+		results = append(results, res)
+		byPath[path] = res
+	}
+
+	// the manifest lists parents before their children.
+	for _, res := range results {
+		if !res.entry.Folder {
+			continue
+		}
+		err := e.metaDataDriver.CreateFolder(ctx, user, res.entry.Path)
+		if codeErr, ok := err.(lib.Error); ok && codeErr.Code() == lib.CodeAlreadyExist {
+			err = nil
+		}
+		res.err = err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, badInputError("corrupted archive: " + err.Error())
+		}
+		res := byPath[strings.TrimPrefix(hdr.Name, filesFolder)]
+		if !strings.HasPrefix(hdr.Name, filesFolder+"/") || res == nil || res.entry.Folder || res.done {
+			return nil, badInputError(fmt.Sprintf("entry %q is not in the manifest", hdr.Name))
+		}
+		res.done = true
+		if parent := byPath[filepath.Dir(res.entry.Path)]; parent != nil && parent.err != nil {
+			res.err = parent.err
+			continue
+		}
+		reader, err := newChecksumReader(tr, res.entry.Checksum)
+		if err != nil {
+			res.err = err
+			continue
+		}
+		res.err = e.dataDriver.UploadFile(ctx, user, res.entry.Path, ioutil.NopCloser(reader), "", res.entry.Modified)
+	}
+
+	fileIDSetter, canSetIDs := e.metaDataDriver.(lib.FileIDSetter)
+	canSetIDs = canSetIDs && capability.Supports(e.metaDataDriver, lib.CapabilityFileIDSetter)
+	for _, res := range results {
+		if !res.entry.Folder && !res.done && res.err == nil {
+			res.err = notFoundError("file missing from the archive")
+		}
+		if res.err != nil || !canSetIDs || res.entry.ID == "" {
+			continue
+		}
+		if err := fileIDSetter.SetFileID(ctx, user, res.entry.Path, res.entry.ID); err != nil {
+			e.logger.Warn().Log("error", err, "msg", "id not preserved", "path", res.entry.Path)
+			continue
+		}
+		res.idPreserved = true
+	}
+
+	// children go after their parents, so folders are updated from the deepest.
+	if modTimeSetter, ok := e.metaDataDriver.(lib.ModTimeSetter); ok && capability.Supports(e.metaDataDriver, lib.CapabilityModTimeSetter) {
+		for i := len(results) - 1; i >= 0; i-- {
+			res := results[i]
+			if res.entry.Folder && res.err == nil {
+				if err := modTimeSetter.SetModTime(ctx, user, res.entry.Path, res.entry.Modified); err != nil {
+					e.logger.Warn().Log("error", err, "msg", "modification time not preserved", "path", res.entry.Path)
+				}
+			}
+		}
+	}
+
+	importResults := []lib.ImportResult{}
+	numErrors := 0
+	for _, res := range results {
+		if res.err == nil {
+			if fi, err := e.metaDataDriver.Examine(ctx, user, res.entry.Path); err == nil {
+				res.id, _ = fi.ExtraAttributes()["id"].(string)
+			}
+		} else {
+			numErrors++
+		}
+		importResults = append(importResults, res)
+	}
+	e.logger.Info().Log("msg", "user imported", "user", user.Username(), "from", m.Username, "numentries", len(results), "numerrors", numErrors)
+	return importResults, nil
+}
+
+// checksumReader fails at the end of the file when the checksum does not match,
+// so the data driver discards the upload.
+type checksumReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	checksum string
+}
+
+// newChecksumReader returns r as is when there is no checksum or its type is not known.
+func newChecksumReader(r io.Reader, checksum string) (io.Reader, error) {
+	tokens := strings.SplitN(checksum, ":", 2)
+	if len(tokens) != 2 {
+		return r, nil
+	}
+	var h hash.Hash
+	switch strings.ToLower(tokens[0]) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "adler32":
+		h = adler32.New()
+	default:
+		return r, nil
+	}
+	return &checksumReader{r: io.TeeReader(r, h), hash: h, checksum: strings.ToLower(tokens[1])}, nil
+}
+
+func (c *checksumReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if err == io.EOF {
+		if computed := fmt.Sprintf("%x", c.hash.Sum(nil)); computed != c.checksum {
+			return n, checksumError(fmt.Sprintf("wrong checksum computed:%q expected:%q", computed, c.checksum))
+		}
+	}
+	return n, err
+}
+
+func fileInfoToEntry(fi lib.FileInfo) *entry {
+	en := &entry{
+		Path:            filepath.Clean("/" + fi.Path()),
+		Folder:          fi.Folder(),
+		Size:            fi.Size(),
+		Modified:        fi.Modified(),
+		Checksum:        fi.Checksum(),
+		ExtraAttributes: fi.ExtraAttributes(),
+	}
+	en.ID, _ = fi.ExtraAttributes()["id"].(string)
+	en.ETag, _ = fi.ExtraAttributes()["etag"].(string)
+	if en.Folder {
+		en.Size = 0
+	}
+	return en
+}
+
+// manifest describes the archive, Username is the user that was exported.
+type manifest struct {
+	Version  int      `json:"version"`
+	Username string   `json:"username"`
+	Created  int64    `json:"created"`
+	Entries  []*entry `json:"entries"`
+}
+
+// entry is a resource of the archive, Modified is in nanoseconds since the epoch.
+type entry struct {
+	Path            string                 `json:"path"`
+	Folder          bool                   `json:"folder"`
+	Size            int64                  `json:"size"`
+	Modified        int64                  `json:"modified"`
+	Checksum        string                 `json:"checksum"`
+	ID              string                 `json:"id"`
+	ETag            string                 `json:"etag"`
+	ExtraAttributes map[string]interface{} `json:"extra_attributes"`
+}
+
+type result struct {
+	entry       *entry
+	done        bool
+	id          string
+	idPreserved bool
+	err         error
+}
+
+func (r *result) Path() string {
+	return r.entry.Path
+}
+
+func (r *result) Folder() bool {
+	return r.entry.Folder
+}
+
+func (r *result) ID() string {
+	return r.id
+}
+
+func (r *result) IDPreserved() bool {
+	return r.idPreserved
+}
+
+func (r *result) Err() error {
+	return r.err
+}
+
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+func (e badInputError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badInputError) Message() string {
+	return string(e)
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+func (e notFoundError) Code() lib.Code {
+	return lib.Code(lib.CodeNotFound)
+}
+func (e notFoundError) Message() string {
+	return string(e)
+}
+
+type checksumError string
+
+func (e checksumError) Error() string {
+	return string(e)
+}
+func (e checksumError) Code() lib.Code {
+	return lib.Code(lib.CodeBadChecksum)
+}
+func (e checksumError) Message() string {
+	return string(e)
+}
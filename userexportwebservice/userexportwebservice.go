@@ -0,0 +1,247 @@
+package userexportwebservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/clawio/lib"
+	"github.com/go-kit/kit/log/levels"
+)
+
+type service struct {
+	cm             lib.ContextManager
+	logger         levels.Levels
+	userExporter   lib.UserExporter
+	provisioner    lib.Provisioner
+	am             lib.AuthenticationMiddleware
+	wec            lib.WebErrorConverter
+	administrators map[string]bool
+	maxImportSize  int64
+}
+
+// New returns a web service that lets administrators export the data of a user
+// and import it again, in this server or in another one.
+// Users are provisioned with provisioner before their data is imported.
+// administrators is a comma separated list of usernames.
+func New(
+	cm lib.ContextManager,
+	logger levels.Levels,
+	userExporter lib.UserExporter,
+	provisioner lib.Provisioner,
+	am lib.AuthenticationMiddleware,
+	wec lib.WebErrorConverter,
+	administrators string,
+	maxImportSize int64) lib.WebService {
+	admins := map[string]bool{}
+	for _, username := range strings.Split(administrators, ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			admins[username] = true
+		}
+	}
+	return &service{
+		cm:             cm,
+		logger:         logger,
+		userExporter:   userExporter,
+		provisioner:    provisioner,
+		am:             am,
+		wec:            wec,
+		administrators: admins,
+		maxImportSize:  maxImportSize,
+	}
+}
+
+func (s *service) IsProxy() bool {
+	return false
+}
+
+func (s *service) Endpoints() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/userexport/export": {
+			"POST": s.am.HandlerFunc(s.exportEndpoint),
+		},
+		"/userexport/import": {
+			"POST": s.am.HandlerFunc(s.importEndpoint),
+		},
+	}
+}
+
+// exportEndpoint streams the archive. The exporter walks the whole home before writing the
+// first byte, so errors walking it get an error response, later ones can only be logged
+// and leave a truncated archive.
+func (s *service) exportEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if !s.isAdministrator(w, r) {
+		return
+	}
+
+	req := &userRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || strings.TrimSpace(req.Username) == "" {
+		logger.Error().Log("error", err)
+		s.handleUserExportEndpointError(badRequestError("invalid json or empty username"), w, r)
+		return
+	}
+
+	ew := &exportResponseWriter{w: w, filename: req.Username + "-export.tar.gz"}
+	if err := s.userExporter.Export(r.Context(), &user{username: req.Username}, ew); err != nil {
+		if !ew.written {
+			s.handleUserExportEndpointError(err, w, r)
+			return
+		}
+		logger.Error().Log("error", err, "msg", "export aborted", "username", req.Username)
+		return
+	}
+	logger.Info().Log("msg", "user exported", "username", req.Username)
+}
+
+// importEndpoint reads the archive from the body, the username goes in the clawio-api-arg header.
+func (s *service) importEndpoint(w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	if !s.isAdministrator(w, r) {
+		return
+	}
+
+	req := &userRequest{}
+	if err := json.Unmarshal([]byte(r.Header.Get("clawio-api-arg")), req); err != nil || strings.TrimSpace(req.Username) == "" {
+		logger.Error().Log("error", err)
+		s.handleUserExportEndpointError(badRequestError("invalid json in clawio-api-arg header or empty username"), w, r)
+		return
+	}
+
+	target := &user{username: req.Username}
+	if err := s.provisioner.Provision(r.Context(), target); err != nil {
+		s.handleUserExportEndpointError(err, w, r)
+		return
+	}
+
+	readCloser := http.MaxBytesReader(w, r.Body, s.maxImportSize)
+	results, err := s.userExporter.Import(r.Context(), target, readCloser)
+	if err != nil {
+		s.handleUserExportEndpointError(err, w, r)
+		return
+	}
+
+	entries := []*importEntry{}
+	for _, res := range results {
+		entry := &importEntry{Path: res.Path(), Folder: res.Folder(), ID: res.ID(), IDPreserved: res.IDPreserved()}
+		if err := res.Err(); err != nil {
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logger.Error().Log("error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Info().Log("msg", "user imported", "username", req.Username, "numentries", len(entries))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *service) isAdministrator(w http.ResponseWriter, r *http.Request) bool {
+	logger := s.cm.MustGetLog(r.Context())
+	user := s.cm.MustGetUser(r.Context())
+	if !s.administrators[user.Username()] {
+		logger.Warn().Log("msg", "user export is only allowed to administrators")
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *service) handleUserExportEndpointError(err error, w http.ResponseWriter, r *http.Request) {
+	logger := s.cm.MustGetLog(r.Context())
+	logger.Error().Log("error", err)
+
+	if err.Error() == "http: request body too large" {
+		logger.Error().Log("error", err, "msg", "request body max size exceed")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if codeErr, ok := err.(lib.Error); ok {
+		if codeErr.Code() == lib.CodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if codeErr.Code() == lib.CodeBadInputData {
+			jsonErr, err := s.wec.ErrorToJSON(codeErr)
+			if err != nil {
+				logger.Error().Log("error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonErr)
+			return
+		}
+	}
+	logger.Error().Log("error", err, "msg", "unexpected error exporting or importing user")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// exportResponseWriter writes the headers of the archive with its first byte.
+type exportResponseWriter struct {
+	w        http.ResponseWriter
+	filename string
+	written  bool
+}
+
+func (e *exportResponseWriter) Write(p []byte) (int, error) {
+	if !e.written {
+		e.written = true
+		e.w.Header().Set("Content-Type", "application/gzip")
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+		e.w.WriteHeader(http.StatusOK)
+	}
+	return e.w.Write(p)
+}
+
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+func (e badRequestError) Code() lib.Code {
+	return lib.Code(lib.CodeBadInputData)
+}
+func (e badRequestError) Message() string {
+	return string(e)
+}
+
+// user is the user to export or import, drivers only need its username to find its home.
+type user struct {
+	username string
+}
+
+func (u *user) Username() string {
+	return u.username
+}
+
+func (u *user) Email() string {
+	return ""
+}
+
+func (u *user) DisplayName() string {
+	return u.username
+}
+
+func (u *user) ExtraAttributes() map[string]interface{} {
+	return nil
+}
+
+type userRequest struct {
+	Username string `json:"username"`
+}
+
+type importEntry struct {
+	Path        string `json:"path"`
+	Folder      bool   `json:"folder"`
+	ID          string `json:"id"`
+	IDPreserved bool   `json:"id_preserved"`
+	Error       string `json:"error,omitempty"`
+}